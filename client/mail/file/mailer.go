@@ -0,0 +1,55 @@
+package file
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/laiker/auth/client/mail"
+)
+
+type fileMailer struct {
+	path   string
+	from   string
+	logger *slog.Logger
+	mu     sync.Mutex
+}
+
+// New for local development, appends the messages to the file at path,
+// or logs them when path is empty, so links in them can be followed without a mail server
+func New(path string, from string, logger *slog.Logger) mail.Mailer {
+	return &fileMailer{
+		path:   path,
+		from:   from,
+		logger: logger,
+	}
+}
+
+func (m *fileMailer) Send(ctx context.Context, msg *mail.Message) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+
+	if len(m.path) == 0 {
+		m.logger.InfoContext(ctx, "mail", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(msg.Format(m.from, time.Now()))
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,58 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"mime"
+	"mime/quotedprintable"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Message plain text email to one recipient
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// Validate rejects line breaks in the headers, they would let a value add headers of its own
+func (m *Message) Validate() error {
+	if len(m.To) == 0 {
+		return errors.New("mail recipient is empty")
+	}
+
+	if strings.ContainsAny(m.To, "\r\n") || strings.ContainsAny(m.Subject, "\r\n") {
+		return errors.New("mail headers must not contain line breaks")
+	}
+
+	return nil
+}
+
+// Format renders the message as a MIME email with a quoted-printable UTF-8 body
+func (m *Message) Format(from string, date time.Time) []byte {
+	buf := &bytes.Buffer{}
+
+	buf.WriteString("From: " + from + "\r\n")
+	buf.WriteString("To: " + m.To + "\r\n")
+	buf.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", m.Subject) + "\r\n")
+	buf.WriteString("Date: " + date.Format(time.RFC1123Z) + "\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
+	buf.WriteString("\r\n")
+
+	body := quotedprintable.NewWriter(buf)
+	_, _ = body.Write([]byte(m.Body))
+	_ = body.Close()
+
+	buf.WriteString("\r\n")
+
+	return buf.Bytes()
+}
@@ -0,0 +1,104 @@
+package smtp
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/smtp"
+	"time"
+
+	"github.com/laiker/auth/client/mail"
+	"github.com/pkg/errors"
+)
+
+// defaultTimeout bounds sending when the context has no deadline
+const defaultTimeout = 10 * time.Second
+
+type smtpMailer struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+}
+
+// New sends through the server at addr, upgrading with STARTTLS when the server offers it.
+// The credentials are optional, net/smtp refuses to send them unencrypted except to localhost.
+func New(addr string, username string, password string, from string) (mail.Mailer, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errors.Errorf("smtp address %q must be host:port", addr)
+	}
+
+	return &smtpMailer{
+		addr:     addr,
+		host:     host,
+		username: username,
+		password: password,
+		from:     from,
+	}, nil
+}
+
+func (m *smtpMailer) Send(ctx context.Context, msg *mail.Message) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+
+	dialer := net.Dialer{}
+
+	conn, err := dialer.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to smtp server")
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultTimeout)
+	}
+
+	if err = conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		return errors.Wrap(err, "failed to greet smtp server")
+	}
+	defer client.Close()
+
+	if ok, _ = client.Extension("STARTTLS"); ok {
+		if err = client.StartTLS(&tls.Config{ServerName: m.host, MinVersion: tls.VersionTLS12}); err != nil {
+			return errors.Wrap(err, "failed to start tls")
+		}
+	}
+
+	if len(m.username) > 0 {
+		if err = client.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return errors.Wrap(err, "failed to authenticate to smtp server")
+		}
+	}
+
+	if err = client.Mail(m.from); err != nil {
+		return errors.Wrap(err, "smtp server refused the sender")
+	}
+
+	if err = client.Rcpt(msg.To); err != nil {
+		return errors.Wrap(err, "smtp server refused the recipient")
+	}
+
+	data, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err = data.Write(msg.Format(m.from, time.Now())); err != nil {
+		return err
+	}
+
+	if err = data.Close(); err != nil {
+		return errors.Wrap(err, "smtp server refused the message")
+	}
+
+	return client.Quit()
+}
@@ -0,0 +1,243 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	netMail "net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/laiker/auth/client/mail"
+	"github.com/laiker/auth/client/mail/smtp"
+)
+
+// smtpServer in-process stand-in for an SMTP relay, records what it receives
+type smtpServer struct {
+	listener net.Listener
+	// rejected recipients answered with 550
+	rejected map[string]bool
+
+	mu       sync.Mutex
+	auth     []string
+	from     []string
+	to       []string
+	messages [][]byte
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &smtpServer{listener: listener, rejected: map[string]bool{}}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go s.serve(conn)
+		}
+	}()
+
+	t.Cleanup(func() { _ = listener.Close() })
+
+	return s
+}
+
+func (s *smtpServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	text := textproto.NewConn(conn)
+	reply := func(line string) bool {
+		return text.PrintfLine("%s", line) == nil
+	}
+
+	if !reply("220 localhost ESMTP stand-in") {
+		return
+	}
+
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			_ = text.PrintfLine("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "HELO", "NOOP", "RSET":
+			reply("250 OK")
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(arg, " ")
+			decoded, err := base64.StdEncoding.DecodeString(initial)
+
+			if mechanism != "PLAIN" || err != nil {
+				reply("504 unsupported authentication")
+				continue
+			}
+
+			s.mu.Lock()
+			s.auth = append(s.auth, string(decoded))
+			s.mu.Unlock()
+
+			reply("235 2.7.0 Authentication successful")
+		case "MAIL":
+			s.mu.Lock()
+			s.from = append(s.from, strings.TrimPrefix(arg, "FROM:"))
+			s.mu.Unlock()
+
+			reply("250 OK")
+		case "RCPT":
+			to := strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>")
+
+			if s.rejected[to] {
+				reply("550 5.1.1 no such user")
+				continue
+			}
+
+			s.mu.Lock()
+			s.to = append(s.to, to)
+			s.mu.Unlock()
+
+			reply("250 OK")
+		case "DATA":
+			reply("354 end data with <CR><LF>.<CR><LF>")
+
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			s.messages = append(s.messages, data)
+			s.mu.Unlock()
+
+			reply("250 OK queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 command not implemented")
+		}
+	}
+}
+
+func TestSMTPMailer_Send(t *testing.T) {
+	server := newSMTPServer(t)
+
+	mailer, err := smtp.New(server.Addr(), "auth", "secret", "no-reply@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := "Follow the link:\n\nhttps://example.com/reset-password?token=abc\n.\nЕсли это были не вы, проигнорируйте письмо"
+
+	err = mailer.Send(context.Background(), &mail.Message{
+		To:      "alice@example.com",
+		Subject: "Сброс пароля",
+		Body:    body,
+	})
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if len(server.auth) != 1 || server.auth[0] != "\x00auth\x00secret" {
+		t.Fatalf("auth = %q", server.auth)
+	}
+
+	if len(server.from) != 1 || server.from[0] != "<no-reply@example.com>" {
+		t.Fatalf("from = %q", server.from)
+	}
+
+	if len(server.to) != 1 || server.to[0] != "alice@example.com" {
+		t.Fatalf("to = %q", server.to)
+	}
+
+	if len(server.messages) != 1 {
+		t.Fatalf("%d messages delivered", len(server.messages))
+	}
+
+	msg, err := netMail.ReadMessage(bufio.NewReader(strings.NewReader(string(server.messages[0]))))
+	if err != nil {
+		t.Fatalf("malformed message: %v", err)
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "Сброс пароля" {
+		t.Fatalf("subject = %q, %v", subject, err)
+	}
+
+	if msg.Header.Get("To") != "alice@example.com" || msg.Header.Get("From") != "no-reply@example.com" {
+		t.Fatalf("headers = %v", msg.Header)
+	}
+
+	decoded, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.ReplaceAll(strings.TrimRight(string(decoded), "\r\n"), "\r\n", "\n"); got != body {
+		t.Fatalf("body = %q, want %q", got, body)
+	}
+}
+
+func TestSMTPMailer_RejectedRecipient(t *testing.T) {
+	server := newSMTPServer(t)
+	server.rejected["nobody@example.com"] = true
+
+	mailer, err := smtp.New(server.Addr(), "", "", "no-reply@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mailer.Send(context.Background(), &mail.Message{To: "nobody@example.com", Subject: "Hi", Body: "Hi"})
+	if err == nil {
+		t.Fatal("a rejected recipient was reported as sent")
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if len(server.auth) != 0 || len(server.messages) != 0 {
+		t.Fatalf("auth = %q, messages = %d", server.auth, len(server.messages))
+	}
+}
+
+func TestSMTPMailer_HeaderInjection(t *testing.T) {
+	server := newSMTPServer(t)
+
+	mailer, err := smtp.New(server.Addr(), "", "", "no-reply@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mailer.Send(context.Background(), &mail.Message{
+		To:      "alice@example.com",
+		Subject: "Hi\r\nBcc: mallory@example.com",
+		Body:    "Hi",
+	})
+	if err == nil {
+		t.Fatal("a subject with a line break was sent")
+	}
+}
@@ -0,0 +1,189 @@
+package file
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/laiker/auth/client/pwned"
+	"github.com/pkg/errors"
+)
+
+// scanWindow bytes of a sorted file read line by line once the binary search gets this close
+const scanWindow = 64 * 1024
+
+// New opens an offline copy of the Have I Been Pwned passwords as written by PwnedPasswordsDownloader.
+// A directory holds a <PREFIX>.txt file of SUFFIX:COUNT lines per range, like the answers of the range API,
+// a file holds HASH:COUNT lines of full SHA-1 hashes sorted by hash.
+func New(path string) (pwned.Store, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open pwned passwords")
+	}
+
+	if info.IsDir() {
+		return &dirStore{dir: path}, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open pwned passwords")
+	}
+
+	return &sortedStore{file: f, size: info.Size()}, nil
+}
+
+type dirStore struct {
+	dir string
+}
+
+func (s *dirStore) Range(_ context.Context, prefix string) (map[string]int, error) {
+	if err := checkPrefix(prefix); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(s.dir, prefix+".txt"))
+	if os.IsNotExist(err) {
+		return map[string]int{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	suffixes := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		suffix, count, ok := parseLine(scanner.Text())
+		if ok {
+			suffixes[suffix] = count
+		}
+	}
+
+	return suffixes, scanner.Err()
+}
+
+type sortedStore struct {
+	// mu the file offset is shared by the lookups
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func (s *sortedStore) Range(_ context.Context, prefix string) (map[string]int, error) {
+	if err := checkPrefix(prefix); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// every line starting before lo is of a lower prefix, lo is a line start
+	lo, hi := int64(0), s.size
+
+	for hi-lo > scanWindow {
+		mid := lo + (hi-lo)/2
+
+		start, line, err := s.lineAfter(mid)
+		if err != nil {
+			return nil, err
+		}
+
+		if start < hi && len(line) >= pwned.PrefixLen && strings.ToUpper(line[:pwned.PrefixLen]) < prefix {
+			lo = start + int64(len(line)) + 1
+		} else {
+			hi = mid
+		}
+	}
+
+	_, err := s.file.Seek(lo, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	suffixes := make(map[string]int)
+	scanner := bufio.NewScanner(s.file)
+
+	for scanner.Scan() {
+		hash, count, ok := parseLine(scanner.Text())
+		if !ok || len(hash) <= pwned.PrefixLen {
+			continue
+		}
+
+		if hash[:pwned.PrefixLen] > prefix {
+			break
+		}
+
+		if hash[:pwned.PrefixLen] == prefix {
+			suffixes[hash[pwned.PrefixLen:]] = count
+		}
+	}
+
+	return suffixes, scanner.Err()
+}
+
+// lineAfter the first line starting at off or later, without its line break
+func (s *sortedStore) lineAfter(off int64) (int64, string, error) {
+	start := off
+
+	if off > 0 {
+		start = off - 1
+	}
+
+	_, err := s.file.Seek(start, io.SeekStart)
+	if err != nil {
+		return 0, "", err
+	}
+
+	reader := bufio.NewReader(s.file)
+
+	if off > 0 {
+		skipped, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return s.size, "", nil
+		}
+
+		if err != nil {
+			return 0, "", err
+		}
+
+		start += int64(len(skipped))
+	}
+
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return 0, "", err
+	}
+
+	return start, strings.TrimSuffix(line, "\n"), nil
+}
+
+func checkPrefix(prefix string) error {
+	if len(prefix) != pwned.PrefixLen || strings.Trim(prefix, "0123456789ABCDEF") != "" {
+		return errors.Errorf("invalid hash prefix %q", prefix)
+	}
+
+	return nil
+}
+
+func parseLine(line string) (string, int, bool) {
+	hash, count, ok := strings.Cut(strings.TrimSpace(line), ":")
+	if !ok {
+		return "", 0, false
+	}
+
+	n, err := strconv.Atoi(count)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return strings.ToUpper(hash), n, true
+}
@@ -0,0 +1,31 @@
+package pwned
+
+import (
+	"context"
+	"crypto/sha1" //nolint:gosec
+	"encoding/hex"
+	"strings"
+)
+
+// PrefixLen hex characters of the SHA-1 hash a range is looked up by, the rest of the hash stays with the caller
+const PrefixLen = 5
+
+// Store Have I Been Pwned passwords, SHA-1 hashes of breached passwords split into ranges by their prefix
+type Store interface {
+	// Range returns the upper case hash suffixes of the range with the times each was seen in breaches,
+	// the answer of the k-anonymity range API
+	Range(ctx context.Context, prefix string) (map[string]int, error)
+}
+
+// Count times the password was seen in breaches, zero for passwords not in the store
+func Count(ctx context.Context, store Store, password string) (int, error) {
+	sum := sha1.Sum([]byte(password)) //nolint:gosec
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes, err := store.Range(ctx, hash[:PrefixLen])
+	if err != nil {
+		return 0, err
+	}
+
+	return suffixes[hash[PrefixLen:]], nil
+}
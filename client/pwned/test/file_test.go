@@ -0,0 +1,122 @@
+package test
+
+import (
+	"context"
+	"crypto/sha1" //nolint:gosec
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/laiker/auth/client/pwned"
+	"github.com/laiker/auth/client/pwned/file"
+)
+
+// sha1Hex upper case SHA-1 of the password, how the dataset stores it
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password)) //nolint:gosec
+
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// breached passwords of the test datasets with the times they were seen
+var breached = map[string]int{
+	"password": 9545824,
+	"123456":   37359195,
+	"qwerty":   10556095,
+}
+
+func TestDirStore(t *testing.T) {
+	dir := t.TempDir()
+	ranges := map[string][]string{}
+
+	for password, count := range breached {
+		hash := sha1Hex(password)
+		ranges[hash[:5]] = append(ranges[hash[:5]], fmt.Sprintf("%s:%d", hash[5:], count))
+	}
+
+	for prefix, lines := range ranges {
+		// the downloader writes CRLF like the range API answers
+		err := os.WriteFile(filepath.Join(dir, prefix+".txt"), []byte(strings.Join(lines, "\r\n")+"\r\n"), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store, err := file.New(dir)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	checkStore(t, store)
+}
+
+func TestSortedStore(t *testing.T) {
+	// enough random hashes around the breached ones for the binary search to skip most of the file
+	rnd := rand.New(rand.NewSource(1))
+	lines := make([]string, 0, 20000)
+
+	hash := make([]byte, sha1.Size)
+
+	for i := 0; i < 20000; i++ {
+		rnd.Read(hash)
+		lines = append(lines, fmt.Sprintf("%X:%d", hash, rnd.Intn(1000)+1))
+	}
+
+	for password, count := range breached {
+		lines = append(lines, fmt.Sprintf("%s:%d", sha1Hex(password), count))
+	}
+
+	sort.Strings(lines)
+
+	path := filepath.Join(t.TempDir(), "pwnedpasswords.txt")
+
+	err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := file.New(path)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	checkStore(t, store)
+
+	// the first and the last range of the file
+	for _, line := range []string{lines[0], lines[len(lines)-1]} {
+		suffixes, err := store.Range(context.Background(), line[:5])
+		if err != nil {
+			t.Fatalf("Range() error = %v", err)
+		}
+
+		hash, _, _ := strings.Cut(line, ":")
+		if _, ok := suffixes[hash[5:]]; !ok {
+			t.Errorf("Range(%s) misses %s", line[:5], hash)
+		}
+	}
+}
+
+func checkStore(t *testing.T, store pwned.Store) {
+	t.Helper()
+
+	for password, want := range breached {
+		count, err := pwned.Count(context.Background(), store, password)
+		if err != nil || count != want {
+			t.Errorf("Count(%q) = %d, %v, want %d", password, count, err, want)
+		}
+	}
+
+	count, err := pwned.Count(context.Background(), store, "correct horse battery staple 7f3a")
+	if err != nil || count != 0 {
+		t.Errorf("Count() of a password not in the store = %d, %v", count, err)
+	}
+
+	if _, err = store.Range(context.Background(), "../x"); err == nil {
+		t.Error("Range() accepted a malformed prefix")
+	}
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"flag"
+	"io"
 	"log"
+	"os"
 
 	"github.com/laiker/auth/internal/app"
 	"github.com/laiker/auth/internal/config"
@@ -16,9 +18,26 @@ func init() {
 }
 
 func main() {
+	flag.Parse()
+
 	ctx := context.Background()
 	ctx = context.WithValue(ctx, config.ConfigPathKey, configPath)
 
+	commands := map[string]func(context.Context, []string, io.Writer) error{
+		"keys":    app.RunKeysCommand,
+		"clients": app.RunClientsCommand,
+	}
+
+	if command, ok := commands[flag.Arg(0)]; ok {
+		err := command(ctx, flag.Args()[1:], os.Stdout)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
 	a, err := app.NewApp(ctx)
 
 	if err != nil {
@@ -3,60 +3,44 @@ package access
 import (
 	"context"
 	"log/slog"
-	"strings"
 
+	"github.com/laiker/auth/internal/interceptor"
 	"github.com/laiker/auth/internal/service"
 	"github.com/laiker/auth/pkg/access_v1"
 	"github.com/pkg/errors"
-	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
-const authPrefix = "Bearer "
-
 type ServerAccess struct {
 	access_v1.UnimplementedAccessV1Server
 	AuthService   service.AuthService
+	ApiKeyService service.ApiKeyService
 	AccessService service.AccessService
 	Logger        *slog.Logger
 }
 
 func NewAccessServer(
 	AuthService service.AuthService,
+	ApiKeyService service.ApiKeyService,
 	AccessService service.AccessService,
 	Logger *slog.Logger,
 ) *ServerAccess {
 	return &ServerAccess{
 		AuthService:   AuthService,
+		ApiKeyService: ApiKeyService,
 		AccessService: AccessService,
 		Logger:        Logger,
 	}
 }
 
+// HasAccess the caller authenticates with "Authorization: Bearer <access token>" or "Authorization: ApiKey <key>"
 func (s *ServerAccess) HasAccess(ctx context.Context, req *access_v1.CheckRequest) (*emptypb.Empty, error) {
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		return nil, errors.New("metadata is not provided")
-	}
-
-	authHeader, ok := md["authorization"]
-	if !ok || len(authHeader) == 0 {
-		return nil, errors.New("authorization header is not provided")
-	}
-
-	if !strings.HasPrefix(authHeader[0], authPrefix) {
-		return nil, errors.New("invalid authorization header format")
-	}
-
-	accessToken := strings.TrimPrefix(authHeader[0], authPrefix)
-
-	claims, err := s.AuthService.VerifyAccessToken(ctx, accessToken)
-
+	claims, err := interceptor.Authenticate(ctx, s.AuthService, s.ApiKeyService)
 	if err != nil {
-		return nil, errors.New("access token is invalid")
+		return nil, err
 	}
 
-	hasEndpointAccess, err := s.AccessService.HasAccessRight(ctx, req.EndpointAddress, claims.Role)
+	hasEndpointAccess, err := s.AccessService.HasAccessRight(ctx, req.EndpointAddress, claims)
 
 	if err != nil {
 		return nil, errors.New("failed to get accessible roles")
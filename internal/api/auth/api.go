@@ -2,80 +2,176 @@ package auth
 
 import (
 	"context"
+	"encoding/json"
+	"strings"
 
+	"github.com/laiker/auth/internal/converter"
+	"github.com/laiker/auth/internal/interceptor"
 	"github.com/laiker/auth/internal/model"
 	"github.com/laiker/auth/internal/service"
+	apiKeyService "github.com/laiker/auth/internal/service/apikey"
+	authService "github.com/laiker/auth/internal/service/auth"
+	impersonationService "github.com/laiker/auth/internal/service/impersonation"
+	lockoutService "github.com/laiker/auth/internal/service/lockout"
+	magicService "github.com/laiker/auth/internal/service/magic"
+	mfaService "github.com/laiker/auth/internal/service/mfa"
+	policyService "github.com/laiker/auth/internal/service/policy"
+	resetService "github.com/laiker/auth/internal/service/reset"
+	sessionService "github.com/laiker/auth/internal/service/session"
+	verificationService "github.com/laiker/auth/internal/service/verification"
+	webauthnService "github.com/laiker/auth/internal/service/webauthn"
 	"github.com/laiker/auth/internal/utils"
 	"github.com/laiker/auth/pkg/auth_v1"
 	"github.com/pkg/errors"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 type ServerAuth struct {
 	auth_v1.UnimplementedAuthV1Server
-	AuthService service.AuthService
-	UserService service.UserService
+	AuthService          service.AuthService
+	UserService          service.UserService
+	ClientService        service.ClientService
+	SessionService       service.SessionService
+	MfaService           service.MfaService
+	WebAuthnService      service.WebAuthnService
+	LockoutService       service.LockoutService
+	ResetService         service.PasswordResetService
+	MagicService         service.MagicLinkService
+	ApiKeyService        service.ApiKeyService
+	ImpersonationService service.ImpersonationService
 }
 
 func NewAuthServer(
 	AuthService service.AuthService,
 	UserService service.UserService,
+	ClientService service.ClientService,
+	SessionService service.SessionService,
+	MfaService service.MfaService,
+	WebAuthnService service.WebAuthnService,
+	LockoutService service.LockoutService,
+	ResetService service.PasswordResetService,
+	MagicService service.MagicLinkService,
+	ApiKeyService service.ApiKeyService,
+	ImpersonationService service.ImpersonationService,
 ) *ServerAuth {
 	return &ServerAuth{
-		AuthService: AuthService,
-		UserService: UserService,
+		AuthService:          AuthService,
+		UserService:          UserService,
+		ClientService:        ClientService,
+		SessionService:       SessionService,
+		MfaService:           MfaService,
+		WebAuthnService:      WebAuthnService,
+		LockoutService:       LockoutService,
+		ResetService:         ResetService,
+		MagicService:         MagicService,
+		ApiKeyService:        ApiKeyService,
+		ImpersonationService: ImpersonationService,
 	}
 }
 
 func (s *ServerAuth) Login(ctx context.Context, req *auth_v1.LoginRequest) (*auth_v1.LoginResponse, error) {
 
-	user, err := s.UserService.GetByEmail(ctx, req.Email)
+	ip := utils.DeviceFromContext(ctx).Ip
+
+	err := s.LockoutService.Check(ctx, req.GetEmail(), ip)
+
+	if errors.Is(err, lockoutService.ErrLocked) {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
 
 	if err != nil {
-		return nil, err
+		return nil, status.Error(codes.Internal, "failed to check login attempts")
 	}
 
-	if !utils.VerifyPassword(user.Password, req.Password) {
-		return nil, errors.New("Неверный логин, пароль")
+	user, err := s.UserService.Authenticate(ctx, req.GetEmail(), req.GetPassword())
+
+	if errors.Is(err, verificationService.ErrEmailNotVerified) {
+		return nil, status.Error(codes.FailedPrecondition, "Email не подтвержден")
 	}
 
-	mu := model.UserJwt{
+	if err != nil {
+		if errFail := s.LockoutService.Fail(ctx, req.GetEmail(), ip); errFail != nil {
+			return nil, status.Error(codes.Internal, "failed to count login attempt")
+		}
+
+		return nil, status.Error(codes.Unauthenticated, "Неверный логин, пароль")
+	}
+
+	err = s.LockoutService.Succeed(ctx, req.GetEmail())
+
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to reset login attempts")
+	}
+
+	return s.finishLogin(ctx, model.UserJwt{
 		UserId:    user.Id,
 		UserLogin: user.Name,
 		Role:      user.Role,
-	}
+		Amr:       []string{model.AmrPassword},
+	})
+}
 
-	accessToken, err := s.AuthService.GetAccessToken(ctx, mu)
+// finishLogin starts a session for a user who passed the first factor, or asks for the second one when enrolled
+func (s *ServerAuth) finishLogin(ctx context.Context, mu model.UserJwt) (*auth_v1.LoginResponse, error) {
+	mfaEnabled, err := s.MfaService.IsEnabled(ctx, mu.UserId)
 
 	if err != nil {
-		return nil, errors.New("failed to generate access token")
+		return nil, status.Error(codes.Internal, "failed to check the second factor")
 	}
 
-	refreshToken, err := s.AuthService.GetRefreshToken(ctx, mu)
+	if mfaEnabled {
+		mfaToken, err := s.AuthService.IssueMfaChallenge(ctx, mu)
 
-	if err != nil {
-		return nil, errors.New("failed to generate refresh token")
+		if err != nil {
+			return nil, errors.New("failed to generate mfa token")
+		}
+
+		return &auth_v1.LoginResponse{MfaRequired: true, MfaToken: mfaToken}, nil
 	}
 
-	return &auth_v1.LoginResponse{RefreshToken: refreshToken, AccessToken: accessToken}, nil
+	return s.startSession(ctx, mu)
 }
 
-func (s *ServerAuth) GetRefreshToken(ctx context.Context, req *auth_v1.GetRefreshTokenRequest) (*auth_v1.GetRefreshTokenResponse, error) {
-	claims, err := s.AuthService.VerifyRefreshToken(ctx, req.GetRefreshToken())
+func (s *ServerAuth) VerifyMfa(ctx context.Context, req *auth_v1.VerifyMfaRequest) (*auth_v1.LoginResponse, error) {
+	claims, err := s.AuthService.VerifyMfaChallenge(ctx, req.GetMfaToken())
 	if err != nil {
-		return nil, status.Errorf(codes.Aborted, "invalid refresh token")
+		return nil, status.Error(codes.Unauthenticated, "mfa token is invalid")
 	}
 
-	mu := model.UserJwt{
-		UserId: claims.UserId,
-		Role:   claims.Role,
+	err = s.MfaService.Verify(ctx, claims.UserId, req.GetCode())
+
+	if errors.Is(err, mfaService.ErrInvalidCode) || errors.Is(err, mfaService.ErrNotEnrolled) {
+		return nil, status.Error(codes.Unauthenticated, mfaService.ErrInvalidCode.Error())
+	}
+
+	if err != nil {
+		return nil, mfaError(err)
 	}
 
-	refreshToken, err := s.AuthService.GetRefreshToken(ctx, mu)
+	err = s.AuthService.ConsumeMfaChallenge(ctx, claims)
+
+	if errors.Is(err, authService.ErrTokenRevoked) {
+		return nil, status.Error(codes.Unauthenticated, "mfa token is invalid")
+	}
 
 	if err != nil {
-		return nil, err
+		return nil, status.Error(codes.Internal, "failed to consume mfa token")
+	}
+
+	return s.startSession(ctx, model.UserJwt{
+		UserId:    claims.UserId,
+		UserLogin: claims.UserLogin,
+		Role:      claims.Role,
+		Amr:       append(claims.Amr, model.AmrMfa),
+	})
+}
+
+func (s *ServerAuth) GetRefreshToken(ctx context.Context, req *auth_v1.GetRefreshTokenRequest) (*auth_v1.GetRefreshTokenResponse, error) {
+	refreshToken, err := s.AuthService.RotateRefreshToken(ctx, req.GetRefreshToken())
+	if err != nil {
+		return nil, status.Errorf(codes.Aborted, "invalid refresh token")
 	}
 
 	return &auth_v1.GetRefreshTokenResponse{RefreshToken: refreshToken}, nil
@@ -88,11 +184,16 @@ func (s *ServerAuth) GetAccessToken(ctx context.Context, req *auth_v1.GetAccessT
 	}
 
 	mu := model.UserJwt{
-		UserId: claims.UserId,
-		Role:   claims.Role,
+		UserId:    claims.UserId,
+		UserLogin: claims.UserLogin,
+		Role:      claims.Role,
+		FamilyId:  claims.FamilyId,
+		Scope:     claims.Scope,
+		Azp:       claims.Azp,
+		Amr:       claims.Amr,
 	}
 
-	accessToken, err := s.AuthService.GetRefreshToken(ctx, mu)
+	accessToken, err := s.AuthService.GetAccessToken(ctx, mu)
 
 	if err != nil {
 		return nil, err
@@ -100,3 +201,560 @@ func (s *ServerAuth) GetAccessToken(ctx context.Context, req *auth_v1.GetAccessT
 
 	return &auth_v1.GetAccessTokenResponse{AccessToken: accessToken}, nil
 }
+
+func (s *ServerAuth) Logout(ctx context.Context, req *auth_v1.LogoutRequest) (*emptypb.Empty, error) {
+	claims, err := s.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.AuthService.Logout(ctx, claims, req.GetRefreshToken())
+
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to logout: %v", err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *ServerAuth) LogoutAll(ctx context.Context, _ *auth_v1.LogoutAllRequest) (*emptypb.Empty, error) {
+	claims, err := s.authorizeUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.AuthService.LogoutAll(ctx, claims.UserId)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to logout: %v", err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *ServerAuth) EnrollMfa(ctx context.Context, _ *auth_v1.EnrollMfaRequest) (*auth_v1.EnrollMfaResponse, error) {
+	claims, err := s.authorizeUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	enrollment, err := s.MfaService.Enroll(ctx, claims.UserId)
+
+	if err != nil {
+		return nil, mfaError(err)
+	}
+
+	return &auth_v1.EnrollMfaResponse{Secret: enrollment.Secret, Uri: enrollment.Uri}, nil
+}
+
+func (s *ServerAuth) ConfirmMfa(ctx context.Context, req *auth_v1.ConfirmMfaRequest) (*auth_v1.ConfirmMfaResponse, error) {
+	claims, err := s.authorizeUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	recoveryCodes, err := s.MfaService.Confirm(ctx, claims.UserId, req.GetCode())
+
+	if err != nil {
+		return nil, mfaError(err)
+	}
+
+	return &auth_v1.ConfirmMfaResponse{RecoveryCodes: recoveryCodes}, nil
+}
+
+func (s *ServerAuth) DisableMfa(ctx context.Context, req *auth_v1.DisableMfaRequest) (*emptypb.Empty, error) {
+	claims, err := s.authorizeUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.MfaService.Disable(ctx, claims.UserId, req.GetCode())
+
+	if err != nil {
+		return nil, mfaError(err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *ServerAuth) ListSessions(ctx context.Context, _ *auth_v1.ListSessionsRequest) (*auth_v1.ListSessionsResponse, error) {
+	claims, err := s.authorizeUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := s.SessionService.List(ctx, claims.UserId)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list sessions: %v", err)
+	}
+
+	res := &auth_v1.ListSessionsResponse{Sessions: make([]*auth_v1.Session, 0, len(sessions))}
+
+	for _, session := range sessions {
+		res.Sessions = append(res.Sessions, converter.ToSessionFromService(session, claims.FamilyId))
+	}
+
+	return res, nil
+}
+
+func (s *ServerAuth) RevokeSession(ctx context.Context, req *auth_v1.RevokeSessionRequest) (*emptypb.Empty, error) {
+	claims, err := s.authorizeUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.SessionService.Revoke(ctx, claims.UserId, req.GetSessionId())
+
+	if errors.Is(err, sessionService.ErrSessionNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to revoke session: %v", err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *ServerAuth) BeginPasskeyRegistration(ctx context.Context, _ *auth_v1.BeginPasskeyRegistrationRequest) (*auth_v1.BeginPasskeyRegistrationResponse, error) {
+	claims, err := s.authorizeUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := s.WebAuthnService.BeginRegistration(ctx, claims.UserId)
+
+	if err != nil {
+		return nil, webAuthnError(err)
+	}
+
+	encoded, err := json.Marshal(options)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to encode passkey options: %v", err)
+	}
+
+	return &auth_v1.BeginPasskeyRegistrationResponse{Options: string(encoded)}, nil
+}
+
+func (s *ServerAuth) FinishPasskeyRegistration(ctx context.Context, req *auth_v1.FinishPasskeyRegistrationRequest) (*auth_v1.Passkey, error) {
+	claims, err := s.authorizeUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &model.WebAuthnRegistrationResponse{}
+
+	if err = json.Unmarshal([]byte(req.GetCredential()), response); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "credential must be a RegistrationResponseJSON")
+	}
+
+	credential, err := s.WebAuthnService.FinishRegistration(ctx, claims.UserId, req.GetName(), response)
+
+	if err != nil {
+		return nil, webAuthnError(err)
+	}
+
+	return converter.ToPasskeyFromService(credential), nil
+}
+
+func (s *ServerAuth) BeginPasskeyLogin(ctx context.Context, req *auth_v1.BeginPasskeyLoginRequest) (*auth_v1.BeginPasskeyLoginResponse, error) {
+	options, err := s.WebAuthnService.BeginLogin(ctx, req.GetEmail())
+
+	if err != nil {
+		return nil, webAuthnError(err)
+	}
+
+	encoded, err := json.Marshal(options)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to encode passkey options: %v", err)
+	}
+
+	return &auth_v1.BeginPasskeyLoginResponse{Options: string(encoded)}, nil
+}
+
+// FinishPasskeyLogin a verified passkey is both factors, the second factor is not asked for
+func (s *ServerAuth) FinishPasskeyLogin(ctx context.Context, req *auth_v1.FinishPasskeyLoginRequest) (*auth_v1.LoginResponse, error) {
+	response := &model.WebAuthnAssertionResponse{}
+
+	if err := json.Unmarshal([]byte(req.GetCredential()), response); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "credential must be an AuthenticationResponseJSON")
+	}
+
+	user, err := s.WebAuthnService.FinishLogin(ctx, response)
+
+	if err != nil {
+		return nil, webAuthnError(err)
+	}
+
+	return s.startSession(ctx, model.UserJwt{
+		UserId:    user.Id,
+		UserLogin: user.Name,
+		Role:      user.Role,
+		Amr:       []string{model.AmrPasskey},
+	})
+}
+
+func (s *ServerAuth) ListPasskeys(ctx context.Context, _ *auth_v1.ListPasskeysRequest) (*auth_v1.ListPasskeysResponse, error) {
+	claims, err := s.authorizeUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := s.WebAuthnService.List(ctx, claims.UserId)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list passkeys: %v", err)
+	}
+
+	res := &auth_v1.ListPasskeysResponse{Passkeys: make([]*auth_v1.Passkey, 0, len(credentials))}
+
+	for _, credential := range credentials {
+		res.Passkeys = append(res.Passkeys, converter.ToPasskeyFromService(credential))
+	}
+
+	return res, nil
+}
+
+func (s *ServerAuth) DeletePasskey(ctx context.Context, req *auth_v1.DeletePasskeyRequest) (*emptypb.Empty, error) {
+	claims, err := s.authorizeUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	credentialId, err := utils.WebAuthnEncoding.DecodeString(req.GetCredentialId())
+
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "credential id must be base64url encoded")
+	}
+
+	err = s.WebAuthnService.Delete(ctx, claims.UserId, credentialId)
+
+	if err != nil {
+		return nil, webAuthnError(err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *ServerAuth) RequestPasswordReset(ctx context.Context, req *auth_v1.RequestPasswordResetRequest) (*emptypb.Empty, error) {
+	err := s.ResetService.Request(ctx, req.GetEmail())
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to request password reset: %v", err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *ServerAuth) ResetPassword(ctx context.Context, req *auth_v1.ResetPasswordRequest) (*emptypb.Empty, error) {
+	err := s.ResetService.Reset(ctx, req.GetToken(), req.GetPassword())
+
+	if errors.Is(err, resetService.ErrInvalidToken) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var violation *policyService.ViolationError
+	if errors.As(err, &violation) {
+		return nil, converter.ToStatusFromPasswordViolations(violation.Violations).Err()
+	}
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to reset password: %v", err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *ServerAuth) RequestMagicLink(ctx context.Context, req *auth_v1.RequestMagicLinkRequest) (*auth_v1.RequestMagicLinkResponse, error) {
+	nonce, err := s.MagicService.Request(ctx, req.GetEmail())
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to request login link: %v", err)
+	}
+
+	return &auth_v1.RequestMagicLinkResponse{Nonce: nonce}, nil
+}
+
+// ConsumeMagicLink a link only proves access to the mailbox, users with a second factor get an MFA challenge
+// like after the password, the session then has both otp and mfa
+func (s *ServerAuth) ConsumeMagicLink(ctx context.Context, req *auth_v1.ConsumeMagicLinkRequest) (*auth_v1.LoginResponse, error) {
+	user, err := s.MagicService.Consume(ctx, req.GetToken(), req.GetNonce())
+
+	if errors.Is(err, magicService.ErrInvalidLink) {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to sign in with login link: %v", err)
+	}
+
+	return s.finishLogin(ctx, model.UserJwt{
+		UserId:    user.Id,
+		UserLogin: user.Name,
+		Role:      user.Role,
+		Amr:       []string{model.AmrOtp},
+	})
+}
+
+// CreateApiKey the caller needs an access token, API keys can not issue more keys
+func (s *ServerAuth) CreateApiKey(ctx context.Context, req *auth_v1.CreateApiKeyRequest) (*auth_v1.CreateApiKeyResponse, error) {
+	claims, err := s.authorizeUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	key, secret, err := s.ApiKeyService.Create(ctx, claims.UserId, converter.ToApiKeyFromCreateRequest(req))
+
+	if errors.Is(err, apiKeyService.ErrMalformedScope) || errors.Is(err, apiKeyService.ErrApiKeyTTL) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to create api key: %v", err)
+	}
+
+	return &auth_v1.CreateApiKeyResponse{
+		ApiKey: converter.ToApiKeyFromService(key),
+		Key:    secret,
+	}, nil
+}
+
+func (s *ServerAuth) ListApiKeys(ctx context.Context, _ *auth_v1.ListApiKeysRequest) (*auth_v1.ListApiKeysResponse, error) {
+	claims, err := s.authorizeUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := s.ApiKeyService.List(ctx, claims.UserId)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list api keys: %v", err)
+	}
+
+	res := &auth_v1.ListApiKeysResponse{ApiKeys: make([]*auth_v1.ApiKey, 0, len(keys))}
+
+	for _, key := range keys {
+		res.ApiKeys = append(res.ApiKeys, converter.ToApiKeyFromService(key))
+	}
+
+	return res, nil
+}
+
+func (s *ServerAuth) RevokeApiKey(ctx context.Context, req *auth_v1.RevokeApiKeyRequest) (*emptypb.Empty, error) {
+	claims, err := s.authorizeUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.ApiKeyService.Revoke(ctx, claims.UserId, req.GetId())
+
+	if errors.Is(err, apiKeyService.ErrApiKeyNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to revoke api key: %v", err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *ServerAuth) Introspect(ctx context.Context, req *auth_v1.IntrospectRequest) (*auth_v1.IntrospectResponse, error) {
+	_, err := s.ClientService.Authenticate(ctx, req.GetClientId(), req.GetClientSecret())
+
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	introspection := s.AuthService.Introspect(ctx, req.GetToken(), req.GetTokenTypeHint())
+
+	return converter.ToIntrospectResponse(introspection), nil
+}
+
+func (s *ServerAuth) ClientCredentials(ctx context.Context, req *auth_v1.ClientCredentialsRequest) (*auth_v1.ClientCredentialsResponse, error) {
+	client, err := s.ClientService.Authenticate(ctx, req.GetClientId(), req.GetClientSecret())
+
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	token, err := s.AuthService.IssueClientToken(ctx, client, req.GetScopes())
+
+	if errors.Is(err, authService.ErrInvalidScope) {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	if err != nil {
+		return nil, errors.New("failed to generate access token")
+	}
+
+	return &auth_v1.ClientCredentialsResponse{
+		AccessToken: token.AccessToken,
+		ExpiresIn:   token.ExpiresIn,
+		Scopes:      strings.Fields(token.Scope),
+	}, nil
+}
+
+func (s *ServerAuth) CreateClient(ctx context.Context, req *auth_v1.CreateClientRequest) (*auth_v1.CreateClientResponse, error) {
+	client, secret, err := s.ClientService.Create(ctx, converter.ToClientFromCreateRequest(req))
+
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to create client: %v", err)
+	}
+
+	return &auth_v1.CreateClientResponse{
+		Client:       converter.ToClientFromService(client),
+		ClientSecret: secret,
+	}, nil
+}
+
+func (s *ServerAuth) GetClient(ctx context.Context, req *auth_v1.GetClientRequest) (*auth_v1.Client, error) {
+	client, err := s.ClientService.Get(ctx, req.GetId())
+
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	return converter.ToClientFromService(client), nil
+}
+
+func (s *ServerAuth) ListClients(ctx context.Context, req *auth_v1.ListClientsRequest) (*auth_v1.ListClientsResponse, error) {
+	clients, err := s.ClientService.List(ctx, req.GetTeam())
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list clients: %v", err)
+	}
+
+	res := &auth_v1.ListClientsResponse{Clients: make([]*auth_v1.Client, 0, len(clients))}
+
+	for _, client := range clients {
+		res.Clients = append(res.Clients, converter.ToClientFromService(client))
+	}
+
+	return res, nil
+}
+
+func (s *ServerAuth) UpdateClient(ctx context.Context, req *auth_v1.UpdateClientRequest) (*emptypb.Empty, error) {
+	err := s.ClientService.Update(ctx, converter.ToClientFromUpdateRequest(req))
+
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to update client: %v", err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *ServerAuth) DeleteClient(ctx context.Context, req *auth_v1.DeleteClientRequest) (*emptypb.Empty, error) {
+	err := s.ClientService.Delete(ctx, req.GetId())
+
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// Impersonate the caller is the admin from the access token checked by AuthInterceptor
+func (s *ServerAuth) Impersonate(ctx context.Context, req *auth_v1.ImpersonateRequest) (*auth_v1.ImpersonateResponse, error) {
+	claims, ok := interceptor.ClaimsFromContext(ctx)
+
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "an access token is required")
+	}
+
+	token, err := s.ImpersonationService.Impersonate(ctx, claims, req.GetUserId())
+
+	if errors.Is(err, impersonationService.ErrImpersonationDenied) {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	if errors.Is(err, impersonationService.ErrUserNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to impersonate user: %v", err)
+	}
+
+	return &auth_v1.ImpersonateResponse{
+		AccessToken: token.AccessToken,
+		ExpiresIn:   token.ExpiresIn,
+	}, nil
+}
+
+func (s *ServerAuth) authorize(ctx context.Context) (model.UserClaims, error) {
+	accessToken, err := utils.GetBearerToken(ctx)
+	if err != nil {
+		return model.UserClaims{}, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	claims, err := s.AuthService.VerifyAccessToken(ctx, accessToken)
+	if err != nil {
+		return model.UserClaims{}, status.Errorf(codes.Unauthenticated, "access token is invalid")
+	}
+
+	return claims, nil
+}
+
+// authorizeUser client tokens are not bound to a user, so user endpoints reject them
+func (s *ServerAuth) authorizeUser(ctx context.Context) (model.UserClaims, error) {
+	claims, err := s.authorize(ctx)
+	if err != nil {
+		return model.UserClaims{}, err
+	}
+
+	if claims.ClientId != "" {
+		return model.UserClaims{}, status.Error(codes.InvalidArgument, "client tokens are not bound to a user")
+	}
+
+	if claims.Act != nil {
+		return model.UserClaims{}, status.Error(codes.PermissionDenied, "impersonation tokens can not manage the credentials of the user")
+	}
+
+	return claims, nil
+}
+
+func (s *ServerAuth) startSession(ctx context.Context, claims model.UserJwt) (*auth_v1.LoginResponse, error) {
+	token, err := s.AuthService.StartSession(ctx, claims)
+
+	if err != nil {
+		return nil, errors.New("failed to generate tokens")
+	}
+
+	return &auth_v1.LoginResponse{RefreshToken: token.RefreshToken, AccessToken: token.AccessToken}, nil
+}
+
+func mfaError(err error) error {
+	switch {
+	case errors.Is(err, mfaService.ErrInvalidCode):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, mfaService.ErrTooManyAttempts):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, mfaService.ErrAlreadyEnabled):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, mfaService.ErrNotEnrolled), errors.Is(err, mfaService.ErrMfaUnavailable):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	return status.Errorf(codes.Internal, "mfa failed: %v", err)
+}
+
+func webAuthnError(err error) error {
+	switch {
+	case errors.Is(err, webauthnService.ErrInvalidCredential),
+		errors.Is(err, webauthnService.ErrInvalidChallenge),
+		errors.Is(err, webauthnService.ErrSignCount):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, webauthnService.ErrCredentialExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, webauthnService.ErrCredentialNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, webauthnService.ErrWebAuthnUnavailable):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	return status.Errorf(codes.Internal, "passkey failed: %v", err)
+}
@@ -0,0 +1,81 @@
+package test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/laiker/auth/internal/api/auth"
+	"github.com/laiker/auth/internal/interceptor"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/service"
+	userService "github.com/laiker/auth/internal/service/user"
+	"github.com/laiker/auth/internal/utils"
+	"github.com/laiker/auth/pkg/auth_v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// lockoutStub records the addresses the attempts are counted for
+type lockoutStub struct {
+	service.LockoutService
+	checked []string
+	failed  []string
+}
+
+func (l *lockoutStub) Check(_ context.Context, _ string, ip string) error {
+	l.checked = append(l.checked, ip)
+	return nil
+}
+
+func (l *lockoutStub) Fail(_ context.Context, _ string, ip string) error {
+	l.failed = append(l.failed, ip)
+	return nil
+}
+
+// wrongPassword every password is wrong
+type wrongPassword struct {
+	service.UserService
+}
+
+func (wrongPassword) Authenticate(context.Context, string, string) (*model.User, error) {
+	return nil, userService.ErrInvalidCredentials
+}
+
+// a forged x-forwarded-for neither spreads the guesses over many addresses nor locks out the forged one
+func TestServerAuth_Login_ForgedForwardedFor(t *testing.T) {
+	lockout := &lockoutStub{}
+	s := &auth.ServerAuth{UserService: wrongPassword{}, LockoutService: lockout}
+
+	proxies := utils.TrustedProxies{netip.MustParsePrefix("127.0.0.0/8")}
+	login := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return s.Login(ctx, req.(*auth_v1.LoginRequest))
+	}
+
+	for _, forged := range []string{"192.0.2.1", "192.0.2.2", "203.0.113.7, 192.0.2.3"} {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("198.51.100.9"), Port: 41000}})
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-forwarded-for", forged))
+
+		_, err := interceptor.DeviceInterceptor(proxies)(ctx, &auth_v1.LoginRequest{Email: "alice@example.com", Password: "guess"},
+			&grpc.UnaryServerInfo{FullMethod: "/auth_v1.AuthV1/Login"}, login)
+		if status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("Login() error = %v, want Unauthenticated", err)
+		}
+	}
+
+	for _, ips := range [][]string{lockout.checked, lockout.failed} {
+		if len(ips) != 3 {
+			t.Fatalf("attempts counted for %q, want 3", ips)
+		}
+
+		for _, ip := range ips {
+			if ip != "198.51.100.9" {
+				t.Fatalf("attempts counted for %q, want the peer address", ips)
+			}
+		}
+	}
+}
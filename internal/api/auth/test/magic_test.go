@@ -0,0 +1,134 @@
+package test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/laiker/auth/internal/api/auth"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/service"
+	authService "github.com/laiker/auth/internal/service/auth"
+	mfaService "github.com/laiker/auth/internal/service/mfa"
+	"github.com/laiker/auth/pkg/auth_v1"
+	"github.com/pkg/errors"
+)
+
+const mfaToken = "mfa-token"
+
+// sessionStub keeps the claims of the challenge it issued and records the sessions started
+type sessionStub struct {
+	service.AuthService
+	challenged *model.UserJwt
+	consumed   bool
+	sessions   []model.UserJwt
+}
+
+func (s *sessionStub) IssueMfaChallenge(_ context.Context, claims model.UserJwt) (string, error) {
+	s.challenged = &claims
+
+	return mfaToken, nil
+}
+
+func (s *sessionStub) VerifyMfaChallenge(_ context.Context, token string) (model.UserClaims, error) {
+	if token != mfaToken || s.challenged == nil || s.consumed {
+		return model.UserClaims{}, errors.New("mfa token is invalid")
+	}
+
+	return model.UserClaims{UserId: s.challenged.UserId, UserLogin: s.challenged.UserLogin, Role: s.challenged.Role, Amr: s.challenged.Amr}, nil
+}
+
+func (s *sessionStub) ConsumeMfaChallenge(context.Context, model.UserClaims) error {
+	if s.consumed {
+		return authService.ErrTokenRevoked
+	}
+
+	s.consumed = true
+
+	return nil
+}
+
+func (s *sessionStub) StartSession(_ context.Context, claims model.UserJwt) (*model.OAuthToken, error) {
+	s.sessions = append(s.sessions, claims)
+
+	return &model.OAuthToken{AccessToken: "access", RefreshToken: "refresh"}, nil
+}
+
+// magicStub every link signs alice in
+type magicStub struct {
+	service.MagicLinkService
+}
+
+func (magicStub) Consume(context.Context, string, string) (*model.User, error) {
+	return &model.User{Id: 7, Name: "alice", Role: "user"}, nil
+}
+
+// mfaStub alice enrolled a second factor or not, a single code is accepted
+type mfaStub struct {
+	service.MfaService
+	enabled bool
+}
+
+func (m mfaStub) IsEnabled(context.Context, int64) (bool, error) {
+	return m.enabled, nil
+}
+
+func (m mfaStub) Verify(_ context.Context, _ int64, code string) error {
+	if code != "123456" {
+		return mfaService.ErrInvalidCode
+	}
+
+	return nil
+}
+
+func TestServerAuth_ConsumeMagicLink(t *testing.T) {
+	ctx := context.Background()
+	sessions := &sessionStub{}
+	s := &auth.ServerAuth{AuthService: sessions, MagicService: magicStub{}, MfaService: mfaStub{}}
+
+	res, err := s.ConsumeMagicLink(ctx, &auth_v1.ConsumeMagicLinkRequest{Token: "token", Nonce: "nonce"})
+	if err != nil {
+		t.Fatalf("ConsumeMagicLink() error = %v", err)
+	}
+
+	if res.MfaRequired || res.AccessToken == "" || len(sessions.sessions) != 1 || !slices.Equal(sessions.sessions[0].Amr, []string{model.AmrOtp}) {
+		t.Fatalf("response %+v, sessions %+v", res, sessions.sessions)
+	}
+}
+
+// a link only proves access to the mailbox, it does not replace the second factor
+func TestServerAuth_ConsumeMagicLink_Mfa(t *testing.T) {
+	ctx := context.Background()
+	sessions := &sessionStub{}
+	s := &auth.ServerAuth{AuthService: sessions, MagicService: magicStub{}, MfaService: mfaStub{enabled: true}}
+
+	res, err := s.ConsumeMagicLink(ctx, &auth_v1.ConsumeMagicLinkRequest{Token: "token", Nonce: "nonce"})
+	if err != nil {
+		t.Fatalf("ConsumeMagicLink() error = %v", err)
+	}
+
+	if !res.MfaRequired || res.MfaToken != mfaToken || res.AccessToken != "" || len(sessions.sessions) != 0 {
+		t.Fatalf("a user with a second factor signed in with a link alone: %+v", res)
+	}
+
+	if !slices.Equal(sessions.challenged.Amr, []string{model.AmrOtp}) {
+		t.Fatalf("challenge amr %q, want the link", sessions.challenged.Amr)
+	}
+
+	if _, err = s.VerifyMfa(ctx, &auth_v1.VerifyMfaRequest{MfaToken: mfaToken, Code: "000000"}); err == nil {
+		t.Fatal("a wrong code finished the login")
+	}
+
+	if _, err = s.VerifyMfa(ctx, &auth_v1.VerifyMfaRequest{MfaToken: mfaToken, Code: "123456"}); err != nil {
+		t.Fatalf("VerifyMfa() error = %v", err)
+	}
+
+	if len(sessions.sessions) != 1 || !slices.Equal(sessions.sessions[0].Amr, []string{model.AmrOtp, model.AmrMfa}) {
+		t.Fatalf("sessions %+v", sessions.sessions)
+	}
+
+	// the challenge is spent with the first accepted code
+	if _, err = s.VerifyMfa(ctx, &auth_v1.VerifyMfaRequest{MfaToken: mfaToken, Code: "123456"}); err == nil || len(sessions.sessions) != 1 {
+		t.Fatalf("a challenge started a second session, error = %v", err)
+	}
+}
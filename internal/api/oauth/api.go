@@ -0,0 +1,295 @@
+package oauth
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/service"
+	authService "github.com/laiker/auth/internal/service/auth"
+	deviceService "github.com/laiker/auth/internal/service/device"
+	"github.com/pkg/errors"
+)
+
+// ServerOAuth plain HTTP endpoints served next to the grpc-gateway
+type ServerOAuth struct {
+	AuthService    service.AuthService
+	ClientService  service.ClientService
+	UserService    service.UserService
+	LockoutService service.LockoutService
+	DeviceService  service.DeviceService
+	MfaService     service.MfaService
+	Logger         *slog.Logger
+}
+
+func NewOAuthServer(
+	AuthService service.AuthService,
+	ClientService service.ClientService,
+	UserService service.UserService,
+	LockoutService service.LockoutService,
+	DeviceService service.DeviceService,
+	MfaService service.MfaService,
+	Logger *slog.Logger,
+) *ServerOAuth {
+	return &ServerOAuth{
+		AuthService:    AuthService,
+		ClientService:  ClientService,
+		UserService:    UserService,
+		LockoutService: LockoutService,
+		DeviceService:  DeviceService,
+		MfaService:     MfaService,
+		Logger:         Logger,
+	}
+}
+
+// JWKS serves the public keys of access tokens at /.well-known/jwks.json
+func (s *ServerOAuth) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	s.writeJSON(w, http.StatusOK, s.AuthService.GetJWKS(r.Context()))
+}
+
+// OpenIDConfiguration serves the discovery document at /.well-known/openid-configuration
+func (s *ServerOAuth) OpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	s.writeJSON(w, http.StatusOK, s.AuthService.GetOpenIDConfiguration(r.Context()))
+}
+
+// UserInfo OpenID Connect userinfo endpoint, the bearer token must carry the openid scope
+func (s *ServerOAuth) UserInfo(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="oauth"`)
+		s.writeError(w, http.StatusUnauthorized, "invalid_token")
+		return
+	}
+
+	claims, err := s.AuthService.VerifyAccessToken(r.Context(), token)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="oauth", error="invalid_token"`)
+		s.writeError(w, http.StatusUnauthorized, "invalid_token")
+		return
+	}
+
+	if claims.ClientId != "" || !model.HasScope(claims.Scope, "openid") {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="oauth", error="insufficient_scope", scope="openid"`)
+		s.writeError(w, http.StatusForbidden, "insufficient_scope")
+		return
+	}
+
+	user, err := s.UserService.Get(r.Context(), claims.UserId)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="oauth", error="invalid_token"`)
+		s.writeError(w, http.StatusUnauthorized, "invalid_token")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	s.writeJSON(w, http.StatusOK, model.NewOpenIDUserInfo(user, claims.Scope))
+}
+
+// Introspect RFC 7662 token introspection, clients authenticate with HTTP Basic or form credentials
+func (s *ServerOAuth) Introspect(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	// public clients can not prove their identity, so they may not introspect
+	client, err := s.authenticateClient(r)
+	if err != nil || client.Public {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		s.writeError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		s.writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	introspection := s.AuthService.Introspect(r.Context(), token, r.PostForm.Get("token_type_hint"))
+
+	w.Header().Set("Cache-Control", "no-store")
+	s.writeJSON(w, http.StatusOK, introspection)
+}
+
+// Token RFC 6749 token endpoint
+func (s *ServerOAuth) Token(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	client, err := s.authenticateClient(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		s.writeError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	var token *model.OAuthToken
+
+	// sessions started here belong to the caller of the token endpoint, the backend of confidential clients,
+	// the device was resolved from the peer by the device middleware
+	ctx := r.Context()
+
+	switch r.PostForm.Get("grant_type") {
+	case "client_credentials":
+		if client.Public {
+			s.writeError(w, http.StatusBadRequest, "unauthorized_client")
+			return
+		}
+
+		token, err = s.AuthService.IssueClientToken(ctx, client, strings.Fields(r.PostForm.Get("scope")))
+	case "authorization_code":
+		token, err = s.AuthService.ExchangeAuthorizationCode(
+			ctx,
+			client,
+			r.PostForm.Get("code"),
+			r.PostForm.Get("redirect_uri"),
+			r.PostForm.Get("code_verifier"),
+		)
+	case "refresh_token":
+		token, err = s.AuthService.RefreshClientToken(ctx, client, r.PostForm.Get("refresh_token"))
+	case model.GrantTypeTokenExchange:
+		if client.Public {
+			s.writeError(w, http.StatusBadRequest, "unauthorized_client")
+			return
+		}
+
+		exchange, errCode := tokenExchangeOf(r.PostForm)
+		if errCode != "" {
+			s.writeError(w, http.StatusBadRequest, errCode)
+			return
+		}
+
+		token, err = s.AuthService.ExchangeToken(ctx, client, exchange)
+	case model.GrantTypeDeviceCode:
+		deviceCode := r.PostForm.Get("device_code")
+		if deviceCode == "" {
+			s.writeError(w, http.StatusBadRequest, "invalid_request")
+			return
+		}
+
+		token, err = s.DeviceService.Poll(ctx, client, deviceCode)
+	default:
+		s.writeError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	if code := tokenErrorCode(err); code != "" {
+		s.writeError(w, http.StatusBadRequest, code)
+		return
+	}
+
+	if err != nil {
+		s.Logger.Error("failed to issue token", "error", err)
+		s.writeError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	s.writeJSON(w, http.StatusOK, token)
+}
+
+// tokenErrors token endpoint errors of RFC 6749 5.2, RFC 8693 2.2.2 and RFC 8628 3.5
+var tokenErrors = []struct {
+	err  error
+	code string
+}{
+	{err: authService.ErrInvalidScope, code: "invalid_scope"},
+	{err: authService.ErrInvalidGrant, code: "invalid_grant"},
+	{err: authService.ErrInvalidTarget, code: "invalid_target"},
+	{err: deviceService.ErrAuthorizationPending, code: "authorization_pending"},
+	{err: deviceService.ErrSlowDown, code: "slow_down"},
+	{err: deviceService.ErrAccessDenied, code: "access_denied"},
+	{err: deviceService.ErrExpiredToken, code: "expired_token"},
+	{err: deviceService.ErrInvalidGrant, code: "invalid_grant"},
+}
+
+// tokenErrorCode the error answered with 400, empty for success and for server errors
+func tokenErrorCode(err error) string {
+	for _, e := range tokenErrors {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+
+	return ""
+}
+
+// tokenExchangeOf RFC 8693 2.1 request parameters, only access tokens are exchanged and only
+// for a single audience, the error code is empty for a valid request
+func tokenExchangeOf(form url.Values) (model.TokenExchange, string) {
+	if form.Get("subject_token") == "" || form.Get("subject_token_type") != model.TokenTypeUriAccessToken {
+		return model.TokenExchange{}, "invalid_request"
+	}
+
+	// actor tokens are not supported, the authenticated client is the actor
+	if form.Get("actor_token") != "" {
+		return model.TokenExchange{}, "invalid_request"
+	}
+
+	if requested := form.Get("requested_token_type"); requested != "" && requested != model.TokenTypeUriAccessToken {
+		return model.TokenExchange{}, "invalid_request"
+	}
+
+	if len(form["audience"]) != 1 || form.Get("audience") == "" || len(form["resource"]) > 0 {
+		return model.TokenExchange{}, "invalid_target"
+	}
+
+	return model.TokenExchange{
+		SubjectToken: form.Get("subject_token"),
+		Audience:     form.Get("audience"),
+		Scopes:       strings.Fields(form.Get("scope")),
+	}, ""
+}
+
+// authenticateClient reads client_secret_basic or client_secret_post credentials (RFC 6749 2.3.1),
+// public clients only send their client_id
+func (s *ServerOAuth) authenticateClient(r *http.Request) (*model.Client, error) {
+	clientId, secret, ok := r.BasicAuth()
+
+	if ok {
+		var err error
+
+		// Basic credentials are form-urlencoded before being base64 encoded
+		clientId, err = url.QueryUnescape(clientId)
+		if err != nil {
+			return nil, err
+		}
+
+		secret, err = url.QueryUnescape(secret)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		clientId, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	if secret == "" {
+		return s.ClientService.Identify(r.Context(), clientId)
+	}
+
+	return s.ClientService.Authenticate(r.Context(), clientId, secret)
+}
+
+func (s *ServerOAuth) writeError(w http.ResponseWriter, code int, oauthError string) {
+	s.writeJSON(w, code, map[string]string{"error": oauthError})
+}
+
+func (s *ServerOAuth) writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		s.Logger.Error("failed to write response", "error", err)
+	}
+}
@@ -0,0 +1,297 @@
+package oauth
+
+import (
+	"embed"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/laiker/auth/internal/model"
+	lockoutService "github.com/laiker/auth/internal/service/lockout"
+	mfaService "github.com/laiker/auth/internal/service/mfa"
+	verificationService "github.com/laiker/auth/internal/service/verification"
+	"github.com/laiker/auth/internal/utils"
+	"github.com/pkg/errors"
+)
+
+//go:embed templates/*.html
+var templates embed.FS
+
+var authorizeTemplate = template.Must(template.ParseFS(templates, "templates/authorize.html"))
+
+// authorizeParams request parameters carried through the login form
+var authorizeParams = []string{
+	"response_type",
+	"client_id",
+	"redirect_uri",
+	"scope",
+	"state",
+	"code_challenge",
+	"code_challenge_method",
+	"nonce",
+}
+
+type authorizeRequest struct {
+	client *model.Client
+	params url.Values
+	scopes []string
+}
+
+type authorizePage struct {
+	ClientName string
+	Scopes     []string
+	Params     map[string]string
+	Email      string
+	Error      string
+}
+
+// Authorize renders the login and consent page of the authorization code flow
+func (s *ServerOAuth) Authorize(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.parseAuthorizeRequest(w, r, r.URL.Query())
+	if !ok {
+		return
+	}
+
+	s.renderAuthorize(w, http.StatusOK, req, "", "")
+}
+
+// Approve handles the submitted login form and redirects back to the client with a code.
+// Users with a second factor enter its code as well, the code carries the methods the user signed in with.
+func (s *ServerOAuth) Approve(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	req, ok := s.parseAuthorizeRequest(w, r, r.PostForm)
+	if !ok {
+		return
+	}
+
+	if r.PostForm.Get("action") != "allow" {
+		s.redirectError(w, r, req.params, "access_denied", "the user denied the request")
+		return
+	}
+
+	email := r.PostForm.Get("email")
+
+	user, failure := s.signIn(r, email, r.PostForm.Get("password"))
+	if failure != nil && failure.code == http.StatusInternalServerError {
+		s.redirectError(w, r, req.params, "server_error", "")
+		return
+	}
+
+	if failure != nil {
+		s.renderAuthorize(w, failure.code, req, email, failure.message)
+		return
+	}
+
+	amr, failure := s.secondFactor(r, user.Id)
+	if failure != nil && failure.code == http.StatusInternalServerError {
+		s.redirectError(w, r, req.params, "server_error", "")
+		return
+	}
+
+	if failure != nil {
+		s.renderAuthorize(w, failure.code, req, email, failure.message)
+		return
+	}
+
+	code, err := s.AuthService.CreateAuthorizationCode(r.Context(), &model.AuthorizationCode{
+		ClientId:      req.client.Id,
+		UserId:        user.Id,
+		UserLogin:     user.Name,
+		Role:          user.Role,
+		RedirectUri:   req.params.Get("redirect_uri"),
+		Scope:         strings.Join(req.scopes, " "),
+		CodeChallenge: req.params.Get("code_challenge"),
+		Nonce:         req.params.Get("nonce"),
+		Amr:           amr,
+	})
+	if err != nil {
+		s.Logger.Error("failed to create authorization code", "error", err)
+		s.redirectError(w, r, req.params, "server_error", "")
+		return
+	}
+
+	s.redirect(w, r, req.params, url.Values{"code": {code}})
+}
+
+// parseAuthorizeRequest validates the request, errors are only redirected to a registered redirect uri
+func (s *ServerOAuth) parseAuthorizeRequest(w http.ResponseWriter, r *http.Request, values url.Values) (*authorizeRequest, bool) {
+	params := url.Values{}
+	for _, name := range authorizeParams {
+		if value := values.Get(name); value != "" {
+			params.Set(name, value)
+		}
+	}
+
+	client, err := s.ClientService.Get(r.Context(), params.Get("client_id"))
+	if err != nil {
+		http.Error(w, "unknown client", http.StatusBadRequest)
+		return nil, false
+	}
+
+	if !slices.Contains(client.RedirectUris, params.Get("redirect_uri")) {
+		http.Error(w, "redirect_uri is not registered for the client", http.StatusBadRequest)
+		return nil, false
+	}
+
+	if params.Get("response_type") != "code" {
+		s.redirectError(w, r, params, "unsupported_response_type", "only the code response type is supported")
+		return nil, false
+	}
+
+	if params.Get("code_challenge_method") != "S256" || !utils.IsCodeChallenge(params.Get("code_challenge")) {
+		s.redirectError(w, r, params, "invalid_request", "PKCE with the S256 method is required")
+		return nil, false
+	}
+
+	scopes := strings.Fields(params.Get("scope"))
+	if !client.AllowsScopes(scopes) {
+		s.redirectError(w, r, params, "invalid_scope", "")
+		return nil, false
+	}
+
+	return &authorizeRequest{client: client, params: params, scopes: scopes}, true
+}
+
+func (s *ServerOAuth) renderAuthorize(w http.ResponseWriter, code int, req *authorizeRequest, email string, errorMessage string) {
+	page := authorizePage{
+		ClientName: req.client.Name,
+		Scopes:     req.scopes,
+		Params:     make(map[string]string, len(req.params)),
+		Email:      email,
+		Error:      errorMessage,
+	}
+
+	for name := range req.params {
+		page.Params[name] = req.params.Get(name)
+	}
+
+	setPageHeaders(w)
+	w.WriteHeader(code)
+
+	err := authorizeTemplate.Execute(w, page)
+	if err != nil {
+		s.Logger.Error("failed to render authorize page", "error", err)
+	}
+}
+
+// signInFailure the HTTP status and the message shown on the page, internal errors are logged and have no message
+type signInFailure struct {
+	code    int
+	message string
+}
+
+// signIn checks the password of a user signing in on one of our pages, failures count towards the lockout
+func (s *ServerOAuth) signIn(r *http.Request, email string, password string) (*model.User, *signInFailure) {
+	ip := utils.DeviceFromContext(r.Context()).Ip
+
+	err := s.LockoutService.Check(r.Context(), email, ip)
+	if errors.Is(err, lockoutService.ErrLocked) {
+		return nil, &signInFailure{code: http.StatusTooManyRequests, message: "Too many failed attempts, try again later"}
+	}
+
+	if err != nil {
+		s.Logger.Error("failed to check login attempts", "error", err)
+		return nil, &signInFailure{code: http.StatusInternalServerError}
+	}
+
+	user, err := s.UserService.Authenticate(r.Context(), email, password)
+	if errors.Is(err, verificationService.ErrEmailNotVerified) {
+		return nil, &signInFailure{code: http.StatusForbidden, message: "Confirm your email before signing in"}
+	}
+
+	if err != nil {
+		if errFail := s.LockoutService.Fail(r.Context(), email, ip); errFail != nil {
+			s.Logger.Error("failed to count login attempt", "error", errFail)
+		}
+
+		return nil, &signInFailure{code: http.StatusUnauthorized, message: "Invalid email or password"}
+	}
+
+	err = s.LockoutService.Succeed(r.Context(), email)
+	if err != nil {
+		s.Logger.Error("failed to reset login attempts", "error", err)
+	}
+
+	return user, nil
+}
+
+// secondFactor checks the one-time code of users who enabled MFA, our pages are a login like any other
+func (s *ServerOAuth) secondFactor(r *http.Request, userId int64) ([]string, *signInFailure) {
+	enabled, err := s.MfaService.IsEnabled(r.Context(), userId)
+	if err != nil {
+		s.Logger.Error("failed to check the second factor", "error", err)
+		return nil, &signInFailure{code: http.StatusInternalServerError}
+	}
+
+	if !enabled {
+		return []string{model.AmrPassword}, nil
+	}
+
+	otp := r.PostForm.Get("otp")
+	if otp == "" {
+		return nil, &signInFailure{code: http.StatusUnauthorized, message: "Enter the code from your authenticator app"}
+	}
+
+	err = s.MfaService.Verify(r.Context(), userId, otp)
+	if errors.Is(err, mfaService.ErrTooManyAttempts) {
+		return nil, &signInFailure{code: http.StatusTooManyRequests, message: "Too many failed attempts, try again later"}
+	}
+
+	if errors.Is(err, mfaService.ErrInvalidCode) || errors.Is(err, mfaService.ErrNotEnrolled) {
+		return nil, &signInFailure{code: http.StatusUnauthorized, message: "Invalid authentication code"}
+	}
+
+	if err != nil {
+		s.Logger.Error("failed to verify the second factor", "error", err)
+		return nil, &signInFailure{code: http.StatusInternalServerError}
+	}
+
+	return []string{model.AmrPassword, model.AmrMfa}, nil
+}
+
+// setPageHeaders our pages ask for credentials, they are never cached or framed
+func setPageHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+}
+
+func (s *ServerOAuth) redirectError(w http.ResponseWriter, r *http.Request, params url.Values, oauthError string, description string) {
+	values := url.Values{"error": {oauthError}}
+
+	if description != "" {
+		values.Set("error_description", description)
+	}
+
+	s.redirect(w, r, params, values)
+}
+
+// redirect sends the user back to the client keeping the query of the registered redirect uri
+func (s *ServerOAuth) redirect(w http.ResponseWriter, r *http.Request, params url.Values, values url.Values) {
+	target, err := url.Parse(params.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	if state := params.Get("state"); state != "" {
+		values.Set("state", state)
+	}
+
+	query := target.Query()
+	for name := range values {
+		query.Set(name, values.Get(name))
+	}
+
+	target.RawQuery = query.Encode()
+
+	http.Redirect(w, r, target.String(), http.StatusSeeOther)
+}
@@ -0,0 +1,164 @@
+package oauth
+
+import (
+	"cmp"
+	"html/template"
+	"net/http"
+	"strings"
+
+	deviceService "github.com/laiker/auth/internal/service/device"
+	"github.com/pkg/errors"
+)
+
+const somethingWentWrong = "Something went wrong, try again"
+
+var deviceTemplate = template.Must(template.ParseFS(templates, "templates/device.html"))
+
+type devicePage struct {
+	UserCode   string
+	ClientName string
+	Scopes     []string
+	Email      string
+	Error      string
+	// Done shown instead of the form once the user decided
+	Done string
+}
+
+// DeviceAuthorization RFC 8628 device authorization endpoint, a CLI or a TV asks for codes to show the user
+func (s *ServerOAuth) DeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	client, err := s.authenticateClient(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		s.writeError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	authorization, err := s.DeviceService.Authorize(r.Context(), client, strings.Fields(r.PostForm.Get("scope")))
+	if errors.Is(err, deviceService.ErrInvalidScope) {
+		s.writeError(w, http.StatusBadRequest, "invalid_scope")
+		return
+	}
+
+	if err != nil {
+		s.Logger.Error("failed to authorize device", "error", err)
+		s.writeError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	s.writeJSON(w, http.StatusOK, authorization)
+}
+
+// Device renders the verification page, the user code is filled in when the device showed the complete uri
+func (s *ServerOAuth) Device(w http.ResponseWriter, r *http.Request) {
+	userCode := r.URL.Query().Get("user_code")
+	if userCode == "" {
+		s.renderDevice(w, http.StatusOK, devicePage{})
+		return
+	}
+
+	page, ok := s.lookupDevice(w, r, userCode)
+	if !ok {
+		return
+	}
+
+	s.renderDevice(w, http.StatusOK, page)
+}
+
+// ApproveDevice handles the submitted verification page, the user signs in to approve the device.
+// Users with a second factor enter its code as well, the device gets the methods the user signed in with.
+func (s *ServerOAuth) ApproveDevice(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	page, ok := s.lookupDevice(w, r, r.PostForm.Get("user_code"))
+	if !ok {
+		return
+	}
+
+	if r.PostForm.Get("action") != "allow" {
+		err = s.DeviceService.Deny(r.Context(), page.UserCode)
+		if err != nil && !errors.Is(err, deviceService.ErrInvalidUserCode) {
+			s.Logger.Error("failed to deny device", "error", err)
+			s.renderDevice(w, http.StatusInternalServerError, devicePage{Error: somethingWentWrong})
+			return
+		}
+
+		s.renderDevice(w, http.StatusOK, devicePage{Done: "The device was denied access, you can close this page"})
+		return
+	}
+
+	page.Email = r.PostForm.Get("email")
+
+	user, failure := s.signIn(r, page.Email, r.PostForm.Get("password"))
+	if failure != nil {
+		page.Error = cmp.Or(failure.message, somethingWentWrong)
+		s.renderDevice(w, failure.code, page)
+		return
+	}
+
+	amr, failure := s.secondFactor(r, user.Id)
+	if failure != nil {
+		page.Error = cmp.Or(failure.message, somethingWentWrong)
+		s.renderDevice(w, failure.code, page)
+		return
+	}
+
+	err = s.DeviceService.Approve(r.Context(), page.UserCode, user.Id, amr)
+	if errors.Is(err, deviceService.ErrInvalidUserCode) {
+		s.renderDevice(w, http.StatusBadRequest, devicePage{Error: "The code is invalid or expired, start again on the device"})
+		return
+	}
+
+	if err != nil {
+		s.Logger.Error("failed to approve device", "error", err)
+		page.Error = somethingWentWrong
+		s.renderDevice(w, http.StatusInternalServerError, page)
+		return
+	}
+
+	s.renderDevice(w, http.StatusOK, devicePage{Done: page.ClientName + " is signed in, you can return to the device"})
+}
+
+// lookupDevice the page of a request awaiting the user, renders an error when there is none
+func (s *ServerOAuth) lookupDevice(w http.ResponseWriter, r *http.Request, userCode string) (devicePage, bool) {
+	code, err := s.DeviceService.Lookup(r.Context(), userCode)
+	if errors.Is(err, deviceService.ErrInvalidUserCode) {
+		s.renderDevice(w, http.StatusBadRequest, devicePage{UserCode: userCode, Error: "The code is invalid or expired"})
+		return devicePage{}, false
+	}
+
+	if err != nil {
+		s.Logger.Error("failed to look up device code", "error", err)
+		s.renderDevice(w, http.StatusInternalServerError, devicePage{UserCode: userCode, Error: somethingWentWrong})
+		return devicePage{}, false
+	}
+
+	client, err := s.ClientService.Get(r.Context(), code.ClientId)
+	if err != nil {
+		s.Logger.Error("failed to get client of device code", "error", err)
+		s.renderDevice(w, http.StatusInternalServerError, devicePage{UserCode: userCode, Error: somethingWentWrong})
+		return devicePage{}, false
+	}
+
+	return devicePage{UserCode: userCode, ClientName: client.Name, Scopes: strings.Fields(code.Scope)}, true
+}
+
+func (s *ServerOAuth) renderDevice(w http.ResponseWriter, code int, page devicePage) {
+	setPageHeaders(w)
+	w.WriteHeader(code)
+
+	err := deviceTemplate.Execute(w, page)
+	if err != nil {
+		s.Logger.Error("failed to render device page", "error", err)
+	}
+}
@@ -0,0 +1,354 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/laiker/auth/internal/api/oauth"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/service"
+	authService "github.com/laiker/auth/internal/service/auth"
+	deviceService "github.com/laiker/auth/internal/service/device"
+	"github.com/pkg/errors"
+)
+
+const (
+	clientId     = "gateway"
+	clientSecret = "s3cret:/+"
+	validToken   = "valid-token"
+)
+
+// authServiceStub answers introspection only, the rest of service.AuthService is not used by the handler
+type authServiceStub struct {
+	service.AuthService
+}
+
+func (authServiceStub) Introspect(_ context.Context, token string, _ string) model.Introspection {
+	if token != validToken {
+		return model.Introspection{Active: false}
+	}
+
+	return model.Introspection{Active: true, Sub: "42", Role: "user", TokenType: "Bearer", Exp: 1700000000}
+}
+
+type clientServiceStub struct {
+	service.ClientService
+}
+
+func (authServiceStub) IssueClientToken(_ context.Context, client *model.Client, scopes []string) (*model.OAuthToken, error) {
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+
+	for _, scope := range scopes {
+		if !slices.Contains(client.Scopes, scope) {
+			return nil, authService.ErrInvalidScope
+		}
+	}
+
+	return &model.OAuthToken{AccessToken: validToken, TokenType: "Bearer", ExpiresIn: 60, Scope: strings.Join(scopes, " ")}, nil
+}
+
+func (authServiceStub) ExchangeToken(_ context.Context, client *model.Client, exchange model.TokenExchange) (*model.OAuthToken, error) {
+	if !client.AllowsAudience(exchange.Audience) {
+		return nil, authService.ErrInvalidTarget
+	}
+
+	if exchange.SubjectToken != validToken {
+		return nil, authService.ErrInvalidGrant
+	}
+
+	return &model.OAuthToken{
+		AccessToken:     validToken,
+		TokenType:       "Bearer",
+		ExpiresIn:       60,
+		Scope:           strings.Join(exchange.Scopes, " "),
+		IssuedTokenType: model.TokenTypeUriAccessToken,
+	}, nil
+}
+
+// deviceServiceStub answers polls by the device code, the rest of service.DeviceService is not used by the handler
+type deviceServiceStub struct {
+	service.DeviceService
+}
+
+var devicePolls = map[string]error{
+	"pending":  deviceService.ErrAuthorizationPending,
+	"too-fast": deviceService.ErrSlowDown,
+	"denied":   deviceService.ErrAccessDenied,
+	"expired":  deviceService.ErrExpiredToken,
+}
+
+func (deviceServiceStub) Poll(_ context.Context, _ *model.Client, deviceCode string) (*model.OAuthToken, error) {
+	if deviceCode == "approved" {
+		return &model.OAuthToken{AccessToken: validToken, TokenType: "Bearer", ExpiresIn: 60}, nil
+	}
+
+	if err, ok := devicePolls[deviceCode]; ok {
+		return nil, err
+	}
+
+	return nil, deviceService.ErrInvalidGrant
+}
+
+func (clientServiceStub) Authenticate(_ context.Context, id string, secret string) (*model.Client, error) {
+	if id != clientId || secret != clientSecret {
+		return nil, errors.New("invalid client credentials")
+	}
+
+	return &model.Client{Id: id, Scopes: []string{"users:read", "users:write"}, ExchangeAudiences: []string{"billing"}}, nil
+}
+
+func TestServerOAuth_Introspect(t *testing.T) {
+	api := oauth.NewOAuthServer(authServiceStub{}, clientServiceStub{}, userServiceStub{}, &lockoutServiceStub{}, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	basic := func(r *http.Request) {
+		r.SetBasicAuth(url.QueryEscape(clientId), url.QueryEscape(clientSecret))
+	}
+
+	tests := []struct {
+		name       string
+		form       url.Values
+		auth       func(r *http.Request)
+		wantCode   int
+		wantBody   map[string]interface{}
+		wantHeader string
+	}{
+		{
+			name:     "active token with basic auth",
+			form:     url.Values{"token": {validToken}},
+			auth:     basic,
+			wantCode: http.StatusOK,
+			wantBody: map[string]interface{}{"active": true, "sub": "42", "role": "user", "token_type": "Bearer", "exp": float64(1700000000)},
+		},
+		{
+			name:     "inactive token with post credentials",
+			form:     url.Values{"token": {"expired"}, "client_id": {clientId}, "client_secret": {clientSecret}},
+			wantCode: http.StatusOK,
+			wantBody: map[string]interface{}{"active": false},
+		},
+		{
+			name:       "wrong secret",
+			form:       url.Values{"token": {validToken}, "client_id": {clientId}, "client_secret": {"nope"}},
+			wantCode:   http.StatusUnauthorized,
+			wantBody:   map[string]interface{}{"error": "invalid_client"},
+			wantHeader: `Basic realm="oauth"`,
+		},
+		{
+			name:     "missing token",
+			form:     url.Values{},
+			auth:     basic,
+			wantCode: http.StatusBadRequest,
+			wantBody: map[string]interface{}{"error": "invalid_request"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/oauth/introspect", strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			if tt.auth != nil {
+				tt.auth(req)
+			}
+
+			rec := httptest.NewRecorder()
+			api.Introspect(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+
+			if got := rec.Header().Get("WWW-Authenticate"); got != tt.wantHeader {
+				t.Errorf("WWW-Authenticate = %q, want %q", got, tt.wantHeader)
+			}
+
+			var body map[string]interface{}
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("invalid JSON: %v", err)
+			}
+
+			if len(body) != len(tt.wantBody) {
+				t.Errorf("body = %v, want %v", body, tt.wantBody)
+			}
+
+			for k, v := range tt.wantBody {
+				if body[k] != v {
+					t.Errorf("body[%q] = %v, want %v", k, body[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestServerOAuth_Token(t *testing.T) {
+	api := oauth.NewOAuthServer(authServiceStub{}, clientServiceStub{}, userServiceStub{}, &lockoutServiceStub{}, deviceServiceStub{}, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	tests := []struct {
+		name     string
+		form     url.Values
+		wantCode int
+		wantBody map[string]interface{}
+	}{
+		{
+			name:     "client credentials",
+			form:     url.Values{"grant_type": {"client_credentials"}, "scope": {"users:read"}},
+			wantCode: http.StatusOK,
+			wantBody: map[string]interface{}{"access_token": validToken, "token_type": "Bearer", "expires_in": float64(60), "scope": "users:read"},
+		},
+		{
+			name:     "scope outside of the client",
+			form:     url.Values{"grant_type": {"client_credentials"}, "scope": {"users:read admin"}},
+			wantCode: http.StatusBadRequest,
+			wantBody: map[string]interface{}{"error": "invalid_scope"},
+		},
+		{
+			name: "token exchange",
+			form: url.Values{
+				"grant_type":         {model.GrantTypeTokenExchange},
+				"subject_token":      {validToken},
+				"subject_token_type": {model.TokenTypeUriAccessToken},
+				"audience":           {"billing"},
+				"scope":              {"users:read"},
+			},
+			wantCode: http.StatusOK,
+			wantBody: map[string]interface{}{
+				"access_token":      validToken,
+				"token_type":        "Bearer",
+				"expires_in":        float64(60),
+				"scope":             "users:read",
+				"issued_token_type": model.TokenTypeUriAccessToken,
+			},
+		},
+		{
+			name: "token exchange for an audience outside of the policy",
+			form: url.Values{
+				"grant_type":         {model.GrantTypeTokenExchange},
+				"subject_token":      {validToken},
+				"subject_token_type": {model.TokenTypeUriAccessToken},
+				"audience":           {"payroll"},
+			},
+			wantCode: http.StatusBadRequest,
+			wantBody: map[string]interface{}{"error": "invalid_target"},
+		},
+		{
+			name: "token exchange for two audiences",
+			form: url.Values{
+				"grant_type":         {model.GrantTypeTokenExchange},
+				"subject_token":      {validToken},
+				"subject_token_type": {model.TokenTypeUriAccessToken},
+				"audience":           {"billing", "payroll"},
+			},
+			wantCode: http.StatusBadRequest,
+			wantBody: map[string]interface{}{"error": "invalid_target"},
+		},
+		{
+			name: "token exchange of a refresh token",
+			form: url.Values{
+				"grant_type":         {model.GrantTypeTokenExchange},
+				"subject_token":      {validToken},
+				"subject_token_type": {"urn:ietf:params:oauth:token-type:refresh_token"},
+				"audience":           {"billing"},
+			},
+			wantCode: http.StatusBadRequest,
+			wantBody: map[string]interface{}{"error": "invalid_request"},
+		},
+		{
+			name: "token exchange of an invalid token",
+			form: url.Values{
+				"grant_type":         {model.GrantTypeTokenExchange},
+				"subject_token":      {"expired"},
+				"subject_token_type": {model.TokenTypeUriAccessToken},
+				"audience":           {"billing"},
+			},
+			wantCode: http.StatusBadRequest,
+			wantBody: map[string]interface{}{"error": "invalid_grant"},
+		},
+		{
+			name:     "device code approved",
+			form:     url.Values{"grant_type": {model.GrantTypeDeviceCode}, "device_code": {"approved"}},
+			wantCode: http.StatusOK,
+			wantBody: map[string]interface{}{"access_token": validToken, "token_type": "Bearer", "expires_in": float64(60)},
+		},
+		{
+			name:     "device code awaiting the user",
+			form:     url.Values{"grant_type": {model.GrantTypeDeviceCode}, "device_code": {"pending"}},
+			wantCode: http.StatusBadRequest,
+			wantBody: map[string]interface{}{"error": "authorization_pending"},
+		},
+		{
+			name:     "device polling too fast",
+			form:     url.Values{"grant_type": {model.GrantTypeDeviceCode}, "device_code": {"too-fast"}},
+			wantCode: http.StatusBadRequest,
+			wantBody: map[string]interface{}{"error": "slow_down"},
+		},
+		{
+			name:     "device code denied",
+			form:     url.Values{"grant_type": {model.GrantTypeDeviceCode}, "device_code": {"denied"}},
+			wantCode: http.StatusBadRequest,
+			wantBody: map[string]interface{}{"error": "access_denied"},
+		},
+		{
+			name:     "device code expired",
+			form:     url.Values{"grant_type": {model.GrantTypeDeviceCode}, "device_code": {"expired"}},
+			wantCode: http.StatusBadRequest,
+			wantBody: map[string]interface{}{"error": "expired_token"},
+		},
+		{
+			name:     "unknown device code",
+			form:     url.Values{"grant_type": {model.GrantTypeDeviceCode}, "device_code": {"unknown"}},
+			wantCode: http.StatusBadRequest,
+			wantBody: map[string]interface{}{"error": "invalid_grant"},
+		},
+		{
+			name:     "missing device code",
+			form:     url.Values{"grant_type": {model.GrantTypeDeviceCode}},
+			wantCode: http.StatusBadRequest,
+			wantBody: map[string]interface{}{"error": "invalid_request"},
+		},
+		{
+			name:     "unknown grant",
+			form:     url.Values{"grant_type": {"password"}},
+			wantCode: http.StatusBadRequest,
+			wantBody: map[string]interface{}{"error": "unsupported_grant_type"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.SetBasicAuth(url.QueryEscape(clientId), url.QueryEscape(clientSecret))
+
+			rec := httptest.NewRecorder()
+			api.Token(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+
+			var body map[string]interface{}
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("invalid JSON: %v", err)
+			}
+
+			if len(body) != len(tt.wantBody) {
+				t.Errorf("body = %v, want %v", body, tt.wantBody)
+			}
+
+			for k, v := range tt.wantBody {
+				if body[k] != v {
+					t.Errorf("body[%q] = %v, want %v", k, body[k], v)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,371 @@
+package test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/laiker/auth/internal/api/oauth"
+	"github.com/laiker/auth/internal/interceptor"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/service"
+	lockoutService "github.com/laiker/auth/internal/service/lockout"
+	mfaService "github.com/laiker/auth/internal/service/mfa"
+	"github.com/pkg/errors"
+)
+
+const (
+	webClientId   = "web"
+	redirectUri   = "https://app.example.com/callback?tenant=1"
+	codeChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	issuedCode    = "issued-code"
+)
+
+type userServiceStub struct {
+	service.UserService
+}
+
+func (userServiceStub) Authenticate(_ context.Context, email string, password string) (*model.User, error) {
+	if email != "alice@example.com" || password != "password" {
+		return nil, errors.New("invalid credentials")
+	}
+
+	return &model.User{Id: 7, Name: "alice", Role: "user"}, nil
+}
+
+// lockoutServiceStub counts the reported logins, every login is refused while locked is set
+type lockoutServiceStub struct {
+	locked    bool
+	failures  int
+	successes int
+	ip        string
+}
+
+func (l *lockoutServiceStub) Check(_ context.Context, _ string, ip string) error {
+	l.ip = ip
+
+	if l.locked {
+		return lockoutService.ErrLocked
+	}
+
+	return nil
+}
+
+func (l *lockoutServiceStub) Fail(context.Context, string, string) error {
+	l.failures++
+	return nil
+}
+
+func (l *lockoutServiceStub) Succeed(context.Context, string) error {
+	l.successes++
+	return nil
+}
+
+func (l *lockoutServiceStub) Unlock(context.Context, string) error {
+	l.locked = false
+	return nil
+}
+
+// mfaServiceStub the second factor of alice, enabled or not, accepts a single code
+type mfaServiceStub struct {
+	service.MfaService
+	enabled bool
+}
+
+func (m mfaServiceStub) IsEnabled(context.Context, int64) (bool, error) {
+	return m.enabled, nil
+}
+
+func (m mfaServiceStub) Verify(_ context.Context, _ int64, code string) error {
+	if !m.enabled {
+		return mfaService.ErrNotEnrolled
+	}
+
+	if code != "123456" {
+		return mfaService.ErrInvalidCode
+	}
+
+	return nil
+}
+
+func (authServiceStub) CreateAuthorizationCode(_ context.Context, code *model.AuthorizationCode) (string, error) {
+	if code.UserId != 7 || code.ClientId != webClientId || code.CodeChallenge != codeChallenge {
+		return "", errors.New("unexpected authorization code")
+	}
+
+	if !slices.Equal(code.Amr, []string{model.AmrPassword}) && !slices.Equal(code.Amr, []string{model.AmrPassword, model.AmrMfa}) {
+		return "", errors.New("authorization code without the methods of the user")
+	}
+
+	return issuedCode, nil
+}
+
+func (clientServiceStub) Get(_ context.Context, id string) (*model.Client, error) {
+	if id != webClientId {
+		return nil, errors.New("client not found")
+	}
+
+	return &model.Client{
+		Id:           webClientId,
+		Name:         "Web App",
+		Scopes:       []string{"profile"},
+		RedirectUris: []string{redirectUri},
+		Public:       true,
+	}, nil
+}
+
+func authorizeValues() url.Values {
+	return url.Values{
+		"response_type":         {"code"},
+		"client_id":             {webClientId},
+		"redirect_uri":          {redirectUri},
+		"scope":                 {"profile"},
+		"state":                 {"xyz"},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+}
+
+func TestServerOAuth_Authorize(t *testing.T) {
+	api := oauth.NewOAuthServer(authServiceStub{}, clientServiceStub{}, userServiceStub{}, &lockoutServiceStub{}, nil, mfaServiceStub{}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	tests := []struct {
+		name         string
+		modify       func(v url.Values)
+		wantCode     int
+		wantLocation map[string]string
+	}{
+		{
+			name:     "login page",
+			modify:   func(url.Values) {},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "unknown redirect uri",
+			modify:   func(v url.Values) { v.Set("redirect_uri", "https://evil.example.com/") },
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "unknown client",
+			modify:   func(v url.Values) { v.Set("client_id", "nope") },
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:         "missing pkce",
+			modify:       func(v url.Values) { v.Del("code_challenge") },
+			wantCode:     http.StatusSeeOther,
+			wantLocation: map[string]string{"error": "invalid_request", "state": "xyz", "tenant": "1"},
+		},
+		{
+			name:         "plain pkce",
+			modify:       func(v url.Values) { v.Set("code_challenge_method", "plain") },
+			wantCode:     http.StatusSeeOther,
+			wantLocation: map[string]string{"error": "invalid_request", "state": "xyz"},
+		},
+		{
+			name:         "scope outside of the client",
+			modify:       func(v url.Values) { v.Set("scope", "profile admin") },
+			wantCode:     http.StatusSeeOther,
+			wantLocation: map[string]string{"error": "invalid_scope", "state": "xyz"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := authorizeValues()
+			tt.modify(values)
+
+			req := httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+values.Encode(), nil)
+			rec := httptest.NewRecorder()
+			api.Authorize(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+
+			assertLocation(t, rec, tt.wantLocation)
+		})
+	}
+}
+
+func TestServerOAuth_Approve(t *testing.T) {
+	api := oauth.NewOAuthServer(authServiceStub{}, clientServiceStub{}, userServiceStub{}, &lockoutServiceStub{}, nil, mfaServiceStub{}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	tests := []struct {
+		name         string
+		form         url.Values
+		wantCode     int
+		wantLocation map[string]string
+	}{
+		{
+			name:         "allow",
+			form:         url.Values{"action": {"allow"}, "email": {"alice@example.com"}, "password": {"password"}},
+			wantCode:     http.StatusSeeOther,
+			wantLocation: map[string]string{"code": issuedCode, "state": "xyz", "tenant": "1"},
+		},
+		{
+			name:     "wrong password",
+			form:     url.Values{"action": {"allow"}, "email": {"alice@example.com"}, "password": {"nope"}},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "deny",
+			form:         url.Values{"action": {"deny"}},
+			wantCode:     http.StatusSeeOther,
+			wantLocation: map[string]string{"error": "access_denied", "state": "xyz"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := authorizeValues()
+			for name := range tt.form {
+				form.Set(name, tt.form.Get(name))
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/oauth/authorize", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			rec := httptest.NewRecorder()
+			api.Approve(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+
+			assertLocation(t, rec, tt.wantLocation)
+		})
+	}
+}
+
+func assertLocation(t *testing.T, rec *httptest.ResponseRecorder, want map[string]string) {
+	t.Helper()
+
+	if want == nil {
+		if location := rec.Header().Get("Location"); location != "" {
+			t.Errorf("unexpected redirect to %s", location)
+		}
+
+		return
+	}
+
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid Location: %v", err)
+	}
+
+	if !strings.HasPrefix(location.String(), "https://app.example.com/callback?") {
+		t.Errorf("Location = %s, want the registered redirect uri", location)
+	}
+
+	query := location.Query()
+	for name, value := range want {
+		if got := query.Get(name); got != value {
+			t.Errorf("Location %s = %q, want %q", name, got, value)
+		}
+	}
+}
+
+func TestServerOAuth_ApproveLockout(t *testing.T) {
+	lockout := &lockoutServiceStub{}
+	api := oauth.NewOAuthServer(authServiceStub{}, clientServiceStub{}, userServiceStub{}, lockout, nil, mfaServiceStub{}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	approve := func(password string) *httptest.ResponseRecorder {
+		form := authorizeValues()
+		form.Set("action", "allow")
+		form.Set("email", "alice@example.com")
+		form.Set("password", password)
+
+		req := httptest.NewRequest(http.MethodPost, "/oauth/authorize", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		api.Approve(rec, req)
+
+		return rec
+	}
+
+	if rec := approve("nope"); rec.Code != http.StatusUnauthorized || lockout.failures != 1 {
+		t.Errorf("wrong password status = %d, failures = %d, want 401 and one failure", rec.Code, lockout.failures)
+	}
+
+	if rec := approve("password"); rec.Code != http.StatusSeeOther || lockout.successes != 1 {
+		t.Errorf("right password status = %d, successes = %d, want 303 and one success", rec.Code, lockout.successes)
+	}
+
+	// a locked account is refused even with the right password
+	lockout.locked = true
+
+	if rec := approve("password"); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Location") != "" {
+		t.Errorf("locked status = %d, location = %q, want 429 without a code", rec.Code, rec.Header().Get("Location"))
+	}
+}
+
+func TestServerOAuth_ApproveForgedForwardedFor(t *testing.T) {
+	lockout := &lockoutServiceStub{}
+	api := oauth.NewOAuthServer(authServiceStub{}, clientServiceStub{}, userServiceStub{}, lockout, nil, mfaServiceStub{}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	handler := interceptor.DeviceMiddleware(nil)(http.HandlerFunc(api.Approve))
+
+	form := authorizeValues()
+	form.Set("action", "allow")
+	form.Set("email", "alice@example.com")
+	form.Set("password", "nope")
+
+	req := httptest.NewRequest(http.MethodPost, "/oauth/authorize", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	req.RemoteAddr = "198.51.100.1:4242"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// nobody is trusted to forward, so the attempt counts against the peer
+	if lockout.ip != "198.51.100.1" {
+		t.Errorf("lockout ip = %q, want the peer 198.51.100.1", lockout.ip)
+	}
+}
+
+func TestServerOAuth_ApproveMfa(t *testing.T) {
+	api := oauth.NewOAuthServer(authServiceStub{}, clientServiceStub{}, userServiceStub{}, &lockoutServiceStub{}, nil, mfaServiceStub{enabled: true}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	tests := []struct {
+		name         string
+		otp          string
+		wantCode     int
+		wantLocation map[string]string
+	}{
+		{name: "password only", wantCode: http.StatusUnauthorized},
+		{name: "wrong code", otp: "000000", wantCode: http.StatusUnauthorized},
+		{
+			name:         "password and code",
+			otp:          "123456",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: map[string]string{"code": issuedCode, "state": "xyz"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := authorizeValues()
+			form.Set("action", "allow")
+			form.Set("email", "alice@example.com")
+			form.Set("password", "password")
+			form.Set("otp", tt.otp)
+
+			req := httptest.NewRequest(http.MethodPost, "/oauth/authorize", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			rec := httptest.NewRecorder()
+			api.Approve(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+
+			assertLocation(t, rec, tt.wantLocation)
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/laiker/auth/internal/api/oauth"
+	"github.com/laiker/auth/internal/model"
+	"github.com/pkg/errors"
+)
+
+// bearer tokens known to authServiceStub.VerifyAccessToken
+var accessTokens = map[string]model.UserClaims{
+	"openid-token":  {UserId: 7, Scope: "openid email", Azp: webClientId},
+	"profile-token": {UserId: 7, Scope: "profile", Azp: webClientId},
+	"client-token":  {ClientId: clientId, Scope: "openid"},
+	"deleted-user":  {UserId: 8, Scope: "openid profile"},
+}
+
+func (authServiceStub) VerifyAccessToken(_ context.Context, token string) (model.UserClaims, error) {
+	claims, ok := accessTokens[token]
+	if !ok {
+		return model.UserClaims{}, errors.New("invalid token")
+	}
+
+	return claims, nil
+}
+
+func (userServiceStub) Get(_ context.Context, id int64) (*model.User, error) {
+	if id != 7 {
+		return nil, errors.New("user not found")
+	}
+
+	return &model.User{Id: 7, Name: "alice", Email: "alice@example.com", Role: "user"}, nil
+}
+
+func TestServerOAuth_UserInfo(t *testing.T) {
+	api := oauth.NewOAuthServer(authServiceStub{}, clientServiceStub{}, userServiceStub{}, &lockoutServiceStub{}, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantCode      int
+		wantBody      map[string]interface{}
+	}{
+		{
+			name:          "openid scope",
+			authorization: "Bearer openid-token",
+			wantCode:      http.StatusOK,
+			wantBody:      map[string]interface{}{"sub": "7", "email": "alice@example.com"},
+		},
+		{
+			name:          "without openid scope",
+			authorization: "Bearer profile-token",
+			wantCode:      http.StatusForbidden,
+			wantBody:      map[string]interface{}{"error": "insufficient_scope"},
+		},
+		{
+			name:          "client token",
+			authorization: "Bearer client-token",
+			wantCode:      http.StatusForbidden,
+			wantBody:      map[string]interface{}{"error": "insufficient_scope"},
+		},
+		{
+			name:          "deleted user",
+			authorization: "Bearer deleted-user",
+			wantCode:      http.StatusUnauthorized,
+			wantBody:      map[string]interface{}{"error": "invalid_token"},
+		},
+		{
+			name:          "invalid token",
+			authorization: "Bearer nope",
+			wantCode:      http.StatusUnauthorized,
+			wantBody:      map[string]interface{}{"error": "invalid_token"},
+		},
+		{
+			name:     "missing token",
+			wantCode: http.StatusUnauthorized,
+			wantBody: map[string]interface{}{"error": "invalid_token"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/userinfo", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+
+			rec := httptest.NewRecorder()
+			api.UserInfo(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+
+			if tt.wantCode != http.StatusOK && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("WWW-Authenticate is missing")
+			}
+
+			var body map[string]interface{}
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("invalid JSON: %v", err)
+			}
+
+			if len(body) != len(tt.wantBody) {
+				t.Errorf("body = %v, want %v", body, tt.wantBody)
+			}
+
+			for k, v := range tt.wantBody {
+				if body[k] != v {
+					t.Errorf("body[%q] = %v, want %v", k, body[k], v)
+				}
+			}
+		})
+	}
+}
@@ -6,19 +6,39 @@ import (
 
 	"github.com/golang/protobuf/ptypes/empty"
 	"github.com/laiker/auth/internal/converter"
+	"github.com/laiker/auth/internal/interceptor"
 	"github.com/laiker/auth/internal/service"
+	lockoutService "github.com/laiker/auth/internal/service/lockout"
+	policyService "github.com/laiker/auth/internal/service/policy"
+	sessionService "github.com/laiker/auth/internal/service/session"
+	userService "github.com/laiker/auth/internal/service/user"
+	verificationService "github.com/laiker/auth/internal/service/verification"
 	"github.com/laiker/auth/pkg/user_v1"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type ServerUser struct {
 	user_v1.UnimplementedUserV1Server
-	UserService service.UserService
+	UserService         service.UserService
+	SessionService      service.SessionService
+	LockoutService      service.LockoutService
+	VerificationService service.VerificationService
 }
 
-func NewUserServer(userService service.UserService) *ServerUser {
+func NewUserServer(
+	userService service.UserService,
+	sessionService service.SessionService,
+	lockoutService service.LockoutService,
+	verificationService service.VerificationService,
+) *ServerUser {
 	return &ServerUser{
-		UserService: userService,
+		UserService:         userService,
+		SessionService:      sessionService,
+		LockoutService:      lockoutService,
+		VerificationService: verificationService,
 	}
 }
 
@@ -26,6 +46,11 @@ func (s *ServerUser) Create(ctx context.Context, request *user_v1.CreateRequest)
 
 	userID, err := s.UserService.Create(ctx, converter.ToUserFromCreateRequest(request))
 
+	var violation *policyService.ViolationError
+	if errors.As(err, &violation) {
+		return nil, converter.ToStatusFromPasswordViolations(violation.Violations).Err()
+	}
+
 	if err != nil {
 		return nil, err
 	}
@@ -50,15 +75,36 @@ func (s *ServerUser) Get(ctx context.Context, request *user_v1.GetRequest) (*use
 		Role:      user_v1.Role(user_v1.Role_value[user.Role]),
 		CreatedAt: timestamppb.New(user.CreatedAt),
 		UpdatedAt: timestamppb.New(user.UpdatedAt.Time),
+
+		EmailVerified: user.EmailVerified,
+		PendingEmail:  user.PendingEmail.String,
 	}, nil
 }
 
+// Update users update their own account, roles managing users the accounts of roles up to their own
 func (s *ServerUser) Update(ctx context.Context, request *user_v1.UpdateRequest) (*empty.Empty, error) {
+	claims, ok := interceptor.ClaimsFromContext(ctx)
+
+	if !ok || claims.UserId == 0 {
+		return nil, status.Error(codes.Unauthenticated, "a user access token is required")
+	}
 
-	err := s.UserService.Update(ctx, converter.ToUserFromUpdateRequest(request))
+	err := s.UserService.Update(ctx, converter.ToUserFromUpdateRequest(request), claims, request.GetCurrentPassword())
+
+	if errors.Is(err, userService.ErrUpdateDenied) || errors.Is(err, userService.ErrWrongPassword) {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	if errors.Is(err, lockoutService.ErrLocked) {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+
+	if errors.Is(err, verificationService.ErrEmailTaken) {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	}
 
 	if err != nil {
-		log.Fatalf("failed to update user: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to update user: %v", err)
 	}
 
 	return nil, nil
@@ -95,3 +141,97 @@ func (s *ServerUser) FindByLogin(ctx context.Context, request *user_v1.FindByLog
 		Results: userList,
 	}, nil
 }
+
+func (s *ServerUser) ListSessions(ctx context.Context, request *user_v1.ListSessionsRequest) (*user_v1.ListSessionsResponse, error) {
+	sessions, err := s.SessionService.List(ctx, request.GetUserId())
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list sessions: %v", err)
+	}
+
+	res := &user_v1.ListSessionsResponse{Sessions: make([]*user_v1.Session, 0, len(sessions))}
+
+	for _, session := range sessions {
+		res.Sessions = append(res.Sessions, converter.ToUserSessionFromService(session))
+	}
+
+	return res, nil
+}
+
+func (s *ServerUser) RevokeSession(ctx context.Context, request *user_v1.RevokeSessionRequest) (*empty.Empty, error) {
+	err := s.SessionService.Revoke(ctx, request.GetUserId(), request.GetSessionId())
+
+	if errors.Is(err, sessionService.ErrSessionNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to revoke session: %v", err)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+func (s *ServerUser) Unlock(ctx context.Context, request *user_v1.UnlockRequest) (*empty.Empty, error) {
+	user, err := s.UserService.Get(ctx, request.GetId())
+
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	err = s.LockoutService.Unlock(ctx, user.Email)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to unlock user: %v", err)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+func (s *ServerUser) VerifyEmail(ctx context.Context, request *user_v1.VerifyEmailRequest) (*empty.Empty, error) {
+	err := s.VerificationService.Verify(ctx, request.GetToken())
+
+	if errors.Is(err, verificationService.ErrInvalidToken) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to verify email: %v", err)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// ChangePassword of the caller, other sessions of the user end
+func (s *ServerUser) ChangePassword(ctx context.Context, request *user_v1.ChangePasswordRequest) (*empty.Empty, error) {
+	claims, ok := interceptor.ClaimsFromContext(ctx)
+
+	if !ok || claims.UserId == 0 {
+		return nil, status.Error(codes.Unauthenticated, "a user access token is required")
+	}
+
+	if claims.Act != nil {
+		return nil, status.Error(codes.PermissionDenied, "impersonation tokens can not change the password")
+	}
+
+	err := s.UserService.ChangePassword(ctx, claims.UserId, claims.FamilyId, request.GetCurrentPassword(), request.GetNewPassword())
+
+	if errors.Is(err, userService.ErrWrongPassword) {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	if errors.Is(err, lockoutService.ErrLocked) {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+
+	var violation *policyService.ViolationError
+	if errors.As(err, &violation) {
+		return nil, converter.ToStatusFromPasswordViolations(violation.Violations).Err()
+	}
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to change password: %v", err)
+	}
+
+	return &empty.Empty{}, nil
+}
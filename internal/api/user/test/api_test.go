@@ -10,10 +10,14 @@ import (
 	"github.com/brianvoe/gofakeit"
 	"github.com/golang/protobuf/ptypes/empty"
 	"github.com/laiker/auth/internal/api/user"
+	"github.com/laiker/auth/internal/interceptor"
 	"github.com/laiker/auth/internal/model"
 	"github.com/laiker/auth/internal/service"
+	userService "github.com/laiker/auth/internal/service/user"
 	"github.com/laiker/auth/pkg/user_v1"
 	. "github.com/ovechkin-dm/mockio/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
@@ -247,66 +251,90 @@ func TestServer_Get(t *testing.T) {
 	}
 }
 
-func TestServer_Update(t *testing.T) {
-	m := Mock[service.UserService]()
+// updatingUserService records updates, other accounts and wrong passwords are refused like the real one
+type updatingUserService struct {
+	service.UserService
+	updated []*model.User
+}
 
-	type args struct {
-		ctx     context.Context
-		request *user_v1.UpdateRequest
+func (s *updatingUserService) Update(_ context.Context, info *model.User, actor model.UserClaims, currentPassword string) error {
+	if actor.UserId != info.Id && actor.Role != "admin" {
+		return userService.ErrUpdateDenied
 	}
 
-	name := gofakeit.Name()
-	email := gofakeit.Email()
-	id := int64(gofakeit.Number(1, 10))
-
-	mu := &model.User{
-		Id:    id,
-		Name:  name,
-		Email: email,
+	if info.Email != "" && currentPassword != "secret" {
+		return userService.ErrWrongPassword
 	}
 
-	a := args{
-		ctx: context.Background(),
-		request: &user_v1.UpdateRequest{
-			Id:    wrapperspb.Int64(id),
-			Name:  wrapperspb.String(name),
-			Email: wrapperspb.String(email),
-		},
-	}
+	s.updated = append(s.updated, info)
+
+	return nil
+}
 
-	When(m.Update(a.ctx, mu)).ThenReturn(nil)
+func TestServer_Update(t *testing.T) {
+	user7 := model.UserClaims{UserId: 7, Role: "user"}
+	admin := model.UserClaims{UserId: 1, Role: "admin"}
 
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		want    *empty.Empty
-		wantErr bool
+		name     string
+		claims   *model.UserClaims
+		request  *user_v1.UpdateRequest
+		wantCode codes.Code
 	}{
 		{
-			name:    "Success Test",
-			want:    nil,
-			wantErr: false,
-			args:    a,
-			fields: fields{
-				UnimplementedUserV1Server: user_v1.UnimplementedUserV1Server{},
-				UserService:               m,
-			},
+			name:     "own name",
+			claims:   &user7,
+			request:  &user_v1.UpdateRequest{Id: wrapperspb.Int64(7), Name: wrapperspb.String("alice")},
+			wantCode: codes.OK,
+		},
+		{
+			name:     "own email with the password",
+			claims:   &user7,
+			request:  &user_v1.UpdateRequest{Id: wrapperspb.Int64(7), Email: wrapperspb.String("alice@example.com"), CurrentPassword: "secret"},
+			wantCode: codes.OK,
+		},
+		{
+			name:     "own email without the password",
+			claims:   &user7,
+			request:  &user_v1.UpdateRequest{Id: wrapperspb.Int64(7), Email: wrapperspb.String("mallory@example.com")},
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name:     "account of another user",
+			claims:   &user7,
+			request:  &user_v1.UpdateRequest{Id: wrapperspb.Int64(8), Email: wrapperspb.String("mallory@example.com"), CurrentPassword: "secret"},
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name:     "admin",
+			claims:   &admin,
+			request:  &user_v1.UpdateRequest{Id: wrapperspb.Int64(8), Name: wrapperspb.String("bob")},
+			wantCode: codes.OK,
+		},
+		{
+			name:     "anonymous",
+			request:  &user_v1.UpdateRequest{Id: wrapperspb.Int64(7), Name: wrapperspb.String("alice")},
+			wantCode: codes.Unauthenticated,
 		},
 	}
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &user.ServerUser{
-				UnimplementedUserV1Server: tt.fields.UnimplementedUserV1Server,
-				UserService:               tt.fields.UserService,
+			ctx := context.Background()
+			if tt.claims != nil {
+				ctx = interceptor.ContextWithClaims(ctx, *tt.claims)
 			}
-			got, err := s.Update(tt.args.ctx, tt.args.request)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("Update() error = %v, wantErr %v", err, tt.wantErr)
-				return
+
+			users := &updatingUserService{}
+			s := &user.ServerUser{UserService: users}
+
+			_, err := s.Update(ctx, tt.request)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("Update() error = %v, want %v", err, tt.wantCode)
 			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("Update() got = %v, want %v", got, tt.want)
+
+			if updated := len(users.updated) == 1; updated != (tt.wantCode == codes.OK) {
+				t.Fatalf("updated %+v", users.updated)
 			}
 		})
 	}
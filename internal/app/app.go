@@ -8,6 +8,7 @@ import (
 	"log/slog"
 	"net"
 	"net/http"
+	"net/netip"
 	"sync"
 	"time"
 
@@ -16,6 +17,7 @@ import (
 	"github.com/laiker/auth/internal/config"
 	"github.com/laiker/auth/internal/interceptor"
 	"github.com/laiker/auth/internal/metrics"
+	"github.com/laiker/auth/internal/utils"
 	"github.com/laiker/auth/pkg/access_v1"
 	"github.com/laiker/auth/pkg/auth_v1"
 	"github.com/laiker/auth/pkg/user_v1"
@@ -29,6 +31,8 @@ import (
 	"google.golang.org/grpc/reflection"
 )
 
+const keyReloadInterval = time.Minute
+
 type App struct {
 	serviceProvider  *ServiceProvider
 	grpcServer       *grpc.Server
@@ -56,7 +60,7 @@ func (a *App) Run() error {
 	}()
 
 	wg := sync.WaitGroup{}
-	wg.Add(4)
+	wg.Add(5)
 
 	go func() {
 		defer wg.Done()
@@ -94,6 +98,12 @@ func (a *App) Run() error {
 		}
 	}()
 
+	go func() {
+		defer wg.Done()
+
+		a.runKeyRotation(context.Background())
+	}()
+
 	wg.Wait()
 
 	return nil
@@ -104,6 +114,7 @@ func (a *App) initDeps(ctx context.Context) error {
 		a.initConfig,
 		a.initServiceProvider,
 		a.initLogger,
+		a.initKeyRing,
 		a.initGRPCServer,
 		a.initHTTPServer,
 		a.initSwaggerServer,
@@ -139,6 +150,10 @@ func (a *App) initServiceProvider(_ context.Context) error {
 	return nil
 }
 
+func (a *App) initKeyRing(ctx context.Context) error {
+	return a.serviceProvider.KeyService(ctx).Reload(ctx)
+}
+
 func (a *App) initGRPCServer(ctx context.Context) error {
 
 	crds, err := credentials.NewServerTLSFromFile("service.pem", "service.key")
@@ -147,10 +162,24 @@ func (a *App) initGRPCServer(ctx context.Context) error {
 		return errors.New(fmt.Sprintf("Failed to generate credentials %v", err))
 	}
 
+	// the gateway calls the gRPC server from this process, the address it appends to x-forwarded-for is believed
+	proxies := append(utils.TrustedProxies{
+		netip.MustParsePrefix("127.0.0.0/8"),
+		netip.MustParsePrefix("::1/128"),
+	}, a.serviceProvider.HTTPConfig().TrustedProxies()...)
+
 	a.grpcServer = grpc.NewServer(
 		grpc.Creds(crds),
 		grpc.ChainUnaryInterceptor(
+			interceptor.DeviceInterceptor(proxies),
 			interceptor.ValidateInterceptor(),
+			interceptor.AuthInterceptor(
+				a.serviceProvider.AuthService(ctx),
+				a.serviceProvider.ApiKeyService(ctx),
+				a.serviceProvider.AccessService(ctx),
+			),
+			interceptor.AuditInterceptor(a.serviceProvider.DBLogger(ctx)),
+			interceptor.RateLimitInterceptor(a.serviceProvider.RateLimiter(ctx), a.serviceProvider.RateLimitConfig().GetLimits()),
 			interceptor.MetricsInterceptor(),
 		),
 	)
@@ -182,6 +211,34 @@ func (a *App) initHTTPServer(ctx context.Context) error {
 		return err
 	}
 
+	err = auth_v1.RegisterAuthV1HandlerFromEndpoint(ctx, mux, a.serviceProvider.GRPCConfig().Address(), opts)
+	if err != nil {
+		return err
+	}
+
+	oauth := a.serviceProvider.OAuthApi(ctx)
+
+	httpMux := http.NewServeMux()
+
+	// the gateway is limited by the gRPC interceptor, only the plain HTTP endpoints are limited here
+	rateLimit := interceptor.RateLimitMiddleware(a.serviceProvider.RateLimiter(ctx), a.serviceProvider.RateLimitConfig().GetLimits())
+	handle := func(pattern string, handler http.HandlerFunc) {
+		httpMux.Handle(pattern, rateLimit(handler))
+	}
+
+	httpMux.Handle("/", mux)
+	handle("GET /.well-known/jwks.json", oauth.JWKS)
+	handle("GET /.well-known/openid-configuration", oauth.OpenIDConfiguration)
+	handle("GET /userinfo", oauth.UserInfo)
+	handle("POST /userinfo", oauth.UserInfo)
+	handle("POST /oauth/introspect", oauth.Introspect)
+	handle("POST /oauth/token", oauth.Token)
+	handle("GET /oauth/authorize", oauth.Authorize)
+	handle("POST /oauth/authorize", oauth.Approve)
+	handle("POST /oauth/device_authorization", oauth.DeviceAuthorization)
+	handle("GET /oauth/device", oauth.Device)
+	handle("POST /oauth/device", oauth.ApproveDevice)
+
 	corsMiddleware := cors.New(cors.Options{
 		AllowedOrigins: []string{"*"},
 		//AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
@@ -191,13 +248,27 @@ func (a *App) initHTTPServer(ctx context.Context) error {
 
 	a.httpServer = &http.Server{
 		Addr:              a.serviceProvider.HTTPConfig().Address(),
-		Handler:           corsMiddleware.Handler(mux),
+		Handler:           corsMiddleware.Handler(interceptor.DeviceMiddleware(a.serviceProvider.HTTPConfig().TrustedProxies())(httpMux)),
 		ReadHeaderTimeout: time.Duration(10) * time.Second,
 	}
 
 	return nil
 }
 
+// runKeyRotation reloads the key ring so keys changed by other replicas or the CLI are picked up
+// and performs the scheduled rotation
+func (a *App) runKeyRotation(ctx context.Context) {
+	ticker := time.NewTicker(keyReloadInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		err := a.serviceProvider.KeyService(ctx).Rotate(ctx)
+		if err != nil {
+			a.logger.Error("failed to rotate signing keys", "error", err)
+		}
+	}
+}
+
 func (a *App) runGRPCServer() error {
 	a.logger.Info(fmt.Sprintf("GRPC server is running on %s", a.serviceProvider.GRPCConfig().Address()))
 
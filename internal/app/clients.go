@@ -0,0 +1,74 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/laiker/auth/internal/model"
+	"github.com/pkg/errors"
+)
+
+const clientsUsage = `usage: clients <command>
+  create [-team] [-scopes] [-redirect-uris] [-public] [-access-token-ttl] <name>   register a client and print its id and secret`
+
+// RunClientsCommand manages OAuth clients from the command line
+func RunClientsCommand(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] != "create" {
+		return errors.New(clientsUsage)
+	}
+
+	flags := flag.NewFlagSet("create", flag.ContinueOnError)
+	team := flags.String("team", "", "owning team")
+	scopes := flags.String("scopes", "", "comma separated scopes")
+	redirectUris := flags.String("redirect-uris", "", "comma separated redirect uris of the authorization code flow")
+	public := flags.Bool("public", false, "client can not keep a secret (SPA, mobile)")
+	accessTokenTTL := flags.Duration("access-token-ttl", 0, "shorter lifetime of the client access tokens, 0 keeps the configured one")
+
+	err := flags.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		return errors.New(clientsUsage)
+	}
+
+	info := &model.ClientInfo{
+		Name:           strings.Join(flags.Args(), " "),
+		Team:           *team,
+		Public:         *public,
+		AccessTokenTTL: *accessTokenTTL,
+	}
+
+	if *scopes != "" {
+		info.Scopes = strings.Split(*scopes, ",")
+	}
+
+	if *redirectUris != "" {
+		info.RedirectUris = strings.Split(*redirectUris, ",")
+	}
+
+	a, err := newCommandApp(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer a.close()
+
+	client, secret, err := a.serviceProvider.ClientService(ctx).Create(ctx, info)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(out, "client_id=%s\n", client.Id)
+	if err != nil || secret == "" {
+		return err
+	}
+
+	_, err = fmt.Fprintf(out, "client_secret=%s\n", secret)
+
+	return err
+}
@@ -0,0 +1,26 @@
+package app
+
+import (
+	"context"
+
+	"github.com/laiker/auth/internal/closer"
+)
+
+// newCommandApp initializes only the config and the service provider for CLI subcommands
+func newCommandApp(ctx context.Context) (*App, error) {
+	a := &App{}
+
+	for _, f := range []func(context.Context) error{a.initConfig, a.initServiceProvider} {
+		err := f(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+func (a *App) close() {
+	closer.CloseAll()
+	closer.Wait()
+}
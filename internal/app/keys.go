@@ -0,0 +1,106 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/laiker/auth/internal/model"
+	"github.com/pkg/errors"
+)
+
+const keysUsage = `usage: keys <command>
+  list            show stored keys
+  generate [-alg] add a pending key, it is published in the JWKS but does not sign yet
+  promote <kid>   make the key the signing one and retire the current key
+  retire <kid>    stop accepting the key once the tokens it signed expire`
+
+// RunKeysCommand manages the signing key ring from the command line
+func RunKeysCommand(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(keysUsage)
+	}
+
+	a, err := newCommandApp(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer a.close()
+
+	keys := a.serviceProvider.KeyService(ctx)
+
+	switch args[0] {
+	case "list":
+		list, err := keys.List(ctx)
+		if err != nil {
+			return err
+		}
+
+		return printKeys(out, list)
+	case "generate":
+		flags := flag.NewFlagSet("generate", flag.ContinueOnError)
+		alg := flags.String("alg", "", "RS256 or EdDSA, defaults to JWT_KEY_ALGORITHM")
+
+		err := flags.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+
+		key, err := keys.Generate(ctx, *alg)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(out, key.Id)
+
+		return err
+	case "promote", "retire":
+		if len(args) != 2 {
+			return errors.New(keysUsage)
+		}
+
+		if args[0] == "promote" {
+			return keys.Promote(ctx, args[1])
+		}
+
+		return keys.Retire(ctx, args[1])
+	}
+
+	return errors.New(keysUsage)
+}
+
+func printKeys(out io.Writer, keys []*model.SigningKey) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	_, err := fmt.Fprintln(w, "KID\tALG\tSTATUS\tCREATED\tPROMOTED\tRETIRED")
+	if err != nil {
+		return err
+	}
+
+	format := func(t time.Time, valid bool) string {
+		if !valid {
+			return "-"
+		}
+		return t.Format(time.RFC3339)
+	}
+
+	for _, k := range keys {
+		_, err = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			k.Id,
+			k.Algorithm,
+			k.Status(),
+			format(k.CreatedAt, true),
+			format(k.PromotedAt.Time, k.PromotedAt.Valid),
+			format(k.RetiredAt.Time, k.RetiredAt.Valid),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.Flush()
+}
@@ -5,24 +5,59 @@ import (
 	"log"
 	"log/slog"
 	"os"
+	"time"
 
 	"github.com/laiker/auth/client/db"
 	"github.com/laiker/auth/client/db/pg"
 	"github.com/laiker/auth/client/db/transaction"
+	"github.com/laiker/auth/client/mail"
+	mailFile "github.com/laiker/auth/client/mail/file"
+	mailSmtp "github.com/laiker/auth/client/mail/smtp"
+	"github.com/laiker/auth/client/pwned"
+	pwnedFile "github.com/laiker/auth/client/pwned/file"
 	accessApi "github.com/laiker/auth/internal/api/access"
 	authApi "github.com/laiker/auth/internal/api/auth"
+	oauthApi "github.com/laiker/auth/internal/api/oauth"
 	userApi "github.com/laiker/auth/internal/api/user"
 	"github.com/laiker/auth/internal/config"
 	"github.com/laiker/auth/internal/config/env"
 	"github.com/laiker/auth/internal/logger/logger"
+	"github.com/laiker/auth/internal/ratelimit"
 	"github.com/laiker/auth/internal/repository"
 	accessRepository "github.com/laiker/auth/internal/repository/access"
+	apiKeyRepository "github.com/laiker/auth/internal/repository/apikey"
+	clientRepository "github.com/laiker/auth/internal/repository/client"
+	codeRepository "github.com/laiker/auth/internal/repository/code"
+	deviceRepository "github.com/laiker/auth/internal/repository/device"
+	keyRepository "github.com/laiker/auth/internal/repository/key"
+	lockoutRepository "github.com/laiker/auth/internal/repository/lockout"
+	mfaRepository "github.com/laiker/auth/internal/repository/mfa"
+	refreshRepository "github.com/laiker/auth/internal/repository/refresh"
+	revocationRepository "github.com/laiker/auth/internal/repository/revocation"
+	sessionRepository "github.com/laiker/auth/internal/repository/session"
+	tokenRepository "github.com/laiker/auth/internal/repository/token"
 	repo "github.com/laiker/auth/internal/repository/user"
+	webauthnRepository "github.com/laiker/auth/internal/repository/webauthn"
 	"github.com/laiker/auth/internal/service"
 	accessService "github.com/laiker/auth/internal/service/access"
+	apiKeyService "github.com/laiker/auth/internal/service/apikey"
 	authService "github.com/laiker/auth/internal/service/auth"
+	clientService "github.com/laiker/auth/internal/service/client"
+	deviceService "github.com/laiker/auth/internal/service/device"
+	impersonationService "github.com/laiker/auth/internal/service/impersonation"
+	keyService "github.com/laiker/auth/internal/service/key"
+	lockoutService "github.com/laiker/auth/internal/service/lockout"
+	magicService "github.com/laiker/auth/internal/service/magic"
+	mfaService "github.com/laiker/auth/internal/service/mfa"
+	policyService "github.com/laiker/auth/internal/service/policy"
+	resetService "github.com/laiker/auth/internal/service/reset"
+	sessionService "github.com/laiker/auth/internal/service/session"
 	serv "github.com/laiker/auth/internal/service/user"
+	verificationService "github.com/laiker/auth/internal/service/verification"
+	webauthnService "github.com/laiker/auth/internal/service/webauthn"
+	"github.com/laiker/auth/internal/utils"
 	"github.com/lmittmann/tint"
+	"github.com/redis/go-redis/v9"
 )
 
 type ServiceProvider struct {
@@ -33,24 +68,94 @@ type ServiceProvider struct {
 	httpConfig       config.HTTPConfig
 	swaggerConfig    config.SwaggerConfig
 	prometheusConfig config.PrometheusConfig
+	mfaConfig        config.MfaConfig
+	webAuthnConfig   config.WebAuthnConfig
+	loginConfig      config.LoginConfig
+	rateLimitConfig  config.RateLimitConfig
+	redisConfig      config.RedisConfig
+	mailConfig       config.MailConfig
+	resetConfig      config.PasswordResetConfig
+	verifyConfig     config.VerificationConfig
+	magicConfig      config.MagicLinkConfig
+	passwordConfig   config.PasswordHashConfig
+	policyConfig     config.PasswordPolicyConfig
+	apiKeyConfig     config.ApiKeyConfig
+	deviceConfig     config.DeviceConfig
 
 	//User
 	userApi        *userApi.ServerUser
 	userService    service.UserService
 	userRepository repository.UserRepository
+	passwordHasher utils.PasswordHasher
+	policyService  service.PasswordPolicyService
+
+	//Email verification
+	verificationService service.VerificationService
+
+	//Magic links
+	magicService service.MagicLinkService
 
 	//Auth
-	authApi     *authApi.ServerAuth
-	authService service.AuthService
+	authApi              *authApi.ServerAuth
+	authService          service.AuthService
+	refreshRepository    repository.RefreshTokenRepository
+	revocationRepository repository.RevocationRepository
+	keyRing              *utils.KeyRing
+
+	//MFA
+	mfaService    service.MfaService
+	mfaRepository repository.MfaRepository
+
+	//Login lockout
+	lockoutService    service.LockoutService
+	lockoutRepository repository.LoginAttemptRepository
+
+	//Passkeys
+	webAuthnService    service.WebAuthnService
+	webAuthnRepository repository.WebAuthnRepository
+
+	//Sessions
+	sessionService    service.SessionService
+	sessionRepository repository.SessionRepository
+
+	//Signing keys
+	keyService    service.KeyService
+	keyRepository repository.SigningKeyRepository
+
+	//OAuth
+	oauthApi         *oauthApi.ServerOAuth
+	clientService    service.ClientService
+	clientRepository repository.ClientRepository
+	codeRepository   repository.AuthorizationCodeRepository
+
+	//Device authorization
+	deviceService    service.DeviceService
+	deviceRepository repository.DeviceCodeRepository
+
+	//Impersonation
+	impersonationService service.ImpersonationService
+
+	//API keys
+	apiKeyService    service.ApiKeyService
+	apiKeyRepository repository.ApiKeyRepository
 
 	//Access
 	accessApi        *accessApi.ServerAccess
 	accessService    service.AccessService
 	accessRepository repository.AccessRepository
 
+	//Password reset
+	resetService        service.PasswordResetService
+	userTokenRepository repository.UserTokenRepository
+	mailer              mail.Mailer
+
+	//Rate limits
+	rateLimiter ratelimit.Limiter
+
 	//Database
 	db        db.Client
 	txManager db.TxManager
+	redis     *redis.Client
 
 	//Loggers
 	dbLogger *logger.DBLogger
@@ -98,7 +203,7 @@ func (s *ServiceProvider) HTTPConfig() config.HTTPConfig {
 		hConfig, err := env.NewHTTPConfig()
 
 		if err != nil {
-			s.Logger().Error("failed to load config: %v", err)
+			s.Logger().Error("failed to load config", "error", err)
 			os.Exit(1)
 		}
 
@@ -109,7 +214,7 @@ func (s *ServiceProvider) HTTPConfig() config.HTTPConfig {
 	return s.httpConfig
 }
 
-func (s *ServiceProvider) SwaggerConfig() config.HTTPConfig {
+func (s *ServiceProvider) SwaggerConfig() config.SwaggerConfig {
 	if s.swaggerConfig == nil {
 
 		sConfig, err := env.NewSwaggerConfig()
@@ -125,7 +230,7 @@ func (s *ServiceProvider) SwaggerConfig() config.HTTPConfig {
 	return s.swaggerConfig
 }
 
-func (s *ServiceProvider) PrometheusConfig() config.HTTPConfig {
+func (s *ServiceProvider) PrometheusConfig() config.PrometheusConfig {
 	if s.prometheusConfig == nil {
 
 		sConfig, err := env.NewPrometheusConfig()
@@ -145,7 +250,7 @@ func (s *ServiceProvider) DB(ctx context.Context) db.Client {
 	if s.db == nil {
 		p, err := pg.New(ctx, s.PGConfig().DSN())
 		if err != nil {
-			s.Logger().Error("failed to connect: %v", err)
+			s.Logger().Error("failed to connect", "error", err)
 			os.Exit(1)
 		}
 
@@ -154,6 +259,71 @@ func (s *ServiceProvider) DB(ctx context.Context) db.Client {
 	return s.db
 }
 
+func (s *ServiceProvider) RedisConfig() config.RedisConfig {
+	if s.redisConfig == nil {
+
+		redisConfig, err := env.NewRedisConfig()
+
+		if err != nil {
+			s.Logger().Error("failed to load config", "error", err)
+			os.Exit(1)
+		}
+
+		s.redisConfig = redisConfig
+
+	}
+
+	return s.redisConfig
+}
+
+func (s *ServiceProvider) Redis(ctx context.Context) *redis.Client {
+	if s.redis == nil {
+		r := redis.NewClient(&redis.Options{
+			Addr:     s.RedisConfig().Address(),
+			Password: s.RedisConfig().Password(),
+			DB:       s.RedisConfig().DB(),
+		})
+
+		err := r.Ping(ctx).Err()
+		if err != nil {
+			s.Logger().Error("failed to connect to redis", "error", err)
+			os.Exit(1)
+		}
+
+		s.redis = r
+	}
+	return s.redis
+}
+
+func (s *ServiceProvider) RateLimitConfig() config.RateLimitConfig {
+	if s.rateLimitConfig == nil {
+
+		rateLimitConfig, err := env.NewRateLimitConfig()
+
+		if err != nil {
+			s.Logger().Error("failed to load config", "error", err)
+			os.Exit(1)
+		}
+
+		s.rateLimitConfig = rateLimitConfig
+
+	}
+
+	return s.rateLimitConfig
+}
+
+func (s *ServiceProvider) RateLimiter(ctx context.Context) ratelimit.Limiter {
+	if s.rateLimiter == nil {
+		if s.RateLimitConfig().GetBackend() == config.RateLimitRedis {
+			s.rateLimiter = ratelimit.NewRedisLimiter(s.Redis(ctx), time.Now)
+		} else {
+			s.rateLimiter = ratelimit.NewMemoryLimiter(time.Now)
+		}
+	}
+
+	return s.rateLimiter
+}
+
 func (s *ServiceProvider) TxManager(ctx context.Context) db.TxManager {
 	if s.txManager == nil {
 		s.txManager = transaction.NewTransactionManager(s.DB(ctx).DB())
@@ -172,9 +342,92 @@ func (s *ServiceProvider) UserRepository(ctx context.Context) repository.UserRep
 	return s.userRepository
 }
 
+func (s *ServiceProvider) PasswordHashConfig() config.PasswordHashConfig {
+	if s.passwordConfig == nil {
+
+		passwordConfig, err := env.NewPasswordHashConfig()
+
+		if err != nil {
+			s.Logger().Error("failed to load config", "error", err)
+			os.Exit(1)
+		}
+
+		s.passwordConfig = passwordConfig
+
+	}
+
+	return s.passwordConfig
+}
+
+func (s *ServiceProvider) PasswordHasher() utils.PasswordHasher {
+	if s.passwordHasher == nil {
+		cfg := s.PasswordHashConfig()
+
+		h, err := utils.NewPasswordHasher(cfg.GetAlgorithm(), cfg.GetArgon2Params(), cfg.GetBcryptCost())
+
+		if err != nil {
+			s.Logger().Error("failed to create password hasher", "error", err)
+			os.Exit(1)
+		}
+
+		s.passwordHasher = h
+	}
+
+	return s.passwordHasher
+}
+
+func (s *ServiceProvider) PasswordPolicyConfig() config.PasswordPolicyConfig {
+	if s.policyConfig == nil {
+
+		policyConfig, err := env.NewPasswordPolicyConfig()
+
+		if err != nil {
+			s.Logger().Error("failed to load config", "error", err)
+			os.Exit(1)
+		}
+
+		s.policyConfig = policyConfig
+
+	}
+
+	return s.policyConfig
+}
+
+func (s *ServiceProvider) PasswordPolicyService() service.PasswordPolicyService {
+	if s.policyService == nil {
+		cfg := s.PasswordPolicyConfig()
+
+		var store pwned.Store
+
+		if cfg.GetPwnedPath() != "" {
+			var err error
+			store, err = pwnedFile.New(cfg.GetPwnedPath())
+
+			if err != nil {
+				s.Logger().Error("failed to open pwned passwords", "error", err)
+				os.Exit(1)
+			}
+		}
+
+		s.policyService = policyService.NewService(cfg, store)
+	}
+
+	return s.policyService
+}
+
 func (s *ServiceProvider) UserService(ctx context.Context) service.UserService {
 	if s.userService == nil {
-		r := serv.NewService(s.UserRepository(ctx), s.TxManager(ctx), *s.DBLogger(ctx))
+		r := serv.NewService(
+			s.UserRepository(ctx),
+			s.TxManager(ctx),
+			s.DBLogger(ctx),
+			s.VerificationService(ctx),
+			s.PasswordHasher(),
+			s.PasswordPolicyService(),
+			s.AuthService(ctx),
+			s.LockoutService(ctx),
+			s.AccessRepository(ctx),
+		)
 		s.userService = r
 	}
 
@@ -183,16 +436,323 @@ func (s *ServiceProvider) UserService(ctx context.Context) service.UserService {
 
 func (s *ServiceProvider) AuthService(ctx context.Context) service.AuthService {
 	if s.authService == nil {
-		r := authService.NewService(s.JwtConfig())
+		r := authService.NewService(
+			s.JwtConfig(),
+			s.KeyRing(),
+			s.RefreshTokenRepository(ctx),
+			s.RevocationRepository(ctx),
+			s.CodeRepository(ctx),
+			s.UserRepository(ctx),
+			s.AccessRepository(ctx),
+			s.SessionRepository(ctx),
+			s.TxManager(ctx),
+		)
 		s.authService = r
 	}
 
 	return s.authService
 }
 
+func (s *ServiceProvider) RefreshTokenRepository(ctx context.Context) repository.RefreshTokenRepository {
+	if s.refreshRepository == nil {
+		r := refreshRepository.NewRepository(s.DB(ctx))
+		s.refreshRepository = r
+	}
+
+	return s.refreshRepository
+}
+
+func (s *ServiceProvider) RevocationRepository(ctx context.Context) repository.RevocationRepository {
+	if s.revocationRepository == nil {
+		r := revocationRepository.NewCachedRepository(revocationRepository.NewRepository(s.DB(ctx)))
+		s.revocationRepository = r
+	}
+
+	return s.revocationRepository
+}
+
+func (s *ServiceProvider) MfaConfig() config.MfaConfig {
+	if s.mfaConfig == nil {
+
+		mfaConfig, err := env.NewMfaConfig()
+
+		if err != nil {
+			s.Logger().Error("failed to load config", "error", err)
+			os.Exit(1)
+		}
+
+		s.mfaConfig = mfaConfig
+
+	}
+
+	return s.mfaConfig
+}
+
+func (s *ServiceProvider) MfaService(ctx context.Context) service.MfaService {
+	if s.mfaService == nil {
+		r := mfaService.NewService(s.MfaConfig(), s.MfaRepository(ctx), s.UserRepository(ctx), s.TxManager(ctx), time.Now)
+		s.mfaService = r
+	}
+
+	return s.mfaService
+}
+
+func (s *ServiceProvider) MfaRepository(ctx context.Context) repository.MfaRepository {
+	if s.mfaRepository == nil {
+		r := mfaRepository.NewRepository(s.DB(ctx))
+		s.mfaRepository = r
+	}
+
+	return s.mfaRepository
+}
+
+func (s *ServiceProvider) LoginConfig() config.LoginConfig {
+	if s.loginConfig == nil {
+
+		loginConfig, err := env.NewLoginConfig()
+
+		if err != nil {
+			s.Logger().Error("failed to load config", "error", err)
+			os.Exit(1)
+		}
+
+		s.loginConfig = loginConfig
+
+	}
+
+	return s.loginConfig
+}
+
+func (s *ServiceProvider) LockoutService(ctx context.Context) service.LockoutService {
+	if s.lockoutService == nil {
+		r := lockoutService.NewService(s.LoginConfig(), s.LockoutRepository(ctx), time.Now)
+		s.lockoutService = r
+	}
+
+	return s.lockoutService
+}
+
+func (s *ServiceProvider) LockoutRepository(ctx context.Context) repository.LoginAttemptRepository {
+	if s.lockoutRepository == nil {
+		r := lockoutRepository.NewRepository(s.DB(ctx))
+		s.lockoutRepository = r
+	}
+
+	return s.lockoutRepository
+}
+
+func (s *ServiceProvider) MailConfig() config.MailConfig {
+	if s.mailConfig == nil {
+
+		mailConfig, err := env.NewMailConfig()
+
+		if err != nil {
+			s.Logger().Error("failed to load config", "error", err)
+			os.Exit(1)
+		}
+
+		s.mailConfig = mailConfig
+
+	}
+
+	return s.mailConfig
+}
+
+func (s *ServiceProvider) Mailer() mail.Mailer {
+	if s.mailer == nil {
+		cfg := s.MailConfig()
+
+		if cfg.GetSender() == config.MailSMTP {
+			m, err := mailSmtp.New(cfg.GetSMTPAddress(), cfg.GetSMTPUsername(), cfg.GetSMTPPassword(), cfg.GetFrom())
+			if err != nil {
+				s.Logger().Error("failed to create mailer", "error", err)
+				os.Exit(1)
+			}
+
+			s.mailer = m
+		} else {
+			s.mailer = mailFile.New(cfg.GetFilePath(), cfg.GetFrom(), s.Logger())
+		}
+	}
+
+	return s.mailer
+}
+
+func (s *ServiceProvider) PasswordResetConfig() config.PasswordResetConfig {
+	if s.resetConfig == nil {
+
+		resetConfig, err := env.NewPasswordResetConfig()
+
+		if err != nil {
+			s.Logger().Error("failed to load config", "error", err)
+			os.Exit(1)
+		}
+
+		s.resetConfig = resetConfig
+
+	}
+
+	return s.resetConfig
+}
+
+func (s *ServiceProvider) PasswordResetService(ctx context.Context) service.PasswordResetService {
+	if s.resetService == nil {
+		r := resetService.NewService(
+			s.PasswordResetConfig(),
+			s.UserRepository(ctx),
+			s.UserTokenRepository(ctx),
+			s.Mailer(),
+			s.PasswordHasher(),
+			s.PasswordPolicyService(),
+			s.AuthService(ctx),
+			s.LockoutService(ctx),
+			s.TxManager(ctx),
+			s.Logger(),
+			time.Now,
+		)
+		s.resetService = r
+	}
+
+	return s.resetService
+}
+
+func (s *ServiceProvider) MagicLinkConfig() config.MagicLinkConfig {
+	if s.magicConfig == nil {
+
+		magicConfig, err := env.NewMagicLinkConfig()
+
+		if err != nil {
+			s.Logger().Error("failed to load config", "error", err)
+			os.Exit(1)
+		}
+
+		s.magicConfig = magicConfig
+
+	}
+
+	return s.magicConfig
+}
+
+func (s *ServiceProvider) MagicLinkService(ctx context.Context) service.MagicLinkService {
+	if s.magicService == nil {
+		r := magicService.NewService(
+			s.MagicLinkConfig(),
+			s.UserRepository(ctx),
+			s.UserTokenRepository(ctx),
+			s.Mailer(),
+			s.TxManager(ctx),
+			s.Logger(),
+			time.Now,
+		)
+		s.magicService = r
+	}
+
+	return s.magicService
+}
+
+func (s *ServiceProvider) VerificationConfig() config.VerificationConfig {
+	if s.verifyConfig == nil {
+
+		verifyConfig, err := env.NewVerificationConfig()
+
+		if err != nil {
+			s.Logger().Error("failed to load config", "error", err)
+			os.Exit(1)
+		}
+
+		s.verifyConfig = verifyConfig
+
+	}
+
+	return s.verifyConfig
+}
+
+func (s *ServiceProvider) VerificationService(ctx context.Context) service.VerificationService {
+	if s.verificationService == nil {
+		r := verificationService.NewService(
+			s.VerificationConfig(),
+			s.UserRepository(ctx),
+			s.UserTokenRepository(ctx),
+			s.Mailer(),
+			s.TxManager(ctx),
+			time.Now,
+		)
+		s.verificationService = r
+	}
+
+	return s.verificationService
+}
+
+func (s *ServiceProvider) UserTokenRepository(ctx context.Context) repository.UserTokenRepository {
+	if s.userTokenRepository == nil {
+		r := tokenRepository.NewRepository(s.DB(ctx))
+		s.userTokenRepository = r
+	}
+
+	return s.userTokenRepository
+}
+
+func (s *ServiceProvider) WebAuthnConfig() config.WebAuthnConfig {
+	if s.webAuthnConfig == nil {
+
+		webAuthnConfig, err := env.NewWebAuthnConfig()
+
+		if err != nil {
+			s.Logger().Error("failed to load config", "error", err)
+			os.Exit(1)
+		}
+
+		s.webAuthnConfig = webAuthnConfig
+
+	}
+
+	return s.webAuthnConfig
+}
+
+func (s *ServiceProvider) WebAuthnService(ctx context.Context) service.WebAuthnService {
+	if s.webAuthnService == nil {
+		r := webauthnService.NewService(s.WebAuthnConfig(), s.WebAuthnRepository(ctx), s.UserRepository(ctx), time.Now)
+		s.webAuthnService = r
+	}
+
+	return s.webAuthnService
+}
+
+func (s *ServiceProvider) WebAuthnRepository(ctx context.Context) repository.WebAuthnRepository {
+	if s.webAuthnRepository == nil {
+		r := webauthnRepository.NewRepository(s.DB(ctx))
+		s.webAuthnRepository = r
+	}
+
+	return s.webAuthnRepository
+}
+
+func (s *ServiceProvider) SessionService(ctx context.Context) service.SessionService {
+	if s.sessionService == nil {
+		r := sessionService.NewService(s.SessionRepository(ctx), s.RefreshTokenRepository(ctx))
+		s.sessionService = r
+	}
+
+	return s.sessionService
+}
+
+func (s *ServiceProvider) SessionRepository(ctx context.Context) repository.SessionRepository {
+	if s.sessionRepository == nil {
+		r := sessionRepository.NewRepository(s.DB(ctx))
+		s.sessionRepository = r
+	}
+
+	return s.sessionRepository
+}
+
 func (s *ServiceProvider) UserApi(ctx context.Context) *userApi.ServerUser {
 	if s.userApi == nil {
-		a := userApi.NewUserServer(s.UserService(ctx))
+		a := userApi.NewUserServer(
+			s.UserService(ctx),
+			s.SessionService(ctx),
+			s.LockoutService(ctx),
+			s.VerificationService(ctx),
+		)
 		s.userApi = a
 	}
 
@@ -201,13 +761,67 @@ func (s *ServiceProvider) UserApi(ctx context.Context) *userApi.ServerUser {
 
 func (s *ServiceProvider) AccessApi(ctx context.Context) *accessApi.ServerAccess {
 	if s.accessApi == nil {
-		a := accessApi.NewAccessServer(s.AuthService(ctx), s.AccessService(ctx), s.Logger())
+		a := accessApi.NewAccessServer(s.AuthService(ctx), s.ApiKeyService(ctx), s.AccessService(ctx), s.Logger())
 		s.accessApi = a
 	}
 
 	return s.accessApi
 }
 
+func (s *ServiceProvider) ImpersonationService(ctx context.Context) service.ImpersonationService {
+	if s.impersonationService == nil {
+		r := impersonationService.NewService(
+			s.UserRepository(ctx),
+			s.AccessRepository(ctx),
+			s.AuthService(ctx),
+			s.DBLogger(ctx),
+		)
+		s.impersonationService = r
+	}
+
+	return s.impersonationService
+}
+
+func (s *ServiceProvider) ApiKeyConfig() config.ApiKeyConfig {
+	if s.apiKeyConfig == nil {
+
+		apiKeyConfig, err := env.NewApiKeyConfig()
+
+		if err != nil {
+			s.Logger().Error("failed to load config", "error", err)
+			os.Exit(1)
+		}
+
+		s.apiKeyConfig = apiKeyConfig
+
+	}
+
+	return s.apiKeyConfig
+}
+
+func (s *ServiceProvider) ApiKeyService(ctx context.Context) service.ApiKeyService {
+	if s.apiKeyService == nil {
+		r := apiKeyService.NewService(
+			s.ApiKeyConfig(),
+			s.ApiKeyRepository(ctx),
+			s.UserRepository(ctx),
+			time.Now,
+		)
+		s.apiKeyService = r
+	}
+
+	return s.apiKeyService
+}
+
+func (s *ServiceProvider) ApiKeyRepository(ctx context.Context) repository.ApiKeyRepository {
+	if s.apiKeyRepository == nil {
+		r := apiKeyRepository.NewRepository(s.DB(ctx))
+		s.apiKeyRepository = r
+	}
+
+	return s.apiKeyRepository
+}
+
 func (s *ServiceProvider) AccessService(ctx context.Context) service.AccessService {
 	if s.accessService == nil {
 		r := accessService.NewService(s.AccessRepository(ctx))
@@ -233,7 +847,7 @@ func (s *ServiceProvider) JwtConfig() config.JwtConfig {
 		jwtConfig, err := env.NewJwtConfig()
 
 		if err != nil {
-			s.Logger().Error("failed to load config: %v", err)
+			s.Logger().Error("failed to load config", "error", err)
 			os.Exit(1)
 		}
 
@@ -244,11 +858,158 @@ func (s *ServiceProvider) JwtConfig() config.JwtConfig {
 	return s.jwtConfig
 }
 
+// KeyRing access token keys, the keys from the config sign until a stored key is promoted
+func (s *ServiceProvider) KeyRing() *utils.KeyRing {
+	if s.keyRing == nil {
+		var fallback []*utils.SigningKey
+
+		if s.JwtConfig().GetAccessKeyFile() != "" {
+			key, err := utils.LoadSigningKey(s.JwtConfig().GetAccessKeyFile())
+
+			if err != nil {
+				s.Logger().Error("failed to load access key", "error", err)
+				os.Exit(1)
+			}
+
+			fallback = append(fallback, key)
+		}
+
+		if s.JwtConfig().GetAccessSecret() != "" {
+			fallback = append(fallback, utils.NewHMACKey([]byte(s.JwtConfig().GetAccessSecret())))
+		}
+
+		s.keyRing = utils.NewKeyRing(fallback...)
+	}
+
+	return s.keyRing
+}
+
+func (s *ServiceProvider) KeyService(ctx context.Context) service.KeyService {
+	if s.keyService == nil {
+		r := keyService.NewService(
+			s.JwtConfig(),
+			s.KeyRing(),
+			s.KeyRepository(ctx),
+			s.TxManager(ctx),
+			// id_tokens are signed with the same keys and may outlive the access tokens
+			max(s.JwtConfig().GetAccessTokenTTL(), authService.IdTokenExpireTime),
+		)
+		s.keyService = r
+	}
+
+	return s.keyService
+}
+
+func (s *ServiceProvider) KeyRepository(ctx context.Context) repository.SigningKeyRepository {
+	if s.keyRepository == nil {
+		r := keyRepository.NewRepository(s.DB(ctx))
+		s.keyRepository = r
+	}
+
+	return s.keyRepository
+}
+
+func (s *ServiceProvider) OAuthApi(ctx context.Context) *oauthApi.ServerOAuth {
+	if s.oauthApi == nil {
+		a := oauthApi.NewOAuthServer(
+			s.AuthService(ctx),
+			s.ClientService(ctx),
+			s.UserService(ctx),
+			s.LockoutService(ctx),
+			s.DeviceService(ctx),
+			s.MfaService(ctx),
+			s.Logger(),
+		)
+		s.oauthApi = a
+	}
+
+	return s.oauthApi
+}
+
+func (s *ServiceProvider) ClientService(ctx context.Context) service.ClientService {
+	if s.clientService == nil {
+		r := clientService.NewService(s.ClientRepository(ctx))
+		s.clientService = r
+	}
+
+	return s.clientService
+}
+
+func (s *ServiceProvider) ClientRepository(ctx context.Context) repository.ClientRepository {
+	if s.clientRepository == nil {
+		r := clientRepository.NewRepository(s.DB(ctx))
+		s.clientRepository = r
+	}
+
+	return s.clientRepository
+}
+
+func (s *ServiceProvider) CodeRepository(ctx context.Context) repository.AuthorizationCodeRepository {
+	if s.codeRepository == nil {
+		r := codeRepository.NewRepository(s.DB(ctx))
+		s.codeRepository = r
+	}
+
+	return s.codeRepository
+}
+
+func (s *ServiceProvider) DeviceConfig() config.DeviceConfig {
+	if s.deviceConfig == nil {
+
+		deviceConfig, err := env.NewDeviceConfig()
+
+		if err != nil {
+			s.Logger().Error("failed to load config", "error", err)
+			os.Exit(1)
+		}
+
+		s.deviceConfig = deviceConfig
+
+	}
+
+	return s.deviceConfig
+}
+
+func (s *ServiceProvider) DeviceService(ctx context.Context) service.DeviceService {
+	if s.deviceService == nil {
+		r := deviceService.NewService(
+			s.DeviceConfig(),
+			s.JwtConfig(),
+			s.DeviceRepository(ctx),
+			s.UserRepository(ctx),
+			s.AuthService(ctx),
+			s.TxManager(ctx),
+			time.Now,
+		)
+		s.deviceService = r
+	}
+
+	return s.deviceService
+}
+
+func (s *ServiceProvider) DeviceRepository(ctx context.Context) repository.DeviceCodeRepository {
+	if s.deviceRepository == nil {
+		r := deviceRepository.NewRepository(s.DB(ctx))
+		s.deviceRepository = r
+	}
+
+	return s.deviceRepository
+}
+
 func (s *ServiceProvider) AuthApi(ctx context.Context) *authApi.ServerAuth {
 	if s.authApi == nil {
 		a := authApi.NewAuthServer(
 			s.AuthService(ctx),
 			s.UserService(ctx),
+			s.ClientService(ctx),
+			s.SessionService(ctx),
+			s.MfaService(ctx),
+			s.WebAuthnService(ctx),
+			s.LockoutService(ctx),
+			s.PasswordResetService(ctx),
+			s.MagicLinkService(ctx),
+			s.ApiKeyService(ctx),
+			s.ImpersonationService(ctx),
 		)
 		s.authApi = a
 	}
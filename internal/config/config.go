@@ -1,7 +1,11 @@
 package config
 
 import (
+	"time"
+
 	"github.com/joho/godotenv"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/utils"
 )
 
 var ConfigPathKey = "configPathKey"
@@ -12,6 +16,8 @@ type GRPCConfig interface {
 
 type HTTPConfig interface {
 	Address() string
+	// TrustedProxies the proxies in front of the service whose X-Forwarded-For hops are believed
+	TrustedProxies() utils.TrustedProxies
 }
 
 type PGConfig interface {
@@ -29,8 +35,143 @@ type PrometheusConfig interface {
 type JwtConfig interface {
 	GetAccessSecret() string
 	GetRefreshSecret() string
+	// GetAccessKeyFile path to the RS256 or Ed25519 PEM key signing access tokens, empty for HMAC
+	GetAccessKeyFile() string
+	// GetKeyEncryptionKey AES-256 key encrypting the key ring at rest, nil when the key ring is disabled
+	GetKeyEncryptionKey() []byte
+	GetKeyAlgorithm() string
+	// GetKeyRotationInterval how long a key signs before being replaced, 0 disables scheduled rotation
+	GetKeyRotationInterval() time.Duration
+	// GetIssuer public base URL of the service, used as iss of id tokens and in the discovery document
+	GetIssuer() string
+	// GetAudience aud of access and refresh tokens, defaults to the issuer
+	GetAudience() string
+	// GetClockSkew tolerated difference between the clocks of the issuer and a verifier
+	GetClockSkew() time.Duration
+	GetAccessTokenTTL() time.Duration
+	// GetSessionIdleTimeout lifetime of refresh tokens, a session not refreshed for this long ends
+	GetSessionIdleTimeout() time.Duration
+	// GetSessionMaxAge absolute session lifetime refresh can not extend, 0 means unlimited
+	GetSessionMaxAge() time.Duration
+}
+
+type MfaConfig interface {
+	// GetEncryptionKey AES-256 key encrypting TOTP secrets at rest, nil disables enrollment
+	GetEncryptionKey() []byte
+	// GetIssuer name shown next to the account in authenticator apps
+	GetIssuer() string
+}
+
+type WebAuthnConfig interface {
+	// GetRPID relying party id passkeys are bound to, the registrable domain of the origins; empty disables passkeys
+	GetRPID() string
+	// GetRPName name shown by the browser when creating a passkey
+	GetRPName() string
+	// GetOrigins origins the browser may report in client data
+	GetOrigins() []string
+}
+
+type LoginConfig interface {
+	// GetMaxFailures failed logins of an account before it is locked
+	GetMaxFailures() int
+	// GetMaxIpFailures failed logins from one address before it is locked
+	GetMaxIpFailures() int
+	// GetLockoutDuration how long a lockout lasts, failures older than that are forgotten
+	GetLockoutDuration() time.Duration
+	// GetBackoffBase delay after the first failure, doubled with each further one
+	GetBackoffBase() time.Duration
+}
+
+type RedisConfig interface {
+	// Address host:port of the server
+	Address() string
+	Password() string
+	DB() int
+}
+
+type RateLimitConfig interface {
+	// GetBackend RateLimitMemory counts per replica, RateLimitRedis shares the counts between replicas
+	GetBackend() string
+	GetLimits() []model.RateLimit
+}
+
+type MailConfig interface {
+	// GetSender MailSMTP or MailFile
+	GetSender() string
+	// GetFrom address the mails are sent from
+	GetFrom() string
+	GetSMTPAddress() string
+	GetSMTPUsername() string
+	GetSMTPPassword() string
+	// GetFilePath file the file sender appends to, empty logs the mails instead
+	GetFilePath() string
+}
+
+// Mail senders
+const (
+	MailSMTP = "smtp"
+	MailFile = "file"
+)
+
+type PasswordResetConfig interface {
+	// GetURL page of the frontend the reset link opens, the token is added as the token query parameter
+	GetURL() string
+	GetTokenTTL() time.Duration
+}
+
+type PasswordPolicyConfig interface {
+	// GetMinLength and GetMaxLength count characters, not bytes
+	GetMinLength() int
+	GetMaxLength() int
+	// GetMinClasses of lower case, upper case, digits and other characters a password must mix
+	GetMinClasses() int
+	// GetDisallowPersonal refuses passwords containing the name or the email of the user
+	GetDisallowPersonal() bool
+	// GetPwnedPath offline copy of the Have I Been Pwned passwords, empty disables the check
+	GetPwnedPath() string
+}
+
+type PasswordHashConfig interface {
+	// GetAlgorithm utils.PasswordArgon2id or utils.PasswordBcrypt, used for new hashes
+	GetAlgorithm() string
+	GetArgon2Params() utils.Argon2Params
+	GetBcryptCost() int
+}
+
+type ApiKeyConfig interface {
+	// GetDefaultTTL lifetime of keys created without one
+	GetDefaultTTL() time.Duration
+	// GetMaxTTL longest lifetime a key can be created with, every key expires
+	GetMaxTTL() time.Duration
 }
 
+type MagicLinkConfig interface {
+	// GetURL page of the frontend the login link opens, the token is added as the token query parameter
+	GetURL() string
+	GetTokenTTL() time.Duration
+}
+
+type DeviceConfig interface {
+	// GetCodeTTL time the user has to approve a device before its codes expire
+	GetCodeTTL() time.Duration
+	// GetPollInterval least time a device waits between two token requests
+	GetPollInterval() time.Duration
+}
+
+type VerificationConfig interface {
+	// GetURL page of the frontend the verification link opens, the token is added as the token query parameter
+	GetURL() string
+	GetTokenTTL() time.Duration
+	// GetRequired refuses password logins of users who have not verified their email
+	GetRequired() bool
+}
+
+// Rate limit backends
+const (
+	RateLimitMemory = "memory"
+	RateLimitRedis  = "redis"
+)
+
 func Load(path string) error {
 	err := godotenv.Load(path)
 	if err != nil {
@@ -0,0 +1,48 @@
+package env
+
+import (
+	"time"
+
+	"github.com/laiker/auth/internal/config"
+	"github.com/pkg/errors"
+)
+
+const (
+	apiKeyDefaultTTL = "API_KEY_DEFAULT_TTL"
+	apiKeyMaxTTL     = "API_KEY_MAX_TTL"
+
+	defaultApiKeyDefaultTTL = 90 * 24 * time.Hour
+	defaultApiKeyMaxTTL     = 365 * 24 * time.Hour
+)
+
+var _ config.ApiKeyConfig = (*ApiKeyConfig)(nil)
+
+type ApiKeyConfig struct {
+	defaultTTL time.Duration
+	maxTTL     time.Duration
+}
+
+func NewApiKeyConfig() (*ApiKeyConfig, error) {
+	maxTTL, err := durationEnv(apiKeyMaxTTL, defaultApiKeyMaxTTL)
+	if err != nil || maxTTL <= 0 {
+		return nil, errors.New("invalid api key max ttl")
+	}
+
+	defaultTTL, err := durationEnv(apiKeyDefaultTTL, min(defaultApiKeyDefaultTTL, maxTTL))
+	if err != nil || defaultTTL <= 0 || defaultTTL > maxTTL {
+		return nil, errors.New("invalid api key default ttl, it must not exceed the max ttl")
+	}
+
+	return &ApiKeyConfig{
+		defaultTTL: defaultTTL,
+		maxTTL:     maxTTL,
+	}, nil
+}
+
+func (cfg *ApiKeyConfig) GetDefaultTTL() time.Duration {
+	return cfg.defaultTTL
+}
+
+func (cfg *ApiKeyConfig) GetMaxTTL() time.Duration {
+	return cfg.maxTTL
+}
@@ -0,0 +1,49 @@
+package env
+
+import (
+	"time"
+
+	"github.com/laiker/auth/internal/config"
+	"github.com/pkg/errors"
+)
+
+const (
+	deviceCodeTTL      = "DEVICE_CODE_TTL"
+	devicePollInterval = "DEVICE_POLL_INTERVAL"
+
+	defaultDeviceCodeTTL      = 10 * time.Minute
+	defaultDevicePollInterval = 5 * time.Second
+)
+
+var _ config.DeviceConfig = (*DeviceConfig)(nil)
+
+type DeviceConfig struct {
+	codeTTL      time.Duration
+	pollInterval time.Duration
+}
+
+func NewDeviceConfig() (*DeviceConfig, error) {
+	codeTTL, err := durationEnv(deviceCodeTTL, defaultDeviceCodeTTL)
+	if err != nil || codeTTL <= 0 {
+		return nil, errors.New("invalid device code ttl")
+	}
+
+	// the interval is sent to devices in whole seconds
+	pollInterval, err := durationEnv(devicePollInterval, defaultDevicePollInterval)
+	if err != nil || pollInterval < time.Second {
+		return nil, errors.New("device poll interval must be at least a second")
+	}
+
+	return &DeviceConfig{
+		codeTTL:      codeTTL,
+		pollInterval: pollInterval,
+	}, nil
+}
+
+func (cfg *DeviceConfig) GetCodeTTL() time.Duration {
+	return cfg.codeTTL
+}
+
+func (cfg *DeviceConfig) GetPollInterval() time.Duration {
+	return cfg.pollInterval
+}
@@ -5,19 +5,22 @@ import (
 	"os"
 
 	"github.com/laiker/auth/internal/config"
+	"github.com/laiker/auth/internal/utils"
 	"github.com/pkg/errors"
 )
 
 const (
-	httpHostEnvName = "HTTP_HOST"
-	httpPortEnvName = "HTTP_PORT"
+	httpHostEnvName           = "HTTP_HOST"
+	httpPortEnvName           = "HTTP_PORT"
+	httpTrustedProxiesEnvName = "HTTP_TRUSTED_PROXIES"
 )
 
 var _ config.HTTPConfig = (*HTTPConfig)(nil)
 
 type HTTPConfig struct {
-	host string
-	port string
+	host           string
+	port           string
+	trustedProxies utils.TrustedProxies
 }
 
 func NewHTTPConfig() (*HTTPConfig, error) {
@@ -31,12 +34,23 @@ func NewHTTPConfig() (*HTTPConfig, error) {
 		return nil, errors.New("http port not found")
 	}
 
+	// without proxies X-Forwarded-For is ignored, clients can put anything into it
+	trustedProxies, err := utils.ParseTrustedProxies(os.Getenv(httpTrustedProxiesEnvName))
+	if err != nil {
+		return nil, err
+	}
+
 	return &HTTPConfig{
-		host: host,
-		port: port,
+		host:           host,
+		port:           port,
+		trustedProxies: trustedProxies,
 	}, nil
 }
 
 func (cfg *HTTPConfig) Address() string {
 	return net.JoinHostPort(cfg.host, cfg.port)
 }
+
+func (cfg *HTTPConfig) TrustedProxies() utils.TrustedProxies {
+	return cfg.trustedProxies
+}
@@ -1,7 +1,12 @@
 package env
 
 import (
+	"encoding/base64"
+	"net"
+	"net/url"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/laiker/auth/internal/config"
 	"github.com/pkg/errors"
@@ -10,6 +15,23 @@ import (
 const (
 	jwtAccessSecret  = "JWT_ACCESS_SECRET"  //nolint:golint,gosec
 	jwtRefreshSecret = "JWT_REFRESH_SECRET" //nolint:golint,gosec
+	jwtAccessKeyFile = "JWT_ACCESS_KEY_FILE"
+
+	jwtKeyEncryptionKey    = "JWT_KEY_ENCRYPTION_KEY" //nolint:golint,gosec
+	jwtKeyAlgorithm        = "JWT_KEY_ALGORITHM"
+	jwtKeyRotationInterval = "JWT_KEY_ROTATION_INTERVAL"
+	jwtIssuer              = "JWT_ISSUER"
+	jwtAudience            = "JWT_AUDIENCE"
+	jwtClockSkew           = "JWT_CLOCK_SKEW"
+	jwtAccessTokenTTL      = "JWT_ACCESS_TOKEN_TTL"
+	jwtSessionIdleTimeout  = "JWT_SESSION_IDLE_TIMEOUT"
+	jwtSessionMaxAge       = "JWT_SESSION_MAX_AGE"
+
+	defaultKeyAlgorithm = "EdDSA"
+	defaultClockSkew    = 30 * time.Second
+
+	defaultAccessTokenTTL     = 24 * time.Hour
+	defaultSessionIdleTimeout = 30 * 24 * time.Hour
 )
 
 var _ config.JwtConfig = (*JwtConfig)(nil)
@@ -17,11 +39,24 @@ var _ config.JwtConfig = (*JwtConfig)(nil)
 type JwtConfig struct {
 	accessSecret  string
 	refreshSecret string
+	accessKeyFile string
+
+	keyEncryptionKey    []byte
+	keyAlgorithm        string
+	keyRotationInterval time.Duration
+	issuer              string
+	audience            string
+	clockSkew           time.Duration
+	accessTokenTTL      time.Duration
+	sessionIdleTimeout  time.Duration
+	sessionMaxAge       time.Duration
 }
 
 func NewJwtConfig() (*JwtConfig, error) {
+	accessKeyFile := os.Getenv(jwtAccessKeyFile)
+
 	accessSecret := os.Getenv(jwtAccessSecret)
-	if len(accessSecret) == 0 {
+	if len(accessSecret) == 0 && len(accessKeyFile) == 0 {
 		return nil, errors.New("jwt access token not found")
 	}
 
@@ -30,12 +65,101 @@ func NewJwtConfig() (*JwtConfig, error) {
 		return nil, errors.New("jwt refresh token not found")
 	}
 
+	var keyEncryptionKey []byte
+	if encoded := os.Getenv(jwtKeyEncryptionKey); len(encoded) > 0 {
+		var err error
+
+		keyEncryptionKey, err = base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(keyEncryptionKey) != 32 {
+			return nil, errors.New("jwt key encryption key must be 32 base64 encoded bytes")
+		}
+	}
+
+	keyAlgorithm := os.Getenv(jwtKeyAlgorithm)
+	if len(keyAlgorithm) == 0 {
+		keyAlgorithm = defaultKeyAlgorithm
+	}
+
+	var keyRotationInterval time.Duration
+	if interval := os.Getenv(jwtKeyRotationInterval); len(interval) > 0 {
+		var err error
+
+		keyRotationInterval, err = time.ParseDuration(interval)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid jwt key rotation interval")
+		}
+	}
+
+	if keyRotationInterval > 0 && keyEncryptionKey == nil {
+		return nil, errors.New("jwt key rotation requires a key encryption key")
+	}
+
+	// without an explicit issuer the service is assumed to be reached directly on its HTTP address
+	issuer := os.Getenv(jwtIssuer)
+	if len(issuer) == 0 {
+		issuer = "http://" + net.JoinHostPort(os.Getenv(httpHostEnvName), os.Getenv(httpPortEnvName))
+	}
+
+	issuerUrl, err := url.Parse(issuer)
+	if err != nil || issuerUrl.Scheme == "" || issuerUrl.Host == "" || issuerUrl.RawQuery != "" {
+		return nil, errors.New("jwt issuer must be an absolute URL without a query")
+	}
+
+	issuer = strings.TrimSuffix(issuer, "/")
+
+	audience := os.Getenv(jwtAudience)
+	if len(audience) == 0 {
+		audience = issuer
+	}
+
+	clockSkew := defaultClockSkew
+	if skew := os.Getenv(jwtClockSkew); len(skew) > 0 {
+		clockSkew, err = time.ParseDuration(skew)
+		if err != nil || clockSkew < 0 {
+			return nil, errors.New("invalid jwt clock skew")
+		}
+	}
+
+	accessTokenTTL, err := durationEnv(jwtAccessTokenTTL, defaultAccessTokenTTL)
+	if err != nil || accessTokenTTL <= 0 {
+		return nil, errors.New("invalid jwt access token ttl")
+	}
+
+	sessionIdleTimeout, err := durationEnv(jwtSessionIdleTimeout, defaultSessionIdleTimeout)
+	if err != nil || sessionIdleTimeout <= 0 {
+		return nil, errors.New("invalid jwt session idle timeout")
+	}
+
+	sessionMaxAge, err := durationEnv(jwtSessionMaxAge, 0)
+	if err != nil || sessionMaxAge < 0 {
+		return nil, errors.New("invalid jwt session max age")
+	}
+
 	return &JwtConfig{
-		accessSecret:  accessSecret,
-		refreshSecret: refreshSecret,
+		accessSecret:        accessSecret,
+		refreshSecret:       refreshSecret,
+		accessKeyFile:       accessKeyFile,
+		keyEncryptionKey:    keyEncryptionKey,
+		keyAlgorithm:        keyAlgorithm,
+		keyRotationInterval: keyRotationInterval,
+		issuer:              issuer,
+		audience:            audience,
+		clockSkew:           clockSkew,
+		accessTokenTTL:      accessTokenTTL,
+		sessionIdleTimeout:  sessionIdleTimeout,
+		sessionMaxAge:       sessionMaxAge,
 	}, nil
 }
 
+func durationEnv(name string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if len(value) == 0 {
+		return def, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
 func (cfg *JwtConfig) GetAccessSecret() string {
 	return cfg.accessSecret
 }
@@ -43,3 +167,43 @@ func (cfg *JwtConfig) GetAccessSecret() string {
 func (cfg *JwtConfig) GetRefreshSecret() string {
 	return cfg.refreshSecret
 }
+
+func (cfg *JwtConfig) GetAccessKeyFile() string {
+	return cfg.accessKeyFile
+}
+
+func (cfg *JwtConfig) GetKeyEncryptionKey() []byte {
+	return cfg.keyEncryptionKey
+}
+
+func (cfg *JwtConfig) GetKeyAlgorithm() string {
+	return cfg.keyAlgorithm
+}
+
+func (cfg *JwtConfig) GetKeyRotationInterval() time.Duration {
+	return cfg.keyRotationInterval
+}
+
+func (cfg *JwtConfig) GetIssuer() string {
+	return cfg.issuer
+}
+
+func (cfg *JwtConfig) GetAudience() string {
+	return cfg.audience
+}
+
+func (cfg *JwtConfig) GetClockSkew() time.Duration {
+	return cfg.clockSkew
+}
+
+func (cfg *JwtConfig) GetAccessTokenTTL() time.Duration {
+	return cfg.accessTokenTTL
+}
+
+func (cfg *JwtConfig) GetSessionIdleTimeout() time.Duration {
+	return cfg.sessionIdleTimeout
+}
+
+func (cfg *JwtConfig) GetSessionMaxAge() time.Duration {
+	return cfg.sessionMaxAge
+}
@@ -0,0 +1,85 @@
+package env
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/laiker/auth/internal/config"
+	"github.com/pkg/errors"
+)
+
+const (
+	loginMaxFailures     = "LOGIN_MAX_FAILURES"
+	loginMaxIpFailures   = "LOGIN_MAX_IP_FAILURES"
+	loginLockoutDuration = "LOGIN_LOCKOUT_DURATION"
+	loginBackoffBase     = "LOGIN_BACKOFF_BASE"
+
+	defaultLoginMaxFailures     = 5
+	defaultLoginMaxIpFailures   = 50
+	defaultLoginLockoutDuration = 15 * time.Minute
+	defaultLoginBackoffBase     = time.Second
+)
+
+var _ config.LoginConfig = (*LoginConfig)(nil)
+
+type LoginConfig struct {
+	maxFailures     int
+	maxIpFailures   int
+	lockoutDuration time.Duration
+	backoffBase     time.Duration
+}
+
+func NewLoginConfig() (*LoginConfig, error) {
+	maxFailures, err := intEnv(loginMaxFailures, defaultLoginMaxFailures)
+	if err != nil || maxFailures <= 0 {
+		return nil, errors.New("invalid login max failures")
+	}
+
+	maxIpFailures, err := intEnv(loginMaxIpFailures, defaultLoginMaxIpFailures)
+	if err != nil || maxIpFailures <= 0 {
+		return nil, errors.New("invalid login max ip failures")
+	}
+
+	lockoutDuration, err := durationEnv(loginLockoutDuration, defaultLoginLockoutDuration)
+	if err != nil || lockoutDuration <= 0 {
+		return nil, errors.New("invalid login lockout duration")
+	}
+
+	backoffBase, err := durationEnv(loginBackoffBase, defaultLoginBackoffBase)
+	if err != nil || backoffBase < 0 {
+		return nil, errors.New("invalid login backoff base")
+	}
+
+	return &LoginConfig{
+		maxFailures:     maxFailures,
+		maxIpFailures:   maxIpFailures,
+		lockoutDuration: lockoutDuration,
+		backoffBase:     backoffBase,
+	}, nil
+}
+
+func intEnv(name string, def int) (int, error) {
+	value := os.Getenv(name)
+	if len(value) == 0 {
+		return def, nil
+	}
+
+	return strconv.Atoi(value)
+}
+
+func (cfg *LoginConfig) GetMaxFailures() int {
+	return cfg.maxFailures
+}
+
+func (cfg *LoginConfig) GetMaxIpFailures() int {
+	return cfg.maxIpFailures
+}
+
+func (cfg *LoginConfig) GetLockoutDuration() time.Duration {
+	return cfg.lockoutDuration
+}
+
+func (cfg *LoginConfig) GetBackoffBase() time.Duration {
+	return cfg.backoffBase
+}
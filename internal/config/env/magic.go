@@ -0,0 +1,55 @@
+package env
+
+import (
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/laiker/auth/internal/config"
+	"github.com/pkg/errors"
+)
+
+const (
+	magicLinkURL      = "MAGIC_LINK_URL"
+	magicLinkTokenTTL = "MAGIC_LINK_TOKEN_TTL" //nolint:golint,gosec
+
+	defaultMagicLinkURL      = "http://localhost:3000/magic-link"
+	defaultMagicLinkTokenTTL = 15 * time.Minute
+)
+
+var _ config.MagicLinkConfig = (*MagicLinkConfig)(nil)
+
+type MagicLinkConfig struct {
+	url      string
+	tokenTTL time.Duration
+}
+
+func NewMagicLinkConfig() (*MagicLinkConfig, error) {
+	linkURL := os.Getenv(magicLinkURL)
+	if len(linkURL) == 0 {
+		linkURL = defaultMagicLinkURL
+	}
+
+	parsed, err := url.Parse(linkURL)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return nil, errors.New("magic link url must be absolute")
+	}
+
+	tokenTTL, err := durationEnv(magicLinkTokenTTL, defaultMagicLinkTokenTTL)
+	if err != nil || tokenTTL <= 0 {
+		return nil, errors.New("invalid magic link token ttl")
+	}
+
+	return &MagicLinkConfig{
+		url:      linkURL,
+		tokenTTL: tokenTTL,
+	}, nil
+}
+
+func (cfg *MagicLinkConfig) GetURL() string {
+	return cfg.url
+}
+
+func (cfg *MagicLinkConfig) GetTokenTTL() time.Duration {
+	return cfg.tokenTTL
+}
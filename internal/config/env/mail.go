@@ -0,0 +1,89 @@
+package env
+
+import (
+	"net"
+	"os"
+
+	"github.com/laiker/auth/internal/config"
+	"github.com/pkg/errors"
+)
+
+const (
+	mailSender       = "MAIL_SENDER"
+	mailFrom         = "MAIL_FROM"
+	mailFilePath     = "MAIL_FILE"
+	mailSMTPAddress  = "SMTP_ADDRESS"
+	mailSMTPUsername = "SMTP_USERNAME"
+	mailSMTPPassword = "SMTP_PASSWORD" //nolint:golint,gosec
+
+	defaultMailFrom = "no-reply@localhost"
+)
+
+var _ config.MailConfig = (*MailConfig)(nil)
+
+type MailConfig struct {
+	sender       string
+	from         string
+	filePath     string
+	smtpAddress  string
+	smtpUsername string
+	smtpPassword string
+}
+
+// NewMailConfig MAIL_SENDER is smtp or file, file by default so local setups need no mail server
+func NewMailConfig() (*MailConfig, error) {
+	sender := os.Getenv(mailSender)
+	if len(sender) == 0 {
+		sender = config.MailFile
+	}
+
+	if sender != config.MailSMTP && sender != config.MailFile {
+		return nil, errors.Errorf("unknown mail sender %q", sender)
+	}
+
+	from := os.Getenv(mailFrom)
+	if len(from) == 0 {
+		from = defaultMailFrom
+	}
+
+	smtpAddress := os.Getenv(mailSMTPAddress)
+
+	if sender == config.MailSMTP {
+		if _, _, err := net.SplitHostPort(smtpAddress); err != nil {
+			return nil, errors.New("smtp address must be host:port")
+		}
+	}
+
+	return &MailConfig{
+		sender:       sender,
+		from:         from,
+		filePath:     os.Getenv(mailFilePath),
+		smtpAddress:  smtpAddress,
+		smtpUsername: os.Getenv(mailSMTPUsername),
+		smtpPassword: os.Getenv(mailSMTPPassword),
+	}, nil
+}
+
+func (cfg *MailConfig) GetSender() string {
+	return cfg.sender
+}
+
+func (cfg *MailConfig) GetFrom() string {
+	return cfg.from
+}
+
+func (cfg *MailConfig) GetSMTPAddress() string {
+	return cfg.smtpAddress
+}
+
+func (cfg *MailConfig) GetSMTPUsername() string {
+	return cfg.smtpUsername
+}
+
+func (cfg *MailConfig) GetSMTPPassword() string {
+	return cfg.smtpPassword
+}
+
+func (cfg *MailConfig) GetFilePath() string {
+	return cfg.filePath
+}
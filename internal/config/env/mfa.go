@@ -0,0 +1,53 @@
+package env
+
+import (
+	"encoding/base64"
+	"os"
+
+	"github.com/laiker/auth/internal/config"
+	"github.com/pkg/errors"
+)
+
+const (
+	mfaEncryptionKey = "MFA_ENCRYPTION_KEY" //nolint:golint,gosec
+	mfaIssuer        = "MFA_ISSUER"
+
+	defaultMfaIssuer = "auth"
+)
+
+var _ config.MfaConfig = (*MfaConfig)(nil)
+
+type MfaConfig struct {
+	encryptionKey []byte
+	issuer        string
+}
+
+func NewMfaConfig() (*MfaConfig, error) {
+	var encryptionKey []byte
+	if encoded := os.Getenv(mfaEncryptionKey); len(encoded) > 0 {
+		var err error
+
+		encryptionKey, err = base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(encryptionKey) != 32 {
+			return nil, errors.New("mfa encryption key must be 32 base64 encoded bytes")
+		}
+	}
+
+	issuer := os.Getenv(mfaIssuer)
+	if len(issuer) == 0 {
+		issuer = defaultMfaIssuer
+	}
+
+	return &MfaConfig{
+		encryptionKey: encryptionKey,
+		issuer:        issuer,
+	}, nil
+}
+
+func (cfg *MfaConfig) GetEncryptionKey() []byte {
+	return cfg.encryptionKey
+}
+
+func (cfg *MfaConfig) GetIssuer() string {
+	return cfg.issuer
+}
@@ -0,0 +1,86 @@
+package env
+
+import (
+	"os"
+
+	"github.com/laiker/auth/internal/config"
+	"github.com/laiker/auth/internal/utils"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	passwordHashAlgorithm = "PASSWORD_HASH_ALGORITHM"
+	argon2Memory          = "ARGON2_MEMORY"
+	argon2Iterations      = "ARGON2_ITERATIONS"
+	argon2Parallelism     = "ARGON2_PARALLELISM"
+	bcryptCost            = "BCRYPT_COST"
+
+	// RFC 9106 second recommended option, 64 MiB of memory
+	defaultArgon2Memory      = 64 * 1024
+	defaultArgon2Iterations  = 3
+	defaultArgon2Parallelism = 4
+)
+
+var _ config.PasswordHashConfig = (*PasswordHashConfig)(nil)
+
+type PasswordHashConfig struct {
+	algorithm  string
+	argon2     utils.Argon2Params
+	bcryptCost int
+}
+
+// NewPasswordHashConfig ARGON2_MEMORY is in KiB. Stored hashes of another algorithm or cost
+// are replaced on the next successful login.
+func NewPasswordHashConfig() (*PasswordHashConfig, error) {
+	algorithm := os.Getenv(passwordHashAlgorithm)
+	if len(algorithm) == 0 {
+		algorithm = utils.PasswordArgon2id
+	}
+
+	if algorithm != utils.PasswordArgon2id && algorithm != utils.PasswordBcrypt {
+		return nil, errors.Errorf("unknown password hashing algorithm %q", algorithm)
+	}
+
+	memory, err := intEnv(argon2Memory, defaultArgon2Memory)
+	if err != nil || memory <= 0 || memory > 1<<22 {
+		return nil, errors.New("invalid argon2 memory")
+	}
+
+	iterations, err := intEnv(argon2Iterations, defaultArgon2Iterations)
+	if err != nil || iterations <= 0 || iterations > 100 {
+		return nil, errors.New("invalid argon2 iterations")
+	}
+
+	parallelism, err := intEnv(argon2Parallelism, defaultArgon2Parallelism)
+	if err != nil || parallelism <= 0 || parallelism > 255 {
+		return nil, errors.New("invalid argon2 parallelism")
+	}
+
+	cost, err := intEnv(bcryptCost, bcrypt.DefaultCost)
+	if err != nil || cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return nil, errors.New("invalid bcrypt cost")
+	}
+
+	return &PasswordHashConfig{
+		algorithm: algorithm,
+		argon2: utils.Argon2Params{
+			Memory:      uint32(memory),
+			Iterations:  uint32(iterations),
+			Parallelism: uint8(parallelism),
+		},
+		bcryptCost: cost,
+	}, nil
+}
+
+func (cfg *PasswordHashConfig) GetAlgorithm() string {
+	return cfg.algorithm
+}
+
+func (cfg *PasswordHashConfig) GetArgon2Params() utils.Argon2Params {
+	return cfg.argon2
+}
+
+func (cfg *PasswordHashConfig) GetBcryptCost() int {
+	return cfg.bcryptCost
+}
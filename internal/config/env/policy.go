@@ -0,0 +1,82 @@
+package env
+
+import (
+	"os"
+
+	"github.com/laiker/auth/internal/config"
+	"github.com/pkg/errors"
+)
+
+const (
+	passwordMinLength        = "PASSWORD_MIN_LENGTH"
+	passwordMaxLength        = "PASSWORD_MAX_LENGTH"
+	passwordMinClasses       = "PASSWORD_MIN_CLASSES"
+	passwordDisallowPersonal = "PASSWORD_DISALLOW_PERSONAL"
+	pwnedPasswordsPath       = "PWNED_PASSWORDS_PATH"
+
+	defaultPasswordMinLength  = 8
+	defaultPasswordMaxLength  = 128
+	defaultPasswordMinClasses = 1
+)
+
+var _ config.PasswordPolicyConfig = (*PasswordPolicyConfig)(nil)
+
+type PasswordPolicyConfig struct {
+	minLength        int
+	maxLength        int
+	minClasses       int
+	disallowPersonal bool
+	pwnedPath        string
+}
+
+// NewPasswordPolicyConfig PWNED_PASSWORDS_PATH is a directory of <PREFIX>.txt range files
+// or a single file of full hashes sorted by hash, as PwnedPasswordsDownloader writes them
+func NewPasswordPolicyConfig() (*PasswordPolicyConfig, error) {
+	minLength, err := intEnv(passwordMinLength, defaultPasswordMinLength)
+	if err != nil || minLength <= 0 {
+		return nil, errors.New("invalid password min length")
+	}
+
+	maxLength, err := intEnv(passwordMaxLength, defaultPasswordMaxLength)
+	if err != nil || maxLength < minLength {
+		return nil, errors.New("invalid password max length")
+	}
+
+	minClasses, err := intEnv(passwordMinClasses, defaultPasswordMinClasses)
+	if err != nil || minClasses < 0 || minClasses > 4 {
+		return nil, errors.New("invalid password min classes")
+	}
+
+	disallowPersonal, err := boolEnv(passwordDisallowPersonal, true)
+	if err != nil {
+		return nil, errors.New("invalid password disallow personal flag")
+	}
+
+	return &PasswordPolicyConfig{
+		minLength:        minLength,
+		maxLength:        maxLength,
+		minClasses:       minClasses,
+		disallowPersonal: disallowPersonal,
+		pwnedPath:        os.Getenv(pwnedPasswordsPath),
+	}, nil
+}
+
+func (cfg *PasswordPolicyConfig) GetMinLength() int {
+	return cfg.minLength
+}
+
+func (cfg *PasswordPolicyConfig) GetMaxLength() int {
+	return cfg.maxLength
+}
+
+func (cfg *PasswordPolicyConfig) GetMinClasses() int {
+	return cfg.minClasses
+}
+
+func (cfg *PasswordPolicyConfig) GetDisallowPersonal() bool {
+	return cfg.disallowPersonal
+}
+
+func (cfg *PasswordPolicyConfig) GetPwnedPath() string {
+	return cfg.pwnedPath
+}
@@ -0,0 +1,121 @@
+package env
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/laiker/auth/internal/config"
+	"github.com/laiker/auth/internal/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	rateLimitBackend = "RATE_LIMIT_BACKEND"
+	rateLimits       = "RATE_LIMITS"
+
+	// defaultRateLimits slows down guessing credentials and mailing links from one address or to one inbox
+	defaultRateLimits = "/auth_v1.AuthV1/Login=ip:20/1m," +
+		"/auth_v1.AuthV1/VerifyMfa=ip:20/1m," +
+		"/auth_v1.AuthV1/FinishPasskeyLogin=ip:20/1m," +
+		"/auth_v1.AuthV1/ClientCredentials=ip:60/1m," +
+		"/auth_v1.AuthV1/RequestPasswordReset=ip:5/1h," +
+		"/auth_v1.AuthV1/ResetPassword=ip:20/1m," +
+		"/auth_v1.AuthV1/RequestMagicLink=ip:10/1h," +
+		"/auth_v1.AuthV1/RequestMagicLink=email:3/15m," +
+		"/auth_v1.AuthV1/ConsumeMagicLink=ip:20/1m," +
+		"/user_v1.UserV1/VerifyEmail=ip:20/1m," +
+		"/user_v1.UserV1/ChangePassword=user:5/15m," +
+		"POST /oauth/token=ip:60/1m," +
+		"POST /oauth/authorize=ip:20/1m," +
+		"POST /oauth/device_authorization=ip:20/1m," +
+		"POST /oauth/device=ip:20/1m"
+)
+
+var _ config.RateLimitConfig = (*RateLimitConfig)(nil)
+
+type RateLimitConfig struct {
+	backend string
+	limits  []model.RateLimit
+}
+
+// NewRateLimitConfig RATE_LIMITS is a comma separated list of <full method>=<key>:<burst>/<period>,
+// e.g. /auth_v1.AuthV1/Login=ip:20/1m allows 20 logins a minute from an address.
+// Plain HTTP endpoints are named by their route, e.g. POST /oauth/token=ip:60/1m.
+// The key is ip, user, client or email and the method * applies to all methods. Set it empty to disable limits.
+func NewRateLimitConfig() (*RateLimitConfig, error) {
+	backend := os.Getenv(rateLimitBackend)
+	if len(backend) == 0 {
+		backend = config.RateLimitMemory
+	}
+
+	if backend != config.RateLimitMemory && backend != config.RateLimitRedis {
+		return nil, errors.Errorf("unknown rate limit backend %q", backend)
+	}
+
+	value, ok := os.LookupEnv(rateLimits)
+	if !ok {
+		value = defaultRateLimits
+	}
+
+	limits, err := parseRateLimits(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RateLimitConfig{
+		backend: backend,
+		limits:  limits,
+	}, nil
+}
+
+func parseRateLimits(value string) ([]model.RateLimit, error) {
+	var limits []model.RateLimit
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+
+		method, rest, ok := strings.Cut(entry, "=")
+		key, rate, ok2 := strings.Cut(rest, ":")
+		burst, period, ok3 := strings.Cut(rate, "/")
+
+		if !ok || !ok2 || !ok3 || len(method) == 0 {
+			return nil, errors.Errorf("rate limit %q must be <method>=<key>:<burst>/<period>", entry)
+		}
+
+		if key != model.RateLimitByIp && key != model.RateLimitByUser && key != model.RateLimitByClient &&
+			key != model.RateLimitByEmail {
+			return nil, errors.Errorf("rate limit %q has unknown key %q", entry, key)
+		}
+
+		limit := model.RateLimit{Method: method, Key: key}
+
+		var err error
+
+		limit.Burst, err = strconv.Atoi(burst)
+		if err != nil || limit.Burst <= 0 {
+			return nil, errors.Errorf("rate limit %q has invalid burst", entry)
+		}
+
+		limit.Period, err = time.ParseDuration(period)
+		if err != nil || limit.Period < time.Duration(limit.Burst) {
+			return nil, errors.Errorf("rate limit %q has invalid period", entry)
+		}
+
+		limits = append(limits, limit)
+	}
+
+	return limits, nil
+}
+
+func (cfg *RateLimitConfig) GetBackend() string {
+	return cfg.backend
+}
+
+func (cfg *RateLimitConfig) GetLimits() []model.RateLimit {
+	return cfg.limits
+}
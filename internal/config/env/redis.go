@@ -0,0 +1,52 @@
+package env
+
+import (
+	"os"
+
+	"github.com/laiker/auth/internal/config"
+	"github.com/pkg/errors"
+)
+
+const (
+	redisAddress  = "REDIS_ADDRESS"
+	redisPassword = "REDIS_PASSWORD" //nolint:golint,gosec
+	redisDB       = "REDIS_DB"
+)
+
+var _ config.RedisConfig = (*RedisConfig)(nil)
+
+type RedisConfig struct {
+	address  string
+	password string
+	db       int
+}
+
+func NewRedisConfig() (*RedisConfig, error) {
+	address := os.Getenv(redisAddress)
+	if len(address) == 0 {
+		return nil, errors.New("redis address not found")
+	}
+
+	db, err := intEnv(redisDB, 0)
+	if err != nil || db < 0 {
+		return nil, errors.New("invalid redis db")
+	}
+
+	return &RedisConfig{
+		address:  address,
+		password: os.Getenv(redisPassword),
+		db:       db,
+	}, nil
+}
+
+func (cfg *RedisConfig) Address() string {
+	return cfg.address
+}
+
+func (cfg *RedisConfig) Password() string {
+	return cfg.password
+}
+
+func (cfg *RedisConfig) DB() int {
+	return cfg.db
+}
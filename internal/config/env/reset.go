@@ -0,0 +1,55 @@
+package env
+
+import (
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/laiker/auth/internal/config"
+	"github.com/pkg/errors"
+)
+
+const (
+	passwordResetURL      = "PASSWORD_RESET_URL"
+	passwordResetTokenTTL = "PASSWORD_RESET_TOKEN_TTL" //nolint:golint,gosec
+
+	defaultPasswordResetURL      = "http://localhost:3000/reset-password"
+	defaultPasswordResetTokenTTL = time.Hour
+)
+
+var _ config.PasswordResetConfig = (*PasswordResetConfig)(nil)
+
+type PasswordResetConfig struct {
+	url      string
+	tokenTTL time.Duration
+}
+
+func NewPasswordResetConfig() (*PasswordResetConfig, error) {
+	resetURL := os.Getenv(passwordResetURL)
+	if len(resetURL) == 0 {
+		resetURL = defaultPasswordResetURL
+	}
+
+	parsed, err := url.Parse(resetURL)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return nil, errors.New("password reset url must be absolute")
+	}
+
+	tokenTTL, err := durationEnv(passwordResetTokenTTL, defaultPasswordResetTokenTTL)
+	if err != nil || tokenTTL <= 0 {
+		return nil, errors.New("invalid password reset token ttl")
+	}
+
+	return &PasswordResetConfig{
+		url:      resetURL,
+		tokenTTL: tokenTTL,
+	}, nil
+}
+
+func (cfg *PasswordResetConfig) GetURL() string {
+	return cfg.url
+}
+
+func (cfg *PasswordResetConfig) GetTokenTTL() time.Duration {
+	return cfg.tokenTTL
+}
@@ -0,0 +1,77 @@
+package env
+
+import (
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/laiker/auth/internal/config"
+	"github.com/pkg/errors"
+)
+
+const (
+	verificationURL      = "EMAIL_VERIFICATION_URL"
+	verificationTokenTTL = "EMAIL_VERIFICATION_TOKEN_TTL" //nolint:golint,gosec
+	verificationRequired = "EMAIL_VERIFICATION_REQUIRED"
+
+	defaultVerificationURL      = "http://localhost:3000/verify-email"
+	defaultVerificationTokenTTL = 24 * time.Hour
+)
+
+var _ config.VerificationConfig = (*VerificationConfig)(nil)
+
+type VerificationConfig struct {
+	url      string
+	tokenTTL time.Duration
+	required bool
+}
+
+func NewVerificationConfig() (*VerificationConfig, error) {
+	verifyURL := os.Getenv(verificationURL)
+	if len(verifyURL) == 0 {
+		verifyURL = defaultVerificationURL
+	}
+
+	parsed, err := url.Parse(verifyURL)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return nil, errors.New("email verification url must be absolute")
+	}
+
+	tokenTTL, err := durationEnv(verificationTokenTTL, defaultVerificationTokenTTL)
+	if err != nil || tokenTTL <= 0 {
+		return nil, errors.New("invalid email verification token ttl")
+	}
+
+	required, err := boolEnv(verificationRequired, false)
+	if err != nil {
+		return nil, errors.New("invalid email verification required flag")
+	}
+
+	return &VerificationConfig{
+		url:      verifyURL,
+		tokenTTL: tokenTTL,
+		required: required,
+	}, nil
+}
+
+func boolEnv(name string, def bool) (bool, error) {
+	value := os.Getenv(name)
+	if len(value) == 0 {
+		return def, nil
+	}
+
+	return strconv.ParseBool(value)
+}
+
+func (cfg *VerificationConfig) GetURL() string {
+	return cfg.url
+}
+
+func (cfg *VerificationConfig) GetTokenTTL() time.Duration {
+	return cfg.tokenTTL
+}
+
+func (cfg *VerificationConfig) GetRequired() bool {
+	return cfg.required
+}
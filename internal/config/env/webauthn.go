@@ -0,0 +1,74 @@
+package env
+
+import (
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/laiker/auth/internal/config"
+	"github.com/pkg/errors"
+)
+
+const (
+	webAuthnRPID    = "WEBAUTHN_RP_ID"
+	webAuthnRPName  = "WEBAUTHN_RP_NAME"
+	webAuthnOrigins = "WEBAUTHN_ORIGINS"
+
+	defaultWebAuthnRPName = "auth"
+)
+
+var _ config.WebAuthnConfig = (*WebAuthnConfig)(nil)
+
+type WebAuthnConfig struct {
+	rpId    string
+	rpName  string
+	origins []string
+}
+
+// NewWebAuthnConfig WEBAUTHN_ORIGINS is a comma separated list, https://<rp id> by default
+func NewWebAuthnConfig() (*WebAuthnConfig, error) {
+	rpId := os.Getenv(webAuthnRPID)
+
+	rpName := os.Getenv(webAuthnRPName)
+	if len(rpName) == 0 {
+		rpName = defaultWebAuthnRPName
+	}
+
+	var origins []string
+
+	for _, origin := range strings.Split(os.Getenv(webAuthnOrigins), ",") {
+		origin = strings.TrimSpace(origin)
+		if len(origin) == 0 {
+			continue
+		}
+
+		parsed, err := url.Parse(origin)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" || parsed.Path != "" {
+			return nil, errors.Errorf("webauthn origin %q must be scheme://host[:port]", origin)
+		}
+
+		origins = append(origins, origin)
+	}
+
+	if len(origins) == 0 && len(rpId) > 0 {
+		origins = []string{"https://" + rpId}
+	}
+
+	return &WebAuthnConfig{
+		rpId:    rpId,
+		rpName:  rpName,
+		origins: origins,
+	}, nil
+}
+
+func (cfg *WebAuthnConfig) GetRPID() string {
+	return cfg.rpId
+}
+
+func (cfg *WebAuthnConfig) GetRPName() string {
+	return cfg.rpName
+}
+
+func (cfg *WebAuthnConfig) GetOrigins() []string {
+	return cfg.origins
+}
@@ -0,0 +1,150 @@
+package converter
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/utils"
+	"github.com/laiker/auth/pkg/auth_v1"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func ToIntrospectResponse(introspection model.Introspection) *auth_v1.IntrospectResponse {
+	res := &auth_v1.IntrospectResponse{
+		Active:    introspection.Active,
+		Sub:       introspection.Sub,
+		Username:  introspection.Username,
+		Role:      introspection.Role,
+		Scope:     introspection.Scope,
+		TokenType: introspection.TokenType,
+		Jti:       introspection.Jti,
+		Exp:       introspection.Exp,
+		Iat:       introspection.Iat,
+		ClientId:  introspection.ClientId,
+		Act:       toActor(introspection.Act),
+	}
+
+	return res
+}
+
+// toActor the delegation chain, nil when nobody acts for the subject
+func toActor(actor *model.Actor) *auth_v1.Actor {
+	if actor == nil {
+		return nil
+	}
+
+	return &auth_v1.Actor{
+		Sub:      actor.Subject,
+		Username: actor.UserLogin,
+		ClientId: actor.ClientId,
+		Act:      toActor(actor.Act),
+	}
+}
+
+func ToClientFromCreateRequest(req *auth_v1.CreateClientRequest) *model.ClientInfo {
+	return &model.ClientInfo{
+		Name:              req.GetName(),
+		Team:              req.GetTeam(),
+		Scopes:            req.GetScopes(),
+		RedirectUris:      req.GetRedirectUris(),
+		Public:            req.GetPublic(),
+		AccessTokenTTL:    req.GetAccessTokenTtl().AsDuration(),
+		ExchangeAudiences: req.GetExchangeAudiences(),
+	}
+}
+
+func ToClientFromUpdateRequest(req *auth_v1.UpdateClientRequest) *model.Client {
+	return &model.Client{
+		Id:                req.GetId(),
+		Name:              req.GetName(),
+		Team:              req.GetTeam(),
+		Scopes:            req.GetScopes(),
+		RedirectUris:      req.GetRedirectUris(),
+		AccessTokenTTL:    toSeconds(req.GetAccessTokenTtl().AsDuration()),
+		ExchangeAudiences: req.GetExchangeAudiences(),
+	}
+}
+
+func ToClientFromService(client *model.Client) *auth_v1.Client {
+	res := &auth_v1.Client{
+		Id:                client.Id,
+		Name:              client.Name,
+		Team:              client.Team,
+		Scopes:            client.Scopes,
+		CreatedAt:         timestamppb.New(client.CreatedAt),
+		RedirectUris:      client.RedirectUris,
+		Public:            client.Public,
+		ExchangeAudiences: client.ExchangeAudiences,
+	}
+
+	if client.AccessTokenTTL.Valid {
+		res.AccessTokenTtl = durationpb.New(time.Duration(client.AccessTokenTTL.Int64) * time.Second)
+	}
+
+	return res
+}
+
+// toSeconds lifetime override stored in seconds, 0 keeps the configured lifetime
+func toSeconds(d time.Duration) sql.NullInt64 {
+	if d == 0 {
+		return sql.NullInt64{}
+	}
+
+	return sql.NullInt64{Int64: int64(d / time.Second), Valid: true}
+}
+
+// ToSessionFromService current marks the session the access token was issued for
+func ToSessionFromService(session *model.Session, current string) *auth_v1.Session {
+	return &auth_v1.Session{
+		Id:         session.Id,
+		UserAgent:  session.UserAgent,
+		Ip:         session.Ip,
+		ClientId:   session.ClientId.String,
+		CreatedAt:  timestamppb.New(session.CreatedAt),
+		LastSeenAt: timestamppb.New(session.LastSeenAt),
+		Current:    session.Id == current,
+	}
+}
+
+func ToApiKeyFromCreateRequest(req *auth_v1.CreateApiKeyRequest) *model.ApiKeyInfo {
+	return &model.ApiKeyInfo{
+		Name:   req.GetName(),
+		Scopes: req.GetScopes(),
+		TTL:    req.GetTtl().AsDuration(),
+	}
+}
+
+func ToApiKeyFromService(key *model.ApiKey) *auth_v1.ApiKey {
+	apiKey := &auth_v1.ApiKey{
+		Id:         key.Id,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     key.Scopes,
+		CreatedAt:  timestamppb.New(key.CreatedAt),
+		ExpiresAt:  timestamppb.New(key.ExpiresAt),
+		LastUsedIp: key.LastUsedIp.String,
+	}
+
+	if key.LastUsedAt.Valid {
+		apiKey.LastUsedAt = timestamppb.New(key.LastUsedAt.Time)
+	}
+
+	return apiKey
+}
+
+func ToPasskeyFromService(credential *model.WebAuthnCredential) *auth_v1.Passkey {
+	passkey := &auth_v1.Passkey{
+		CredentialId: utils.WebAuthnEncoding.EncodeToString(credential.Id),
+		Name:         credential.Name,
+		Transports:   credential.Transports,
+		CreatedAt:    timestamppb.New(credential.CreatedAt),
+	}
+
+	if credential.LastUsedAt.Valid {
+		passkey.LastUsedAt = timestamppb.New(credential.LastUsedAt.Time)
+	}
+
+	return passkey
+}
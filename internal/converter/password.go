@@ -0,0 +1,29 @@
+package converter
+
+import (
+	"github.com/laiker/auth/internal/model"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ToStatusFromPasswordViolations InvalidArgument listing the broken rules as violations of the password field
+func ToStatusFromPasswordViolations(violations []model.PasswordViolation) *status.Status {
+	st := status.New(codes.InvalidArgument, "password does not meet the policy")
+
+	badRequest := &errdetails.BadRequest{}
+	for _, v := range violations {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       "password",
+			Description: v.Description,
+			Reason:      v.Rule,
+		})
+	}
+
+	detailed, err := st.WithDetails(badRequest)
+	if err != nil {
+		return st
+	}
+
+	return detailed
+}
@@ -3,6 +3,7 @@ package converter
 import (
 	"github.com/laiker/auth/internal/model"
 	"github.com/laiker/auth/pkg/user_v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func ToUserFromCreateRequest(user *user_v1.CreateRequest) *model.UserInfo {
@@ -29,6 +30,17 @@ func ToUserFromUpdateRequest(user *user_v1.UpdateRequest) *model.User {
 	return &model.User{
 		Id:    user.GetId().GetValue(),
 		Name:  user.GetName().String(),
-		Email: user.GetEmail().String(),
+		Email: user.GetEmail().GetValue(),
+	}
+}
+
+func ToUserSessionFromService(session *model.Session) *user_v1.Session {
+	return &user_v1.Session{
+		Id:         session.Id,
+		UserAgent:  session.UserAgent,
+		Ip:         session.Ip,
+		ClientId:   session.ClientId.String,
+		CreatedAt:  timestamppb.New(session.CreatedAt),
+		LastSeenAt: timestamppb.New(session.LastSeenAt),
 	}
 }
@@ -0,0 +1,43 @@
+package interceptor
+
+import (
+	"context"
+
+	log "github.com/laiker/auth/internal/logger"
+	"github.com/laiker/auth/internal/logger/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AuditInterceptor writes every request made with an impersonation token to the audit log,
+// naming both the impersonated user and the admin behind the token, also when the token was exchanged since. It runs after AuthInterceptor.
+// A request that can not be audited is refused.
+func AuditInterceptor(auditLogger logger.DBLoggerInterface) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		claims, ok := ClaimsFromContext(ctx)
+		impersonator := claims.Act.Impersonator()
+
+		if !ok || impersonator == nil {
+			return handler(ctx, req)
+		}
+
+		name := "impersonated " + info.FullMethod
+
+		// access checks of other services name the endpoint the user called there
+		if check, ok := req.(interface{ GetEndpointAddress() string }); ok && check.GetEndpointAddress() != "" {
+			name += " " + check.GetEndpointAddress()
+		}
+
+		err := auditLogger.Log(ctx, log.LogData{
+			Name:     name,
+			EntityID: claims.UserId,
+			ActorID:  impersonator.UserId,
+		})
+		if err != nil {
+			return nil, status.Error(codes.Internal, "failed to audit impersonated request")
+		}
+
+		return handler(ctx, req)
+	}
+}
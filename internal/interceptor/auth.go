@@ -0,0 +1,92 @@
+package interceptor
+
+import (
+	"context"
+
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/service"
+	"github.com/laiker/auth/internal/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type claimsKey struct{}
+
+// AuthInterceptor enforces the permission table for our own endpoints.
+// Open endpoints are passed through, with the claims of the caller when the authorization header is valid.
+func AuthInterceptor(
+	authService service.AuthService,
+	apiKeyService service.ApiKeyService,
+	accessService service.AccessService,
+) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		open, err := accessService.HasAccessRight(ctx, info.FullMethod, model.UserClaims{})
+		if err != nil {
+			return nil, status.Error(codes.Internal, "failed to check access")
+		}
+
+		if open {
+			// the caller is still identified, e.g. for the audit of impersonated requests
+			if claims, err := Authenticate(ctx, authService, apiKeyService); err == nil {
+				ctx = ContextWithClaims(ctx, claims)
+			}
+
+			return handler(ctx, req)
+		}
+
+		claims, err := Authenticate(ctx, authService, apiKeyService)
+		if err != nil {
+			return nil, err
+		}
+
+		allowed, err := accessService.HasAccessRight(ctx, info.FullMethod, claims)
+		if err != nil {
+			return nil, status.Error(codes.Internal, "failed to check access")
+		}
+
+		if !allowed {
+			return nil, status.Error(codes.PermissionDenied, "access denied")
+		}
+
+		return handler(ContextWithClaims(ctx, claims), req)
+	}
+}
+
+// Authenticate claims of the caller from the authorization header,
+// a Bearer access token or an ApiKey personal access token
+func Authenticate(ctx context.Context, authService service.AuthService, apiKeyService service.ApiKeyService) (model.UserClaims, error) {
+	apiKey, err := utils.GetApiKey(ctx)
+	if err == nil {
+		claims, err := apiKeyService.Verify(ctx, apiKey, utils.DeviceFromContext(ctx).Ip)
+		if err != nil {
+			return model.UserClaims{}, status.Error(codes.Unauthenticated, "api key is invalid")
+		}
+
+		return claims, nil
+	}
+
+	accessToken, err := utils.GetBearerToken(ctx)
+	if err != nil {
+		return model.UserClaims{}, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	claims, err := authService.VerifyAccessToken(ctx, accessToken)
+	if err != nil {
+		return model.UserClaims{}, status.Error(codes.Unauthenticated, "access token is invalid")
+	}
+
+	return claims, nil
+}
+
+// ContextWithClaims the context of a request made by the caller of the claims
+func ContextWithClaims(ctx context.Context, claims model.UserClaims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFromContext claims of the caller, always set for restricted endpoints
+func ClaimsFromContext(ctx context.Context) (model.UserClaims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(model.UserClaims)
+
+	return claims, ok
+}
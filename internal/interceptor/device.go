@@ -0,0 +1,26 @@
+package interceptor
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/laiker/auth/internal/utils"
+	"google.golang.org/grpc"
+)
+
+// DeviceInterceptor resolves the caller once for the lockout, the rate limits and the sessions,
+// so it runs before the other interceptors
+func DeviceInterceptor(proxies utils.TrustedProxies) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(utils.WithDevice(ctx, utils.DeviceFromMetadata(ctx, proxies)), req)
+	}
+}
+
+// DeviceMiddleware resolves the caller of the plain HTTP endpoints like DeviceInterceptor
+func DeviceMiddleware(proxies utils.TrustedProxies) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(utils.WithDevice(r.Context(), utils.DeviceFromRequest(r, proxies))))
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package interceptor
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/laiker/auth/internal/metrics"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/ratelimit"
+	"github.com/laiker/auth/internal/utils"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// RateLimitInterceptor takes a token from every limit of the called method before handling it.
+// Limits keyed by user or client only count callers authenticated as one, so it runs after AuthInterceptor.
+// Requests are let through when the limiter fails, an unavailable backend must not stop logins.
+func RateLimitInterceptor(limiter ratelimit.Limiter, limits []model.RateLimit) grpc.UnaryServerInterceptor {
+	byMethod := make(map[string][]model.RateLimit)
+	for _, limit := range limits {
+		byMethod[limit.Method] = append(byMethod[limit.Method], limit)
+	}
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		caller := func(key string) (string, bool) {
+			return rateLimitCaller(ctx, req, key)
+		}
+
+		wait := takeTokens(ctx, limiter, info.FullMethod, caller, byMethod[info.FullMethod], byMethod[model.RateLimitAnyMethod])
+		if wait > 0 {
+			return nil, rateLimitError(ctx, wait)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// RateLimitMiddleware limits the plain HTTP endpoints with the limits of their route pattern,
+// e.g. "POST /oauth/token". Only limits keyed by ip or by the email of a form apply,
+// the caller is not authenticated yet. It wraps the handlers of a http.ServeMux, which sets the pattern.
+func RateLimitMiddleware(limiter ratelimit.Limiter, limits []model.RateLimit) func(http.Handler) http.Handler {
+	byMethod := make(map[string][]model.RateLimit)
+	for _, limit := range limits {
+		byMethod[limit.Method] = append(byMethod[limit.Method], limit)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			caller := func(key string) (string, bool) {
+				switch key {
+				case model.RateLimitByIp:
+					ip := utils.DeviceFromContext(r.Context()).Ip
+					return ip, ip != ""
+				case model.RateLimitByEmail:
+					email := r.PostFormValue("email")
+					return strings.ToLower(email), email != ""
+				}
+
+				return "", false
+			}
+
+			wait := takeTokens(r.Context(), limiter, r.Pattern, caller, byMethod[r.Pattern], byMethod[model.RateLimitAnyMethod])
+			if wait > 0 {
+				w.Header().Set("Retry-After", retryAfter(wait))
+				http.Error(w, "too many requests, try again later", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// takeTokens takes a token from every limit with a caller and returns the wait of an exhausted one.
+// Requests are let through when the limiter fails, an unavailable backend must not stop logins.
+func takeTokens(ctx context.Context, limiter ratelimit.Limiter, method string, caller func(key string) (string, bool), limits ...[]model.RateLimit) time.Duration {
+	for _, methodLimits := range limits {
+		for _, limit := range methodLimits {
+			key, ok := caller(limit.Key)
+			if !ok {
+				continue
+			}
+
+			wait, err := limiter.Allow(ctx, limit.Method+"|"+limit.Key+":"+key, limit)
+			if err != nil {
+				log.Printf("failed to check rate limit of %s: %v", method, err)
+				continue
+			}
+
+			if wait > 0 {
+				metrics.IncRateLimited(method)
+				return wait
+			}
+		}
+	}
+
+	return 0
+}
+
+func rateLimitCaller(ctx context.Context, req interface{}, key string) (string, bool) {
+	switch key {
+	case model.RateLimitByIp:
+		ip := utils.DeviceFromContext(ctx).Ip
+		return ip, ip != ""
+	case model.RateLimitByUser:
+		claims, ok := ClaimsFromContext(ctx)
+		if !ok || claims.UserId == 0 {
+			return "", false
+		}
+
+		return strconv.FormatInt(claims.UserId, 10), true
+	case model.RateLimitByClient:
+		claims, ok := ClaimsFromContext(ctx)
+		if !ok || claims.ClientId == "" {
+			return "", false
+		}
+
+		return claims.ClientId, true
+	case model.RateLimitByEmail:
+		withEmail, ok := req.(interface{ GetEmail() string })
+		if !ok || withEmail.GetEmail() == "" {
+			return "", false
+		}
+
+		return strings.ToLower(withEmail.GetEmail()), true
+	}
+
+	return "", false
+}
+
+// rateLimitError carries the wait in RetryInfo and, for HTTP clients of the gateway, in a retry-after header
+func rateLimitError(ctx context.Context, wait time.Duration) error {
+	_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", retryAfter(wait)))
+
+	st := status.New(codes.ResourceExhausted, "too many requests, try again later")
+
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(wait)})
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
+
+// retryAfter the wait in whole seconds, rounded up
+func retryAfter(wait time.Duration) string {
+	return strconv.FormatInt(int64((wait+time.Second-1)/time.Second), 10)
+}
@@ -0,0 +1,130 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/laiker/auth/internal/interceptor"
+	log "github.com/laiker/auth/internal/logger"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/service"
+	"github.com/laiker/auth/pkg/access_v1"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// tokenAuth accepts the tokens it maps to claims
+type tokenAuth struct {
+	service.AuthService
+	tokens map[string]model.UserClaims
+}
+
+func (a tokenAuth) VerifyAccessToken(_ context.Context, token string) (model.UserClaims, error) {
+	if claims, ok := a.tokens[token]; ok {
+		return claims, nil
+	}
+
+	return model.UserClaims{}, errors.New("access token is invalid")
+}
+
+// openAccess every endpoint is open
+type openAccess struct{}
+
+func (openAccess) HasAccessRight(context.Context, string, model.UserClaims) (bool, error) {
+	return true, nil
+}
+
+type auditLogger struct {
+	entries []log.LogData
+	err     error
+}
+
+func (l *auditLogger) Log(_ context.Context, data log.LogData) error {
+	if l.err != nil {
+		return l.err
+	}
+
+	l.entries = append(l.entries, data)
+
+	return nil
+}
+
+var auth = tokenAuth{tokens: map[string]model.UserClaims{
+	"user-token": {Type: model.TokenTypeAccess, UserId: 7, Role: "user"},
+	"impersonation-token": {
+		Type:   model.TokenTypeAccess,
+		UserId: 7,
+		Role:   "user",
+		Act:    &model.Actor{Subject: "1", UserId: 1},
+	},
+}}
+
+// audited runs a request with the token through AuthInterceptor and AuditInterceptor
+func audited(logger *auditLogger, token string, method string, req interface{}) (bool, error) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+	info := &grpc.UnaryServerInfo{FullMethod: method}
+
+	handled := false
+	handler := func(context.Context, interface{}) (interface{}, error) {
+		handled = true
+		return "ok", nil
+	}
+
+	_, err := interceptor.AuthInterceptor(auth, nil, openAccess{})(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return interceptor.AuditInterceptor(logger)(ctx, req, info, handler)
+	})
+
+	return handled, err
+}
+
+func TestAuditInterceptor(t *testing.T) {
+	logger := &auditLogger{}
+
+	if handled, err := audited(logger, "user-token", "/user_v1.UserV1/Get", nil); err != nil || !handled {
+		t.Fatalf("request of the user error = %v, handled = %v", err, handled)
+	}
+
+	if len(logger.entries) != 0 {
+		t.Fatalf("a request of the user was audited: %+v", logger.entries)
+	}
+
+	if handled, err := audited(logger, "impersonation-token", "/user_v1.UserV1/Get", nil); err != nil || !handled {
+		t.Fatalf("impersonated request error = %v, handled = %v", err, handled)
+	}
+
+	req := &access_v1.CheckRequest{EndpointAddress: "/chat_v1.ChatV1/Delete"}
+	if handled, err := audited(logger, "impersonation-token", "/access_v1.AccessV1/HasAccess", req); err != nil || !handled {
+		t.Fatalf("impersonated access check error = %v, handled = %v", err, handled)
+	}
+
+	want := []log.LogData{
+		{Name: "impersonated /user_v1.UserV1/Get", EntityID: 7, ActorID: 1},
+		{Name: "impersonated /access_v1.AccessV1/HasAccess /chat_v1.ChatV1/Delete", EntityID: 7, ActorID: 1},
+	}
+
+	if len(logger.entries) != len(want) {
+		t.Fatalf("audit entries %+v, want %+v", logger.entries, want)
+	}
+
+	for i := range want {
+		if logger.entries[i] != want[i] {
+			t.Errorf("audit entry %d = %+v, want %+v", i, logger.entries[i], want[i])
+		}
+	}
+}
+
+func TestAuditInterceptor_Fails(t *testing.T) {
+	logger := &auditLogger{err: errors.New("connection refused")}
+
+	handled, err := audited(logger, "impersonation-token", "/user_v1.UserV1/Get", nil)
+	if status.Code(err) != codes.Internal || handled {
+		t.Fatalf("unaudited request error = %v, handled = %v", err, handled)
+	}
+
+	if handled, err = audited(logger, "user-token", "/user_v1.UserV1/Get", nil); err != nil || !handled {
+		t.Fatalf("request of the user error = %v, handled = %v", err, handled)
+	}
+}
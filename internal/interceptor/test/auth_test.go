@@ -0,0 +1,37 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/laiker/auth/internal/interceptor"
+	"github.com/laiker/auth/internal/model"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// failingAccess the permission table can not be read
+type failingAccess struct{}
+
+func (failingAccess) HasAccessRight(context.Context, string, model.UserClaims) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func TestAuthInterceptor_AccessFails(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer user-token"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/auth_v1.AuthV1/DeleteClient"}
+
+	handled := false
+	handler := func(context.Context, interface{}) (interface{}, error) {
+		handled = true
+		return "ok", nil
+	}
+
+	_, err := interceptor.AuthInterceptor(auth, nil, failingAccess{})(ctx, nil, info, handler)
+	if status.Code(err) != codes.Internal || handled {
+		t.Fatalf("request without a permission check error = %v, handled = %v", err, handled)
+	}
+}
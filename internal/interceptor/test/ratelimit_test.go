@@ -0,0 +1,202 @@
+package test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/laiker/auth/internal/interceptor"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/ratelimit"
+	"github.com/laiker/auth/internal/utils"
+	"github.com/laiker/auth/pkg/auth_v1"
+	"github.com/pkg/errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const loginMethod = "/auth_v1.AuthV1/Login"
+
+var limits = []model.RateLimit{
+	{Method: loginMethod, Key: model.RateLimitByIp, Burst: 2, Period: time.Minute},
+	{Method: model.RateLimitAnyMethod, Key: model.RateLimitByUser, Burst: 1, Period: time.Minute},
+}
+
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(context.Context, string, model.RateLimit) (time.Duration, error) {
+	return 0, errors.New("connection refused")
+}
+
+func call(limiter ratelimit.Limiter, method string, ip string) error {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 41000}})
+
+	handler := func(context.Context, interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	_, err := interceptor.RateLimitInterceptor(limiter, limits)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+
+	return err
+}
+
+func TestRateLimitInterceptor(t *testing.T) {
+	limiter := ratelimit.NewMemoryLimiter(time.Now)
+
+	for i := 0; i < 2; i++ {
+		if err := call(limiter, loginMethod, "203.0.113.7"); err != nil {
+			t.Fatalf("request %d error = %v", i+1, err)
+		}
+	}
+
+	err := call(limiter, loginMethod, "203.0.113.7")
+
+	st := status.Convert(err)
+	if st.Code() != codes.ResourceExhausted {
+		t.Fatalf("code = %v, want ResourceExhausted", st.Code())
+	}
+
+	var retryInfo *errdetails.RetryInfo
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			retryInfo = info
+		}
+	}
+
+	if retryInfo == nil {
+		t.Fatal("the error has no RetryInfo")
+	}
+
+	if delay := retryInfo.GetRetryDelay().AsDuration(); delay <= 0 || delay > 30*time.Second {
+		t.Fatalf("retry delay = %v, want up to 30s", delay)
+	}
+
+	if err = call(limiter, loginMethod, "198.51.100.1"); err != nil {
+		t.Fatalf("another address error = %v", err)
+	}
+
+	// limits keyed by user do not apply without claims, other methods have no ip limit
+	for i := 0; i < 3; i++ {
+		if err = call(limiter, "/auth_v1.AuthV1/Introspect", "203.0.113.7"); err != nil {
+			t.Fatalf("unlimited method error = %v", err)
+		}
+	}
+}
+
+func TestRateLimitInterceptor_FailsOpen(t *testing.T) {
+	for i := 0; i < 3; i++ {
+		if err := call(failingLimiter{}, loginMethod, "203.0.113.7"); err != nil {
+			t.Fatalf("request %d error = %v", i+1, err)
+		}
+	}
+}
+
+func TestRateLimitInterceptor_ByEmail(t *testing.T) {
+	limiter := ratelimit.NewMemoryLimiter(time.Now)
+	method := "/auth_v1.AuthV1/RequestMagicLink"
+	byEmail := []model.RateLimit{{Method: method, Key: model.RateLimitByEmail, Burst: 1, Period: time.Minute}}
+
+	handler := func(context.Context, interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	send := func(email string) error {
+		_, err := interceptor.RateLimitInterceptor(limiter, byEmail)(
+			context.Background(),
+			&auth_v1.RequestMagicLinkRequest{Email: email},
+			&grpc.UnaryServerInfo{FullMethod: method},
+			handler,
+		)
+
+		return err
+	}
+
+	if err := send("alice@example.com"); err != nil {
+		t.Fatalf("first request error = %v", err)
+	}
+
+	if code := status.Code(send("Alice@Example.com")); code != codes.ResourceExhausted {
+		t.Fatalf("same email in another case code = %v, want ResourceExhausted", code)
+	}
+
+	if err := send("bob@example.com"); err != nil {
+		t.Fatalf("another email error = %v", err)
+	}
+}
+
+// every forged x-forwarded-for value must not get a fresh bucket
+func TestRateLimitInterceptor_ForgedForwardedFor(t *testing.T) {
+	limiter := ratelimit.NewMemoryLimiter(time.Now)
+	proxies := utils.TrustedProxies{netip.MustParsePrefix("127.0.0.0/8")}
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return interceptor.RateLimitInterceptor(limiter, limits)(ctx, req, &grpc.UnaryServerInfo{FullMethod: loginMethod},
+			func(context.Context, interface{}) (interface{}, error) { return "ok", nil })
+	}
+
+	var err error
+
+	for _, forged := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 41000}})
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-forwarded-for", forged))
+
+		_, err = interceptor.DeviceInterceptor(proxies)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: loginMethod}, handler)
+	}
+
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("third request code = %v, want ResourceExhausted", status.Code(err))
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	limiter := ratelimit.NewMemoryLimiter(time.Now)
+	byIp := []model.RateLimit{{Method: "POST /oauth/token", Key: model.RateLimitByIp, Burst: 2, Period: time.Minute}}
+	rateLimit := interceptor.RateLimitMiddleware(limiter, byIp)
+
+	mux := http.NewServeMux()
+	ok := func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) }
+	mux.Handle("POST /oauth/token", rateLimit(http.HandlerFunc(ok)))
+	mux.Handle("POST /oauth/introspect", rateLimit(http.HandlerFunc(ok)))
+
+	handler := interceptor.DeviceMiddleware(nil)(mux)
+
+	send := func(path string, remoteAddr string, forged string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, path, nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("X-Forwarded-For", forged)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		return w
+	}
+
+	for i, forged := range []string{"192.0.2.1", "192.0.2.2"} {
+		if w := send("/oauth/token", "203.0.113.7:41000", forged); w.Code != http.StatusOK {
+			t.Fatalf("request %d status = %d", i+1, w.Code)
+		}
+	}
+
+	w := send("/oauth/token", "203.0.113.7:41000", "192.0.2.3")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("status = %d, Retry-After = %q, want 429 with a wait", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	if w = send("/oauth/token", "198.51.100.1:41000", ""); w.Code != http.StatusOK {
+		t.Fatalf("another address status = %d", w.Code)
+	}
+
+	for i := 0; i < 3; i++ {
+		if w = send("/oauth/introspect", "203.0.113.7:41000", ""); w.Code != http.StatusOK {
+			t.Fatalf("unlimited route status = %d", w.Code)
+		}
+	}
+}
@@ -3,4 +3,6 @@ package logger
 type LogData struct {
 	Name     string
 	EntityID int64
+	// ActorID admin acting on behalf of the user EntityID, 0 when users act themselves
+	ActorID int64
 }
@@ -3,6 +3,7 @@ package logger
 import (
 	"context"
 	_ "context"
+	"database/sql"
 	"log"
 	"log/slog"
 
@@ -11,6 +12,10 @@ import (
 	"github.com/laiker/auth/internal/logger"
 )
 
+type DBLoggerInterface interface {
+	Log(ctx context.Context, data logger.LogData) error
+}
+
 type DBLogger struct {
 	*slog.Logger
 	db db.Client
@@ -23,8 +28,8 @@ func NewDBLogger(db db.Client, logger *slog.Logger) *DBLogger {
 func (l *DBLogger) Log(ctx context.Context, data logger.LogData) error {
 
 	sBuilder := sq.Insert("auth_user_log").
-		Columns("name", "entity_id").
-		Values(data.Name, data.EntityID).
+		Columns("name", "entity_id", "actor_id").
+		Values(data.Name, data.EntityID, sql.NullInt64{Int64: data.ActorID, Valid: data.ActorID != 0}).
 		PlaceholderFormat(sq.Dollar)
 
 	query, args, err := sBuilder.ToSql()
@@ -39,7 +44,7 @@ func (l *DBLogger) Log(ctx context.Context, data logger.LogData) error {
 		QueryRaw: query,
 	}
 
-	l.Logger.Info("Database Operation:", data)
+	l.Logger.Info("Database Operation:", "name", data.Name, "entity_id", data.EntityID, "actor_id", data.ActorID)
 
 	_, err = l.db.DB().ExecContext(ctx, q, args...)
 
@@ -16,6 +16,9 @@ type Metrics struct {
 	requestCounter        prometheus.Counter
 	responseCounter       *prometheus.CounterVec
 	histogramResponseTime *prometheus.HistogramVec
+	failedLoginCounter    *prometheus.CounterVec
+	lockoutCounter        *prometheus.CounterVec
+	rateLimitedCounter    *prometheus.CounterVec
 }
 
 var metrics *Metrics
@@ -49,6 +52,33 @@ func Init(_ context.Context) error {
 			},
 			[]string{"status"},
 		),
+		failedLoginCounter: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: "auth",
+				Name:      appName + "_failed_logins_total",
+				Help:      "Количество неудачных попыток входа",
+			},
+			[]string{"reason"},
+		),
+		lockoutCounter: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: "auth",
+				Name:      appName + "_lockouts_total",
+				Help:      "Количество блокировок входа после неудачных попыток",
+			},
+			[]string{"scope"},
+		),
+		rateLimitedCounter: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: "grpc",
+				Name:      appName + "_rate_limited_total",
+				Help:      "Количество запросов, отклоненных ограничением частоты",
+			},
+			[]string{"method"},
+		),
 	}
 
 	return nil
@@ -65,3 +95,31 @@ func IncResponseCounter(status string, method string) {
 func HistogramResponseTimeObserve(status string, time float64) {
 	metrics.histogramResponseTime.WithLabelValues(status).Observe(time)
 }
+
+// IncFailedLogin reason is invalid_credentials or locked.
+// The login counters are reported from services, which run without Init in tests.
+func IncFailedLogin(reason string) {
+	if metrics == nil {
+		return
+	}
+
+	metrics.failedLoginCounter.WithLabelValues(reason).Inc()
+}
+
+// IncLockout scope is account or ip
+func IncLockout(scope string) {
+	if metrics == nil {
+		return
+	}
+
+	metrics.lockoutCounter.WithLabelValues(scope).Inc()
+}
+
+// IncRateLimited method is the full method name of the rejected request
+func IncRateLimited(method string) {
+	if metrics == nil {
+		return
+	}
+
+	metrics.rateLimitedCounter.WithLabelValues(method).Inc()
+}
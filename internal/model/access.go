@@ -1,13 +1,22 @@
 package model
 
+import "database/sql"
+
 type Permission struct {
-	Id          int64  `json:"id" db:"permission_id"`
-	Endpoint    string `json:"endpoint" db:"resource_name"`
-	MinPriority int64  `json:"minPriority" db:"min_role_priority"`
+	Id            int64  `json:"id" db:"permission_id"`
+	Endpoint      string `json:"endpoint" db:"resource_name"`
+	MinPriority   int64  `json:"minPriority" db:"min_role_priority"`
+	RequiredScope string `json:"requiredScope" db:"required_scope"`
+	// RequirePasskey users must have started the session with a passkey
+	RequirePasskey bool `json:"requirePasskey" db:"require_passkey"`
 }
 
 type Role struct {
 	Id       int64  `db:"role_id"`
 	Name     string `db:"role_name"`
 	Priority int64  `db:"priority"`
+	// lifetime overrides in seconds, null keeps the configured lifetime
+	AccessTokenTTL     sql.NullInt64 `db:"access_token_ttl"`
+	SessionIdleTimeout sql.NullInt64 `db:"session_idle_timeout"`
+	SessionMaxAge      sql.NullInt64 `db:"session_max_age"`
 }
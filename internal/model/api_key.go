@@ -0,0 +1,32 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// ApiKeyPrefix starts every API key, the key reads ak_<prefix>_<secret>
+const ApiKeyPrefix = "ak_"
+
+// ApiKey personal access token of a user for scripts and CI, only the hash of the secret is stored
+type ApiKey struct {
+	Id     string `db:"id"`
+	UserId int64  `db:"user_id"`
+	Name   string `db:"name"`
+	// Prefix public part of the key the key is looked up by, shown in the key list
+	Prefix     string         `db:"prefix"`
+	SecretHash string         `db:"secret_hash"`
+	Scopes     []string       `db:"scopes"`
+	CreatedAt  time.Time      `db:"created_at"`
+	ExpiresAt  time.Time      `db:"expires_at"`
+	LastUsedAt sql.NullTime   `db:"last_used_at"`
+	LastUsedIp sql.NullString `db:"last_used_ip"`
+	RevokedAt  sql.NullTime   `db:"revoked_at"`
+}
+
+type ApiKeyInfo struct {
+	Name   string
+	Scopes []string
+	// TTL 0 keeps the configured lifetime
+	TTL time.Duration
+}
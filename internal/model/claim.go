@@ -1,16 +1,105 @@
 package model
 
-import "github.com/dgrijalva/jwt-go"
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+	// TokenTypeMfa challenge returned by the first login step, exchanged for tokens with a second factor
+	TokenTypeMfa = "mfa"
+	// TokenTypeApiKey claims of a caller authenticated with an API key, they are never signed into a token
+	TokenTypeApiKey = "api_key"
+)
+
+// RFC 8176 authentication method references
+const (
+	AmrPassword = "pwd"
+	AmrMfa      = "mfa"
+	// AmrPasskey proof of possession of a hardware-bound key, a WebAuthn assertion
+	AmrPasskey = "hwk"
+	// AmrOtp one-time password, a login link mailed to the user
+	AmrOtp = "otp"
+)
 
 type UserJwt struct {
 	UserId    int64  `json:"userId"`
 	UserLogin string `json:"userLogin"`
 	Role      string `json:"role"`
+	TokenId   string `json:"tokenId"`
+	FamilyId  string `json:"familyId"`
+	ClientId  string `json:"clientId"`
+	Scope     string `json:"scope"`
+	Azp       string `json:"azp"`
+	// Amr RFC 8176 methods the user authenticated with, carried over on refresh
+	Amr []string `json:"amr"`
+	// Act admin impersonating the user or the client a token was exchanged by
+	Act *Actor `json:"act"`
+	// Audience overrides the audience of the policy, set only for exchanged tokens
+	Audience string `json:"aud"`
+}
+
+// Actor RFC 8693 act claim, the party acting on behalf of the subject of the token
+type Actor struct {
+	// Subject user id of an admin as a string or the id of a client, like the sub of our tokens
+	Subject   string `json:"sub"`
+	UserId    int64  `json:"userId,omitempty"`
+	UserLogin string `json:"userLogin,omitempty"`
+	ClientId  string `json:"client_id,omitempty"`
+	// Act the prior actor of the delegation chain, the most recent actor is the outermost one
+	Act *Actor `json:"act,omitempty"`
+}
+
+// Impersonator the admin in the delegation chain, nil when only clients act for the subject
+func (a *Actor) Impersonator() *Actor {
+	for ; a != nil; a = a.Act {
+		if a.UserId != 0 {
+			return a
+		}
+	}
+
+	return nil
 }
 
 type UserClaims struct {
 	jwt.StandardClaims
+	// Type TokenTypeAccess or TokenTypeRefresh, TokenTypeApiKey for callers with an API key
+	Type      string `json:"typ"`
 	UserId    int64  `json:"userId"`
 	UserLogin string `json:"userLogin"`
 	Role      string `json:"role"`
+	FamilyId  string `json:"fid,omitempty"`
+	// ClientId is set instead of UserId for client_credentials tokens
+	ClientId string `json:"client_id,omitempty"`
+	// Scope space separated scopes granted to a client
+	Scope string `json:"scope,omitempty"`
+	// Azp client a user token was issued to by the authorization code flow
+	Azp string `json:"azp,omitempty"`
+	// Amr RFC 8176 authentication methods of the session, e.g. pwd, mfa or hwk
+	Amr []string `json:"amr,omitempty"`
+	// Act the admin impersonating the user or the client the token was exchanged by,
+	// requests made on behalf of an impersonated user are audited
+	Act *Actor `json:"act,omitempty"`
+	// IssuedAtMs iat in milliseconds, a logout revokes the tokens issued before it and not the whole second
+	IssuedAtMs int64 `json:"iat_ms,omitempty"`
+}
+
+// IssuedAtTime falls back to the iat seconds for tokens issued without iat_ms
+func (c *UserClaims) IssuedAtTime() time.Time {
+	if c.IssuedAtMs != 0 {
+		return time.UnixMilli(c.IssuedAtMs)
+	}
+
+	return time.Unix(c.IssuedAt, 0)
+}
+
+// IdTokenClaims OpenID Connect id_token, Subject is the user id and Audience the client id
+type IdTokenClaims struct {
+	jwt.StandardClaims
+	Nonce string `json:"nonce,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
 }
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"database/sql"
+	"slices"
+	"time"
+)
+
+// Client OAuth client, i.e. a service calling us on its own behalf
+type Client struct {
+	Id           string    `db:"id"`
+	Name         string    `db:"name"`
+	Team         string    `db:"team"`
+	Scopes       []string  `db:"scopes"`
+	RedirectUris []string  `db:"redirect_uris"`
+	Public       bool      `db:"public"`
+	SecretHash   string    `db:"secret_hash"`
+	CreatedAt    time.Time `db:"created_at"`
+	// AccessTokenTTL override in seconds, null keeps the lifetime of the user or the config
+	AccessTokenTTL sql.NullInt64 `db:"access_token_ttl"`
+	// ExchangeAudiences services the client may exchange user tokens for (RFC 8693), none when empty
+	ExchangeAudiences []string `db:"exchange_audiences"`
+}
+
+type ClientInfo struct {
+	Name         string
+	Team         string
+	Scopes       []string
+	RedirectUris []string
+	Public       bool
+	// AccessTokenTTL 0 keeps the configured lifetime
+	AccessTokenTTL    time.Duration
+	ExchangeAudiences []string
+}
+
+// AllowsScopes reports whether every requested scope is granted to the client
+func (c *Client) AllowsScopes(scopes []string) bool {
+	for _, scope := range scopes {
+		if !slices.Contains(c.Scopes, scope) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// AllowsAudience reports whether the client may exchange tokens for the audience
+func (c *Client) AllowsAudience(audience string) bool {
+	return slices.Contains(c.ExchangeAudiences, audience)
+}
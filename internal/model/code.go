@@ -0,0 +1,25 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// AuthorizationCode only the hash of the code is stored, the user is snapshotted at approval time
+type AuthorizationCode struct {
+	CodeHash      string `db:"code_hash"`
+	ClientId      string `db:"client_id"`
+	UserId        int64  `db:"user_id"`
+	UserLogin     string `db:"user_login"`
+	Role          string `db:"role"`
+	RedirectUri   string `db:"redirect_uri"`
+	Scope         string `db:"scope"`
+	CodeChallenge string `db:"code_challenge"`
+	Nonce         string `db:"nonce"`
+	// Amr how the user signed in to approve the request
+	Amr       []string       `db:"amr"`
+	FamilyId  sql.NullString `db:"family_id"`
+	ExpiresAt time.Time      `db:"expires_at"`
+	UsedAt    sql.NullTime   `db:"used_at"`
+	CreatedAt time.Time      `db:"created_at"`
+}
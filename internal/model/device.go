@@ -0,0 +1,39 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// GrantTypeDeviceCode RFC 8628 device authorization grant
+const GrantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+// DeviceCode RFC 8628 authorization of a device, e.g. a CLI, by a user on another one.
+// Only hashes of the device code and the user code are stored.
+type DeviceCode struct {
+	DeviceCodeHash string `db:"device_code_hash"`
+	UserCodeHash   string `db:"user_code_hash"`
+	ClientId       string `db:"client_id"`
+	Scope          string `db:"scope"`
+	// PollInterval seconds the device waits between token requests, slow_down raises it
+	PollInterval int64 `db:"poll_interval"`
+	// UserId the user who approved the device, Amr how the user signed in to approve it
+	UserId       sql.NullInt64 `db:"user_id"`
+	Amr          []string      `db:"amr"`
+	ApprovedAt   sql.NullTime  `db:"approved_at"`
+	DeniedAt     sql.NullTime  `db:"denied_at"`
+	LastPolledAt sql.NullTime  `db:"last_polled_at"`
+	UsedAt       sql.NullTime  `db:"used_at"`
+	CreatedAt    time.Time     `db:"created_at"`
+	ExpiresAt    time.Time     `db:"expires_at"`
+}
+
+// DeviceAuthorization RFC 8628 3.2 device authorization response
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationUri         string `json:"verification_uri"`
+	VerificationUriComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
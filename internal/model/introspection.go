@@ -0,0 +1,17 @@
+package model
+
+// Introspection RFC 7662 response, only Active is set for invalid tokens
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Sub       string `json:"sub,omitempty"`
+	Username  string `json:"username,omitempty"`
+	Role      string `json:"role,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	ClientId  string `json:"client_id,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Jti       string `json:"jti,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	// Act RFC 8693 actor of an impersonation or an exchanged token
+	Act *Actor `json:"act,omitempty"`
+}
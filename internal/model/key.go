@@ -0,0 +1,28 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// SigningKey persisted key of the access token key ring.
+// A key is pending until promoted, the promoted key signs, retired keys only verify until tokens expire.
+type SigningKey struct {
+	Id         string       `db:"kid"`
+	Algorithm  string       `db:"algorithm"`
+	PrivateKey []byte       `db:"private_key"`
+	CreatedAt  time.Time    `db:"created_at"`
+	PromotedAt sql.NullTime `db:"promoted_at"`
+	RetiredAt  sql.NullTime `db:"retired_at"`
+}
+
+func (k *SigningKey) Status() string {
+	switch {
+	case k.RetiredAt.Valid:
+		return "retired"
+	case k.PromotedAt.Valid:
+		return "current"
+	default:
+		return "pending"
+	}
+}
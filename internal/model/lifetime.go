@@ -0,0 +1,59 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// TokenLifetimes how long the tokens of one user or client live
+type TokenLifetimes struct {
+	AccessToken time.Duration
+	// SessionIdle a session whose refresh token is not used for this long ends, it is the refresh token lifetime
+	SessionIdle time.Duration
+	// SessionMaxAge absolute session lifetime refresh can not extend, 0 means unlimited
+	SessionMaxAge time.Duration
+}
+
+// ForRole applies the overrides of the role, overrides can only shorten a lifetime
+func (l TokenLifetimes) ForRole(role *Role) TokenLifetimes {
+	l.AccessToken = shorten(l.AccessToken, role.AccessTokenTTL)
+	l.SessionIdle = shorten(l.SessionIdle, role.SessionIdleTimeout)
+
+	if role.SessionMaxAge.Valid && role.SessionMaxAge.Int64 > 0 {
+		maxAge := time.Duration(role.SessionMaxAge.Int64) * time.Second
+
+		if l.SessionMaxAge == 0 || maxAge < l.SessionMaxAge {
+			l.SessionMaxAge = maxAge
+		}
+	}
+
+	return l
+}
+
+// ForClient applies the access token override of the client
+func (l TokenLifetimes) ForClient(client *Client) TokenLifetimes {
+	l.AccessToken = shorten(l.AccessToken, client.AccessTokenTTL)
+
+	return l
+}
+
+// SessionExpiresAt absolute end of a session started at the given time
+func (l TokenLifetimes) SessionExpiresAt(start time.Time) sql.NullTime {
+	if l.SessionMaxAge == 0 {
+		return sql.NullTime{}
+	}
+
+	return sql.NullTime{Time: start.Add(l.SessionMaxAge), Valid: true}
+}
+
+func shorten(d time.Duration, seconds sql.NullInt64) time.Duration {
+	if !seconds.Valid || seconds.Int64 <= 0 {
+		return d
+	}
+
+	if override := time.Duration(seconds.Int64) * time.Second; override < d {
+		return override
+	}
+
+	return d
+}
@@ -0,0 +1,14 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// LoginAttempt failed logins counted for one key, an account or a client address
+type LoginAttempt struct {
+	Key            string       `db:"key"`
+	FailedAttempts int          `db:"failed_attempts"`
+	LastFailedAt   time.Time    `db:"last_failed_at"`
+	LockedUntil    sql.NullTime `db:"locked_until"`
+}
@@ -0,0 +1,30 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Mfa TOTP second factor of a user, Secret is encrypted
+type Mfa struct {
+	UserId         int64         `db:"user_id"`
+	Secret         []byte        `db:"secret"`
+	ConfirmedAt    sql.NullTime  `db:"confirmed_at"`
+	LastCounter    sql.NullInt64 `db:"last_counter"`
+	FailedAttempts int           `db:"failed_attempts"`
+	LastFailedAt   sql.NullTime  `db:"last_failed_at"`
+	CreatedAt      time.Time     `db:"created_at"`
+}
+
+// Enabled the enrollment was confirmed with a code, only then login asks for a second factor
+func (m *Mfa) Enabled() bool {
+	return m.ConfirmedAt.Valid
+}
+
+// MfaEnrollment shown once to set up an authenticator app
+type MfaEnrollment struct {
+	// Secret base32 encoded for manual entry
+	Secret string
+	// Uri otpauth:// URI rendered as a QR code
+	Uri string
+}
@@ -0,0 +1,53 @@
+package model
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// OpenIDUserInfo claims about the user released for the granted scopes
+type OpenIDUserInfo struct {
+	Sub   string `json:"sub"`
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+// OpenIDConfiguration OpenID Connect Discovery 1.0 provider metadata
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JwksUri                           string   `json:"jwks_uri"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IdTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// HasScope reports whether the space separated scope contains the given one
+func HasScope(scope string, want string) bool {
+	return slices.Contains(strings.Fields(scope), want)
+}
+
+// NewOpenIDUserInfo releases name for the profile scope and email for the email scope
+func NewOpenIDUserInfo(user *User, scope string) OpenIDUserInfo {
+	info := OpenIDUserInfo{Sub: strconv.FormatInt(user.Id, 10)}
+
+	if HasScope(scope, "profile") {
+		info.Name = user.Name
+	}
+
+	if HasScope(scope, "email") {
+		info.Email = user.Email
+	}
+
+	return info
+}
@@ -0,0 +1,16 @@
+package model
+
+// Rules of the password policy
+const (
+	PasswordRuleMinLength = "min_length"
+	PasswordRuleMaxLength = "max_length"
+	PasswordRuleClasses   = "character_classes"
+	PasswordRulePersonal  = "personal_info"
+	PasswordRuleBreached  = "breached"
+)
+
+// PasswordViolation rule of the password policy a password breaks
+type PasswordViolation struct {
+	Rule        string
+	Description string
+}
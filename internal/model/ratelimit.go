@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+// Callers a rate limit is counted per
+const (
+	RateLimitByIp     = "ip"
+	RateLimitByUser   = "user"
+	RateLimitByClient = "client"
+	// RateLimitByEmail email field of the request, e.g. the address a link is mailed to
+	RateLimitByEmail = "email"
+)
+
+// RateLimitAnyMethod Method of a limit shared by all methods
+const RateLimitAnyMethod = "*"
+
+// RateLimit token bucket of Burst requests refilled evenly over Period,
+// one bucket per full method name and per caller identified by Key
+type RateLimit struct {
+	Method string
+	Key    string
+	Burst  int
+	Period time.Duration
+}
@@ -0,0 +1,19 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// RefreshToken a single issued refresh token; every rotation adds a new one to the same family
+type RefreshToken struct {
+	Id              string       `db:"id"`
+	FamilyId        string       `db:"family_id"`
+	UserId          int64        `db:"user_id"`
+	ExpiresAt       time.Time    `db:"expires_at"`
+	UsedAt          sql.NullTime `db:"used_at"`
+	FamilyRevokedAt sql.NullTime `db:"family_revoked_at"`
+	// FamilyExpiresAt absolute end of the session, null when sessions have no maximum age
+	FamilyExpiresAt sql.NullTime `db:"family_expires_at"`
+	CreatedAt       time.Time    `db:"created_at"`
+}
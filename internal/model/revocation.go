@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// RevokedToken identifies an issued token for the revocation store
+type RevokedToken struct {
+	Jti       string
+	UserId    int64
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	// SessionId refresh token family the token was issued for, revoking the family revokes the token
+	SessionId string
+}
@@ -0,0 +1,26 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Session a login on one device, Id is the refresh token family of the session
+type Session struct {
+	Id         string         `db:"id"`
+	UserId     int64          `db:"user_id"`
+	ClientId   sql.NullString `db:"client_id"`
+	UserAgent  string         `db:"user_agent"`
+	Ip         string         `db:"ip"`
+	CreatedAt  time.Time      `db:"created_at"`
+	LastSeenAt time.Time      `db:"last_seen_at"`
+}
+
+// Device the caller starting a session as reported by the transport
+type Device struct {
+	UserAgent string
+	// Ip the peer address, or the hop a trusted proxy in front of us appended to X-Forwarded-For
+	Ip string
+	// ForwardedFor the X-Forwarded-For header as received, set by the client and shown for display only
+	ForwardedFor string
+}
@@ -0,0 +1,28 @@
+package model
+
+// GrantTypeTokenExchange RFC 8693 token exchange grant
+const GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+// TokenTypeUriAccessToken RFC 8693 3 identifier of access tokens, the only type we exchange and issue
+const TokenTypeUriAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+
+// OAuthToken RFC 6749 5.1 token response
+type OAuthToken struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	// IdToken OpenID Connect id_token, only issued for the openid scope
+	IdToken string `json:"id_token,omitempty"`
+	// IssuedTokenType RFC 8693 2.2.1, set only in token exchange responses
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+}
+
+// TokenExchange RFC 8693 request of a client for a token to call Audience on behalf of the subject
+type TokenExchange struct {
+	SubjectToken string
+	Audience     string
+	// Scopes subset of the scopes of the subject token and the client, all they share when empty
+	Scopes []string
+}
@@ -14,13 +14,16 @@ type UserInfo struct {
 }
 
 type User struct {
-	Id        int64
-	Name      string
-	Email     string
-	Role      string
-	Password  string
-	UpdatedAt sql.NullTime
-	CreatedAt time.Time
+	Id            int64
+	Name          string
+	Email         string
+	EmailVerified bool
+	// PendingEmail new address waiting for confirmation, Email stays in use until then
+	PendingEmail sql.NullString
+	Role         string
+	Password     string
+	UpdatedAt    sql.NullTime
+	CreatedAt    time.Time
 }
 
 type UserName struct {
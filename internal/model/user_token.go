@@ -0,0 +1,25 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Purposes of one-time tokens mailed to users
+const (
+	UserTokenPasswordReset     = "password_reset"
+	UserTokenEmailVerification = "email_verification"
+	UserTokenMagicLink         = "magic_link"
+)
+
+// UserToken one-time token, only the hash of the mailed token is stored
+type UserToken struct {
+	TokenHash string `db:"token_hash"`
+	UserId    int64  `db:"user_id"`
+	Purpose   string `db:"purpose"`
+	// Email address the token was mailed to, set for email verification and magic links
+	Email     sql.NullString `db:"email"`
+	CreatedAt time.Time      `db:"created_at"`
+	ExpiresAt time.Time      `db:"expires_at"`
+	UsedAt    sql.NullTime   `db:"used_at"`
+}
@@ -0,0 +1,112 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// WebAuthn ceremonies a challenge is issued for
+const (
+	WebAuthnRegistration = "registration"
+	WebAuthnLogin        = "login"
+)
+
+// WebAuthnCredential passkey registered by a user, Id is the credential id chosen by the authenticator
+type WebAuthnCredential struct {
+	Id         []byte       `db:"id"`
+	UserId     int64        `db:"user_id"`
+	PublicKey  []byte       `db:"public_key"`
+	SignCount  int64        `db:"sign_count"`
+	Transports []string     `db:"transports"`
+	Name       string       `db:"name"`
+	CreatedAt  time.Time    `db:"created_at"`
+	LastUsedAt sql.NullTime `db:"last_used_at"`
+}
+
+// WebAuthnChallenge started ceremony, only the hash of the challenge is stored
+type WebAuthnChallenge struct {
+	ChallengeHash string        `db:"challenge_hash"`
+	UserId        sql.NullInt64 `db:"user_id"`
+	Ceremony      string        `db:"ceremony"`
+	ExpiresAt     time.Time     `db:"expires_at"`
+}
+
+// The types below are the JSON forms of WebAuthn Level 3, binary values are base64url encoded.
+// Browsers read options with PublicKeyCredential.parseCreationOptionsFromJSON and
+// parseRequestOptionsFromJSON and send responses serialized with toJSON.
+
+type WebAuthnRelyingParty struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type WebAuthnUser struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type WebAuthnCredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type WebAuthnCredentialDescriptor struct {
+	Type       string   `json:"type"`
+	Id         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type WebAuthnAuthenticatorSelection struct {
+	ResidentKey        string `json:"residentKey"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
+}
+
+type WebAuthnCreationOptions struct {
+	Rp                     WebAuthnRelyingParty           `json:"rp"`
+	User                   WebAuthnUser                   `json:"user"`
+	Challenge              string                         `json:"challenge"`
+	PubKeyCredParams       []WebAuthnCredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                          `json:"timeout"`
+	ExcludeCredentials     []WebAuthnCredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection WebAuthnAuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                         `json:"attestation"`
+}
+
+type WebAuthnRequestOptions struct {
+	Challenge        string                         `json:"challenge"`
+	Timeout          int64                          `json:"timeout"`
+	RpId             string                         `json:"rpId"`
+	AllowCredentials []WebAuthnCredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                         `json:"userVerification"`
+}
+
+type WebAuthnAttestation struct {
+	ClientDataJSON    string   `json:"clientDataJSON"`
+	AttestationObject string   `json:"attestationObject"`
+	Transports        []string `json:"transports,omitempty"`
+}
+
+// WebAuthnRegistrationResponse RegistrationResponseJSON of navigator.credentials.create
+type WebAuthnRegistrationResponse struct {
+	Id       string              `json:"id"`
+	RawId    string              `json:"rawId"`
+	Type     string              `json:"type"`
+	Response WebAuthnAttestation `json:"response"`
+}
+
+type WebAuthnAssertion struct {
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+	UserHandle        string `json:"userHandle,omitempty"`
+}
+
+// WebAuthnAssertionResponse AuthenticationResponseJSON of navigator.credentials.get
+type WebAuthnAssertionResponse struct {
+	Id       string            `json:"id"`
+	RawId    string            `json:"rawId"`
+	Type     string            `json:"type"`
+	Response WebAuthnAssertion `json:"response"`
+}
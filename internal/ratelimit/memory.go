@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/laiker/auth/internal/model"
+)
+
+// sweepInterval how often buckets that are full again are dropped
+const sweepInterval = time.Minute
+
+type memoryBucket struct {
+	Bucket
+	// fullAt the bucket is refilled and can be forgotten
+	fullAt time.Time
+}
+
+type memoryLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+	sweptAt time.Time
+	now     func() time.Time
+}
+
+// NewMemoryLimiter counts in the process, every replica enforces the limits on its own
+func NewMemoryLimiter(now func() time.Time) Limiter {
+	return &memoryLimiter{
+		buckets: make(map[string]*memoryBucket),
+		sweptAt: now(),
+		now:     now,
+	}
+}
+
+func (l *memoryLimiter) Allow(_ context.Context, key string, limit model.RateLimit) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+
+	if now.Sub(l.sweptAt) >= sweepInterval {
+		for k, bucket := range l.buckets {
+			if !now.Before(bucket.fullAt) {
+				delete(l.buckets, k)
+			}
+		}
+
+		l.sweptAt = now
+	}
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{Bucket: NewBucket(limit, now)}
+		l.buckets[key] = bucket
+	}
+
+	wait := bucket.Take(limit, now)
+	bucket.fullAt = now.Add(limit.Period)
+
+	return wait, nil
+}
@@ -0,0 +1,41 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/laiker/auth/internal/model"
+)
+
+type Limiter interface {
+	// Allow takes a token from the bucket of key, returning how long to wait for one when it is empty
+	Allow(ctx context.Context, key string, limit model.RateLimit) (time.Duration, error)
+}
+
+// Bucket tokens left at UpdatedAt
+type Bucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// NewBucket a bucket nobody took from is full
+func NewBucket(limit model.RateLimit, now time.Time) Bucket {
+	return Bucket{Tokens: float64(limit.Burst), UpdatedAt: now}
+}
+
+// Take refills the bucket up to now and takes a token, 0 means the request is allowed
+func (b *Bucket) Take(limit model.RateLimit, now time.Time) time.Duration {
+	perToken := limit.Period / time.Duration(limit.Burst)
+
+	if elapsed := now.Sub(b.UpdatedAt); elapsed > 0 {
+		b.Tokens = min(float64(limit.Burst), b.Tokens+float64(elapsed)/float64(perToken))
+		b.UpdatedAt = now
+	}
+
+	if b.Tokens >= 1 {
+		b.Tokens--
+		return 0
+	}
+
+	return time.Duration((1 - b.Tokens) * float64(perToken))
+}
@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/laiker/auth/internal/model"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	keyPrefix = "ratelimit:"
+	// maxAttempts concurrent updates of one bucket retried before giving up
+	maxAttempts = 5
+)
+
+var ErrContention = errors.New("rate limit bucket is updated concurrently")
+
+type redisLimiter struct {
+	client redis.UniversalClient
+	now    func() time.Time
+}
+
+// NewRedisLimiter shares the buckets between replicas. A bucket is read and written back
+// under WATCH, so concurrent requests can not both take the last token. The replicas
+// compute refills with their own clocks, which are expected to be in sync.
+func NewRedisLimiter(client redis.UniversalClient, now func() time.Time) Limiter {
+	return &redisLimiter{
+		client: client,
+		now:    now,
+	}
+}
+
+func (l *redisLimiter) Allow(ctx context.Context, key string, limit model.RateLimit) (time.Duration, error) {
+	key = keyPrefix + key
+
+	var wait time.Duration
+
+	take := func(tx *redis.Tx) error {
+		now := l.now()
+
+		bucket, err := l.bucket(ctx, tx, key, limit, now)
+		if err != nil {
+			return err
+		}
+
+		if wait = bucket.Take(limit, now); wait > 0 {
+			return nil
+		}
+
+		// the transaction fails with redis.TxFailedErr when the bucket changed since WATCH
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, encodeBucket(bucket), limit.Period+time.Millisecond)
+			return nil
+		})
+
+		return err
+	}
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		err := l.client.Watch(ctx, take, key)
+
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		return wait, nil
+	}
+
+	return 0, ErrContention
+}
+
+// bucket a missing key is a full bucket
+func (l *redisLimiter) bucket(ctx context.Context, tx *redis.Tx, key string, limit model.RateLimit, now time.Time) (Bucket, error) {
+	value, err := tx.Get(ctx, key).Result()
+
+	if errors.Is(err, redis.Nil) {
+		return NewBucket(limit, now), nil
+	}
+
+	if err != nil {
+		return Bucket{}, err
+	}
+
+	return decodeBucket(value)
+}
+
+// encodeBucket "<tokens> <unix nanoseconds>", the key expires once the bucket is full again
+func encodeBucket(bucket Bucket) string {
+	return strconv.FormatFloat(bucket.Tokens, 'f', -1, 64) + " " + strconv.FormatInt(bucket.UpdatedAt.UnixNano(), 10)
+}
+
+func decodeBucket(value string) (Bucket, error) {
+	tokens, updatedAt, ok := strings.Cut(value, " ")
+	if !ok {
+		return Bucket{}, errors.Errorf("malformed rate limit bucket %q", value)
+	}
+
+	bucket := Bucket{}
+
+	var err error
+
+	bucket.Tokens, err = strconv.ParseFloat(tokens, 64)
+	if err != nil {
+		return Bucket{}, errors.Errorf("malformed rate limit bucket %q", value)
+	}
+
+	nanos, err := strconv.ParseInt(updatedAt, 10, 64)
+	if err != nil {
+		return Bucket{}, errors.Errorf("malformed rate limit bucket %q", value)
+	}
+
+	bucket.UpdatedAt = time.Unix(0, nanos)
+
+	return bucket, nil
+}
@@ -0,0 +1,200 @@
+package test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/ratelimit"
+	"github.com/redis/go-redis/v9"
+)
+
+var limit = model.RateLimit{Method: "/auth_v1.AuthV1/Login", Key: model.RateLimitByIp, Burst: 3, Period: time.Minute}
+
+// clock fake time buckets refill by, shared by concurrent requests
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *clock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+func newClock() *clock {
+	return &clock{now: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)}
+}
+
+func newRedisLimiter(t *testing.T, server *miniredis.Miniredis, c *clock) ratelimit.Limiter {
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+
+	t.Cleanup(func() { _ = client.Close() })
+
+	return ratelimit.NewRedisLimiter(client, c.Now)
+}
+
+func backends(t *testing.T) map[string]func(c *clock) ratelimit.Limiter {
+	return map[string]func(c *clock) ratelimit.Limiter{
+		"memory": func(c *clock) ratelimit.Limiter {
+			return ratelimit.NewMemoryLimiter(c.Now)
+		},
+		"redis": func(c *clock) ratelimit.Limiter {
+			return newRedisLimiter(t, miniredis.RunT(t), c)
+		},
+	}
+}
+
+func allow(t *testing.T, limiter ratelimit.Limiter, key string) time.Duration {
+	t.Helper()
+
+	wait, err := limiter.Allow(context.Background(), key, limit)
+	if err != nil {
+		t.Fatalf("Allow() error = %v", err)
+	}
+
+	return wait
+}
+
+func TestLimiter_Burst(t *testing.T) {
+	for name, newLimiter := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			c := newClock()
+			limiter := newLimiter(c)
+
+			for i := 0; i < limit.Burst; i++ {
+				if wait := allow(t, limiter, "a"); wait != 0 {
+					t.Fatalf("request %d waits %v within the burst", i+1, wait)
+				}
+			}
+
+			if wait := allow(t, limiter, "a"); wait != 20*time.Second {
+				t.Fatalf("wait = %v, want a token every 20s", wait)
+			}
+
+			if wait := allow(t, limiter, "b"); wait != 0 {
+				t.Fatalf("another key waits %v", wait)
+			}
+
+			c.Add(5 * time.Second)
+
+			if wait := allow(t, limiter, "a"); wait != 15*time.Second {
+				t.Fatalf("wait = %v after 5s, want 15s", wait)
+			}
+
+			c.Add(15 * time.Second)
+
+			if wait := allow(t, limiter, "a"); wait != 0 {
+				t.Fatalf("refilled token waits %v", wait)
+			}
+
+			if wait := allow(t, limiter, "a"); wait == 0 {
+				t.Fatal("only one token was refilled")
+			}
+
+			c.Add(time.Hour)
+
+			for i := 0; i < limit.Burst; i++ {
+				if wait := allow(t, limiter, "a"); wait != 0 {
+					t.Fatalf("request %d waits %v after a full refill", i+1, wait)
+				}
+			}
+		})
+	}
+}
+
+func TestRedisLimiter_SharedByReplicas(t *testing.T) {
+	server := miniredis.RunT(t)
+	c := newClock()
+
+	replicas := []ratelimit.Limiter{newRedisLimiter(t, server, c), newRedisLimiter(t, server, c)}
+
+	for i := 0; i < limit.Burst; i++ {
+		if wait := allow(t, replicas[i%2], "a"); wait != 0 {
+			t.Fatalf("request %d waits %v within the burst", i+1, wait)
+		}
+	}
+
+	for _, replica := range replicas {
+		if wait := allow(t, replica, "a"); wait == 0 {
+			t.Fatal("a replica allowed more than the shared burst")
+		}
+	}
+}
+
+func TestRedisLimiter_Concurrent(t *testing.T) {
+	server := miniredis.RunT(t)
+	c := newClock()
+	limiter := newRedisLimiter(t, server, c)
+
+	burst := model.RateLimit{Method: limit.Method, Key: limit.Key, Burst: 10, Period: time.Minute}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			// losing the race more often than the limiter retries is reported as an error, not a pass
+			wait, err := limiter.Allow(context.Background(), "a", burst)
+			if err == nil && wait == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	for {
+		wait, err := limiter.Allow(context.Background(), "a", burst)
+		if err != nil {
+			t.Fatalf("Allow() error = %v", err)
+		}
+
+		if wait > 0 {
+			break
+		}
+
+		allowed++
+	}
+
+	if allowed != burst.Burst {
+		t.Fatalf("allowed %d requests, want %d", allowed, burst.Burst)
+	}
+}
+
+func TestRedisLimiter_MalformedBucket(t *testing.T) {
+	server := miniredis.RunT(t)
+	limiter := newRedisLimiter(t, server, newClock())
+
+	server.Set("ratelimit:a", "garbage")
+
+	if _, err := limiter.Allow(context.Background(), "a", limit); err == nil {
+		t.Fatal("a malformed bucket was accepted")
+	}
+
+	// the connection went back to the pool without watching the key
+	if wait := allow(t, limiter, "b"); wait != 0 {
+		t.Fatalf("wait = %v", wait)
+	}
+}
@@ -2,11 +2,11 @@ package access
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"log/slog"
 
 	sq "github.com/Masterminds/squirrel"
+	"github.com/georgysavva/scany/pgxscan"
 	"github.com/laiker/auth/client/db"
 	"github.com/laiker/auth/internal/model"
 	"github.com/laiker/auth/internal/repository"
@@ -18,6 +18,8 @@ const (
 	idColumn              = "permission_id"
 	resourceNameColumn    = "resource_name"
 	minRolePriorityColumn = "min_role_priority"
+	requiredScopeColumn   = "required_scope"
+	requirePasskeyColumn  = "require_passkey"
 )
 
 type accessRepo struct {
@@ -30,9 +32,9 @@ func NewRepository(db db.Client, logger *slog.Logger) repository.AccessRepositor
 	return &accessRepo{db: db, logger: logger}
 }
 
+// GetEndpointPermission an empty permission when the endpoint has none, errors of the database are returned
 func (r *accessRepo) GetEndpointPermission(ctx context.Context, endpoint string) (*model.Permission, error) {
-	fmt.Println("1")
-	sBuilder := sq.Select(idColumn, resourceNameColumn, minRolePriorityColumn).
+	sBuilder := sq.Select(idColumn, resourceNameColumn, minRolePriorityColumn, requiredScopeColumn, requirePasskeyColumn).
 		From(tableName).
 		Where(sq.Eq{"resource_name": endpoint}).
 		PlaceholderFormat(sq.Dollar)
@@ -41,27 +43,34 @@ func (r *accessRepo) GetEndpointPermission(ctx context.Context, endpoint string)
 
 	if err != nil {
 		log.Printf("failed to build query: %v\n", err)
+		return nil, err
 	}
 
 	q := db.Query{
 		Name:     "access.GetPermissionByRole",
 		QueryRaw: query,
 	}
-	fmt.Println("2")
+
 	permission := model.Permission{}
 
 	err = r.db.DB().ScanOneContext(ctx, &permission, q, args...)
 
+	if pgxscan.NotFound(err) {
+		return &model.Permission{}, nil
+	}
+
 	if err != nil {
-		log.Printf("failed to select user: %v\n", err)
+		log.Printf("failed to select permission: %v\n", err)
+		return nil, err
 	}
 
 	return &permission, nil
 }
 
+// GetRole an empty role without any priority when the role is unknown, errors of the database are returned
 func (r *accessRepo) GetRole(ctx context.Context, role string) (*model.Role, error) {
 
-	sBuilder := sq.Select("role_id", "role_name", "priority").
+	sBuilder := sq.Select("role_id", "role_name", "priority", "access_token_ttl", "session_idle_timeout", "session_max_age").
 		From("user_role").
 		Where(sq.Eq{"role_name": role}).
 		PlaceholderFormat(sq.Dollar)
@@ -70,6 +79,7 @@ func (r *accessRepo) GetRole(ctx context.Context, role string) (*model.Role, err
 
 	if err != nil {
 		log.Printf("failed to build query: %v\n", err)
+		return nil, err
 	}
 
 	q := db.Query{
@@ -81,8 +91,13 @@ func (r *accessRepo) GetRole(ctx context.Context, role string) (*model.Role, err
 
 	err = r.db.DB().ScanOneContext(ctx, &mrole, q, args...)
 
+	if pgxscan.NotFound(err) {
+		return &model.Role{}, nil
+	}
+
 	if err != nil {
-		log.Printf("failed to select user: %v\n", err)
+		log.Printf("failed to select role: %v\n", err)
+		return nil, err
 	}
 
 	return &mrole, nil
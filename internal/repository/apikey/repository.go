@@ -0,0 +1,206 @@
+package apikey
+
+import (
+	"context"
+	"log"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/laiker/auth/client/db"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+)
+
+const (
+	tableName = "api_key"
+
+	idColumn         = "id"
+	userIdColumn     = "user_id"
+	nameColumn       = "name"
+	prefixColumn     = "prefix"
+	secretHashColumn = "secret_hash"
+	scopesColumn     = "scopes"
+	createdAtColumn  = "created_at"
+	expiresAtColumn  = "expires_at"
+	lastUsedAtColumn = "last_used_at"
+	lastUsedIpColumn = "last_used_ip"
+	revokedAtColumn  = "revoked_at"
+)
+
+type repo struct {
+	db db.Client
+}
+
+func NewRepository(db db.Client) repository.ApiKeyRepository {
+	return &repo{db: db}
+}
+
+func (r *repo) Create(ctx context.Context, key *model.ApiKey) error {
+	sBuilder := sq.Insert(tableName).
+		Columns(idColumn, userIdColumn, nameColumn, prefixColumn, secretHashColumn, scopesColumn, expiresAtColumn).
+		Values(key.Id, key.UserId, key.Name, key.Prefix, key.SecretHash, key.Scopes, key.ExpiresAt).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     "apikey.create",
+		QueryRaw: query,
+	}
+
+	_, err = r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to insert api key: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetByPrefix revoked and expired keys are returned too, the caller checks them
+func (r *repo) GetByPrefix(ctx context.Context, prefix string) (*model.ApiKey, error) {
+	sBuilder := r.selectBuilder().
+		Where(sq.Eq{prefixColumn: prefix})
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return nil, err
+	}
+
+	q := db.Query{
+		Name:     "apikey.getByPrefix",
+		QueryRaw: query,
+	}
+
+	key := model.ApiKey{}
+
+	err = r.db.DB().ScanOneContext(ctx, &key, q, args...)
+
+	if err != nil {
+		log.Printf("failed to select api key: %v\n", err)
+		return nil, repository.ErrApiKeyNotFound
+	}
+
+	return &key, nil
+}
+
+// List keys of the user that are not revoked, expired ones included
+func (r *repo) List(ctx context.Context, userId int64) ([]*model.ApiKey, error) {
+	sBuilder := r.selectBuilder().
+		Where(sq.Eq{userIdColumn: userId, revokedAtColumn: nil}).
+		OrderBy(createdAtColumn)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return nil, err
+	}
+
+	q := db.Query{
+		Name:     "apikey.list",
+		QueryRaw: query,
+	}
+
+	var keys []*model.ApiKey
+
+	err = r.db.DB().ScanAllContext(ctx, &keys, q, args...)
+
+	if err != nil {
+		log.Printf("failed to select api keys: %v\n", err)
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Revoke false when the user has no key with the id that is not revoked yet
+func (r *repo) Revoke(ctx context.Context, userId int64, id string, at time.Time) (bool, error) {
+	sBuilder := sq.Update(tableName).
+		PlaceholderFormat(sq.Dollar).
+		Set(revokedAtColumn, at).
+		Where(sq.Eq{idColumn: id, userIdColumn: userId, revokedAtColumn: nil})
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return false, err
+	}
+
+	q := db.Query{
+		Name:     "apikey.revoke",
+		QueryRaw: query,
+	}
+
+	tag, err := r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to revoke api key: %v\n", err)
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// Touch records a use of the key, skipped when it was last used from the same ip after since,
+// so a busy CI job does not write on every request
+func (r *repo) Touch(ctx context.Context, id string, ip string, at time.Time, since time.Time) error {
+	sBuilder := sq.Update(tableName).
+		PlaceholderFormat(sq.Dollar).
+		Set(lastUsedAtColumn, at).
+		Set(lastUsedIpColumn, ip).
+		Where(sq.Eq{idColumn: id}).
+		Where(sq.Or{
+			sq.Eq{lastUsedAtColumn: nil},
+			sq.Lt{lastUsedAtColumn: since},
+			sq.NotEq{lastUsedIpColumn: ip},
+		})
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     "apikey.touch",
+		QueryRaw: query,
+	}
+
+	_, err = r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to update api key: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *repo) selectBuilder() sq.SelectBuilder {
+	return sq.Select(
+		idColumn,
+		userIdColumn,
+		nameColumn,
+		prefixColumn,
+		secretHashColumn,
+		scopesColumn,
+		createdAtColumn,
+		expiresAtColumn,
+		lastUsedAtColumn,
+		lastUsedIpColumn,
+		revokedAtColumn,
+	).
+		From(tableName).
+		PlaceholderFormat(sq.Dollar)
+}
@@ -0,0 +1,191 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/laiker/auth/client/db"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+)
+
+const (
+	tableName = "oauth_client"
+
+	idColumn         = "id"
+	nameColumn       = "name"
+	teamColumn       = "team"
+	scopesColumn     = "scopes"
+	redirectColumn   = "redirect_uris"
+	publicColumn     = "public"
+	secretHashColumn = "secret_hash"
+	createdAtColumn  = "created_at"
+	accessTTLColumn  = "access_token_ttl"
+	audiencesColumn  = "exchange_audiences"
+)
+
+var errClientNotFound = errors.New("client not found")
+
+type repo struct {
+	db db.Client
+}
+
+func NewRepository(db db.Client) repository.ClientRepository {
+	return &repo{db: db}
+}
+
+func (r *repo) Create(ctx context.Context, client *model.Client) error {
+	sBuilder := sq.Insert(tableName).
+		Columns(idColumn, nameColumn, teamColumn, scopesColumn, redirectColumn, publicColumn, secretHashColumn, accessTTLColumn, audiencesColumn).
+		Values(client.Id, client.Name, client.Team, client.Scopes, client.RedirectUris, client.Public, client.SecretHash, client.AccessTokenTTL, client.ExchangeAudiences).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     "client.create",
+		QueryRaw: query,
+	}
+
+	_, err = r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to create client: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *repo) Get(ctx context.Context, id string) (*model.Client, error) {
+	sBuilder := r.selectBuilder().
+		Where(sq.Eq{idColumn: id})
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return nil, err
+	}
+
+	q := db.Query{
+		Name:     "client.get",
+		QueryRaw: query,
+	}
+
+	client := model.Client{}
+
+	err = r.db.DB().ScanOneContext(ctx, &client, q, args...)
+
+	if err != nil {
+		log.Printf("failed to select client: %v\n", err)
+		return nil, errClientNotFound
+	}
+
+	return &client, nil
+}
+
+func (r *repo) List(ctx context.Context, team string) ([]*model.Client, error) {
+	sBuilder := r.selectBuilder().
+		OrderBy(createdAtColumn)
+
+	if team != "" {
+		sBuilder = sBuilder.Where(sq.Eq{teamColumn: team})
+	}
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return nil, err
+	}
+
+	q := db.Query{
+		Name:     "client.list",
+		QueryRaw: query,
+	}
+
+	var clients []*model.Client
+
+	err = r.db.DB().ScanAllContext(ctx, &clients, q, args...)
+
+	if err != nil {
+		log.Printf("failed to select clients: %v\n", err)
+		return nil, err
+	}
+
+	return clients, nil
+}
+
+func (r *repo) Update(ctx context.Context, client *model.Client) error {
+	sBuilder := sq.Update(tableName).
+		Set(nameColumn, client.Name).
+		Set(teamColumn, client.Team).
+		Set(scopesColumn, client.Scopes).
+		Set(redirectColumn, client.RedirectUris).
+		Set(accessTTLColumn, client.AccessTokenTTL).
+		Set(audiencesColumn, client.ExchangeAudiences).
+		Where(sq.Eq{idColumn: client.Id}).
+		PlaceholderFormat(sq.Dollar)
+
+	return r.exec(ctx, "client.update", sBuilder)
+}
+
+func (r *repo) Delete(ctx context.Context, id string) error {
+	sBuilder := sq.Delete(tableName).
+		Where(sq.Eq{idColumn: id}).
+		PlaceholderFormat(sq.Dollar)
+
+	return r.exec(ctx, "client.delete", sBuilder)
+}
+
+func (r *repo) selectBuilder() sq.SelectBuilder {
+	return sq.Select(
+		idColumn,
+		nameColumn,
+		teamColumn,
+		scopesColumn,
+		redirectColumn,
+		publicColumn,
+		secretHashColumn,
+		createdAtColumn,
+		accessTTLColumn,
+		audiencesColumn,
+	).
+		From(tableName).
+		PlaceholderFormat(sq.Dollar)
+}
+
+func (r *repo) exec(ctx context.Context, name string, sBuilder sq.Sqlizer) error {
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     name,
+		QueryRaw: query,
+	}
+
+	res, err := r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to change client: %v\n", err)
+		return err
+	}
+
+	if res.RowsAffected() == 0 {
+		return errClientNotFound
+	}
+
+	return nil
+}
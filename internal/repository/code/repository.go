@@ -0,0 +1,196 @@
+package code
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/laiker/auth/client/db"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+)
+
+const (
+	tableName = "authorization_code"
+
+	codeHashColumn      = "code_hash"
+	clientIdColumn      = "client_id"
+	userIdColumn        = "user_id"
+	userLoginColumn     = "user_login"
+	roleColumn          = "role"
+	redirectUriColumn   = "redirect_uri"
+	scopeColumn         = "scope"
+	codeChallengeColumn = "code_challenge"
+	nonceColumn         = "nonce"
+	amrColumn           = "amr"
+	familyIdColumn      = "family_id"
+	expiresAtColumn     = "expires_at"
+	usedAtColumn        = "used_at"
+	createdAtColumn     = "created_at"
+)
+
+type repo struct {
+	db db.Client
+}
+
+func NewRepository(db db.Client) repository.AuthorizationCodeRepository {
+	return &repo{db: db}
+}
+
+func (r *repo) Create(ctx context.Context, code *model.AuthorizationCode) error {
+	sBuilder := sq.Insert(tableName).
+		Columns(
+			codeHashColumn,
+			clientIdColumn,
+			userIdColumn,
+			userLoginColumn,
+			roleColumn,
+			redirectUriColumn,
+			scopeColumn,
+			codeChallengeColumn,
+			nonceColumn,
+			amrColumn,
+			expiresAtColumn,
+		).
+		Values(
+			code.CodeHash,
+			code.ClientId,
+			code.UserId,
+			code.UserLogin,
+			code.Role,
+			code.RedirectUri,
+			code.Scope,
+			code.CodeChallenge,
+			code.Nonce,
+			code.Amr,
+			code.ExpiresAt,
+		).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     "code.create",
+		QueryRaw: query,
+	}
+
+	_, err = r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to create authorization code: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *repo) Get(ctx context.Context, codeHash string) (*model.AuthorizationCode, error) {
+	sBuilder := sq.Select(
+		codeHashColumn,
+		clientIdColumn,
+		userIdColumn,
+		userLoginColumn,
+		roleColumn,
+		redirectUriColumn,
+		scopeColumn,
+		codeChallengeColumn,
+		nonceColumn,
+		amrColumn,
+		familyIdColumn,
+		expiresAtColumn,
+		usedAtColumn,
+		createdAtColumn,
+	).
+		From(tableName).
+		Where(sq.Eq{codeHashColumn: codeHash}).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return nil, err
+	}
+
+	q := db.Query{
+		Name:     "code.get",
+		QueryRaw: query,
+	}
+
+	code := model.AuthorizationCode{}
+
+	err = r.db.DB().ScanOneContext(ctx, &code, q, args...)
+
+	if err != nil {
+		log.Printf("failed to select authorization code: %v\n", err)
+		return nil, errors.New("authorization code not found")
+	}
+
+	return &code, nil
+}
+
+// MarkUsed returns false when the code has already been exchanged
+func (r *repo) MarkUsed(ctx context.Context, codeHash string) (bool, error) {
+	sBuilder := sq.Update(tableName).
+		Set(usedAtColumn, time.Now()).
+		Where(sq.Eq{codeHashColumn: codeHash}).
+		Where(sq.Eq{usedAtColumn: nil}).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return false, err
+	}
+
+	q := db.Query{
+		Name:     "code.markUsed",
+		QueryRaw: query,
+	}
+
+	res, err := r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to mark authorization code used: %v\n", err)
+		return false, err
+	}
+
+	return res.RowsAffected() == 1, nil
+}
+
+// SetFamily remembers the refresh token family issued for the code, so a replay can revoke it
+func (r *repo) SetFamily(ctx context.Context, codeHash string, familyId string) error {
+	sBuilder := sq.Update(tableName).
+		Set(familyIdColumn, familyId).
+		Where(sq.Eq{codeHashColumn: codeHash}).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     "code.setFamily",
+		QueryRaw: query,
+	}
+
+	_, err = r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to set authorization code family: %v\n", err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,210 @@
+package device
+
+import (
+	"context"
+	"log"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/laiker/auth/client/db"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+)
+
+const (
+	tableName = "device_code"
+
+	deviceCodeHashColumn = "device_code_hash"
+	userCodeHashColumn   = "user_code_hash"
+	clientIdColumn       = "client_id"
+	scopeColumn          = "scope"
+	pollIntervalColumn   = "poll_interval"
+	userIdColumn         = "user_id"
+	amrColumn            = "amr"
+	approvedAtColumn     = "approved_at"
+	deniedAtColumn       = "denied_at"
+	lastPolledAtColumn   = "last_polled_at"
+	usedAtColumn         = "used_at"
+	createdAtColumn      = "created_at"
+	expiresAtColumn      = "expires_at"
+)
+
+type repo struct {
+	db db.Client
+}
+
+func NewRepository(db db.Client) repository.DeviceCodeRepository {
+	return &repo{db: db}
+}
+
+func (r *repo) Create(ctx context.Context, code *model.DeviceCode) error {
+	sBuilder := sq.Insert(tableName).
+		Columns(
+			deviceCodeHashColumn,
+			userCodeHashColumn,
+			clientIdColumn,
+			scopeColumn,
+			pollIntervalColumn,
+			createdAtColumn,
+			expiresAtColumn,
+		).
+		Values(
+			code.DeviceCodeHash,
+			code.UserCodeHash,
+			code.ClientId,
+			code.Scope,
+			code.PollInterval,
+			code.CreatedAt,
+			code.ExpiresAt,
+		).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     "device.create",
+		QueryRaw: query,
+	}
+
+	_, err = r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to create device code: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// Get expired, decided and used codes are returned too, the caller checks them
+func (r *repo) Get(ctx context.Context, deviceCodeHash string) (*model.DeviceCode, error) {
+	return r.get(ctx, "device.get", sq.Eq{deviceCodeHashColumn: deviceCodeHash})
+}
+
+// GetPending the code the user was shown, as long as it awaits approval
+func (r *repo) GetPending(ctx context.Context, userCodeHash string, at time.Time) (*model.DeviceCode, error) {
+	return r.get(ctx, "device.getPending", sq.And{
+		sq.Eq{userCodeHashColumn: userCodeHash, approvedAtColumn: nil, deniedAtColumn: nil},
+		sq.Gt{expiresAtColumn: at},
+	})
+}
+
+// Approve false when no code with the user code awaits approval
+func (r *repo) Approve(ctx context.Context, userCodeHash string, userId int64, amr []string, at time.Time) (bool, error) {
+	sBuilder := sq.Update(tableName).
+		PlaceholderFormat(sq.Dollar).
+		Set(userIdColumn, userId).
+		Set(amrColumn, amr).
+		Set(approvedAtColumn, at).
+		Where(sq.Eq{userCodeHashColumn: userCodeHash, approvedAtColumn: nil, deniedAtColumn: nil}).
+		Where(sq.Gt{expiresAtColumn: at})
+
+	return r.update(ctx, "device.approve", sBuilder)
+}
+
+// Deny false when no code with the user code awaits approval
+func (r *repo) Deny(ctx context.Context, userCodeHash string, at time.Time) (bool, error) {
+	sBuilder := sq.Update(tableName).
+		PlaceholderFormat(sq.Dollar).
+		Set(deniedAtColumn, at).
+		Where(sq.Eq{userCodeHashColumn: userCodeHash, approvedAtColumn: nil, deniedAtColumn: nil}).
+		Where(sq.Gt{expiresAtColumn: at})
+
+	return r.update(ctx, "device.deny", sBuilder)
+}
+
+// Poll records a token request of the device and the interval it has to keep from now on
+func (r *repo) Poll(ctx context.Context, deviceCodeHash string, pollInterval int64, at time.Time) error {
+	sBuilder := sq.Update(tableName).
+		PlaceholderFormat(sq.Dollar).
+		Set(lastPolledAtColumn, at).
+		Set(pollIntervalColumn, pollInterval).
+		Where(sq.Eq{deviceCodeHashColumn: deviceCodeHash})
+
+	_, err := r.update(ctx, "device.poll", sBuilder)
+
+	return err
+}
+
+// Use false when the code is not approved or tokens were already issued for it
+func (r *repo) Use(ctx context.Context, deviceCodeHash string, at time.Time) (bool, error) {
+	sBuilder := sq.Update(tableName).
+		PlaceholderFormat(sq.Dollar).
+		Set(usedAtColumn, at).
+		Where(sq.Eq{deviceCodeHashColumn: deviceCodeHash, usedAtColumn: nil}).
+		Where(sq.NotEq{approvedAtColumn: nil})
+
+	return r.update(ctx, "device.use", sBuilder)
+}
+
+func (r *repo) get(ctx context.Context, name string, where sq.Sqlizer) (*model.DeviceCode, error) {
+	sBuilder := sq.Select(
+		deviceCodeHashColumn,
+		userCodeHashColumn,
+		clientIdColumn,
+		scopeColumn,
+		pollIntervalColumn,
+		userIdColumn,
+		amrColumn,
+		approvedAtColumn,
+		deniedAtColumn,
+		lastPolledAtColumn,
+		usedAtColumn,
+		createdAtColumn,
+		expiresAtColumn,
+	).
+		From(tableName).
+		Where(where).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return nil, err
+	}
+
+	q := db.Query{
+		Name:     name,
+		QueryRaw: query,
+	}
+
+	code := model.DeviceCode{}
+
+	err = r.db.DB().ScanOneContext(ctx, &code, q, args...)
+
+	if err != nil {
+		log.Printf("failed to select device code: %v\n", err)
+		return nil, repository.ErrDeviceCodeNotFound
+	}
+
+	return &code, nil
+}
+
+func (r *repo) update(ctx context.Context, name string, sBuilder sq.UpdateBuilder) (bool, error) {
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return false, err
+	}
+
+	q := db.Query{
+		Name:     name,
+		QueryRaw: query,
+	}
+
+	tag, err := r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to update device code: %v\n", err)
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
@@ -0,0 +1,199 @@
+package key
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/laiker/auth/client/db"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+)
+
+const (
+	tableName = "signing_key"
+
+	kidColumn        = "kid"
+	algorithmColumn  = "algorithm"
+	privateKeyColumn = "private_key"
+	createdAtColumn  = "created_at"
+	promotedAtColumn = "promoted_at"
+	retiredAtColumn  = "retired_at"
+
+	// lockId arbitrary advisory lock id serializing key ring changes between replicas
+	lockId = 7301
+)
+
+var errKeyNotFound = errors.New("signing key not found")
+
+type repo struct {
+	db db.Client
+}
+
+func NewRepository(db db.Client) repository.SigningKeyRepository {
+	return &repo{db: db}
+}
+
+func (r *repo) Create(ctx context.Context, key *model.SigningKey) error {
+	sBuilder := sq.Insert(tableName).
+		Columns(kidColumn, algorithmColumn, privateKeyColumn, createdAtColumn).
+		Values(key.Id, key.Algorithm, key.PrivateKey, key.CreatedAt).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     "key.create",
+		QueryRaw: query,
+	}
+
+	_, err = r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to create signing key: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *repo) Get(ctx context.Context, kid string) (*model.SigningKey, error) {
+	sBuilder := r.selectBuilder().
+		Where(sq.Eq{kidColumn: kid})
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return nil, err
+	}
+
+	q := db.Query{
+		Name:     "key.get",
+		QueryRaw: query,
+	}
+
+	key := model.SigningKey{}
+
+	err = r.db.DB().ScanOneContext(ctx, &key, q, args...)
+
+	if err != nil {
+		log.Printf("failed to select signing key: %v\n", err)
+		return nil, errKeyNotFound
+	}
+
+	return &key, nil
+}
+
+func (r *repo) List(ctx context.Context) ([]*model.SigningKey, error) {
+	sBuilder := r.selectBuilder().
+		OrderBy(createdAtColumn)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return nil, err
+	}
+
+	q := db.Query{
+		Name:     "key.list",
+		QueryRaw: query,
+	}
+
+	var keys []*model.SigningKey
+
+	err = r.db.DB().ScanAllContext(ctx, &keys, q, args...)
+
+	if err != nil {
+		log.Printf("failed to select signing keys: %v\n", err)
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Promote marks a pending key as the signing one, retired keys can not be promoted
+func (r *repo) Promote(ctx context.Context, kid string, at time.Time) error {
+	sBuilder := sq.Update(tableName).
+		Set(promotedAtColumn, at).
+		Where(sq.Eq{kidColumn: kid}).
+		Where(sq.Eq{retiredAtColumn: nil}).
+		PlaceholderFormat(sq.Dollar)
+
+	return r.exec(ctx, "key.promote", sBuilder)
+}
+
+func (r *repo) Retire(ctx context.Context, kid string, at time.Time) error {
+	sBuilder := sq.Update(tableName).
+		Set(retiredAtColumn, at).
+		Where(sq.Eq{kidColumn: kid}).
+		Where(sq.Eq{retiredAtColumn: nil}).
+		PlaceholderFormat(sq.Dollar)
+
+	return r.exec(ctx, "key.retire", sBuilder)
+}
+
+// Lock takes a transaction-level advisory lock, must be called inside a transaction
+func (r *repo) Lock(ctx context.Context) error {
+	q := db.Query{
+		Name:     "key.lock",
+		QueryRaw: "SELECT pg_advisory_xact_lock($1)",
+	}
+
+	_, err := r.db.DB().ExecContext(ctx, q, lockId)
+
+	if err != nil {
+		log.Printf("failed to lock signing keys: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *repo) selectBuilder() sq.SelectBuilder {
+	return sq.Select(
+		kidColumn,
+		algorithmColumn,
+		privateKeyColumn,
+		createdAtColumn,
+		promotedAtColumn,
+		retiredAtColumn,
+	).
+		From(tableName).
+		PlaceholderFormat(sq.Dollar)
+}
+
+func (r *repo) exec(ctx context.Context, name string, sBuilder sq.UpdateBuilder) error {
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     name,
+		QueryRaw: query,
+	}
+
+	res, err := r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to update signing key: %v\n", err)
+		return err
+	}
+
+	if res.RowsAffected() == 0 {
+		return errKeyNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package lockout
+
+import (
+	"context"
+	"log"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/laiker/auth/client/db"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+)
+
+const (
+	tableName = "login_attempt"
+
+	keyColumn            = "key"
+	failedAttemptsColumn = "failed_attempts"
+	lastFailedAtColumn   = "last_failed_at"
+	lockedUntilColumn    = "locked_until"
+)
+
+type repo struct {
+	db db.Client
+}
+
+func NewRepository(db db.Client) repository.LoginAttemptRepository {
+	return &repo{db: db}
+}
+
+// List the counters of the keys that failed before
+func (r *repo) List(ctx context.Context, keys []string) ([]*model.LoginAttempt, error) {
+	sBuilder := sq.Select(keyColumn, failedAttemptsColumn, lastFailedAtColumn, lockedUntilColumn).
+		From(tableName).
+		Where(sq.Eq{keyColumn: keys}).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return nil, err
+	}
+
+	q := db.Query{
+		Name:     "lockout.list",
+		QueryRaw: query,
+	}
+
+	var attempts []*model.LoginAttempt
+
+	err = r.db.DB().ScanAllContext(ctx, &attempts, q, args...)
+
+	if err != nil {
+		log.Printf("failed to select login attempts: %v\n", err)
+		return nil, err
+	}
+
+	return attempts, nil
+}
+
+// Fail counts a failure and returns the failures of the key, failures before since are forgotten
+func (r *repo) Fail(ctx context.Context, key string, at time.Time, since time.Time) (int, error) {
+	sBuilder := sq.Insert(tableName).
+		Columns(keyColumn, failedAttemptsColumn, lastFailedAtColumn).
+		Values(key, 1, at).
+		Suffix("ON CONFLICT ("+keyColumn+") DO UPDATE SET "+
+			failedAttemptsColumn+" = CASE WHEN "+tableName+"."+lastFailedAtColumn+" < ? THEN 1 "+
+			"ELSE "+tableName+"."+failedAttemptsColumn+" + 1 END, "+
+			lastFailedAtColumn+" = EXCLUDED."+lastFailedAtColumn+" "+
+			"RETURNING "+failedAttemptsColumn, since).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return 0, err
+	}
+
+	q := db.Query{
+		Name:     "lockout.fail",
+		QueryRaw: query,
+	}
+
+	var failures int
+
+	err = r.db.DB().QueryRowContext(ctx, q, args...).Scan(&failures)
+
+	if err != nil {
+		log.Printf("failed to count login failure: %v\n", err)
+		return 0, err
+	}
+
+	return failures, nil
+}
+
+func (r *repo) Lock(ctx context.Context, key string, until time.Time) error {
+	sBuilder := sq.Update(tableName).
+		PlaceholderFormat(sq.Dollar).
+		Set(lockedUntilColumn, until).
+		Where(sq.Eq{keyColumn: key})
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     "lockout.lock",
+		QueryRaw: query,
+	}
+
+	_, err = r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to lock login: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *repo) Reset(ctx context.Context, key string) error {
+	sBuilder := sq.Delete(tableName).
+		Where(sq.Eq{keyColumn: key}).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     "lockout.reset",
+		QueryRaw: query,
+	}
+
+	_, err = r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to reset login attempts: %v\n", err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,327 @@
+package mfa
+
+import (
+	"context"
+	"log"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/laiker/auth/client/db"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+)
+
+const (
+	tableName         = "user_mfa"
+	recoveryTableName = "mfa_recovery_code"
+
+	userIdColumn         = "user_id"
+	secretColumn         = "secret"
+	confirmedAtColumn    = "confirmed_at"
+	lastCounterColumn    = "last_counter"
+	failedAttemptsColumn = "failed_attempts"
+	lastFailedAtColumn   = "last_failed_at"
+	createdAtColumn      = "created_at"
+	codeHashColumn       = "code_hash"
+	usedAtColumn         = "used_at"
+)
+
+type repo struct {
+	db db.Client
+}
+
+func NewRepository(db db.Client) repository.MfaRepository {
+	return &repo{db: db}
+}
+
+// Save stores a new unconfirmed secret, replacing an earlier unconfirmed one
+func (r *repo) Save(ctx context.Context, mfa *model.Mfa) error {
+	sBuilder := sq.Insert(tableName).
+		Columns(userIdColumn, secretColumn).
+		Values(mfa.UserId, mfa.Secret).
+		Suffix("ON CONFLICT (" + userIdColumn + ") DO UPDATE SET " +
+			secretColumn + " = EXCLUDED." + secretColumn + ", " +
+			lastCounterColumn + " = NULL, " +
+			failedAttemptsColumn + " = 0, " +
+			lastFailedAtColumn + " = NULL, " +
+			createdAtColumn + " = now() " +
+			"WHERE " + tableName + "." + confirmedAtColumn + " IS NULL").
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     "mfa.save",
+		QueryRaw: query,
+	}
+
+	_, err = r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to save mfa secret: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *repo) Get(ctx context.Context, userId int64) (*model.Mfa, error) {
+	sBuilder := sq.Select(
+		userIdColumn,
+		secretColumn,
+		confirmedAtColumn,
+		lastCounterColumn,
+		failedAttemptsColumn,
+		lastFailedAtColumn,
+		createdAtColumn,
+	).
+		From(tableName).
+		Where(sq.Eq{userIdColumn: userId}).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return nil, err
+	}
+
+	q := db.Query{
+		Name:     "mfa.get",
+		QueryRaw: query,
+	}
+
+	mfa := model.Mfa{}
+
+	err = r.db.DB().ScanOneContext(ctx, &mfa, q, args...)
+
+	if pgxscan.NotFound(err) {
+		return nil, repository.ErrMfaNotFound
+	}
+
+	if err != nil {
+		log.Printf("failed to select mfa: %v\n", err)
+		return nil, err
+	}
+
+	return &mfa, nil
+}
+
+func (r *repo) Delete(ctx context.Context, userId int64) error {
+	sBuilder := sq.Delete(tableName).
+		Where(sq.Eq{userIdColumn: userId}).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     "mfa.delete",
+		QueryRaw: query,
+	}
+
+	_, err = r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to delete mfa: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// UseCounter records an accepted time step, false when the step or a later one was already used
+func (r *repo) UseCounter(ctx context.Context, userId int64, counter int64, confirmedAt time.Time) (bool, error) {
+	sBuilder := sq.Update(tableName).
+		PlaceholderFormat(sq.Dollar).
+		Set(lastCounterColumn, counter).
+		Set(confirmedAtColumn, sq.Expr("COALESCE("+confirmedAtColumn+", ?)", confirmedAt)).
+		Where(sq.Eq{userIdColumn: userId}).
+		Where(sq.Or{sq.Eq{lastCounterColumn: nil}, sq.Lt{lastCounterColumn: counter}})
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return false, err
+	}
+
+	q := db.Query{
+		Name:     "mfa.useCounter",
+		QueryRaw: query,
+	}
+
+	tag, err := r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to use mfa counter: %v\n", err)
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *repo) SetFailedAttempts(ctx context.Context, userId int64, attempts int, at time.Time) error {
+	sBuilder := sq.Update(tableName).
+		PlaceholderFormat(sq.Dollar).
+		Set(failedAttemptsColumn, attempts).
+		Set(lastFailedAtColumn, at).
+		Where(sq.Eq{userIdColumn: userId})
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     "mfa.setFailedAttempts",
+		QueryRaw: query,
+	}
+
+	_, err = r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to update mfa attempts: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// Fail counts an attempt in one statement and returns the attempts of the user, attempts up to since are forgotten
+func (r *repo) Fail(ctx context.Context, userId int64, at time.Time, since time.Time) (int, error) {
+	sBuilder := sq.Update(tableName).
+		PlaceholderFormat(sq.Dollar).
+		Set(failedAttemptsColumn, sq.Expr("CASE WHEN "+lastFailedAtColumn+" IS NULL OR "+lastFailedAtColumn+" <= ? THEN 1 "+
+			"ELSE "+failedAttemptsColumn+" + 1 END", since)).
+		Set(lastFailedAtColumn, at).
+		Where(sq.Eq{userIdColumn: userId}).
+		Suffix("RETURNING " + failedAttemptsColumn)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return 0, err
+	}
+
+	q := db.Query{
+		Name:     "mfa.fail",
+		QueryRaw: query,
+	}
+
+	var attempts int
+
+	err = r.db.DB().QueryRowContext(ctx, q, args...).Scan(&attempts)
+
+	if pgxscan.NotFound(err) {
+		return 0, repository.ErrMfaNotFound
+	}
+
+	if err != nil {
+		log.Printf("failed to count mfa attempt: %v\n", err)
+		return 0, err
+	}
+
+	return attempts, nil
+}
+
+// ReplaceRecoveryCodes drops the unused codes of the user, must run inside a transaction
+func (r *repo) ReplaceRecoveryCodes(ctx context.Context, userId int64, codeHashes []string) error {
+	dBuilder := sq.Delete(recoveryTableName).
+		Where(sq.Eq{userIdColumn: userId}).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := dBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     "mfa.deleteRecoveryCodes",
+		QueryRaw: query,
+	}
+
+	_, err = r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to delete recovery codes: %v\n", err)
+		return err
+	}
+
+	if len(codeHashes) == 0 {
+		return nil
+	}
+
+	iBuilder := sq.Insert(recoveryTableName).
+		Columns(userIdColumn, codeHashColumn).
+		PlaceholderFormat(sq.Dollar)
+
+	for _, codeHash := range codeHashes {
+		iBuilder = iBuilder.Values(userId, codeHash)
+	}
+
+	query, args, err = iBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q = db.Query{
+		Name:     "mfa.createRecoveryCodes",
+		QueryRaw: query,
+	}
+
+	_, err = r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to insert recovery codes: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// UseRecoveryCode false when the code is unknown or already used
+func (r *repo) UseRecoveryCode(ctx context.Context, userId int64, codeHash string, at time.Time) (bool, error) {
+	sBuilder := sq.Update(recoveryTableName).
+		PlaceholderFormat(sq.Dollar).
+		Set(usedAtColumn, at).
+		Where(sq.Eq{userIdColumn: userId, codeHashColumn: codeHash, usedAtColumn: nil})
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return false, err
+	}
+
+	q := db.Query{
+		Name:     "mfa.useRecoveryCode",
+		QueryRaw: query,
+	}
+
+	tag, err := r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to use recovery code: %v\n", err)
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
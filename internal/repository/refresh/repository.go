@@ -0,0 +1,246 @@
+package refresh
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/laiker/auth/client/db"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+	"github.com/pkg/errors"
+)
+
+const (
+	tableName       = "refresh_token"
+	familyTableName = "refresh_token_family"
+
+	idColumn        = "id"
+	familyIdColumn  = "family_id"
+	userIdColumn    = "user_id"
+	expiresAtColumn = "expires_at"
+	usedAtColumn    = "used_at"
+	revokedAtColumn = "revoked_at"
+	createdAtColumn = "created_at"
+)
+
+type repo struct {
+	db db.Client
+}
+
+func NewRepository(db db.Client) repository.RefreshTokenRepository {
+	return &repo{db: db}
+}
+
+func (r *repo) CreateFamily(ctx context.Context, familyId string, userId int64, expiresAt sql.NullTime) error {
+	sBuilder := sq.Insert(familyTableName).
+		Columns(idColumn, userIdColumn, expiresAtColumn).
+		Values(familyId, userId, expiresAt).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     "refresh.createFamily",
+		QueryRaw: query,
+	}
+
+	_, err = r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to insert refresh token family: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *repo) Create(ctx context.Context, token *model.RefreshToken) error {
+	sBuilder := sq.Insert(tableName).
+		Columns(idColumn, familyIdColumn, userIdColumn, expiresAtColumn).
+		Values(token.Id, token.FamilyId, token.UserId, token.ExpiresAt).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     "refresh.create",
+		QueryRaw: query,
+	}
+
+	_, err = r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to insert refresh token: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *repo) Get(ctx context.Context, id string) (*model.RefreshToken, error) {
+	sBuilder := sq.Select(
+		tableName+"."+idColumn,
+		tableName+"."+familyIdColumn,
+		tableName+"."+userIdColumn,
+		tableName+"."+expiresAtColumn,
+		tableName+"."+usedAtColumn,
+		familyTableName+"."+revokedAtColumn+" as family_revoked_at",
+		familyTableName+"."+expiresAtColumn+" as family_expires_at",
+		tableName+"."+createdAtColumn,
+	).
+		From(tableName).
+		Join(familyTableName + " on " + tableName + ".family_id = " + familyTableName + ".id").
+		Where(sq.Eq{tableName + "." + idColumn: id}).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return nil, err
+	}
+
+	q := db.Query{
+		Name:     "refresh.get",
+		QueryRaw: query,
+	}
+
+	token := model.RefreshToken{}
+
+	err = r.db.DB().ScanOneContext(ctx, &token, q, args...)
+
+	if err != nil {
+		log.Printf("failed to select refresh token: %v\n", err)
+		return nil, errors.New("refresh token not found")
+	}
+
+	return &token, nil
+}
+
+// MarkUsed returns false when the token has already been used by someone else
+func (r *repo) MarkUsed(ctx context.Context, id string) (bool, error) {
+	sBuilder := sq.Update(tableName).
+		PlaceholderFormat(sq.Dollar).
+		Set(usedAtColumn, time.Now()).
+		Where(sq.Eq{idColumn: id, usedAtColumn: nil})
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return false, err
+	}
+
+	q := db.Query{
+		Name:     "refresh.markUsed",
+		QueryRaw: query,
+	}
+
+	tag, err := r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to mark refresh token used: %v\n", err)
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *repo) RevokeFamily(ctx context.Context, familyId string) error {
+	sBuilder := sq.Update(familyTableName).
+		PlaceholderFormat(sq.Dollar).
+		Set(revokedAtColumn, time.Now()).
+		Where(sq.Eq{idColumn: familyId, revokedAtColumn: nil})
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     "refresh.revokeFamily",
+		QueryRaw: query,
+	}
+
+	_, err = r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to revoke refresh token family: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *repo) RevokeUserFamilies(ctx context.Context, userId int64) error {
+	sBuilder := sq.Update(familyTableName).
+		PlaceholderFormat(sq.Dollar).
+		Set(revokedAtColumn, time.Now()).
+		Where(sq.Eq{userIdColumn: userId, revokedAtColumn: nil})
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     "refresh.revokeUserFamilies",
+		QueryRaw: query,
+	}
+
+	_, err = r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to revoke refresh token families: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// RevokeOtherFamilies revokes every family of the user but the one kept, an empty id keeps none
+func (r *repo) RevokeOtherFamilies(ctx context.Context, userId int64, keepFamilyId string) error {
+	sBuilder := sq.Update(familyTableName).
+		PlaceholderFormat(sq.Dollar).
+		Set(revokedAtColumn, time.Now()).
+		Where(sq.Eq{userIdColumn: userId, revokedAtColumn: nil}).
+		Where(sq.NotEq{idColumn: keepFamilyId})
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     "refresh.revokeOtherFamilies",
+		QueryRaw: query,
+	}
+
+	_, err = r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to revoke refresh token families: %v\n", err)
+		return err
+	}
+
+	return nil
+}
@@ -2,8 +2,26 @@ package repository
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/laiker/auth/internal/model"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrMfaNotFound the user has not enrolled a second factor
+	ErrMfaNotFound = errors.New("mfa not enrolled")
+	// ErrWebAuthnCredentialNotFound no passkey with the credential id is registered
+	ErrWebAuthnCredentialNotFound = errors.New("webauthn credential not found")
+	// ErrWebAuthnChallengeNotFound the challenge is unknown, expired, used or issued for another ceremony
+	ErrWebAuthnChallengeNotFound = errors.New("webauthn challenge not found")
+	// ErrUserTokenNotFound the token is unknown, expired, used or issued for another purpose
+	ErrUserTokenNotFound = errors.New("user token not found")
+	// ErrApiKeyNotFound no key with the prefix was created
+	ErrApiKeyNotFound = errors.New("api key not found")
+	// ErrDeviceCodeNotFound the code is unknown or, looked up by the user code, no longer awaits the user
+	ErrDeviceCodeNotFound = errors.New("device code not found")
 )
 
 type UserRepository interface {
@@ -13,9 +31,111 @@ type UserRepository interface {
 	Update(ctx context.Context, info *model.User) error
 	GetByEmail(ctx context.Context, email string) (*model.User, error)
 	FindByName(ctx context.Context, name string) ([]*model.UserName, error)
+	UpdatePassword(ctx context.Context, id int64, passwordHash string) error
+	SetPendingEmail(ctx context.Context, id int64, email string) error
+	VerifyEmail(ctx context.Context, id int64, email string) (bool, error)
 }
 
 type AccessRepository interface {
 	GetEndpointPermission(ctx context.Context, endpoint string) (*model.Permission, error)
 	GetRole(ctx context.Context, role string) (*model.Role, error)
 }
+
+type RefreshTokenRepository interface {
+	CreateFamily(ctx context.Context, familyId string, userId int64, expiresAt sql.NullTime) error
+	Create(ctx context.Context, token *model.RefreshToken) error
+	Get(ctx context.Context, id string) (*model.RefreshToken, error)
+	MarkUsed(ctx context.Context, id string) (bool, error)
+	RevokeFamily(ctx context.Context, familyId string) error
+	RevokeUserFamilies(ctx context.Context, userId int64) error
+	RevokeOtherFamilies(ctx context.Context, userId int64, keepFamilyId string) error
+}
+
+type RevocationRepository interface {
+	Revoke(ctx context.Context, token *model.RevokedToken) (bool, error)
+	RevokeUser(ctx context.Context, userId int64, before time.Time) error
+	IsRevoked(ctx context.Context, token *model.RevokedToken) (bool, error)
+}
+
+type SigningKeyRepository interface {
+	Create(ctx context.Context, key *model.SigningKey) error
+	Get(ctx context.Context, kid string) (*model.SigningKey, error)
+	List(ctx context.Context) ([]*model.SigningKey, error)
+	Promote(ctx context.Context, kid string, at time.Time) error
+	Retire(ctx context.Context, kid string, at time.Time) error
+	Lock(ctx context.Context) error
+}
+
+type ClientRepository interface {
+	Create(ctx context.Context, client *model.Client) error
+	Get(ctx context.Context, id string) (*model.Client, error)
+	List(ctx context.Context, team string) ([]*model.Client, error)
+	Update(ctx context.Context, client *model.Client) error
+	Delete(ctx context.Context, id string) error
+}
+
+type AuthorizationCodeRepository interface {
+	Create(ctx context.Context, code *model.AuthorizationCode) error
+	Get(ctx context.Context, codeHash string) (*model.AuthorizationCode, error)
+	MarkUsed(ctx context.Context, codeHash string) (bool, error)
+	SetFamily(ctx context.Context, codeHash string, familyId string) error
+}
+
+type SessionRepository interface {
+	Create(ctx context.Context, session *model.Session) error
+	Get(ctx context.Context, id string) (*model.Session, error)
+	List(ctx context.Context, userId int64) ([]*model.Session, error)
+	Touch(ctx context.Context, id string, at time.Time) error
+}
+
+type MfaRepository interface {
+	Save(ctx context.Context, mfa *model.Mfa) error
+	Get(ctx context.Context, userId int64) (*model.Mfa, error)
+	Delete(ctx context.Context, userId int64) error
+	UseCounter(ctx context.Context, userId int64, counter int64, confirmedAt time.Time) (bool, error)
+	SetFailedAttempts(ctx context.Context, userId int64, attempts int, at time.Time) error
+	Fail(ctx context.Context, userId int64, at time.Time, since time.Time) (int, error)
+	ReplaceRecoveryCodes(ctx context.Context, userId int64, codeHashes []string) error
+	UseRecoveryCode(ctx context.Context, userId int64, codeHash string, at time.Time) (bool, error)
+}
+
+type WebAuthnRepository interface {
+	CreateCredential(ctx context.Context, credential *model.WebAuthnCredential) (bool, error)
+	GetCredential(ctx context.Context, id []byte) (*model.WebAuthnCredential, error)
+	ListCredentials(ctx context.Context, userId int64) ([]*model.WebAuthnCredential, error)
+	UseCredential(ctx context.Context, id []byte, signCount int64, at time.Time) (bool, error)
+	DeleteCredential(ctx context.Context, userId int64, id []byte) (bool, error)
+	CreateChallenge(ctx context.Context, challenge *model.WebAuthnChallenge) error
+	UseChallenge(ctx context.Context, challengeHash string, ceremony string, at time.Time) (*model.WebAuthnChallenge, error)
+}
+
+type LoginAttemptRepository interface {
+	List(ctx context.Context, keys []string) ([]*model.LoginAttempt, error)
+	Fail(ctx context.Context, key string, at time.Time, since time.Time) (int, error)
+	Lock(ctx context.Context, key string, until time.Time) error
+	Reset(ctx context.Context, key string) error
+}
+
+type UserTokenRepository interface {
+	Create(ctx context.Context, token *model.UserToken) error
+	Use(ctx context.Context, tokenHash string, purpose string, at time.Time) (*model.UserToken, error)
+	UseAll(ctx context.Context, userId int64, purpose string, at time.Time) error
+}
+
+type ApiKeyRepository interface {
+	Create(ctx context.Context, key *model.ApiKey) error
+	GetByPrefix(ctx context.Context, prefix string) (*model.ApiKey, error)
+	List(ctx context.Context, userId int64) ([]*model.ApiKey, error)
+	Revoke(ctx context.Context, userId int64, id string, at time.Time) (bool, error)
+	Touch(ctx context.Context, id string, ip string, at time.Time, since time.Time) error
+}
+
+type DeviceCodeRepository interface {
+	Create(ctx context.Context, code *model.DeviceCode) error
+	Get(ctx context.Context, deviceCodeHash string) (*model.DeviceCode, error)
+	GetPending(ctx context.Context, userCodeHash string, at time.Time) (*model.DeviceCode, error)
+	Approve(ctx context.Context, userCodeHash string, userId int64, amr []string, at time.Time) (bool, error)
+	Deny(ctx context.Context, userCodeHash string, at time.Time) (bool, error)
+	Poll(ctx context.Context, deviceCodeHash string, pollInterval int64, at time.Time) error
+	Use(ctx context.Context, deviceCodeHash string, at time.Time) (bool, error)
+}
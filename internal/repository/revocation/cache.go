@@ -0,0 +1,97 @@
+package revocation
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+)
+
+const sweepInterval = time.Minute
+
+// cachedRepo keeps revoked jti in memory until the token would expire anyway.
+// Only positive answers are cached so revocations made by other replicas stay visible.
+type cachedRepo struct {
+	repository.RevocationRepository
+	mu        sync.RWMutex
+	revoked   map[string]time.Time
+	lastSweep time.Time
+}
+
+func NewCachedRepository(repo repository.RevocationRepository) repository.RevocationRepository {
+	return &cachedRepo{
+		RevocationRepository: repo,
+		revoked:              make(map[string]time.Time),
+		lastSweep:            time.Now(),
+	}
+}
+
+func (r *cachedRepo) Revoke(ctx context.Context, token *model.RevokedToken) (bool, error) {
+	revoked, err := r.RevocationRepository.Revoke(ctx, token)
+
+	if err != nil {
+		return false, err
+	}
+
+	r.remember(token)
+
+	return revoked, nil
+}
+
+func (r *cachedRepo) IsRevoked(ctx context.Context, token *model.RevokedToken) (bool, error) {
+	if r.cached(token.Jti) {
+		return true, nil
+	}
+
+	revoked, err := r.RevocationRepository.IsRevoked(ctx, token)
+
+	if err != nil {
+		return false, err
+	}
+
+	if revoked {
+		r.remember(token)
+	}
+
+	return revoked, nil
+}
+
+func (r *cachedRepo) cached(jti string) bool {
+	if jti == "" {
+		return false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	expiresAt, ok := r.revoked[jti]
+
+	return ok && time.Now().Before(expiresAt)
+}
+
+func (r *cachedRepo) remember(token *model.RevokedToken) {
+	if token.Jti == "" {
+		return
+	}
+
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.revoked[token.Jti] = token.ExpiresAt
+
+	if now.Sub(r.lastSweep) < sweepInterval {
+		return
+	}
+
+	for jti, expiresAt := range r.revoked {
+		if now.After(expiresAt) {
+			delete(r.revoked, jti)
+		}
+	}
+
+	r.lastSweep = now
+}
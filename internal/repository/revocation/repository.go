@@ -0,0 +1,137 @@
+package revocation
+
+import (
+	"context"
+	"log"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/laiker/auth/client/db"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+)
+
+const (
+	tableName       = "revoked_token"
+	userTableName   = "user_token_revocation"
+	familyTableName = "refresh_token_family"
+
+	jtiColumn           = "jti"
+	userIdColumn        = "user_id"
+	expiresAtColumn     = "expires_at"
+	revokedBeforeColumn = "revoked_before"
+	idColumn            = "id"
+	revokedAtColumn     = "revoked_at"
+)
+
+type repo struct {
+	db db.Client
+}
+
+func NewRepository(db db.Client) repository.RevocationRepository {
+	return &repo{db: db}
+}
+
+// Revoke false when the jti was revoked before
+func (r *repo) Revoke(ctx context.Context, token *model.RevokedToken) (bool, error) {
+	sBuilder := sq.Insert(tableName).
+		Columns(jtiColumn, userIdColumn, expiresAtColumn).
+		Values(token.Jti, token.UserId, token.ExpiresAt).
+		Suffix("ON CONFLICT (" + jtiColumn + ") DO NOTHING").
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return false, err
+	}
+
+	q := db.Query{
+		Name:     "revocation.revoke",
+		QueryRaw: query,
+	}
+
+	tag, err := r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to revoke token: %v\n", err)
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// RevokeUser revokes every token of the user issued before the given time
+func (r *repo) RevokeUser(ctx context.Context, userId int64, before time.Time) error {
+	sBuilder := sq.Insert(userTableName).
+		Columns(userIdColumn, revokedBeforeColumn).
+		Values(userId, before).
+		Suffix("ON CONFLICT (" + userIdColumn + ") DO UPDATE SET " + revokedBeforeColumn + " = EXCLUDED." + revokedBeforeColumn).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     "revocation.revokeUser",
+		QueryRaw: query,
+	}
+
+	_, err = r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to revoke user tokens: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *repo) IsRevoked(ctx context.Context, token *model.RevokedToken) (bool, error) {
+	byJti := sq.Select("1").
+		From(tableName).
+		Where(sq.Eq{jtiColumn: token.Jti})
+
+	byUser := sq.Select("1").
+		From(userTableName).
+		Where(sq.Eq{userIdColumn: token.UserId}).
+		Where(sq.Gt{revokedBeforeColumn: token.IssuedAt})
+
+	// tokens issued before sessions were tracked have no session
+	bySession := sq.Select("1").
+		From(familyTableName).
+		Where(sq.Eq{idColumn: token.SessionId}).
+		Where(sq.NotEq{revokedAtColumn: nil})
+
+	sBuilder := sq.Select().
+		Column(sq.Expr("EXISTS(?) OR EXISTS(?) OR EXISTS(?)", byJti, byUser, bySession)).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return false, err
+	}
+
+	q := db.Query{
+		Name:     "revocation.isRevoked",
+		QueryRaw: query,
+	}
+
+	var revoked bool
+
+	err = r.db.DB().QueryRowContext(ctx, q, args...).Scan(&revoked)
+
+	if err != nil {
+		log.Printf("failed to check token revocation: %v\n", err)
+		return false, err
+	}
+
+	return revoked, nil
+}
@@ -0,0 +1,170 @@
+package session
+
+import (
+	"context"
+	"log"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/laiker/auth/client/db"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+	"github.com/pkg/errors"
+)
+
+const (
+	tableName       = "session"
+	familyTableName = "refresh_token_family"
+	tokenTableName  = "refresh_token"
+
+	idColumn         = "id"
+	userIdColumn     = "user_id"
+	clientIdColumn   = "client_id"
+	userAgentColumn  = "user_agent"
+	ipColumn         = "ip"
+	createdAtColumn  = "created_at"
+	lastSeenAtColumn = "last_seen_at"
+)
+
+type repo struct {
+	db db.Client
+}
+
+func NewRepository(db db.Client) repository.SessionRepository {
+	return &repo{db: db}
+}
+
+func (r *repo) Create(ctx context.Context, session *model.Session) error {
+	sBuilder := sq.Insert(tableName).
+		Columns(idColumn, userIdColumn, clientIdColumn, userAgentColumn, ipColumn).
+		Values(session.Id, session.UserId, session.ClientId, session.UserAgent, session.Ip).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     "session.create",
+		QueryRaw: query,
+	}
+
+	_, err = r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to insert session: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *repo) Get(ctx context.Context, id string) (*model.Session, error) {
+	sBuilder := sq.Select(idColumn, userIdColumn, clientIdColumn, userAgentColumn, ipColumn, createdAtColumn, lastSeenAtColumn).
+		From(tableName).
+		Where(sq.Eq{idColumn: id}).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return nil, err
+	}
+
+	q := db.Query{
+		Name:     "session.get",
+		QueryRaw: query,
+	}
+
+	session := model.Session{}
+
+	err = r.db.DB().ScanOneContext(ctx, &session, q, args...)
+
+	if err != nil {
+		log.Printf("failed to select session: %v\n", err)
+		return nil, errors.New("session not found")
+	}
+
+	return &session, nil
+}
+
+// List sessions that can still be refreshed: the family is not revoked
+// and its latest refresh token is neither used nor expired
+func (r *repo) List(ctx context.Context, userId int64) ([]*model.Session, error) {
+	alive := sq.Select("1").
+		From(tokenTableName).
+		Where(tokenTableName + ".family_id = " + tableName + "." + idColumn).
+		Where(sq.Eq{tokenTableName + ".used_at": nil}).
+		Where(tokenTableName + ".expires_at > now()")
+
+	sBuilder := sq.Select(
+		tableName+"."+idColumn,
+		tableName+"."+userIdColumn,
+		tableName+"."+clientIdColumn,
+		tableName+"."+userAgentColumn,
+		tableName+"."+ipColumn,
+		tableName+"."+createdAtColumn,
+		tableName+"."+lastSeenAtColumn,
+	).
+		From(tableName).
+		Join(familyTableName + " on " + familyTableName + ".id = " + tableName + "." + idColumn).
+		Where(sq.Eq{tableName + "." + userIdColumn: userId, familyTableName + ".revoked_at": nil}).
+		Where(sq.Expr("EXISTS(?)", alive)).
+		OrderBy(tableName + "." + lastSeenAtColumn + " DESC").
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return nil, err
+	}
+
+	q := db.Query{
+		Name:     "session.list",
+		QueryRaw: query,
+	}
+
+	var sessions []*model.Session
+
+	err = r.db.DB().ScanAllContext(ctx, &sessions, q, args...)
+
+	if err != nil {
+		log.Printf("failed to select sessions: %v\n", err)
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (r *repo) Touch(ctx context.Context, id string, at time.Time) error {
+	sBuilder := sq.Update(tableName).
+		PlaceholderFormat(sq.Dollar).
+		Set(lastSeenAtColumn, at).
+		Where(sq.Eq{idColumn: id})
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     "session.touch",
+		QueryRaw: query,
+	}
+
+	_, err = r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to update session: %v\n", err)
+		return err
+	}
+
+	return nil
+}
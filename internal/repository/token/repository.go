@@ -0,0 +1,128 @@
+package token
+
+import (
+	"context"
+	"log"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/laiker/auth/client/db"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+)
+
+const (
+	tableName = "user_token"
+
+	tokenHashColumn = "token_hash"
+	userIdColumn    = "user_id"
+	purposeColumn   = "purpose"
+	emailColumn     = "email"
+	createdAtColumn = "created_at"
+	expiresAtColumn = "expires_at"
+	usedAtColumn    = "used_at"
+)
+
+type repo struct {
+	db db.Client
+}
+
+func NewRepository(db db.Client) repository.UserTokenRepository {
+	return &repo{db: db}
+}
+
+func (r *repo) Create(ctx context.Context, token *model.UserToken) error {
+	sBuilder := sq.Insert(tableName).
+		Columns(tokenHashColumn, userIdColumn, purposeColumn, emailColumn, createdAtColumn, expiresAtColumn).
+		Values(token.TokenHash, token.UserId, token.Purpose, token.Email, token.CreatedAt, token.ExpiresAt).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     "token.create",
+		QueryRaw: query,
+	}
+
+	_, err = r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to create user token: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// Use marks the token used and returns it, once and only before it expires
+func (r *repo) Use(ctx context.Context, tokenHash string, purpose string, at time.Time) (*model.UserToken, error) {
+	sBuilder := sq.Update(tableName).
+		PlaceholderFormat(sq.Dollar).
+		Set(usedAtColumn, at).
+		Where(sq.Eq{tokenHashColumn: tokenHash, purposeColumn: purpose, usedAtColumn: nil}).
+		Where(sq.Gt{expiresAtColumn: at}).
+		Suffix("RETURNING " + tokenHashColumn + ", " + userIdColumn + ", " + purposeColumn + ", " + emailColumn + ", " +
+			createdAtColumn + ", " + expiresAtColumn + ", " + usedAtColumn)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return nil, err
+	}
+
+	q := db.Query{
+		Name:     "token.use",
+		QueryRaw: query,
+	}
+
+	token := model.UserToken{}
+
+	err = r.db.DB().ScanOneContext(ctx, &token, q, args...)
+
+	if pgxscan.NotFound(err) {
+		return nil, repository.ErrUserTokenNotFound
+	}
+
+	if err != nil {
+		log.Printf("failed to use user token: %v\n", err)
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// UseAll spends the unused tokens of the user issued for the purpose
+func (r *repo) UseAll(ctx context.Context, userId int64, purpose string, at time.Time) error {
+	sBuilder := sq.Update(tableName).
+		PlaceholderFormat(sq.Dollar).
+		Set(usedAtColumn, at).
+		Where(sq.Eq{userIdColumn: userId, purposeColumn: purpose, usedAtColumn: nil})
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     "token.useAll",
+		QueryRaw: query,
+	}
+
+	_, err = r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to use user tokens: %v\n", err)
+		return err
+	}
+
+	return nil
+}
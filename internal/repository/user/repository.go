@@ -1,6 +1,7 @@
 package user
 
 import (
+	"database/sql"
 	"fmt"
 	"log"
 	"time"
@@ -21,6 +22,8 @@ const (
 	passwordColumn  = "password"
 	roleColumn      = "role_id"
 	emailColumn     = "email"
+	verifiedColumn  = "email_verified"
+	pendingColumn   = "pending_email"
 	createdAtColumn = "created_at"
 	updatedAtColumn = "updated_at"
 )
@@ -71,6 +74,8 @@ func (r *repo) Get(ctx context.Context, id int64) (*model.User, error) {
 		tableName+"."+idColumn,
 		tableName+"."+nameColumn,
 		tableName+"."+emailColumn,
+		tableName+"."+verifiedColumn,
+		tableName+"."+pendingColumn,
 		"user_role.role_name as role",
 		tableName+"."+createdAtColumn,
 		tableName+"."+updatedAtColumn).
@@ -113,6 +118,8 @@ func (r *repo) GetByEmail(ctx context.Context, email string) (*model.User, error
 		tableName+"."+idColumn,
 		tableName+"."+nameColumn,
 		tableName+"."+emailColumn,
+		tableName+"."+verifiedColumn,
+		tableName+"."+pendingColumn,
 		"user_role.role_name as role",
 		tableName+"."+passwordColumn,
 	).
@@ -208,7 +215,6 @@ func (r *repo) Update(ctx context.Context, info *model.User) error {
 
 	sBuilder := sq.Update(tableName).
 		PlaceholderFormat(sq.Dollar).
-		Set(emailColumn, info.Email).
 		Set(nameColumn, info.Name).
 		Set(updatedAtColumn, time.Now()).
 		Where(sq.Eq{idColumn: info.Id})
@@ -234,3 +240,96 @@ func (r *repo) Update(ctx context.Context, info *model.User) error {
 
 	return nil
 }
+
+func (r *repo) UpdatePassword(ctx context.Context, id int64, passwordHash string) error {
+	sBuilder := sq.Update(tableName).
+		PlaceholderFormat(sq.Dollar).
+		Set(passwordColumn, passwordHash).
+		Set(updatedAtColumn, time.Now()).
+		Where(sq.Eq{idColumn: id})
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     "user.updatePassword",
+		QueryRaw: query,
+	}
+
+	_, err = r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to update password: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// SetPendingEmail keeps a new address until it is confirmed, an empty email cancels the change
+func (r *repo) SetPendingEmail(ctx context.Context, id int64, email string) error {
+	sBuilder := sq.Update(tableName).
+		PlaceholderFormat(sq.Dollar).
+		Set(pendingColumn, sql.NullString{String: email, Valid: email != ""}).
+		Set(updatedAtColumn, time.Now()).
+		Where(sq.Eq{idColumn: id})
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     "user.setPendingEmail",
+		QueryRaw: query,
+	}
+
+	_, err = r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to set pending email: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// VerifyEmail marks the address verified when it is still the email or the pending email of the user,
+// a confirmed pending email replaces the current one
+func (r *repo) VerifyEmail(ctx context.Context, id int64, email string) (bool, error) {
+	sBuilder := sq.Update(tableName).
+		PlaceholderFormat(sq.Dollar).
+		Set(emailColumn, email).
+		Set(verifiedColumn, true).
+		Set(pendingColumn, nil).
+		Set(updatedAtColumn, time.Now()).
+		Where(sq.Eq{idColumn: id}).
+		Where(sq.Or{sq.Eq{emailColumn: email}, sq.Eq{pendingColumn: email}})
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return false, err
+	}
+
+	q := db.Query{
+		Name:     "user.verifyEmail",
+		QueryRaw: query,
+	}
+
+	tag, err := r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to verify email: %v\n", err)
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
@@ -0,0 +1,275 @@
+package webauthn
+
+import (
+	"context"
+	"log"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/laiker/auth/client/db"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+)
+
+const (
+	credentialTableName = "webauthn_credential"
+	challengeTableName  = "webauthn_challenge"
+
+	idColumn            = "id"
+	userIdColumn        = "user_id"
+	publicKeyColumn     = "public_key"
+	signCountColumn     = "sign_count"
+	transportsColumn    = "transports"
+	nameColumn          = "name"
+	createdAtColumn     = "created_at"
+	lastUsedAtColumn    = "last_used_at"
+	challengeHashColumn = "challenge_hash"
+	ceremonyColumn      = "ceremony"
+	expiresAtColumn     = "expires_at"
+	usedAtColumn        = "used_at"
+)
+
+var credentialColumns = []string{
+	idColumn,
+	userIdColumn,
+	publicKeyColumn,
+	signCountColumn,
+	transportsColumn,
+	nameColumn,
+	createdAtColumn,
+	lastUsedAtColumn,
+}
+
+type repo struct {
+	db db.Client
+}
+
+func NewRepository(db db.Client) repository.WebAuthnRepository {
+	return &repo{db: db}
+}
+
+// CreateCredential false when the credential id is already registered
+func (r *repo) CreateCredential(ctx context.Context, credential *model.WebAuthnCredential) (bool, error) {
+	transports := credential.Transports
+	if transports == nil {
+		transports = []string{}
+	}
+
+	sBuilder := sq.Insert(credentialTableName).
+		Columns(idColumn, userIdColumn, publicKeyColumn, signCountColumn, transportsColumn, nameColumn).
+		Values(credential.Id, credential.UserId, credential.PublicKey, credential.SignCount, transports, credential.Name).
+		Suffix("ON CONFLICT (" + idColumn + ") DO NOTHING").
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return false, err
+	}
+
+	q := db.Query{
+		Name:     "webauthn.createCredential",
+		QueryRaw: query,
+	}
+
+	tag, err := r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to insert webauthn credential: %v\n", err)
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *repo) GetCredential(ctx context.Context, id []byte) (*model.WebAuthnCredential, error) {
+	sBuilder := sq.Select(credentialColumns...).
+		From(credentialTableName).
+		Where(sq.Eq{idColumn: id}).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return nil, err
+	}
+
+	q := db.Query{
+		Name:     "webauthn.getCredential",
+		QueryRaw: query,
+	}
+
+	credential := model.WebAuthnCredential{}
+
+	err = r.db.DB().ScanOneContext(ctx, &credential, q, args...)
+
+	if pgxscan.NotFound(err) {
+		return nil, repository.ErrWebAuthnCredentialNotFound
+	}
+
+	if err != nil {
+		log.Printf("failed to select webauthn credential: %v\n", err)
+		return nil, err
+	}
+
+	return &credential, nil
+}
+
+func (r *repo) ListCredentials(ctx context.Context, userId int64) ([]*model.WebAuthnCredential, error) {
+	sBuilder := sq.Select(credentialColumns...).
+		From(credentialTableName).
+		Where(sq.Eq{userIdColumn: userId}).
+		OrderBy(createdAtColumn).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return nil, err
+	}
+
+	q := db.Query{
+		Name:     "webauthn.listCredentials",
+		QueryRaw: query,
+	}
+
+	var credentials []*model.WebAuthnCredential
+
+	err = r.db.DB().ScanAllContext(ctx, &credentials, q, args...)
+
+	if err != nil {
+		log.Printf("failed to select webauthn credentials: %v\n", err)
+		return nil, err
+	}
+
+	return credentials, nil
+}
+
+// UseCredential stores the counter of an accepted assertion, false when the stored counter is not lower.
+// Authenticators without a counter always report 0.
+func (r *repo) UseCredential(ctx context.Context, id []byte, signCount int64, at time.Time) (bool, error) {
+	sBuilder := sq.Update(credentialTableName).
+		PlaceholderFormat(sq.Dollar).
+		Set(signCountColumn, signCount).
+		Set(lastUsedAtColumn, at).
+		Where(sq.Eq{idColumn: id}).
+		Where(sq.Or{sq.Lt{signCountColumn: signCount}, sq.Eq{signCountColumn: 0}})
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return false, err
+	}
+
+	q := db.Query{
+		Name:     "webauthn.useCredential",
+		QueryRaw: query,
+	}
+
+	tag, err := r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to update webauthn credential: %v\n", err)
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// DeleteCredential false when the user has no credential with the id
+func (r *repo) DeleteCredential(ctx context.Context, userId int64, id []byte) (bool, error) {
+	sBuilder := sq.Delete(credentialTableName).
+		Where(sq.Eq{idColumn: id, userIdColumn: userId}).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return false, err
+	}
+
+	q := db.Query{
+		Name:     "webauthn.deleteCredential",
+		QueryRaw: query,
+	}
+
+	tag, err := r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to delete webauthn credential: %v\n", err)
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *repo) CreateChallenge(ctx context.Context, challenge *model.WebAuthnChallenge) error {
+	sBuilder := sq.Insert(challengeTableName).
+		Columns(challengeHashColumn, userIdColumn, ceremonyColumn, expiresAtColumn).
+		Values(challenge.ChallengeHash, challenge.UserId, challenge.Ceremony, challenge.ExpiresAt).
+		PlaceholderFormat(sq.Dollar)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return err
+	}
+
+	q := db.Query{
+		Name:     "webauthn.createChallenge",
+		QueryRaw: query,
+	}
+
+	_, err = r.db.DB().ExecContext(ctx, q, args...)
+
+	if err != nil {
+		log.Printf("failed to insert webauthn challenge: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// UseChallenge marks the challenge used and returns it, a challenge is answered at most once
+func (r *repo) UseChallenge(ctx context.Context, challengeHash string, ceremony string, at time.Time) (*model.WebAuthnChallenge, error) {
+	sBuilder := sq.Update(challengeTableName).
+		PlaceholderFormat(sq.Dollar).
+		Set(usedAtColumn, at).
+		Where(sq.Eq{challengeHashColumn: challengeHash, ceremonyColumn: ceremony, usedAtColumn: nil}).
+		Where(sq.Gt{expiresAtColumn: at}).
+		Suffix("RETURNING " + challengeHashColumn + ", " + userIdColumn + ", " + ceremonyColumn + ", " + expiresAtColumn)
+
+	query, args, err := sBuilder.ToSql()
+
+	if err != nil {
+		log.Printf("failed to build query: %v\n", err)
+		return nil, err
+	}
+
+	q := db.Query{
+		Name:     "webauthn.useChallenge",
+		QueryRaw: query,
+	}
+
+	challenge := model.WebAuthnChallenge{}
+
+	err = r.db.DB().ScanOneContext(ctx, &challenge, q, args...)
+
+	if pgxscan.NotFound(err) {
+		return nil, repository.ErrWebAuthnChallengeNotFound
+	}
+
+	if err != nil {
+		log.Printf("failed to use webauthn challenge: %v\n", err)
+		return nil, err
+	}
+
+	return &challenge, nil
+}
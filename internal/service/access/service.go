@@ -2,7 +2,10 @@ package access
 
 import (
 	"context"
+	"slices"
+	"strings"
 
+	"github.com/laiker/auth/internal/model"
 	"github.com/laiker/auth/internal/repository"
 	"github.com/laiker/auth/internal/service"
 )
@@ -17,14 +20,34 @@ func NewService(repo repository.AccessRepository) service.AccessService {
 	}
 }
 
-func (s *accessService) HasAccessRight(ctx context.Context, endpoint string, role string) (bool, error) {
+// HasAccessRight users are authorized by the role priority, clients by the scope the endpoint requires.
+// API keys need both, the role of their owner and the scope of the endpoint among their own.
+// Endpoints requiring a passkey also deny users who did not start the session with one.
+// Endpoints without a permission are open to everyone.
+func (s *accessService) HasAccessRight(ctx context.Context, endpoint string, claims model.UserClaims) (bool, error) {
 	permission, err := s.repo.GetEndpointPermission(ctx, endpoint)
 
 	if err != nil {
 		return false, err
 	}
 
-	mrole, errs := s.repo.GetRole(ctx, role)
+	if permission.Id == 0 {
+		return true, nil
+	}
+
+	if claims.ClientId != "" {
+		return hasScope(claims, permission), nil
+	}
+
+	if claims.Type == model.TokenTypeApiKey && !hasScope(claims, permission) {
+		return false, nil
+	}
+
+	if permission.RequirePasskey && !slices.Contains(claims.Amr, model.AmrPasskey) {
+		return false, nil
+	}
+
+	mrole, errs := s.repo.GetRole(ctx, claims.Role)
 
 	if errs != nil {
 		return false, errs
@@ -32,3 +55,7 @@ func (s *accessService) HasAccessRight(ctx context.Context, endpoint string, rol
 
 	return permission.MinPriority <= mrole.Priority, nil
 }
+
+func hasScope(claims model.UserClaims, permission *model.Permission) bool {
+	return permission.RequiredScope != "" && slices.Contains(strings.Fields(claims.Scope), permission.RequiredScope)
+}
@@ -0,0 +1,109 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/laiker/auth/internal/model"
+	accessService "github.com/laiker/auth/internal/service/access"
+	"github.com/pkg/errors"
+)
+
+// accessRepo in-memory repository.AccessRepository, unknown rows come back empty like the Postgres one
+type accessRepo struct {
+	permissions map[string]*model.Permission
+	roles       map[string]*model.Role
+	// err of the database, returned by every query
+	err error
+}
+
+func (r *accessRepo) GetEndpointPermission(_ context.Context, endpoint string) (*model.Permission, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if permission, ok := r.permissions[endpoint]; ok {
+		return permission, nil
+	}
+
+	return &model.Permission{}, nil
+}
+
+func (r *accessRepo) GetRole(_ context.Context, role string) (*model.Role, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if mrole, ok := r.roles[role]; ok {
+		return mrole, nil
+	}
+
+	return &model.Role{}, nil
+}
+
+func Test_accessService_HasAccessRight(t *testing.T) {
+	repo := &accessRepo{
+		permissions: map[string]*model.Permission{
+			"/user_v1.UserV1/Delete":       {Id: 1, MinPriority: 100, RequiredScope: "users:write"},
+			"/auth_v1.AuthV1/ListClients":  {Id: 2, MinPriority: 100},
+			"/auth_v1.AuthV1/DeleteClient": {Id: 3, MinPriority: 100, RequiredScope: "clients:write", RequirePasskey: true},
+		},
+		roles: map[string]*model.Role{
+			"user":  {Id: 1, Name: "user", Priority: 10},
+			"admin": {Id: 2, Name: "admin", Priority: 100},
+		},
+	}
+
+	s := accessService.NewService(repo)
+
+	tests := []struct {
+		name     string
+		endpoint string
+		claims   model.UserClaims
+		want     bool
+	}{
+		{name: "open endpoint anonymous", endpoint: "/auth_v1.AuthV1/Login", want: true},
+		{name: "open endpoint client", endpoint: "/auth_v1.AuthV1/Login", claims: model.UserClaims{ClientId: "worker"}, want: true},
+		{name: "restricted anonymous", endpoint: "/user_v1.UserV1/Delete", want: false},
+		{name: "admin user", endpoint: "/user_v1.UserV1/Delete", claims: model.UserClaims{UserId: 1, Role: "admin"}, want: true},
+		{name: "regular user", endpoint: "/user_v1.UserV1/Delete", claims: model.UserClaims{UserId: 2, Role: "user"}, want: false},
+		{name: "client with scope", endpoint: "/user_v1.UserV1/Delete", claims: model.UserClaims{ClientId: "worker", Scope: "users:read users:write"}, want: true},
+		{name: "client without scope", endpoint: "/user_v1.UserV1/Delete", claims: model.UserClaims{ClientId: "worker", Scope: "users:read"}, want: false},
+		{name: "client with scope prefix", endpoint: "/user_v1.UserV1/Delete", claims: model.UserClaims{ClientId: "worker", Scope: "users:writer"}, want: false},
+		{name: "client on endpoint without scope", endpoint: "/auth_v1.AuthV1/ListClients", claims: model.UserClaims{ClientId: "worker", Scope: "users:write"}, want: false},
+		{name: "admin with passkey", endpoint: "/auth_v1.AuthV1/DeleteClient", claims: model.UserClaims{UserId: 1, Role: "admin", Amr: []string{model.AmrPasskey}}, want: true},
+		{name: "admin with password and mfa", endpoint: "/auth_v1.AuthV1/DeleteClient", claims: model.UserClaims{UserId: 1, Role: "admin", Amr: []string{model.AmrPassword, model.AmrMfa}}, want: false},
+		{name: "user with passkey", endpoint: "/auth_v1.AuthV1/DeleteClient", claims: model.UserClaims{UserId: 2, Role: "user", Amr: []string{model.AmrPasskey}}, want: false},
+		{name: "client on passkey endpoint", endpoint: "/auth_v1.AuthV1/DeleteClient", claims: model.UserClaims{ClientId: "worker", Scope: "clients:write"}, want: true},
+		{name: "api key of admin with scope", endpoint: "/user_v1.UserV1/Delete", claims: model.UserClaims{Type: model.TokenTypeApiKey, UserId: 1, Role: "admin", Scope: "users:write"}, want: true},
+		{name: "api key of admin without scope", endpoint: "/user_v1.UserV1/Delete", claims: model.UserClaims{Type: model.TokenTypeApiKey, UserId: 1, Role: "admin", Scope: "users:read"}, want: false},
+		{name: "api key of user with scope", endpoint: "/user_v1.UserV1/Delete", claims: model.UserClaims{Type: model.TokenTypeApiKey, UserId: 2, Role: "user", Scope: "users:write"}, want: false},
+		{name: "api key on endpoint without scope", endpoint: "/auth_v1.AuthV1/ListClients", claims: model.UserClaims{Type: model.TokenTypeApiKey, UserId: 1, Role: "admin", Scope: "users:write"}, want: false},
+		{name: "api key on passkey endpoint", endpoint: "/auth_v1.AuthV1/DeleteClient", claims: model.UserClaims{Type: model.TokenTypeApiKey, UserId: 1, Role: "admin", Scope: "clients:write"}, want: false},
+		{name: "api key on open endpoint", endpoint: "/auth_v1.AuthV1/Login", claims: model.UserClaims{Type: model.TokenTypeApiKey, UserId: 2, Role: "user"}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.HasAccessRight(context.Background(), tt.endpoint, tt.claims)
+			if err != nil {
+				t.Fatalf("HasAccessRight() error = %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("HasAccessRight() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_accessService_HasAccessRight_DatabaseDown(t *testing.T) {
+	s := accessService.NewService(&accessRepo{err: errors.New("connection refused")})
+
+	for _, endpoint := range []string{"/auth_v1.AuthV1/Login", "/auth_v1.AuthV1/DeleteClient"} {
+		allowed, err := s.HasAccessRight(context.Background(), endpoint, model.UserClaims{UserId: 1, Role: "admin"})
+		if err == nil || allowed {
+			t.Errorf("HasAccessRight(%q) = %v, %v, an endpoint was opened without its permission", endpoint, allowed, err)
+		}
+	}
+}
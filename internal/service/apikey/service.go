@@ -0,0 +1,211 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/laiker/auth/internal/config"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+	"github.com/laiker/auth/internal/service"
+	"github.com/laiker/auth/internal/utils"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidApiKey  = errors.New("api key is invalid")
+	ErrApiKeyNotFound = errors.New("api key not found")
+	ErrMalformedScope = errors.New("api keys need at least one scope, scopes can not contain spaces")
+	ErrApiKeyTTL      = errors.New("api key ttl exceeds the allowed maximum")
+)
+
+const (
+	// prefixLen random bytes of the public part of a key, hex encoded
+	prefixLen = 6
+	// touchInterval uses of a key from the same ip within it are not recorded again
+	touchInterval = time.Minute
+)
+
+type apiKeyService struct {
+	defaultTTL time.Duration
+	maxTTL     time.Duration
+	apiKeyRepo repository.ApiKeyRepository
+	userRepo   repository.UserRepository
+	now        func() time.Time
+}
+
+func NewService(
+	config config.ApiKeyConfig,
+	apiKeyRepo repository.ApiKeyRepository,
+	userRepo repository.UserRepository,
+	now func() time.Time,
+) service.ApiKeyService {
+	return &apiKeyService{
+		defaultTTL: config.GetDefaultTTL(),
+		maxTTL:     config.GetMaxTTL(),
+		apiKeyRepo: apiKeyRepo,
+		userRepo:   userRepo,
+		now:        now,
+	}
+}
+
+// Create issues a key of the user and returns it, the key is shown only once
+func (s *apiKeyService) Create(ctx context.Context, userId int64, info *model.ApiKeyInfo) (*model.ApiKey, string, error) {
+	scopes, err := normalizeScopes(info.Scopes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ttl := info.TTL
+	if ttl == 0 {
+		ttl = s.defaultTTL
+	}
+
+	if ttl < 0 || ttl > s.maxTTL {
+		return nil, "", ErrApiKeyTTL
+	}
+
+	raw := make([]byte, prefixLen)
+
+	_, err = rand.Read(raw)
+	if err != nil {
+		return nil, "", err
+	}
+
+	prefix := hex.EncodeToString(raw)
+
+	secret, err := utils.RandomToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := &model.ApiKey{
+		Id:         uuid.NewString(),
+		UserId:     userId,
+		Name:       info.Name,
+		Prefix:     prefix,
+		SecretHash: utils.HashToken(secret),
+		Scopes:     scopes,
+		CreatedAt:  s.now(),
+		ExpiresAt:  s.now().Add(ttl),
+	}
+
+	err = s.apiKeyRepo.Create(ctx, key)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return key, model.ApiKeyPrefix + prefix + "_" + secret, nil
+}
+
+// List keys of the user that are not revoked, the oldest first
+func (s *apiKeyService) List(ctx context.Context, userId int64) ([]*model.ApiKey, error) {
+	return s.apiKeyRepo.List(ctx, userId)
+}
+
+// Revoke a key of the user, it stops working at once
+func (s *apiKeyService) Revoke(ctx context.Context, userId int64, id string) error {
+	revoked, err := s.apiKeyRepo.Revoke(ctx, userId, id, s.now())
+
+	if err != nil {
+		return err
+	}
+
+	// a key of someone else is reported as missing so ids can not be probed
+	if !revoked {
+		return ErrApiKeyNotFound
+	}
+
+	return nil
+}
+
+// Verify claims of the owner of the key, typed model.TokenTypeApiKey and carrying the scopes of the key.
+// The use is recorded with the ip of the caller.
+func (s *apiKeyService) Verify(ctx context.Context, apiKey string, ip string) (model.UserClaims, error) {
+	prefix, secret, ok := parseKey(apiKey)
+	if !ok {
+		return model.UserClaims{}, ErrInvalidApiKey
+	}
+
+	key, err := s.apiKeyRepo.GetByPrefix(ctx, prefix)
+
+	if errors.Is(err, repository.ErrApiKeyNotFound) {
+		return model.UserClaims{}, ErrInvalidApiKey
+	}
+
+	if err != nil {
+		return model.UserClaims{}, err
+	}
+
+	now := s.now()
+
+	if subtle.ConstantTimeCompare([]byte(key.SecretHash), []byte(utils.HashToken(secret))) != 1 ||
+		key.RevokedAt.Valid || !now.Before(key.ExpiresAt) {
+		return model.UserClaims{}, ErrInvalidApiKey
+	}
+
+	user, err := s.userRepo.Get(ctx, key.UserId)
+
+	if err != nil {
+		return model.UserClaims{}, ErrInvalidApiKey
+	}
+
+	// the last use is informational, failing to record it does not deny the caller
+	_ = s.apiKeyRepo.Touch(ctx, key.Id, ip, now, now.Add(-touchInterval))
+
+	return model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        key.Id,
+			ExpiresAt: key.ExpiresAt.Unix(),
+		},
+		Type:      model.TokenTypeApiKey,
+		UserId:    user.Id,
+		UserLogin: user.Name,
+		Role:      user.Role,
+		Scope:     strings.Join(key.Scopes, " "),
+	}, nil
+}
+
+// parseKey splits ak_<prefix>_<secret>, the secret is base64url and may contain underscores itself
+func parseKey(apiKey string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(apiKey, model.ApiKeyPrefix)
+	if !ok {
+		return "", "", false
+	}
+
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || len(prefix) != 2*prefixLen || secret == "" {
+		return "", "", false
+	}
+
+	return prefix, secret, true
+}
+
+// normalizeScopes drops duplicates, scopes are joined with spaces in claims so they can not contain one
+func normalizeScopes(scopes []string) ([]string, error) {
+	normalized := make([]string, 0, len(scopes))
+
+	for _, scope := range scopes {
+		if scope == "" || strings.ContainsAny(scope, " \t\n") {
+			return nil, ErrMalformedScope
+		}
+
+		if !slices.Contains(normalized, scope) {
+			normalized = append(normalized, scope)
+		}
+	}
+
+	if len(normalized) == 0 {
+		return nil, ErrMalformedScope
+	}
+
+	return normalized, nil
+}
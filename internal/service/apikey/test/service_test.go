@@ -0,0 +1,294 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+	"github.com/laiker/auth/internal/service"
+	apiKeyService "github.com/laiker/auth/internal/service/apikey"
+	"github.com/pkg/errors"
+)
+
+type apiKeyConfig struct{}
+
+func (apiKeyConfig) GetDefaultTTL() time.Duration { return 30 * 24 * time.Hour }
+func (apiKeyConfig) GetMaxTTL() time.Duration     { return 365 * 24 * time.Hour }
+
+// clock fake time keys expire by
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time { return c.now }
+
+func (c *clock) Add(d time.Duration) { c.now = c.now.Add(d) }
+
+// userRepo serves a single user, the rest of repository.UserRepository is not used by the service
+type userRepo struct {
+	repository.UserRepository
+	user *model.User
+}
+
+func (r *userRepo) Get(_ context.Context, id int64) (*model.User, error) {
+	if id != r.user.Id {
+		return nil, errors.New("Пользователь не найден")
+	}
+
+	return r.user, nil
+}
+
+// apiKeyRepo in-memory repository.ApiKeyRepository, touches counts the recorded uses
+type apiKeyRepo struct {
+	mu      sync.Mutex
+	keys    map[string]*model.ApiKey
+	touches int
+}
+
+func (r *apiKeyRepo) Create(_ context.Context, key *model.ApiKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := *key
+	r.keys[key.Prefix] = &stored
+
+	return nil
+}
+
+func (r *apiKeyRepo) GetByPrefix(_ context.Context, prefix string) (*model.ApiKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.keys[prefix]
+	if !ok {
+		return nil, repository.ErrApiKeyNotFound
+	}
+
+	found := *key
+
+	return &found, nil
+}
+
+func (r *apiKeyRepo) List(_ context.Context, userId int64) ([]*model.ApiKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var keys []*model.ApiKey
+
+	for _, key := range r.keys {
+		if key.UserId == userId && !key.RevokedAt.Valid {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+func (r *apiKeyRepo) Revoke(_ context.Context, userId int64, id string, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, key := range r.keys {
+		if key.Id == id && key.UserId == userId && !key.RevokedAt.Valid {
+			key.RevokedAt = sql.NullTime{Time: at, Valid: true}
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (r *apiKeyRepo) Touch(_ context.Context, id string, ip string, at time.Time, since time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, key := range r.keys {
+		if key.Id != id {
+			continue
+		}
+
+		if key.LastUsedAt.Valid && !key.LastUsedAt.Time.Before(since) && key.LastUsedIp.String == ip {
+			return nil
+		}
+
+		key.LastUsedAt = sql.NullTime{Time: at, Valid: true}
+		key.LastUsedIp = sql.NullString{String: ip, Valid: true}
+		r.touches++
+	}
+
+	return nil
+}
+
+type fixture struct {
+	service service.ApiKeyService
+	repo    *apiKeyRepo
+	clock   *clock
+	user    *model.User
+}
+
+func newFixture() *fixture {
+	f := &fixture{
+		repo:  &apiKeyRepo{keys: map[string]*model.ApiKey{}},
+		clock: &clock{now: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)},
+		user:  &model.User{Id: 7, Name: "alice", Email: "alice@example.com", Role: "admin"},
+	}
+
+	f.service = apiKeyService.NewService(apiKeyConfig{}, f.repo, &userRepo{user: f.user}, f.clock.Now)
+
+	return f
+}
+
+func (f *fixture) create(t *testing.T, info *model.ApiKeyInfo) (*model.ApiKey, string) {
+	t.Helper()
+
+	key, secret, err := f.service.Create(context.Background(), f.user.Id, info)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if !strings.HasPrefix(secret, model.ApiKeyPrefix+key.Prefix+"_") {
+		t.Fatalf("key %q does not start with its prefix %q", secret, key.Prefix)
+	}
+
+	if strings.Contains(key.SecretHash, strings.TrimPrefix(secret, model.ApiKeyPrefix+key.Prefix+"_")) {
+		t.Fatal("the secret is stored in plain text")
+	}
+
+	return key, secret
+}
+
+func TestApiKeyService_Verify(t *testing.T) {
+	f := newFixture()
+	key, secret := f.create(t, &model.ApiKeyInfo{Name: "ci", Scopes: []string{"users:read", "users:write", "users:read"}})
+
+	claims, err := f.service.Verify(context.Background(), secret, "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	if claims.Type != model.TokenTypeApiKey || claims.UserId != f.user.Id || claims.Role != "admin" ||
+		claims.Scope != "users:read users:write" || claims.Id != key.Id {
+		t.Fatalf("claims %+v", claims)
+	}
+
+	stored, _ := f.repo.GetByPrefix(context.Background(), key.Prefix)
+	if !stored.LastUsedAt.Valid || !stored.LastUsedAt.Time.Equal(f.clock.now) || stored.LastUsedIp.String != "10.0.0.1" {
+		t.Fatalf("last use %v from %q was not recorded", stored.LastUsedAt, stored.LastUsedIp.String)
+	}
+}
+
+func TestApiKeyService_RecordsUses(t *testing.T) {
+	f := newFixture()
+	_, secret := f.create(t, &model.ApiKeyInfo{Name: "ci", Scopes: []string{"users:read"}})
+
+	for _, use := range []struct {
+		after time.Duration
+		ip    string
+	}{
+		{after: 0, ip: "10.0.0.1"},
+		{after: time.Second, ip: "10.0.0.1"},
+		{after: time.Second, ip: "10.0.0.2"},
+		{after: 2 * time.Minute, ip: "10.0.0.2"},
+	} {
+		f.clock.Add(use.after)
+
+		if _, err := f.service.Verify(context.Background(), secret, use.ip); err != nil {
+			t.Fatalf("Verify() error = %v", err)
+		}
+	}
+
+	// the second use came from the same ip right after the first one
+	if f.repo.touches != 3 {
+		t.Fatalf("%d uses recorded, want 3", f.repo.touches)
+	}
+}
+
+func TestApiKeyService_InvalidKeys(t *testing.T) {
+	f := newFixture()
+	key, secret := f.create(t, &model.ApiKeyInfo{Name: "ci", Scopes: []string{"users:read"}, TTL: time.Hour})
+
+	for name, apiKey := range map[string]string{
+		"empty":          "",
+		"no prefix":      strings.TrimPrefix(secret, model.ApiKeyPrefix),
+		"unknown prefix": model.ApiKeyPrefix + "000000000000_" + strings.Split(secret, "_")[2],
+		"wrong secret":   model.ApiKeyPrefix + key.Prefix + "_wrong",
+		"no secret":      model.ApiKeyPrefix + key.Prefix + "_",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := f.service.Verify(context.Background(), apiKey, "10.0.0.1")
+			if !errors.Is(err, apiKeyService.ErrInvalidApiKey) {
+				t.Fatalf("Verify() error = %v, want ErrInvalidApiKey", err)
+			}
+		})
+	}
+
+	f.clock.Add(time.Hour)
+
+	_, err := f.service.Verify(context.Background(), secret, "10.0.0.1")
+	if !errors.Is(err, apiKeyService.ErrInvalidApiKey) {
+		t.Fatalf("Verify() of an expired key error = %v, want ErrInvalidApiKey", err)
+	}
+}
+
+func TestApiKeyService_Revoke(t *testing.T) {
+	f := newFixture()
+	key, secret := f.create(t, &model.ApiKeyInfo{Name: "ci", Scopes: []string{"users:read"}})
+	other, _ := f.create(t, &model.ApiKeyInfo{Name: "deploy", Scopes: []string{"users:read"}})
+
+	err := f.service.Revoke(context.Background(), f.user.Id+1, key.Id)
+	if !errors.Is(err, apiKeyService.ErrApiKeyNotFound) {
+		t.Fatalf("Revoke() of a key of someone else error = %v, want ErrApiKeyNotFound", err)
+	}
+
+	if err = f.service.Revoke(context.Background(), f.user.Id, key.Id); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+
+	_, err = f.service.Verify(context.Background(), secret, "10.0.0.1")
+	if !errors.Is(err, apiKeyService.ErrInvalidApiKey) {
+		t.Fatalf("Verify() of a revoked key error = %v, want ErrInvalidApiKey", err)
+	}
+
+	keys, err := f.service.List(context.Background(), f.user.Id)
+	if err != nil || len(keys) != 1 || keys[0].Id != other.Id {
+		t.Fatalf("List() = %v, %v, want only the key left", keys, err)
+	}
+
+	err = f.service.Revoke(context.Background(), f.user.Id, key.Id)
+	if !errors.Is(err, apiKeyService.ErrApiKeyNotFound) {
+		t.Fatalf("second Revoke() error = %v, want ErrApiKeyNotFound", err)
+	}
+}
+
+func TestApiKeyService_Create(t *testing.T) {
+	f := newFixture()
+
+	key, _ := f.create(t, &model.ApiKeyInfo{Name: "ci", Scopes: []string{"users:read"}})
+	if !key.ExpiresAt.Equal(f.clock.now.Add(30 * 24 * time.Hour)) {
+		t.Fatalf("a key without ttl expires at %v, want the configured default", key.ExpiresAt)
+	}
+
+	tests := []struct {
+		name string
+		info *model.ApiKeyInfo
+		want error
+	}{
+		{name: "no scopes", info: &model.ApiKeyInfo{Name: "ci"}, want: apiKeyService.ErrMalformedScope},
+		{name: "scope with a space", info: &model.ApiKeyInfo{Name: "ci", Scopes: []string{"users:read users:write"}}, want: apiKeyService.ErrMalformedScope},
+		{name: "ttl over the maximum", info: &model.ApiKeyInfo{Name: "ci", Scopes: []string{"users:read"}, TTL: 366 * 24 * time.Hour}, want: apiKeyService.ErrApiKeyTTL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := f.service.Create(context.Background(), f.user.Id, tt.info)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Create() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
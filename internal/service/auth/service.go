@@ -1,52 +1,525 @@
 package user
 
 import (
+	"database/sql"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/laiker/auth/client/db"
 	"github.com/laiker/auth/internal/config"
 	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
 	"github.com/laiker/auth/internal/service"
 	"github.com/laiker/auth/internal/utils"
+	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 )
 
-const JwtAccessExpireTime = 24 * time.Hour
-const JwtRefreshExpireTime = 30 * 24 * time.Hour
+const AuthorizationCodeTTL = time.Minute
+const IdTokenExpireTime = time.Hour
+
+// MfaChallengeTTL time the user has to enter the second factor after the password
+const MfaChallengeTTL = 5 * time.Minute
+
+// ImpersonationTokenTTL longest lifetime of an impersonation token, a role can shorten it further
+const ImpersonationTokenTTL = 15 * time.Minute
+
+var (
+	ErrRefreshTokenRevoked = errors.New("refresh token revoked")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+	ErrTokenRevoked        = errors.New("token revoked")
+	ErrInvalidScope        = errors.New("requested scope is not allowed for the client")
+	ErrInvalidGrant        = errors.New("invalid authorization grant")
+	ErrInvalidTarget       = errors.New("client may not exchange tokens for the audience")
+	ErrSessionExpired      = errors.New("session reached its maximum age")
+
+	errCodeReused = errors.New("authorization code reused")
+)
 
 type authService struct {
-	jwtConfig config.JwtConfig
+	keyRing        *utils.KeyRing
+	refreshKeys    *utils.KeyRing
+	refreshRepo    repository.RefreshTokenRepository
+	revocationRepo repository.RevocationRepository
+	codeRepo       repository.AuthorizationCodeRepository
+	userRepo       repository.UserRepository
+	accessRepo     repository.AccessRepository
+	sessionRepo    repository.SessionRepository
+	txManager      db.TxManager
+	issuer         string
+	accessPolicy   utils.TokenPolicy
+	refreshPolicy  utils.TokenPolicy
+	mfaPolicy      utils.TokenPolicy
+	lifetimes      model.TokenLifetimes
 }
 
-func NewService(config config.JwtConfig) service.AuthService {
+// NewService signs access tokens with the current key of keyRing,
+// refresh tokens are always signed with the HMAC refresh secret.
+// Token lifetimes come from the config, roles and clients can only shorten them.
+func NewService(
+	config config.JwtConfig,
+	keyRing *utils.KeyRing,
+	refreshRepo repository.RefreshTokenRepository,
+	revocationRepo repository.RevocationRepository,
+	codeRepo repository.AuthorizationCodeRepository,
+	userRepo repository.UserRepository,
+	accessRepo repository.AccessRepository,
+	sessionRepo repository.SessionRepository,
+	txManager db.TxManager,
+) service.AuthService {
 	return &authService{
-		jwtConfig: config,
+		keyRing:        keyRing,
+		refreshKeys:    utils.NewKeyRing(utils.NewHMACKey([]byte(config.GetRefreshSecret()))),
+		refreshRepo:    refreshRepo,
+		revocationRepo: revocationRepo,
+		codeRepo:       codeRepo,
+		userRepo:       userRepo,
+		accessRepo:     accessRepo,
+		sessionRepo:    sessionRepo,
+		txManager:      txManager,
+		issuer:         config.GetIssuer(),
+		accessPolicy:   tokenPolicy(config, model.TokenTypeAccess),
+		refreshPolicy:  tokenPolicy(config, model.TokenTypeRefresh),
+		mfaPolicy:      tokenPolicy(config, model.TokenTypeMfa),
+		lifetimes: model.TokenLifetimes{
+			AccessToken:   config.GetAccessTokenTTL(),
+			SessionIdle:   config.GetSessionIdleTimeout(),
+			SessionMaxAge: config.GetSessionMaxAge(),
+		},
+	}
+}
+
+func tokenPolicy(config config.JwtConfig, tokenType string) utils.TokenPolicy {
+	return utils.TokenPolicy{
+		Issuer:   config.GetIssuer(),
+		Audience: config.GetAudience(),
+		Type:     tokenType,
+		Leeway:   config.GetClockSkew(),
 	}
 }
 
 func (s *authService) GetAccessToken(ctx context.Context, claims model.UserJwt) (string, error) {
-	token, err := utils.GenerateToken(claims, []byte(s.jwtConfig.GetAccessSecret()), JwtAccessExpireTime)
+	lifetimes, err := s.lifetimesOf(ctx, claims.Role, nil)
 
 	if err != nil {
 		return "", err
 	}
 
-	return token, nil
+	return s.issueAccessToken(claims, lifetimes.AccessToken)
+}
+
+// IssueImpersonationToken access token of the user with the admin in claims.Act.
+// It comes without a refresh token and session, so it can not outlive ImpersonationTokenTTL.
+func (s *authService) IssueImpersonationToken(ctx context.Context, claims model.UserJwt) (*model.OAuthToken, error) {
+	if claims.Act == nil {
+		return nil, errors.New("impersonation token needs an actor")
+	}
+
+	lifetimes, err := s.lifetimesOf(ctx, claims.Role, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := min(lifetimes.AccessToken, ImpersonationTokenTTL)
+
+	token, err := s.issueAccessToken(claims, ttl)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.OAuthToken{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(ttl.Seconds()),
+	}, nil
+}
+
+// IssueClientToken client_credentials grant, the token identifies the client and carries no refresh token.
+// Requested scopes must be a subset of the client scopes, no scopes means all of them.
+func (s *authService) IssueClientToken(ctx context.Context, client *model.Client, scopes []string) (*model.OAuthToken, error) {
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+
+	if !client.AllowsScopes(scopes) {
+		return nil, ErrInvalidScope
+	}
+
+	scope := strings.Join(scopes, " ")
+
+	lifetimes, err := s.lifetimesOf(ctx, "", client)
+
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := s.issueAccessToken(model.UserJwt{
+		ClientId: client.Id,
+		Scope:    scope,
+	}, lifetimes.AccessToken)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.OAuthToken{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(lifetimes.AccessToken.Seconds()),
+		Scope:       scope,
+	}, nil
 }
 
+// GetRefreshToken starts a new token family, used on login
 func (s *authService) GetRefreshToken(ctx context.Context, claims model.UserJwt) (string, error) {
-	token, err := utils.GenerateToken(claims, []byte(s.jwtConfig.GetRefreshSecret()), JwtRefreshExpireTime)
+	lifetimes, err := s.lifetimesOf(ctx, claims.Role, nil)
 
 	if err != nil {
 		return "", err
 	}
 
+	var token string
+
+	err = s.txManager.ReadCommitted(ctx, func(ctx context.Context) error {
+		var errTx error
+
+		token, _, errTx = s.startFamily(ctx, claims, lifetimes)
+
+		return errTx
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// StartSession issues the tokens of a login, the access token is bound to the new session
+// and stops working once the session is revoked
+func (s *authService) StartSession(ctx context.Context, claims model.UserJwt) (*model.OAuthToken, error) {
+	lifetimes, err := s.lifetimesOf(ctx, claims.Role, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	token := &model.OAuthToken{
+		TokenType: "Bearer",
+		ExpiresIn: int64(lifetimes.AccessToken.Seconds()),
+	}
+
+	err = s.txManager.ReadCommitted(ctx, func(ctx context.Context) error {
+		var errTx error
+
+		token.RefreshToken, claims.FamilyId, errTx = s.startFamily(ctx, claims, lifetimes)
+
+		return errTx
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	token.AccessToken, err = s.issueAccessToken(claims, lifetimes.AccessToken)
+
+	if err != nil {
+		return nil, err
+	}
+
 	return token, nil
 }
 
+// IssueMfaChallenge token proving the password step of a login, signed with the refresh secret
+// so services verifying access tokens with the JWKS never see it
+func (s *authService) IssueMfaChallenge(_ context.Context, claims model.UserJwt) (string, error) {
+	claims.TokenId = uuid.NewString()
+
+	return utils.GenerateToken(claims, s.refreshKeys.Current(), s.mfaPolicy, MfaChallengeTTL)
+}
+
+func (s *authService) VerifyMfaChallenge(ctx context.Context, token string) (model.UserClaims, error) {
+	claims, err := utils.VerifyToken(token, s.refreshKeys, s.mfaPolicy)
+
+	if err != nil {
+		return model.UserClaims{}, err
+	}
+
+	err = s.checkRevoked(ctx, claims)
+
+	if err != nil {
+		return model.UserClaims{}, err
+	}
+
+	return *claims, nil
+}
+
+// CreateAuthorizationCode stores an approved authorization request and returns the code for the client
+func (s *authService) CreateAuthorizationCode(ctx context.Context, code *model.AuthorizationCode) (string, error) {
+	raw, err := utils.RandomToken()
+
+	if err != nil {
+		return "", err
+	}
+
+	code.CodeHash = utils.HashToken(raw)
+	code.ExpiresAt = time.Now().Add(AuthorizationCodeTTL)
+
+	err = s.codeRepo.Create(ctx, code)
+
+	if err != nil {
+		return "", err
+	}
+
+	return raw, nil
+}
+
+// ExchangeAuthorizationCode authorization_code grant with mandatory PKCE.
+// A code can be exchanged once, a replay revokes the tokens issued for it (RFC 6749 4.1.2).
+func (s *authService) ExchangeAuthorizationCode(
+	ctx context.Context,
+	client *model.Client,
+	code string,
+	redirectUri string,
+	codeVerifier string,
+) (*model.OAuthToken, error) {
+	codeHash := utils.HashToken(code)
+
+	stored, err := s.codeRepo.Get(ctx, codeHash)
+
+	if err != nil {
+		return nil, ErrInvalidGrant
+	}
+
+	if stored.ClientId != client.Id ||
+		stored.RedirectUri != redirectUri ||
+		time.Now().After(stored.ExpiresAt) ||
+		!utils.VerifyCodeChallenge(stored.CodeChallenge, codeVerifier) {
+		return nil, ErrInvalidGrant
+	}
+
+	user := model.UserJwt{
+		UserId:    stored.UserId,
+		UserLogin: stored.UserLogin,
+		Role:      stored.Role,
+		Scope:     stored.Scope,
+		Azp:       client.Id,
+		Amr:       stored.Amr,
+	}
+
+	lifetimes, err := s.lifetimesOf(ctx, stored.Role, client)
+
+	if err != nil {
+		return nil, err
+	}
+
+	token := &model.OAuthToken{
+		TokenType: "Bearer",
+		ExpiresIn: int64(lifetimes.AccessToken.Seconds()),
+		Scope:     stored.Scope,
+	}
+
+	err = s.txManager.ReadCommitted(ctx, func(ctx context.Context) error {
+		used, errTx := s.codeRepo.MarkUsed(ctx, codeHash)
+
+		if errTx != nil {
+			return errTx
+		}
+
+		if !used {
+			return errCodeReused
+		}
+
+		token.RefreshToken, user.FamilyId, errTx = s.startFamily(ctx, user, lifetimes)
+
+		if errTx != nil {
+			return errTx
+		}
+
+		return s.codeRepo.SetFamily(ctx, codeHash, user.FamilyId)
+	})
+
+	if errors.Is(err, errCodeReused) {
+		// read again, the first exchange may have committed after our first read
+		stored, err = s.codeRepo.Get(ctx, codeHash)
+
+		if err == nil && stored.FamilyId.Valid {
+			err = s.refreshRepo.RevokeFamily(ctx, stored.FamilyId.String)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, ErrInvalidGrant
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	token.AccessToken, err = s.issueAccessToken(user, lifetimes.AccessToken)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if model.HasScope(stored.Scope, "openid") {
+		token.IdToken, err = s.issueIdToken(ctx, stored)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return token, nil
+}
+
+// RefreshClientToken refresh_token grant, the refresh token must have been issued to the same client
+func (s *authService) RefreshClientToken(ctx context.Context, client *model.Client, refreshToken string) (*model.OAuthToken, error) {
+	claims, stored, err := s.verifyRefreshToken(ctx, refreshToken)
+
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidGrant, err.Error())
+	}
+
+	if claims.Azp != client.Id {
+		return nil, ErrInvalidGrant
+	}
+
+	lifetimes, err := s.lifetimesOf(ctx, claims.Role, client)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rotated, err := s.rotateRefreshToken(ctx, claims, stored, lifetimes)
+
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidGrant, err.Error())
+	}
+
+	accessToken, err := s.issueAccessToken(model.UserJwt{
+		UserId:    claims.UserId,
+		UserLogin: claims.UserLogin,
+		Role:      claims.Role,
+		FamilyId:  claims.FamilyId,
+		Scope:     claims.Scope,
+		Azp:       claims.Azp,
+		Amr:       claims.Amr,
+	}, lifetimes.AccessToken)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.OAuthToken{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(lifetimes.AccessToken.Seconds()),
+		RefreshToken: rotated,
+		Scope:        claims.Scope,
+	}, nil
+}
+
+// ExchangeToken RFC 8693 token exchange, a client calls another service on behalf of the user of the subject token.
+// The new token is issued for the audience with scopes the subject token and the client both have, never
+// more than those and never for longer than the subject token. The client is added to the act delegation chain.
+// Subject tokens are our access tokens or tokens exchanged for an audience named like the client,
+// so a service can pass the tokens it receives further down the chain.
+func (s *authService) ExchangeToken(ctx context.Context, client *model.Client, exchange model.TokenExchange) (*model.OAuthToken, error) {
+	if !client.AllowsAudience(exchange.Audience) {
+		return nil, ErrInvalidTarget
+	}
+
+	claims, err := s.verifySubjectToken(ctx, client, exchange.SubjectToken)
+
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidGrant, err.Error())
+	}
+
+	// client tokens act on behalf of nobody
+	if claims.ClientId != "" {
+		return nil, ErrInvalidGrant
+	}
+
+	scopes, err := narrowScopes(claims.Scope, client, exchange.Scopes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	lifetimes, err := s.lifetimesOf(ctx, claims.Role, client)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := min(lifetimes.AccessToken, time.Until(time.Unix(claims.ExpiresAt, 0)).Truncate(time.Second))
+
+	if ttl < time.Second {
+		return nil, ErrInvalidGrant
+	}
+
+	scope := strings.Join(scopes, " ")
+
+	token, err := s.issueAccessToken(model.UserJwt{
+		UserId:    claims.UserId,
+		UserLogin: claims.UserLogin,
+		Role:      claims.Role,
+		FamilyId:  claims.FamilyId,
+		Scope:     scope,
+		Azp:       client.Id,
+		Amr:       claims.Amr,
+		Audience:  exchange.Audience,
+		Act: &model.Actor{
+			Subject:  client.Id,
+			ClientId: client.Id,
+			Act:      claims.Act,
+		},
+	}, ttl)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.OAuthToken{
+		AccessToken:     token,
+		TokenType:       "Bearer",
+		ExpiresIn:       int64(ttl.Seconds()),
+		Scope:           scope,
+		IssuedTokenType: model.TokenTypeUriAccessToken,
+	}, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one of the same family.
+// Every refresh token can be used only once, presenting it again revokes the whole family.
+func (s *authService) RotateRefreshToken(ctx context.Context, token string) (string, error) {
+	claims, stored, err := s.verifyRefreshToken(ctx, token)
+
+	if err != nil {
+		return "", err
+	}
+
+	lifetimes, err := s.lifetimesOf(ctx, claims.Role, nil)
+
+	if err != nil {
+		return "", err
+	}
+
+	return s.rotateRefreshToken(ctx, claims, stored, lifetimes)
+}
+
 func (s *authService) VerifyRefreshToken(ctx context.Context, token string) (model.UserClaims, error) {
-	claims, err := utils.VerifyToken(token, []byte(s.jwtConfig.GetRefreshSecret()))
+	claims, _, err := s.verifyRefreshToken(ctx, token)
 
-	if err != nil || claims == nil {
+	if err != nil {
 		return model.UserClaims{}, err
 	}
 
@@ -54,11 +527,506 @@ func (s *authService) VerifyRefreshToken(ctx context.Context, token string) (mod
 }
 
 func (s *authService) VerifyAccessToken(ctx context.Context, token string) (model.UserClaims, error) {
-	claims, err := utils.VerifyToken(token, []byte(s.jwtConfig.GetAccessSecret()))
+	claims, err := utils.VerifyToken(token, s.keyRing, s.accessPolicy)
 
 	if err != nil || claims == nil {
 		return model.UserClaims{}, err
 	}
 
+	err = s.checkRevoked(ctx, claims)
+
+	if err != nil {
+		return model.UserClaims{}, err
+	}
+
 	return *claims, nil
 }
+
+// ConsumeMfaChallenge revokes a challenge once its second factor was accepted,
+// of two logins racing with the same challenge only one gets ahead
+func (s *authService) ConsumeMfaChallenge(ctx context.Context, claims model.UserClaims) error {
+	revoked, err := s.revocationRepo.Revoke(ctx, &model.RevokedToken{
+		Jti:       claims.Id,
+		UserId:    claims.UserId,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if !revoked {
+		return ErrTokenRevoked
+	}
+
+	return nil
+}
+
+// Logout revokes the presented access token and ends its session,
+// the session of the given refresh token is ended as well
+func (s *authService) Logout(ctx context.Context, claims model.UserClaims, refreshToken string) error {
+	_, err := s.revocationRepo.Revoke(ctx, &model.RevokedToken{
+		Jti:       claims.Id,
+		UserId:    claims.UserId,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if claims.FamilyId != "" {
+		err = s.refreshRepo.RevokeFamily(ctx, claims.FamilyId)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if refreshToken == "" {
+		return nil
+	}
+
+	refreshClaims, err := s.VerifyRefreshToken(ctx, refreshToken)
+
+	if err != nil {
+		return err
+	}
+
+	if refreshClaims.UserId != claims.UserId {
+		return errors.New("refresh token belongs to another user")
+	}
+
+	return s.refreshRepo.RevokeFamily(ctx, refreshClaims.FamilyId)
+}
+
+// LogoutAll revokes every access and refresh token issued to the user so far
+func (s *authService) LogoutAll(ctx context.Context, userId int64) error {
+	return s.txManager.ReadCommitted(ctx, func(ctx context.Context) error {
+		// iat_ms is truncated, so only tokens issued within the millisecond of the logout are revoked as well
+		errTx := s.revocationRepo.RevokeUser(ctx, userId, time.Now())
+
+		if errTx != nil {
+			return errTx
+		}
+
+		return s.refreshRepo.RevokeUserFamilies(ctx, userId)
+	})
+}
+
+// LogoutOthers ends every session of the user but the one given, with their refresh and access tokens
+func (s *authService) LogoutOthers(ctx context.Context, userId int64, sessionId string) error {
+	return s.refreshRepo.RevokeOtherFamilies(ctx, userId, sessionId)
+}
+
+// GetJWKS public keys other services use to verify access tokens locally,
+// pending keys are published before they start signing
+func (s *authService) GetJWKS(_ context.Context) model.JWKS {
+	jwks := model.JWKS{Keys: []model.JWK{}}
+
+	for _, key := range s.keyRing.Keys() {
+		if jwk, ok := key.JWK(); ok {
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+
+	return jwks
+}
+
+// GetOpenIDConfiguration discovery document, every endpoint is served under the issuer
+func (s *authService) GetOpenIDConfiguration(ctx context.Context) model.OpenIDConfiguration {
+	algs := []string{}
+
+	for _, jwk := range s.GetJWKS(ctx).Keys {
+		if !slices.Contains(algs, jwk.Alg) {
+			algs = append(algs, jwk.Alg)
+		}
+	}
+
+	// only the HMAC key is configured, id tokens can be checked through introspection only
+	if len(algs) == 0 {
+		algs = append(algs, s.keyRing.IdTokenKey().Method.Alg())
+	}
+
+	return model.OpenIDConfiguration{
+		Issuer:                      s.issuer,
+		AuthorizationEndpoint:       s.issuer + "/oauth/authorize",
+		TokenEndpoint:               s.issuer + "/oauth/token",
+		UserinfoEndpoint:            s.issuer + "/userinfo",
+		JwksUri:                     s.issuer + "/.well-known/jwks.json",
+		IntrospectionEndpoint:       s.issuer + "/oauth/introspect",
+		DeviceAuthorizationEndpoint: s.issuer + "/oauth/device_authorization",
+		ScopesSupported:             []string{"openid", "profile", "email"},
+		ResponseTypesSupported:      []string{"code"},
+		GrantTypesSupported: []string{
+			"authorization_code",
+			"refresh_token",
+			"client_credentials",
+			model.GrantTypeTokenExchange,
+			model.GrantTypeDeviceCode,
+		},
+		SubjectTypesSupported:             []string{"public"},
+		IdTokenSigningAlgValuesSupported:  algs,
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "nonce", "name", "email"},
+	}
+}
+
+// Introspect reports whether the token is active, it has no side effects,
+// so a used refresh token is reported inactive without revoking its family
+func (s *authService) Introspect(ctx context.Context, token string, tokenTypeHint string) model.Introspection {
+	inspectAccess := func() (*model.UserClaims, string, bool) {
+		claims, err := s.VerifyAccessToken(ctx, token)
+		return &claims, "Bearer", err == nil
+	}
+
+	inspectRefresh := func() (*model.UserClaims, string, bool) {
+		claims, stored, err := s.checkRefreshToken(ctx, token)
+		return claims, "Refresh", err == nil && !stored.UsedAt.Valid
+	}
+
+	inspect := []func() (*model.UserClaims, string, bool){inspectAccess, inspectRefresh}
+	if tokenTypeHint == "refresh_token" {
+		inspect[0], inspect[1] = inspect[1], inspect[0]
+	}
+
+	for _, f := range inspect {
+		claims, tokenType, active := f()
+
+		if !active {
+			continue
+		}
+
+		sub := strconv.FormatInt(claims.UserId, 10)
+		if claims.ClientId != "" {
+			sub = claims.ClientId
+		}
+
+		return model.Introspection{
+			Active:    true,
+			Sub:       sub,
+			Username:  claims.UserLogin,
+			Role:      claims.Role,
+			Scope:     claims.Scope,
+			ClientId:  claims.ClientId,
+			TokenType: tokenType,
+			Jti:       claims.Id,
+			Exp:       claims.ExpiresAt,
+			Iat:       claims.IssuedAt,
+			Act:       claims.Act,
+		}
+	}
+
+	return model.Introspection{Active: false}
+}
+
+// lifetimesOf configured lifetimes shortened by the role of the user and the client the token is issued to
+func (s *authService) lifetimesOf(ctx context.Context, role string, client *model.Client) (model.TokenLifetimes, error) {
+	lifetimes := s.lifetimes
+
+	if role != "" {
+		r, err := s.accessRepo.GetRole(ctx, role)
+
+		if err != nil {
+			return model.TokenLifetimes{}, err
+		}
+
+		lifetimes = lifetimes.ForRole(r)
+	}
+
+	if client != nil {
+		lifetimes = lifetimes.ForClient(client)
+	}
+
+	return lifetimes, nil
+}
+
+// verifySubjectToken checks an access token presented for exchange, issued for us or for the client
+func (s *authService) verifySubjectToken(ctx context.Context, client *model.Client, token string) (*model.UserClaims, error) {
+	claims, err := utils.VerifyToken(token, s.keyRing, s.accessPolicy)
+
+	if errors.Is(err, utils.ErrTokenAudience) {
+		policy := s.accessPolicy
+		policy.Audience = client.Id
+
+		claims, err = utils.VerifyToken(token, s.keyRing, policy)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.checkRevoked(ctx, claims)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// narrowScopes requested scopes must be granted to both the subject token and the client,
+// an unscoped user token is limited by the client alone. No scopes means all they share,
+// an exchanged token always carries at least one scope.
+func narrowScopes(subjectScope string, client *model.Client, requested []string) ([]string, error) {
+	allowed := client.Scopes
+
+	if subjectScope != "" {
+		allowed = slices.DeleteFunc(slices.Clone(allowed), func(scope string) bool {
+			return !model.HasScope(subjectScope, scope)
+		})
+	}
+
+	if len(requested) == 0 {
+		requested = allowed
+	}
+
+	if len(requested) == 0 {
+		return nil, ErrInvalidScope
+	}
+
+	for _, scope := range requested {
+		if !slices.Contains(allowed, scope) {
+			return nil, ErrInvalidScope
+		}
+	}
+
+	return requested, nil
+}
+
+func (s *authService) issueAccessToken(claims model.UserJwt, ttl time.Duration) (string, error) {
+	claims.TokenId = uuid.NewString()
+
+	return utils.GenerateToken(claims, s.keyRing.Current(), s.accessPolicy, ttl)
+}
+
+// verifyRefreshToken presenting a used refresh token revokes its family
+func (s *authService) verifyRefreshToken(ctx context.Context, token string) (*model.UserClaims, *model.RefreshToken, error) {
+	claims, stored, err := s.checkRefreshToken(ctx, token)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if stored.UsedAt.Valid {
+		return nil, nil, s.revokeFamily(ctx, stored.FamilyId)
+	}
+
+	return claims, stored, nil
+}
+
+func (s *authService) rotateRefreshToken(
+	ctx context.Context,
+	claims *model.UserClaims,
+	stored *model.RefreshToken,
+	lifetimes model.TokenLifetimes,
+) (string, error) {
+	expiresAt, err := refreshExpiresAt(lifetimes, stored.FamilyExpiresAt)
+
+	if err != nil {
+		return "", err
+	}
+
+	var refreshToken string
+
+	err = s.txManager.ReadCommitted(ctx, func(ctx context.Context) error {
+		used, errTx := s.refreshRepo.MarkUsed(ctx, claims.Id)
+
+		if errTx != nil {
+			return errTx
+		}
+
+		// someone else rotated the token between verification and now
+		if !used {
+			return ErrRefreshTokenReused
+		}
+
+		errTx = s.sessionRepo.Touch(ctx, claims.FamilyId, time.Now())
+
+		if errTx != nil {
+			return errTx
+		}
+
+		refreshToken, errTx = s.issueRefreshToken(ctx, model.UserJwt{
+			UserId:    claims.UserId,
+			UserLogin: claims.UserLogin,
+			Role:      claims.Role,
+			FamilyId:  claims.FamilyId,
+			Scope:     claims.Scope,
+			Azp:       claims.Azp,
+			Amr:       claims.Amr,
+		}, expiresAt)
+
+		return errTx
+	})
+
+	if errors.Is(err, ErrRefreshTokenReused) {
+		// revoke outside the transaction, otherwise the rollback would undo it
+		return "", s.revokeFamily(ctx, claims.FamilyId)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return refreshToken, nil
+}
+
+// refreshExpiresAt a refresh token lives for the idle timeout but never past the end of its session
+func refreshExpiresAt(lifetimes model.TokenLifetimes, sessionExpiresAt sql.NullTime) (time.Time, error) {
+	expiresAt := time.Now().Add(lifetimes.SessionIdle)
+
+	if !sessionExpiresAt.Valid || expiresAt.Before(sessionExpiresAt.Time) {
+		return expiresAt, nil
+	}
+
+	if !time.Now().Before(sessionExpiresAt.Time) {
+		return time.Time{}, ErrSessionExpired
+	}
+
+	return sessionExpiresAt.Time, nil
+}
+
+// checkRefreshToken verifies the signature and the revocation state, the caller decides what to do with used tokens
+func (s *authService) checkRefreshToken(ctx context.Context, token string) (*model.UserClaims, *model.RefreshToken, error) {
+	claims, err := utils.VerifyToken(token, s.refreshKeys, s.refreshPolicy)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = s.checkRevoked(ctx, claims)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stored, err := s.refreshRepo.Get(ctx, claims.Id)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if stored.FamilyRevokedAt.Valid {
+		return nil, nil, ErrRefreshTokenRevoked
+	}
+
+	return claims, stored, nil
+}
+
+func (s *authService) checkRevoked(ctx context.Context, claims *model.UserClaims) error {
+	revoked, err := s.revocationRepo.IsRevoked(ctx, &model.RevokedToken{
+		Jti:       claims.Id,
+		UserId:    claims.UserId,
+		IssuedAt:  claims.IssuedAtTime(),
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+		SessionId: claims.FamilyId,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if revoked {
+		return ErrTokenRevoked
+	}
+
+	return nil
+}
+
+// startFamily creates a token family with its first refresh token and the session of the family,
+// must run inside a transaction
+func (s *authService) startFamily(ctx context.Context, claims model.UserJwt, lifetimes model.TokenLifetimes) (string, string, error) {
+	claims.FamilyId = uuid.NewString()
+	sessionExpiresAt := lifetimes.SessionExpiresAt(time.Now())
+
+	err := s.refreshRepo.CreateFamily(ctx, claims.FamilyId, claims.UserId, sessionExpiresAt)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	device := utils.DeviceFromContext(ctx)
+
+	err = s.sessionRepo.Create(ctx, &model.Session{
+		Id:        claims.FamilyId,
+		UserId:    claims.UserId,
+		ClientId:  sql.NullString{String: claims.Azp, Valid: claims.Azp != ""},
+		UserAgent: device.UserAgent,
+		Ip:        device.Ip,
+	})
+
+	if err != nil {
+		return "", "", err
+	}
+
+	expiresAt, err := refreshExpiresAt(lifetimes, sessionExpiresAt)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	token, err := s.issueRefreshToken(ctx, claims, expiresAt)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	return token, claims.FamilyId, nil
+}
+
+func (s *authService) issueRefreshToken(ctx context.Context, claims model.UserJwt, expiresAt time.Time) (string, error) {
+	claims.TokenId = uuid.NewString()
+
+	token, err := utils.GenerateToken(claims, s.refreshKeys.Current(), s.refreshPolicy, time.Until(expiresAt))
+
+	if err != nil {
+		return "", err
+	}
+
+	err = s.refreshRepo.Create(ctx, &model.RefreshToken{
+		Id:        claims.TokenId,
+		FamilyId:  claims.FamilyId,
+		UserId:    claims.UserId,
+		ExpiresAt: expiresAt,
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// issueIdToken builds the id_token from the current user, so a renamed user gets the new name
+func (s *authService) issueIdToken(ctx context.Context, code *model.AuthorizationCode) (string, error) {
+	user, err := s.userRepo.Get(ctx, code.UserId)
+
+	if err != nil {
+		return "", err
+	}
+
+	info := model.NewOpenIDUserInfo(user, code.Scope)
+
+	return utils.GenerateIdToken(model.IdTokenClaims{
+		StandardClaims: jwt.StandardClaims{
+			Issuer:   s.issuer,
+			Subject:  info.Sub,
+			Audience: code.ClientId,
+		},
+		Nonce: code.Nonce,
+		Name:  info.Name,
+		Email: info.Email,
+	}, s.keyRing.IdTokenKey(), IdTokenExpireTime)
+}
+
+func (s *authService) revokeFamily(ctx context.Context, familyId string) error {
+	err := s.refreshRepo.RevokeFamily(ctx, familyId)
+
+	if err != nil {
+		return err
+	}
+
+	return ErrRefreshTokenReused
+}
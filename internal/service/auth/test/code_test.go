@@ -0,0 +1,162 @@
+package test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/laiker/auth/internal/model"
+	authService "github.com/laiker/auth/internal/service/auth"
+	"github.com/laiker/auth/internal/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	redirectUri  = "https://app.example.com/callback"
+	codeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func authorize(t *testing.T, s interface {
+	CreateAuthorizationCode(context.Context, *model.AuthorizationCode) (string, error)
+}, client *model.Client) string {
+	t.Helper()
+
+	code, err := s.CreateAuthorizationCode(context.Background(), &model.AuthorizationCode{
+		ClientId:      client.Id,
+		UserId:        7,
+		UserLogin:     "alice",
+		Role:          "user",
+		RedirectUri:   redirectUri,
+		Scope:         "openid profile",
+		CodeChallenge: codeChallenge(codeVerifier),
+		Amr:           []string{model.AmrPassword, model.AmrMfa},
+	})
+	if err != nil {
+		t.Fatalf("CreateAuthorizationCode() error = %v", err)
+	}
+
+	return code
+}
+
+func Test_authService_ExchangeAuthorizationCode(t *testing.T) {
+	client := &model.Client{Id: "web", Public: true}
+	other := &model.Client{Id: "other"}
+
+	tests := []struct {
+		name     string
+		client   *model.Client
+		redirect string
+		verifier string
+		expire   bool
+		wantErr  error
+	}{
+		{name: "valid", client: client, redirect: redirectUri, verifier: codeVerifier},
+		{name: "wrong verifier", client: client, redirect: redirectUri, verifier: strings.Repeat("a", 43), wantErr: authService.ErrInvalidGrant},
+		{name: "missing verifier", client: client, redirect: redirectUri, wantErr: authService.ErrInvalidGrant},
+		{name: "other redirect uri", client: client, redirect: "https://evil.example.com/callback", verifier: codeVerifier, wantErr: authService.ErrInvalidGrant},
+		{name: "other client", client: other, redirect: redirectUri, verifier: codeVerifier, wantErr: authService.ErrInvalidGrant},
+		{name: "expired", client: client, redirect: redirectUri, verifier: codeVerifier, expire: true, wantErr: authService.ErrInvalidGrant},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			deps := SetupServiceTest(t)
+			s := newService(deps)
+
+			code := authorize(t, s, client)
+
+			if tt.expire {
+				deps.codes[utils.HashToken(code)].ExpiresAt = time.Now().Add(-time.Second)
+			}
+
+			token, err := s.ExchangeAuthorizationCode(ctx, tt.client, code, tt.redirect, tt.verifier)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ExchangeAuthorizationCode() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr != nil {
+				return
+			}
+
+			if token.RefreshToken == "" || token.Scope != "openid profile" {
+				t.Errorf("ExchangeAuthorizationCode() = %+v", token)
+			}
+
+			claims, err := s.VerifyAccessToken(ctx, token.AccessToken)
+			if err != nil {
+				t.Fatalf("VerifyAccessToken() error = %v", err)
+			}
+
+			if claims.UserId != 7 || claims.Azp != client.Id || claims.ClientId != "" || claims.Scope != "openid profile" ||
+				len(claims.Amr) != 2 || claims.Amr[1] != model.AmrMfa {
+				t.Errorf("claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func Test_authService_ExchangeAuthorizationCode_Replay(t *testing.T) {
+	ctx := context.Background()
+	s := newService(SetupServiceTest(t))
+	client := &model.Client{Id: "web", Public: true}
+
+	code := authorize(t, s, client)
+
+	token, err := s.ExchangeAuthorizationCode(ctx, client, code, redirectUri, codeVerifier)
+	if err != nil {
+		t.Fatalf("ExchangeAuthorizationCode() error = %v", err)
+	}
+
+	if _, err = s.ExchangeAuthorizationCode(ctx, client, code, redirectUri, codeVerifier); !errors.Is(err, authService.ErrInvalidGrant) {
+		t.Fatalf("replay error = %v, want %v", err, authService.ErrInvalidGrant)
+	}
+
+	// the tokens issued by the first exchange are revoked as well
+	if _, err = s.RefreshClientToken(ctx, client, token.RefreshToken); !errors.Is(err, authService.ErrInvalidGrant) {
+		t.Errorf("refresh after replay error = %v, want %v", err, authService.ErrInvalidGrant)
+	}
+}
+
+func Test_authService_RefreshClientToken(t *testing.T) {
+	ctx := context.Background()
+	s := newService(SetupServiceTest(t))
+	client := &model.Client{Id: "web", Public: true}
+
+	token, err := s.ExchangeAuthorizationCode(ctx, client, authorize(t, s, client), redirectUri, codeVerifier)
+	if err != nil {
+		t.Fatalf("ExchangeAuthorizationCode() error = %v", err)
+	}
+
+	// a refresh token can not be redeemed by another client, and the attempt does not burn it
+	if _, err = s.RefreshClientToken(ctx, &model.Client{Id: "other"}, token.RefreshToken); !errors.Is(err, authService.ErrInvalidGrant) {
+		t.Fatalf("RefreshClientToken() by another client error = %v, want %v", err, authService.ErrInvalidGrant)
+	}
+
+	refreshed, err := s.RefreshClientToken(ctx, client, token.RefreshToken)
+	if err != nil {
+		t.Fatalf("RefreshClientToken() error = %v", err)
+	}
+
+	if refreshed.RefreshToken == token.RefreshToken || refreshed.Scope != token.Scope {
+		t.Errorf("RefreshClientToken() = %+v", refreshed)
+	}
+
+	claims, err := s.VerifyAccessToken(ctx, refreshed.AccessToken)
+	if err != nil {
+		t.Fatalf("VerifyAccessToken() error = %v", err)
+	}
+
+	if claims.Azp != client.Id || claims.UserLogin != "alice" {
+		t.Errorf("claims = %+v", claims)
+	}
+}
@@ -0,0 +1,216 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/laiker/auth/internal/model"
+	authService "github.com/laiker/auth/internal/service/auth"
+	"github.com/laiker/auth/internal/utils"
+	"github.com/pkg/errors"
+)
+
+// chat receives user tokens and calls billing on behalf of the user, billing calls the ledger
+var (
+	chatClient    = &model.Client{Id: "chat", Scopes: []string{"billing:read", "billing:write"}, ExchangeAudiences: []string{"billing"}}
+	billingClient = &model.Client{Id: "billing", Scopes: []string{"billing:read"}, ExchangeAudiences: []string{"ledger"}}
+)
+
+// verifyFor claims of a token issued for the audience
+func verifyFor(t *testing.T, token string, audience string) *model.UserClaims {
+	t.Helper()
+
+	claims, err := utils.VerifyToken(token, utils.NewKeyRing(utils.NewHMACKey([]byte(accessSecret))), utils.TokenPolicy{
+		Issuer:   issuer,
+		Audience: audience,
+		Type:     model.TokenTypeAccess,
+	})
+	if err != nil {
+		t.Fatalf("VerifyToken() error = %v", err)
+	}
+
+	return claims
+}
+
+func Test_authService_ExchangeToken(t *testing.T) {
+	ctx := context.Background()
+	s := newService(SetupServiceTest(t))
+
+	subject, err := s.GetAccessToken(ctx, model.UserJwt{UserId: 7, UserLogin: "alice", Role: "user", FamilyId: "family", Amr: []string{model.AmrPassword}})
+	if err != nil {
+		t.Fatalf("GetAccessToken() error = %v", err)
+	}
+
+	token, err := s.ExchangeToken(ctx, chatClient, model.TokenExchange{
+		SubjectToken: subject,
+		Audience:     "billing",
+		Scopes:       []string{"billing:read"},
+	})
+	if err != nil {
+		t.Fatalf("ExchangeToken() error = %v", err)
+	}
+
+	if token.Scope != "billing:read" || token.RefreshToken != "" || token.IssuedTokenType != model.TokenTypeUriAccessToken {
+		t.Fatalf("token %+v", token)
+	}
+
+	claims := verifyFor(t, token.AccessToken, "billing")
+
+	if claims.UserId != 7 || claims.Role != "user" || claims.FamilyId != "family" || claims.Azp != chatClient.Id ||
+		claims.Scope != "billing:read" || len(claims.Amr) != 1 {
+		t.Fatalf("claims %+v", claims)
+	}
+
+	if claims.Act == nil || claims.Act.Subject != chatClient.Id || claims.Act.ClientId != chatClient.Id || claims.Act.Act != nil {
+		t.Fatalf("act %+v does not name the client", claims.Act)
+	}
+
+	if claims.Act.Impersonator() != nil {
+		t.Fatal("a client acting for the user is taken for an impersonator")
+	}
+
+	// the token is for billing, not for us
+	if _, err = s.VerifyAccessToken(ctx, token.AccessToken); err == nil {
+		t.Fatal("an exchanged token was accepted for another audience")
+	}
+
+	// billing passes the token it received further down the chain
+	chained, err := s.ExchangeToken(ctx, billingClient, model.TokenExchange{SubjectToken: token.AccessToken, Audience: "ledger"})
+	if err != nil {
+		t.Fatalf("ExchangeToken() of an exchanged token error = %v", err)
+	}
+
+	claims = verifyFor(t, chained.AccessToken, "ledger")
+
+	if claims.Scope != "billing:read" || claims.Act == nil || claims.Act.Subject != billingClient.Id ||
+		claims.Act.Act == nil || claims.Act.Act.Subject != chatClient.Id {
+		t.Fatalf("claims %+v, act %+v do not keep the delegation chain", claims, claims.Act)
+	}
+}
+
+func Test_authService_ExchangeToken_Denied(t *testing.T) {
+	ctx := context.Background()
+	s := newService(SetupServiceTest(t))
+
+	subject, err := s.GetAccessToken(ctx, model.UserJwt{UserId: 7, Role: "user"})
+	if err != nil {
+		t.Fatalf("GetAccessToken() error = %v", err)
+	}
+
+	scoped, err := s.ExchangeToken(ctx, chatClient, model.TokenExchange{SubjectToken: subject, Audience: "billing", Scopes: []string{"billing:read"}})
+	if err != nil {
+		t.Fatalf("ExchangeToken() error = %v", err)
+	}
+
+	clientToken, err := s.IssueClientToken(ctx, chatClient, nil)
+	if err != nil {
+		t.Fatalf("IssueClientToken() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		client   *model.Client
+		exchange model.TokenExchange
+		wantErr  error
+	}{
+		{
+			name:     "audience outside of the policy",
+			client:   chatClient,
+			exchange: model.TokenExchange{SubjectToken: subject, Audience: "ledger"},
+			wantErr:  authService.ErrInvalidTarget,
+		},
+		{
+			name:     "scope outside of the client",
+			client:   chatClient,
+			exchange: model.TokenExchange{SubjectToken: subject, Audience: "billing", Scopes: []string{"admin"}},
+			wantErr:  authService.ErrInvalidScope,
+		},
+		{
+			name:     "scope wider than the subject token",
+			client:   &model.Client{Id: "billing", Scopes: []string{"billing:read", "billing:write"}, ExchangeAudiences: []string{"ledger"}},
+			exchange: model.TokenExchange{SubjectToken: scoped.AccessToken, Audience: "ledger", Scopes: []string{"billing:write"}},
+			wantErr:  authService.ErrInvalidScope,
+		},
+		{
+			name:     "no scope in common",
+			client:   &model.Client{Id: "chat", ExchangeAudiences: []string{"billing"}},
+			exchange: model.TokenExchange{SubjectToken: subject, Audience: "billing"},
+			wantErr:  authService.ErrInvalidScope,
+		},
+		{
+			name:     "token issued for another service",
+			client:   &model.Client{Id: "payroll", Scopes: []string{"billing:read"}, ExchangeAudiences: []string{"ledger"}},
+			exchange: model.TokenExchange{SubjectToken: scoped.AccessToken, Audience: "ledger"},
+			wantErr:  authService.ErrInvalidGrant,
+		},
+		{
+			name:     "client token",
+			client:   chatClient,
+			exchange: model.TokenExchange{SubjectToken: clientToken.AccessToken, Audience: "billing"},
+			wantErr:  authService.ErrInvalidGrant,
+		},
+		{
+			name:     "malformed token",
+			client:   chatClient,
+			exchange: model.TokenExchange{SubjectToken: "not-a-token", Audience: "billing"},
+			wantErr:  authService.ErrInvalidGrant,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.ExchangeToken(ctx, tt.client, tt.exchange); !errors.Is(err, tt.wantErr) {
+				t.Errorf("ExchangeToken() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_authService_ExchangeToken_Revoked(t *testing.T) {
+	ctx := context.Background()
+	s := newService(SetupServiceTest(t))
+
+	subject, err := s.GetAccessToken(ctx, model.UserJwt{UserId: 7, Role: "user"})
+	if err != nil {
+		t.Fatalf("GetAccessToken() error = %v", err)
+	}
+
+	if err = s.LogoutAll(ctx, 7); err != nil {
+		t.Fatalf("LogoutAll() error = %v", err)
+	}
+
+	_, err = s.ExchangeToken(ctx, chatClient, model.TokenExchange{SubjectToken: subject, Audience: "billing"})
+	if !errors.Is(err, authService.ErrInvalidGrant) {
+		t.Fatalf("ExchangeToken() of a revoked token error = %v, want %v", err, authService.ErrInvalidGrant)
+	}
+}
+
+func Test_authService_ExchangeToken_Impersonated(t *testing.T) {
+	ctx := context.Background()
+	s := newService(SetupServiceTest(t))
+
+	subject, err := s.IssueImpersonationToken(ctx, model.UserJwt{
+		UserId: 7,
+		Role:   "user",
+		Act:    &model.Actor{Subject: "1", UserId: 1, UserLogin: "admin"},
+	})
+	if err != nil {
+		t.Fatalf("IssueImpersonationToken() error = %v", err)
+	}
+
+	token, err := s.ExchangeToken(ctx, chatClient, model.TokenExchange{SubjectToken: subject.AccessToken, Audience: "billing"})
+	if err != nil {
+		t.Fatalf("ExchangeToken() error = %v", err)
+	}
+
+	// the exchanged token can not outlive the subject token
+	if token.ExpiresIn > subject.ExpiresIn {
+		t.Fatalf("exchanged token expires in %d s, the subject token in %d s", token.ExpiresIn, subject.ExpiresIn)
+	}
+
+	claims := verifyFor(t, token.AccessToken, "billing")
+
+	if impersonator := claims.Act.Impersonator(); impersonator == nil || impersonator.UserId != 1 {
+		t.Fatalf("act %+v lost the impersonating admin", claims.Act)
+	}
+}
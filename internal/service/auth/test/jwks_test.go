@@ -0,0 +1,225 @@
+package test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/utils"
+)
+
+// writeKey stores the private key the way openssl genpkey does
+func writeKey(t *testing.T, key interface{}) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey() error = %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "access.pem")
+
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	return path
+}
+
+// publicKey rebuilds the verification key from the JWKS as a third-party service would
+func publicKey(t *testing.T, jwk model.JWK) interface{} {
+	t.Helper()
+
+	decode := func(s string) []byte {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatalf("invalid base64url %q: %v", s, err)
+		}
+		return b
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(decode(jwk.N)),
+			E: int(new(big.Int).SetBytes(decode(jwk.E)).Int64()),
+		}
+	case "OKP":
+		return ed25519.PublicKey(decode(jwk.X))
+	}
+
+	t.Fatalf("unexpected key type %q", jwk.Kty)
+	return nil
+}
+
+func Test_authService_AsymmetricAccessToken(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() error = %v", err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		key  interface{}
+		alg  string
+	}{
+		{name: "rsa", key: rsaKey, alg: "RS256"},
+		{name: "ed25519", key: edKey, alg: "EdDSA"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			key, err := utils.LoadSigningKey(writeKey(t, tt.key))
+			if err != nil {
+				t.Fatalf("LoadSigningKey() error = %v", err)
+			}
+
+			keyRing := utils.NewKeyRing(key, utils.NewHMACKey([]byte(accessSecret)))
+			s := newServiceWithKeys(SetupServiceTest(t), keyRing)
+
+			token, err := s.GetAccessToken(ctx, model.UserJwt{UserId: 1, Role: "user"})
+			if err != nil {
+				t.Fatalf("GetAccessToken() error = %v", err)
+			}
+
+			if _, err = s.VerifyAccessToken(ctx, token); err != nil {
+				t.Fatalf("VerifyAccessToken() error = %v", err)
+			}
+
+			jwks := s.GetJWKS(ctx)
+			if len(jwks.Keys) != 1 {
+				t.Fatalf("GetJWKS() returned %d keys, want 1", len(jwks.Keys))
+			}
+
+			jwk := jwks.Keys[0]
+			if jwk.Kid != key.Id || jwk.Alg != tt.alg {
+				t.Errorf("GetJWKS() = %+v, want kid %q alg %q", jwk, key.Id, tt.alg)
+			}
+
+			parsed, err := jwt.ParseWithClaims(token, &model.UserClaims{}, func(token *jwt.Token) (interface{}, error) {
+				if token.Header["kid"] != jwk.Kid {
+					t.Errorf("token kid = %v, want %q", token.Header["kid"], jwk.Kid)
+				}
+				return publicKey(t, jwk), nil
+			})
+			if err != nil {
+				t.Fatalf("token does not verify against the JWKS: %v", err)
+			}
+
+			if parsed.Method.Alg() != tt.alg {
+				t.Errorf("token alg = %q, want %q", parsed.Method.Alg(), tt.alg)
+			}
+
+			// tokens signed with the old shared secret are still accepted
+			legacy, err := utils.GenerateToken(model.UserJwt{UserId: 1, Role: "user"}, utils.NewHMACKey([]byte(accessSecret)), accessPolicy, time.Hour)
+			if err != nil {
+				t.Fatalf("GenerateToken() error = %v", err)
+			}
+
+			if _, err = s.VerifyAccessToken(ctx, legacy); err != nil {
+				t.Errorf("VerifyAccessToken() of an HMAC token error = %v", err)
+			}
+
+			// the HMAC refresh secret must never validate access tokens
+			forged, err := utils.GenerateToken(model.UserJwt{UserId: 1, Role: "admin"}, utils.NewHMACKey([]byte(refreshSecret)), accessPolicy, time.Hour)
+			if err != nil {
+				t.Fatalf("GenerateToken() error = %v", err)
+			}
+
+			if _, err = s.VerifyAccessToken(ctx, forged); err == nil {
+				t.Errorf("VerifyAccessToken() accepted a token signed with the refresh secret")
+			}
+		})
+	}
+}
+
+func Test_authService_GetJWKS_HMAC(t *testing.T) {
+	s := newService(SetupServiceTest(t))
+
+	if keys := s.GetJWKS(context.Background()).Keys; len(keys) != 0 {
+		t.Errorf("GetJWKS() published %d symmetric keys", len(keys))
+	}
+}
+
+// the HMAC secret keeps signing access tokens until a stored key is promoted,
+// id_tokens move to the published asymmetric key right away and discovery does not offer HS256
+func Test_authService_IdTokenAsymmetric(t *testing.T) {
+	ctx := context.Background()
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey() error = %v", err)
+	}
+
+	pending, err := utils.NewSigningKey(edKey)
+	if err != nil {
+		t.Fatalf("NewSigningKey() error = %v", err)
+	}
+
+	keyRing := utils.NewKeyRing(utils.NewHMACKey([]byte(accessSecret)))
+	keyRing.Load(nil, []*utils.SigningKey{pending})
+	s := newServiceWithKeys(SetupServiceTest(t), keyRing)
+
+	if keyRing.Current().Asymmetric() {
+		t.Fatal("a pending key signs access tokens")
+	}
+
+	if algs := s.GetOpenIDConfiguration(ctx).IdTokenSigningAlgValuesSupported; len(algs) != 1 || algs[0] != "EdDSA" {
+		t.Errorf("id_token_signing_alg_values_supported = %v, want [EdDSA]", algs)
+	}
+
+	client := &model.Client{Id: "grafana"}
+
+	code, err := s.CreateAuthorizationCode(ctx, &model.AuthorizationCode{
+		ClientId:      client.Id,
+		UserId:        7,
+		UserLogin:     "alice",
+		Role:          "user",
+		RedirectUri:   redirectUri,
+		Scope:         "openid",
+		CodeChallenge: codeChallenge(codeVerifier),
+	})
+	if err != nil {
+		t.Fatalf("CreateAuthorizationCode() error = %v", err)
+	}
+
+	token, err := s.ExchangeAuthorizationCode(ctx, client, code, redirectUri, codeVerifier)
+	if err != nil {
+		t.Fatalf("ExchangeAuthorizationCode() error = %v", err)
+	}
+
+	jwk := s.GetJWKS(ctx).Keys[0]
+
+	parsed, err := jwt.ParseWithClaims(token.IdToken, &model.IdTokenClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if token.Header["kid"] != jwk.Kid {
+			t.Errorf("id_token kid = %v, want %q", token.Header["kid"], jwk.Kid)
+		}
+		return publicKey(t, jwk), nil
+	})
+	if err != nil {
+		t.Fatalf("id_token does not verify against the JWKS: %v", err)
+	}
+
+	if parsed.Method.Alg() != "EdDSA" {
+		t.Errorf("id_token alg = %q, want EdDSA", parsed.Method.Alg())
+	}
+}
@@ -0,0 +1,161 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/service"
+	authService "github.com/laiker/auth/internal/service/auth"
+	"github.com/pkg/errors"
+)
+
+var roles = map[string]*model.Role{
+	"admin": {
+		Name:               "admin",
+		AccessTokenTTL:     sql.NullInt64{Int64: 900, Valid: true},
+		SessionIdleTimeout: sql.NullInt64{Int64: 3600, Valid: true},
+		SessionMaxAge:      sql.NullInt64{Int64: 7200, Valid: true},
+	},
+	// overrides can not extend the configured lifetimes
+	"robot": {
+		Name:           "robot",
+		AccessTokenTTL: sql.NullInt64{Int64: int64((48 * time.Hour).Seconds()), Valid: true},
+	},
+}
+
+func newServiceWithRoles(deps *TestDependencies) service.AuthService {
+	deps.roles = roles
+
+	return newService(deps)
+}
+
+// lifetime seconds between iat and exp of a token
+func lifetime(t *testing.T, token string) time.Duration {
+	t.Helper()
+
+	claims := &model.UserClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+		t.Fatalf("ParseUnverified() error = %v", err)
+	}
+
+	return time.Duration(claims.ExpiresAt-claims.IssuedAt) * time.Second
+}
+
+func Test_authService_Lifetimes(t *testing.T) {
+	tests := []struct {
+		name        string
+		role        string
+		wantAccess  time.Duration
+		wantRefresh time.Duration
+	}{
+		{name: "configured", role: "user", wantAccess: 24 * time.Hour, wantRefresh: 30 * 24 * time.Hour},
+		{name: "shortened by the role", role: "admin", wantAccess: 15 * time.Minute, wantRefresh: time.Hour},
+		{name: "not extended by the role", role: "robot", wantAccess: 24 * time.Hour, wantRefresh: 30 * 24 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newServiceWithRoles(SetupServiceTest(t))
+			user := model.UserJwt{UserId: 1, Role: tt.role}
+
+			access, err := s.GetAccessToken(ctx, user)
+			if err != nil {
+				t.Fatalf("GetAccessToken() error = %v", err)
+			}
+
+			if got := lifetime(t, access); got != tt.wantAccess {
+				t.Errorf("access token lifetime = %v, want %v", got, tt.wantAccess)
+			}
+
+			refresh, err := s.GetRefreshToken(ctx, user)
+			if err != nil {
+				t.Fatalf("GetRefreshToken() error = %v", err)
+			}
+
+			if got := lifetime(t, refresh); got != tt.wantRefresh {
+				t.Errorf("refresh token lifetime = %v, want %v", got, tt.wantRefresh)
+			}
+		})
+	}
+}
+
+func Test_authService_ClientLifetime(t *testing.T) {
+	ctx := context.Background()
+	s := newServiceWithRoles(SetupServiceTest(t))
+
+	client := &model.Client{Id: "gateway", Scopes: []string{"users:read"}, AccessTokenTTL: sql.NullInt64{Int64: 60, Valid: true}}
+
+	token, err := s.IssueClientToken(ctx, client, nil)
+	if err != nil {
+		t.Fatalf("IssueClientToken() error = %v", err)
+	}
+
+	if token.ExpiresIn != 60 || lifetime(t, token.AccessToken) != time.Minute {
+		t.Errorf("IssueClientToken() expires_in = %d, lifetime = %v, want 60s", token.ExpiresIn, lifetime(t, token.AccessToken))
+	}
+
+	// the shorter of the role and the client lifetime wins
+	web := &model.Client{Id: "web", Public: true, AccessTokenTTL: sql.NullInt64{Int64: 1800, Valid: true}}
+
+	code, err := s.CreateAuthorizationCode(ctx, &model.AuthorizationCode{
+		ClientId:      web.Id,
+		UserId:        7,
+		Role:          "admin",
+		RedirectUri:   redirectUri,
+		CodeChallenge: codeChallenge(codeVerifier),
+	})
+	if err != nil {
+		t.Fatalf("CreateAuthorizationCode() error = %v", err)
+	}
+
+	token, err = s.ExchangeAuthorizationCode(ctx, web, code, redirectUri, codeVerifier)
+	if err != nil {
+		t.Fatalf("ExchangeAuthorizationCode() error = %v", err)
+	}
+
+	if token.ExpiresIn != 900 {
+		t.Errorf("ExchangeAuthorizationCode() expires_in = %d, want 900", token.ExpiresIn)
+	}
+}
+
+func Test_authService_SessionMaxAge(t *testing.T) {
+	ctx := context.Background()
+	deps := SetupServiceTest(t)
+	s := newServiceWithRoles(deps)
+
+	first, err := s.GetRefreshToken(ctx, model.UserJwt{UserId: 1, Role: "admin"})
+	if err != nil {
+		t.Fatalf("GetRefreshToken() error = %v", err)
+	}
+
+	family := familyOf(t, first)
+	sessionEnd := deps.families[family]
+
+	if !sessionEnd.Valid || sessionEnd.Time.Sub(time.Now()) > 2*time.Hour {
+		t.Fatalf("session end = %v, want in 2 hours", sessionEnd)
+	}
+
+	// close to the end of the session the refresh token lives only until it
+	deps.families[family] = sql.NullTime{Time: time.Now().Add(10 * time.Minute), Valid: true}
+
+	second, err := s.RotateRefreshToken(ctx, first)
+	if err != nil {
+		t.Fatalf("RotateRefreshToken() error = %v", err)
+	}
+
+	if got := lifetime(t, second); got > 10*time.Minute {
+		t.Errorf("refresh token lifetime = %v, want at most 10m", got)
+	}
+
+	// past the end of the session refresh fails even if the token is still valid
+	deps.families[family] = sql.NullTime{Time: time.Now().Add(-time.Second), Valid: true}
+
+	if _, err = s.RotateRefreshToken(ctx, second); !errors.Is(err, authService.ErrSessionExpired) {
+		t.Errorf("RotateRefreshToken() error = %v, want %v", err, authService.ErrSessionExpired)
+	}
+}
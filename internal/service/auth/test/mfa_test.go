@@ -0,0 +1,76 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/laiker/auth/internal/model"
+	authService "github.com/laiker/auth/internal/service/auth"
+	"github.com/laiker/auth/internal/utils"
+	"github.com/pkg/errors"
+)
+
+func Test_authService_MfaChallenge(t *testing.T) {
+	ctx := context.Background()
+	s := newService(SetupServiceTest(t))
+	user := model.UserJwt{UserId: 7, UserLogin: "alice", Role: "user"}
+
+	challenge, err := s.IssueMfaChallenge(ctx, user)
+	if err != nil {
+		t.Fatalf("IssueMfaChallenge() error = %v", err)
+	}
+
+	claims, err := s.VerifyMfaChallenge(ctx, challenge)
+	if err != nil {
+		t.Fatalf("VerifyMfaChallenge() error = %v", err)
+	}
+
+	if claims.UserId != user.UserId || claims.UserLogin != user.UserLogin || claims.Role != user.Role {
+		t.Errorf("VerifyMfaChallenge() = %+v, want the user of the password step", claims)
+	}
+
+	// the challenge skips the second factor, so it must not work as any other token
+	if _, err = s.VerifyAccessToken(ctx, challenge); err == nil {
+		t.Errorf("VerifyAccessToken() accepted a challenge")
+	}
+
+	if _, err = s.VerifyRefreshToken(ctx, challenge); !errors.Is(err, utils.ErrTokenType) {
+		t.Errorf("VerifyRefreshToken() of a challenge error = %v, want %v", err, utils.ErrTokenType)
+	}
+
+	accessToken, err := s.GetAccessToken(ctx, user)
+	if err != nil {
+		t.Fatalf("GetAccessToken() error = %v", err)
+	}
+
+	if _, err = s.VerifyMfaChallenge(ctx, accessToken); err == nil {
+		t.Errorf("VerifyMfaChallenge() accepted an access token")
+	}
+}
+
+func Test_authService_ConsumeMfaChallenge(t *testing.T) {
+	ctx := context.Background()
+	s := newService(SetupServiceTest(t))
+
+	challenge, err := s.IssueMfaChallenge(ctx, model.UserJwt{UserId: 7, UserLogin: "alice", Role: "user"})
+	if err != nil {
+		t.Fatalf("IssueMfaChallenge() error = %v", err)
+	}
+
+	claims, err := s.VerifyMfaChallenge(ctx, challenge)
+	if err != nil {
+		t.Fatalf("VerifyMfaChallenge() error = %v", err)
+	}
+
+	if err = s.ConsumeMfaChallenge(ctx, claims); err != nil {
+		t.Fatalf("ConsumeMfaChallenge() error = %v", err)
+	}
+
+	if err = s.ConsumeMfaChallenge(ctx, claims); !errors.Is(err, authService.ErrTokenRevoked) {
+		t.Errorf("ConsumeMfaChallenge() twice error = %v, want %v", err, authService.ErrTokenRevoked)
+	}
+
+	if _, err = s.VerifyMfaChallenge(ctx, challenge); !errors.Is(err, authService.ErrTokenRevoked) {
+		t.Errorf("VerifyMfaChallenge() of a consumed challenge error = %v, want %v", err, authService.ErrTokenRevoked)
+	}
+}
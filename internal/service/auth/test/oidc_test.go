@@ -0,0 +1,88 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/laiker/auth/internal/model"
+)
+
+func Test_authService_IdToken(t *testing.T) {
+	tests := []struct {
+		name      string
+		scope     string
+		wantToken bool
+		wantName  string
+		wantEmail string
+	}{
+		{name: "no openid scope", scope: "profile"},
+		{name: "openid only", scope: "openid", wantToken: true},
+		{name: "profile and email", scope: "openid profile email", wantToken: true, wantName: "alice", wantEmail: "alice@example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newService(SetupServiceTest(t))
+			client := &model.Client{Id: "grafana"}
+
+			code, err := s.CreateAuthorizationCode(ctx, &model.AuthorizationCode{
+				ClientId:      client.Id,
+				UserId:        7,
+				UserLogin:     "alice",
+				Role:          "user",
+				RedirectUri:   redirectUri,
+				Scope:         tt.scope,
+				CodeChallenge: codeChallenge(codeVerifier),
+				Nonce:         "n-0S6_WzA2Mj",
+			})
+			if err != nil {
+				t.Fatalf("CreateAuthorizationCode() error = %v", err)
+			}
+
+			token, err := s.ExchangeAuthorizationCode(ctx, client, code, redirectUri, codeVerifier)
+			if err != nil {
+				t.Fatalf("ExchangeAuthorizationCode() error = %v", err)
+			}
+
+			if (token.IdToken != "") != tt.wantToken {
+				t.Fatalf("id_token = %q, want issued %v", token.IdToken, tt.wantToken)
+			}
+
+			if !tt.wantToken {
+				return
+			}
+
+			claims := &model.IdTokenClaims{}
+			_, err = jwt.ParseWithClaims(token.IdToken, claims, func(*jwt.Token) (interface{}, error) {
+				return []byte(accessSecret), nil
+			})
+			if err != nil {
+				t.Fatalf("invalid id_token: %v", err)
+			}
+
+			if claims.Issuer != issuer || claims.Subject != "7" || !claims.VerifyAudience(client.Id, true) || claims.Nonce != "n-0S6_WzA2Mj" {
+				t.Errorf("claims = %+v", claims)
+			}
+
+			if claims.Name != tt.wantName || claims.Email != tt.wantEmail {
+				t.Errorf("name, email = %q, %q, want %q, %q", claims.Name, claims.Email, tt.wantName, tt.wantEmail)
+			}
+		})
+	}
+}
+
+func Test_authService_GetOpenIDConfiguration(t *testing.T) {
+	s := newService(SetupServiceTest(t))
+
+	got := s.GetOpenIDConfiguration(context.Background())
+
+	if got.Issuer != issuer || got.JwksUri != issuer+"/.well-known/jwks.json" || got.UserinfoEndpoint != issuer+"/userinfo" {
+		t.Errorf("GetOpenIDConfiguration() = %+v", got)
+	}
+
+	if len(got.IdTokenSigningAlgValuesSupported) != 1 || got.IdTokenSigningAlgValuesSupported[0] != "HS256" {
+		t.Errorf("id_token_signing_alg_values_supported = %v, want [HS256]", got.IdTokenSigningAlgValuesSupported)
+	}
+}
@@ -0,0 +1,805 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/laiker/auth/client/db"
+	"github.com/laiker/auth/internal/config"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+	"github.com/laiker/auth/internal/service"
+	authService "github.com/laiker/auth/internal/service/auth"
+	"github.com/laiker/auth/internal/utils"
+	. "github.com/ovechkin-dm/mockio/mock"
+	"github.com/pkg/errors"
+)
+
+var accessPolicy = utils.TokenPolicy{Issuer: issuer, Audience: issuer, Type: model.TokenTypeAccess}
+
+const (
+	accessSecret  = "access-secret"
+	refreshSecret = "refresh-secret"
+	issuer        = "https://auth.example.com"
+)
+
+// TestDependencies mocks of the auth service dependencies. The repository mocks answer from the
+// in-memory tables below, so tokens issued by the service can be rotated, revoked and verified.
+type TestDependencies struct {
+	jwtConfigMock      config.JwtConfig
+	refreshRepoMock    repository.RefreshTokenRepository
+	revocationRepoMock repository.RevocationRepository
+	codeRepoMock       repository.AuthorizationCodeRepository
+	userRepoMock       repository.UserRepository
+	accessRepoMock     repository.AccessRepository
+	sessionRepoMock    repository.SessionRepository
+	txManagerMock      db.TxManager
+	contextMock        context.Context
+
+	mu              sync.Mutex
+	refreshTokens   map[string]*model.RefreshToken
+	families        map[string]sql.NullTime
+	revokedFamilies map[string]bool
+	revokedTokens   map[string]bool
+	revokedUsers    map[int64]time.Time
+	codes           map[string]*model.AuthorizationCode
+	sessions        map[string]*model.Session
+	// roles with their lifetime overrides, unknown roles come back empty like the Postgres ones
+	roles map[string]*model.Role
+	// revokedSessions revocations see the revoked families, as the Postgres repository does
+	revokedSessions bool
+}
+
+func SetupServiceTest(t *testing.T) *TestDependencies {
+	t.Helper()
+
+	SetUp(t)
+
+	deps := &TestDependencies{
+		jwtConfigMock:      Mock[config.JwtConfig](),
+		refreshRepoMock:    Mock[repository.RefreshTokenRepository](),
+		revocationRepoMock: Mock[repository.RevocationRepository](),
+		codeRepoMock:       Mock[repository.AuthorizationCodeRepository](),
+		userRepoMock:       Mock[repository.UserRepository](),
+		accessRepoMock:     Mock[repository.AccessRepository](),
+		sessionRepoMock:    Mock[repository.SessionRepository](),
+		txManagerMock:      Mock[db.TxManager](),
+		contextMock:        context.Background(),
+
+		refreshTokens:   map[string]*model.RefreshToken{},
+		families:        map[string]sql.NullTime{},
+		revokedFamilies: map[string]bool{},
+		revokedTokens:   map[string]bool{},
+		revokedUsers:    map[int64]time.Time{},
+		codes:           map[string]*model.AuthorizationCode{},
+		sessions:        map[string]*model.Session{},
+		roles:           map[string]*model.Role{},
+	}
+
+	WhenSingle(deps.jwtConfigMock.GetAccessSecret()).ThenReturn(accessSecret)
+	WhenSingle(deps.jwtConfigMock.GetRefreshSecret()).ThenReturn(refreshSecret)
+	WhenSingle(deps.jwtConfigMock.GetAccessKeyFile()).ThenReturn("")
+	WhenSingle(deps.jwtConfigMock.GetKeyEncryptionKey()).ThenReturn(nil)
+	WhenSingle(deps.jwtConfigMock.GetKeyAlgorithm()).ThenReturn("EdDSA")
+	WhenSingle(deps.jwtConfigMock.GetKeyRotationInterval()).ThenReturn(time.Duration(0))
+	WhenSingle(deps.jwtConfigMock.GetIssuer()).ThenReturn(issuer)
+	WhenSingle(deps.jwtConfigMock.GetAudience()).ThenReturn(issuer)
+	WhenSingle(deps.jwtConfigMock.GetClockSkew()).ThenReturn(30 * time.Second)
+	WhenSingle(deps.jwtConfigMock.GetAccessTokenTTL()).ThenReturn(24 * time.Hour)
+	WhenSingle(deps.jwtConfigMock.GetSessionIdleTimeout()).ThenReturn(30 * 24 * time.Hour)
+	WhenSingle(deps.jwtConfigMock.GetSessionMaxAge()).ThenReturn(time.Duration(0))
+
+	When(deps.txManagerMock.ReadCommitted(AnyContext(), Any[db.Handler]())).
+		ThenAnswer(func(args []any) []any {
+			fn := args[1].(db.Handler)
+			return []any{fn(args[0].(context.Context))}
+		})
+
+	When(deps.userRepoMock.Get(AnyContext(), Exact(int64(7)))).
+		ThenReturn(&model.User{Id: 7, Name: "alice", Email: "alice@example.com", Role: "user"}, nil)
+	When(deps.userRepoMock.Get(AnyContext(), Any[int64]())).
+		ThenReturn(nil, errors.New("user not found"))
+
+	When(deps.accessRepoMock.GetRole(AnyContext(), AnyString())).
+		ThenAnswer(func(args []any) []any {
+			if role, ok := deps.roles[args[1].(string)]; ok {
+				return []any{role, nil}
+			}
+
+			return []any{&model.Role{}, nil}
+		})
+
+	deps.setupRefreshRepo()
+	deps.setupRevocationRepo()
+	deps.setupCodeRepo()
+	deps.setupSessionRepo()
+
+	return deps
+}
+
+func (d *TestDependencies) setupRefreshRepo() {
+	When(d.refreshRepoMock.CreateFamily(AnyContext(), AnyString(), Any[int64](), Any[sql.NullTime]())).
+		ThenAnswer(func(args []any) []any {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+
+			d.families[args[1].(string)] = args[3].(sql.NullTime)
+
+			return []any{nil}
+		})
+
+	When(d.refreshRepoMock.Create(AnyContext(), Any[*model.RefreshToken]())).
+		ThenAnswer(func(args []any) []any {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+
+			token := *args[1].(*model.RefreshToken)
+			if _, ok := d.families[token.FamilyId]; !ok {
+				return []any{errors.New("family not found")}
+			}
+
+			d.refreshTokens[token.Id] = &token
+
+			return []any{nil}
+		})
+
+	When(d.refreshRepoMock.Get(AnyContext(), AnyString())).
+		ThenAnswer(func(args []any) []any {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+
+			token, ok := d.refreshTokens[args[1].(string)]
+			if !ok {
+				return []any{nil, errors.New("refresh token not found")}
+			}
+
+			stored := *token
+			stored.FamilyExpiresAt = d.families[token.FamilyId]
+
+			if d.revokedFamilies[token.FamilyId] {
+				stored.FamilyRevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+			}
+
+			return []any{&stored, nil}
+		})
+
+	When(d.refreshRepoMock.MarkUsed(AnyContext(), AnyString())).
+		ThenAnswer(func(args []any) []any {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+
+			token, ok := d.refreshTokens[args[1].(string)]
+			if !ok || token.UsedAt.Valid {
+				return []any{false, nil}
+			}
+
+			token.UsedAt = sql.NullTime{Time: time.Now(), Valid: true}
+
+			return []any{true, nil}
+		})
+
+	When(d.refreshRepoMock.RevokeFamily(AnyContext(), AnyString())).
+		ThenAnswer(func(args []any) []any {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+
+			d.revokedFamilies[args[1].(string)] = true
+
+			return []any{nil}
+		})
+
+	When(d.refreshRepoMock.RevokeUserFamilies(AnyContext(), Any[int64]())).
+		ThenAnswer(func(args []any) []any {
+			d.revokeFamilies(args[1].(int64), "")
+			return []any{nil}
+		})
+
+	When(d.refreshRepoMock.RevokeOtherFamilies(AnyContext(), Any[int64](), AnyString())).
+		ThenAnswer(func(args []any) []any {
+			d.revokeFamilies(args[1].(int64), args[2].(string))
+			return []any{nil}
+		})
+}
+
+// revokeFamilies every family of the user but keepFamilyId
+func (d *TestDependencies) revokeFamilies(userId int64, keepFamilyId string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, token := range d.refreshTokens {
+		if token.UserId == userId && token.FamilyId != keepFamilyId {
+			d.revokedFamilies[token.FamilyId] = true
+		}
+	}
+}
+
+func (d *TestDependencies) setupRevocationRepo() {
+	When(d.revocationRepoMock.Revoke(AnyContext(), Any[*model.RevokedToken]())).
+		ThenAnswer(func(args []any) []any {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+
+			token := args[1].(*model.RevokedToken)
+			if d.revokedTokens[token.Jti] {
+				return []any{false, nil}
+			}
+
+			d.revokedTokens[token.Jti] = true
+
+			return []any{true, nil}
+		})
+
+	When(d.revocationRepoMock.RevokeUser(AnyContext(), Any[int64](), Any[time.Time]())).
+		ThenAnswer(func(args []any) []any {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+
+			d.revokedUsers[args[1].(int64)] = args[2].(time.Time)
+
+			return []any{nil}
+		})
+
+	When(d.revocationRepoMock.IsRevoked(AnyContext(), Any[*model.RevokedToken]())).
+		ThenAnswer(func(args []any) []any {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+
+			token := args[1].(*model.RevokedToken)
+			before, ok := d.revokedUsers[token.UserId]
+
+			if d.revokedSessions && d.revokedFamilies[token.SessionId] {
+				return []any{true, nil}
+			}
+
+			return []any{d.revokedTokens[token.Jti] || ok && before.After(token.IssuedAt), nil}
+		})
+}
+
+func (d *TestDependencies) setupCodeRepo() {
+	When(d.codeRepoMock.Create(AnyContext(), Any[*model.AuthorizationCode]())).
+		ThenAnswer(func(args []any) []any {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+
+			code := *args[1].(*model.AuthorizationCode)
+			d.codes[code.CodeHash] = &code
+
+			return []any{nil}
+		})
+
+	When(d.codeRepoMock.Get(AnyContext(), AnyString())).
+		ThenAnswer(func(args []any) []any {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+
+			code, ok := d.codes[args[1].(string)]
+			if !ok {
+				return []any{nil, errors.New("authorization code not found")}
+			}
+
+			stored := *code
+
+			return []any{&stored, nil}
+		})
+
+	When(d.codeRepoMock.MarkUsed(AnyContext(), AnyString())).
+		ThenAnswer(func(args []any) []any {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+
+			code, ok := d.codes[args[1].(string)]
+			if !ok || code.UsedAt.Valid {
+				return []any{false, nil}
+			}
+
+			code.UsedAt = sql.NullTime{Time: time.Now(), Valid: true}
+
+			return []any{true, nil}
+		})
+
+	When(d.codeRepoMock.SetFamily(AnyContext(), AnyString(), AnyString())).
+		ThenAnswer(func(args []any) []any {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+
+			d.codes[args[1].(string)].FamilyId = sql.NullString{String: args[2].(string), Valid: true}
+
+			return []any{nil}
+		})
+}
+
+func (d *TestDependencies) setupSessionRepo() {
+	When(d.sessionRepoMock.Create(AnyContext(), Any[*model.Session]())).
+		ThenAnswer(func(args []any) []any {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+
+			session := *args[1].(*model.Session)
+			session.CreatedAt = time.Now()
+			session.LastSeenAt = session.CreatedAt
+			d.sessions[session.Id] = &session
+
+			return []any{nil}
+		})
+
+	When(d.sessionRepoMock.Get(AnyContext(), AnyString())).
+		ThenAnswer(func(args []any) []any {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+
+			session, ok := d.sessions[args[1].(string)]
+			if !ok {
+				return []any{nil, errors.New("session not found")}
+			}
+
+			stored := *session
+
+			return []any{&stored, nil}
+		})
+
+	When(d.sessionRepoMock.List(AnyContext(), Any[int64]())).
+		ThenAnswer(func(args []any) []any {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+
+			var sessions []*model.Session
+
+			for _, session := range d.sessions {
+				if session.UserId == args[1].(int64) {
+					stored := *session
+					sessions = append(sessions, &stored)
+				}
+			}
+
+			return []any{sessions, nil}
+		})
+
+	When(d.sessionRepoMock.Touch(AnyContext(), AnyString(), Any[time.Time]())).
+		ThenAnswer(func(args []any) []any {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+
+			if session, ok := d.sessions[args[1].(string)]; ok {
+				session.LastSeenAt = args[2].(time.Time)
+			}
+
+			return []any{nil}
+		})
+}
+
+// newService signs access tokens with the HMAC secret
+func newService(deps *TestDependencies) service.AuthService {
+	return newServiceWithKeys(deps, utils.NewKeyRing(utils.NewHMACKey([]byte(accessSecret))))
+}
+
+func newServiceWithKeys(deps *TestDependencies, keyRing *utils.KeyRing) service.AuthService {
+	return authService.NewService(
+		deps.jwtConfigMock,
+		keyRing,
+		deps.refreshRepoMock,
+		deps.revocationRepoMock,
+		deps.codeRepoMock,
+		deps.userRepoMock,
+		deps.accessRepoMock,
+		deps.sessionRepoMock,
+		deps.txManagerMock,
+	)
+}
+
+func familyOf(t *testing.T, token string) string {
+	t.Helper()
+
+	claims, err := utils.VerifyToken(token, utils.NewKeyRing(utils.NewHMACKey([]byte(refreshSecret))), utils.TokenPolicy{
+		Issuer:   issuer,
+		Audience: issuer,
+		Type:     model.TokenTypeRefresh,
+	})
+	if err != nil {
+		t.Fatalf("invalid refresh token: %v", err)
+	}
+
+	return claims.FamilyId
+}
+
+func Test_authService_RotateRefreshToken(t *testing.T) {
+	ctx := context.Background()
+	deps := SetupServiceTest(t)
+	s := newService(deps)
+
+	first, err := s.GetRefreshToken(ctx, model.UserJwt{UserId: 1, Role: "user"})
+	if err != nil {
+		t.Fatalf("GetRefreshToken() error = %v", err)
+	}
+
+	second, err := s.RotateRefreshToken(ctx, first)
+	if err != nil {
+		t.Fatalf("RotateRefreshToken() error = %v", err)
+	}
+
+	if second == first {
+		t.Fatalf("RotateRefreshToken() returned the same token")
+	}
+
+	if familyOf(t, first) != familyOf(t, second) {
+		t.Errorf("RotateRefreshToken() started a new family")
+	}
+
+	if _, err = s.RotateRefreshToken(ctx, second); err != nil {
+		t.Errorf("rotating the newest token error = %v", err)
+	}
+
+	// the family is not revoked on a legitimate rotation
+	Verify(deps.refreshRepoMock, Never()).RevokeFamily(AnyContext(), AnyString())
+}
+
+func Test_authService_RotateRefreshToken_Replay(t *testing.T) {
+	ctx := context.Background()
+	deps := SetupServiceTest(t)
+	s := newService(deps)
+
+	stolen, err := s.GetRefreshToken(ctx, model.UserJwt{UserId: 1, Role: "user"})
+	if err != nil {
+		t.Fatalf("GetRefreshToken() error = %v", err)
+	}
+
+	family := familyOf(t, stolen)
+
+	// the legitimate client rotates first
+	current, err := s.RotateRefreshToken(ctx, stolen)
+	if err != nil {
+		t.Fatalf("RotateRefreshToken() error = %v", err)
+	}
+
+	// the attacker replays the already used token
+	if _, err = s.RotateRefreshToken(ctx, stolen); !errors.Is(err, authService.ErrRefreshTokenReused) {
+		t.Fatalf("replay error = %v, want %v", err, authService.ErrRefreshTokenReused)
+	}
+
+	Verify(deps.refreshRepoMock, Once()).RevokeFamily(AnyContext(), Exact(family))
+
+	// the whole family is dead, including the token the legitimate client holds
+	if _, err = s.RotateRefreshToken(ctx, current); !errors.Is(err, authService.ErrRefreshTokenRevoked) {
+		t.Errorf("rotation after revocation error = %v, want %v", err, authService.ErrRefreshTokenRevoked)
+	}
+
+	if _, err = s.VerifyRefreshToken(ctx, current); !errors.Is(err, authService.ErrRefreshTokenRevoked) {
+		t.Errorf("verification after revocation error = %v, want %v", err, authService.ErrRefreshTokenRevoked)
+	}
+
+	// other families of the same user are not affected
+	other, err := s.GetRefreshToken(ctx, model.UserJwt{UserId: 1, Role: "user"})
+	if err != nil {
+		t.Fatalf("GetRefreshToken() error = %v", err)
+	}
+
+	if _, err = s.RotateRefreshToken(ctx, other); err != nil {
+		t.Errorf("rotation of another family error = %v", err)
+	}
+}
+
+func Test_authService_RotateRefreshToken_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	deps := SetupServiceTest(t)
+	s := newService(deps)
+
+	token, err := s.GetRefreshToken(ctx, model.UserJwt{UserId: 1, Role: "user"})
+	if err != nil {
+		t.Fatalf("GetRefreshToken() error = %v", err)
+	}
+
+	const workers = 8
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.RotateRefreshToken(ctx, token)
+			errs <- err
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+		}
+	}
+
+	if succeeded > 1 {
+		t.Errorf("token rotated %d times, want at most 1", succeeded)
+	}
+}
+
+func Test_authService_Logout(t *testing.T) {
+	ctx := context.Background()
+	s := newService(SetupServiceTest(t))
+	user := model.UserJwt{UserId: 1, Role: "user"}
+
+	access, err := s.GetAccessToken(ctx, user)
+	if err != nil {
+		t.Fatalf("GetAccessToken() error = %v", err)
+	}
+
+	refresh, err := s.GetRefreshToken(ctx, user)
+	if err != nil {
+		t.Fatalf("GetRefreshToken() error = %v", err)
+	}
+
+	other, err := s.GetAccessToken(ctx, user)
+	if err != nil {
+		t.Fatalf("GetAccessToken() error = %v", err)
+	}
+
+	claims, err := s.VerifyAccessToken(ctx, access)
+	if err != nil {
+		t.Fatalf("VerifyAccessToken() error = %v", err)
+	}
+
+	if err = s.Logout(ctx, claims, refresh); err != nil {
+		t.Fatalf("Logout() error = %v", err)
+	}
+
+	if _, err = s.VerifyAccessToken(ctx, access); !errors.Is(err, authService.ErrTokenRevoked) {
+		t.Errorf("access token after logout error = %v, want %v", err, authService.ErrTokenRevoked)
+	}
+
+	if _, err = s.RotateRefreshToken(ctx, refresh); !errors.Is(err, authService.ErrRefreshTokenRevoked) {
+		t.Errorf("refresh token after logout error = %v, want %v", err, authService.ErrRefreshTokenRevoked)
+	}
+
+	// only the presented access token is revoked
+	if _, err = s.VerifyAccessToken(ctx, other); err != nil {
+		t.Errorf("other access token error = %v", err)
+	}
+}
+
+func Test_authService_LogoutAll(t *testing.T) {
+	ctx := context.Background()
+	s := newService(SetupServiceTest(t))
+	user := model.UserJwt{UserId: 1, Role: "user"}
+
+	access, err := s.GetAccessToken(ctx, user)
+	if err != nil {
+		t.Fatalf("GetAccessToken() error = %v", err)
+	}
+
+	refresh, err := s.GetRefreshToken(ctx, user)
+	if err != nil {
+		t.Fatalf("GetRefreshToken() error = %v", err)
+	}
+
+	stranger, err := s.GetAccessToken(ctx, model.UserJwt{UserId: 2, Role: "user"})
+	if err != nil {
+		t.Fatalf("GetAccessToken() error = %v", err)
+	}
+
+	if err = s.LogoutAll(ctx, user.UserId); err != nil {
+		t.Fatalf("LogoutAll() error = %v", err)
+	}
+
+	if _, err = s.VerifyAccessToken(ctx, access); !errors.Is(err, authService.ErrTokenRevoked) {
+		t.Errorf("access token after logout error = %v, want %v", err, authService.ErrTokenRevoked)
+	}
+
+	if _, err = s.VerifyRefreshToken(ctx, refresh); !errors.Is(err, authService.ErrTokenRevoked) {
+		t.Errorf("refresh token after logout error = %v, want %v", err, authService.ErrTokenRevoked)
+	}
+
+	if _, err = s.VerifyAccessToken(ctx, stranger); err != nil {
+		t.Errorf("token of another user error = %v", err)
+	}
+
+	// a login right after the logout is not caught by it, even within the same second
+	time.Sleep(time.Millisecond)
+
+	access, err = s.GetAccessToken(ctx, user)
+	if err != nil {
+		t.Fatalf("GetAccessToken() error = %v", err)
+	}
+
+	if _, err = s.VerifyAccessToken(ctx, access); err != nil {
+		t.Errorf("access token issued after the logout error = %v", err)
+	}
+}
+
+func Test_authService_IssueImpersonationToken(t *testing.T) {
+	ctx := context.Background()
+	s := newService(SetupServiceTest(t))
+
+	token, err := s.IssueImpersonationToken(ctx, model.UserJwt{
+		UserId: 7,
+		Role:   "user",
+		Act:    &model.Actor{Subject: "1", UserId: 1, UserLogin: "admin"},
+	})
+	if err != nil {
+		t.Fatalf("IssueImpersonationToken() error = %v", err)
+	}
+
+	// the configured access token lifetime is a day
+	if token.ExpiresIn != int64(authService.ImpersonationTokenTTL.Seconds()) || token.RefreshToken != "" {
+		t.Fatalf("token expires in %d s with refresh token %q", token.ExpiresIn, token.RefreshToken)
+	}
+
+	claims, err := s.VerifyAccessToken(ctx, token.AccessToken)
+	if err != nil {
+		t.Fatalf("VerifyAccessToken() error = %v", err)
+	}
+
+	if claims.UserId != 7 || claims.Act == nil || claims.Act.Subject != "1" || claims.Act.UserId != 1 {
+		t.Fatalf("claims %+v, act %+v", claims, claims.Act)
+	}
+
+	if introspection := s.Introspect(ctx, token.AccessToken, ""); introspection.Act == nil || introspection.Act.Subject != "1" {
+		t.Fatalf("introspection %+v does not name the actor", introspection)
+	}
+
+	if _, err = s.IssueImpersonationToken(ctx, model.UserJwt{UserId: 7, Role: "user"}); err == nil {
+		t.Fatal("a token without an actor was issued")
+	}
+}
+
+func Test_authService_LogoutOthers(t *testing.T) {
+	ctx := context.Background()
+	s := newService(SetupServiceTest(t))
+	user := model.UserJwt{UserId: 1, Role: "user"}
+
+	current, err := s.GetRefreshToken(ctx, user)
+	if err != nil {
+		t.Fatalf("GetRefreshToken() error = %v", err)
+	}
+
+	other, err := s.GetRefreshToken(ctx, user)
+	if err != nil {
+		t.Fatalf("GetRefreshToken() error = %v", err)
+	}
+
+	if err = s.LogoutOthers(ctx, user.UserId, familyOf(t, current)); err != nil {
+		t.Fatalf("LogoutOthers() error = %v", err)
+	}
+
+	if _, err = s.VerifyRefreshToken(ctx, current); err != nil {
+		t.Errorf("refresh token of the current session error = %v", err)
+	}
+
+	if _, err = s.VerifyRefreshToken(ctx, other); !errors.Is(err, authService.ErrRefreshTokenRevoked) {
+		t.Errorf("refresh token of another session error = %v, want %v", err, authService.ErrRefreshTokenRevoked)
+	}
+}
+
+func Test_authService_Introspect(t *testing.T) {
+	ctx := context.Background()
+	deps := SetupServiceTest(t)
+	s := newService(deps)
+	user := model.UserJwt{UserId: 42, UserLogin: "alice", Role: "user"}
+
+	access, err := s.GetAccessToken(ctx, user)
+	if err != nil {
+		t.Fatalf("GetAccessToken() error = %v", err)
+	}
+
+	refresh, err := s.GetRefreshToken(ctx, user)
+	if err != nil {
+		t.Fatalf("GetRefreshToken() error = %v", err)
+	}
+
+	got := s.Introspect(ctx, access, "")
+	if !got.Active || got.Sub != "42" || got.Username != "alice" || got.Role != "user" || got.TokenType != "Bearer" || got.Exp == 0 {
+		t.Errorf("Introspect(access) = %+v", got)
+	}
+
+	got = s.Introspect(ctx, refresh, "refresh_token")
+	if !got.Active || got.TokenType != "Refresh" {
+		t.Errorf("Introspect(refresh) = %+v", got)
+	}
+
+	if got = s.Introspect(ctx, "garbage", ""); got.Active || got.Sub != "" {
+		t.Errorf("Introspect(garbage) = %+v, want only active=false", got)
+	}
+
+	// introspecting a used refresh token must not trigger the reuse detection
+	if _, err = s.RotateRefreshToken(ctx, refresh); err != nil {
+		t.Fatalf("RotateRefreshToken() error = %v", err)
+	}
+
+	if got = s.Introspect(ctx, refresh, "refresh_token"); got.Active {
+		t.Errorf("Introspect(used refresh) active")
+	}
+
+	// introspection does not revoke the refresh token family
+	Verify(deps.refreshRepoMock, Never()).RevokeFamily(AnyContext(), AnyString())
+
+	claims, err := s.VerifyAccessToken(ctx, access)
+	if err != nil {
+		t.Fatalf("VerifyAccessToken() error = %v", err)
+	}
+
+	if err = s.Logout(ctx, claims, ""); err != nil {
+		t.Fatalf("Logout() error = %v", err)
+	}
+
+	if got = s.Introspect(ctx, access, ""); got.Active {
+		t.Errorf("Introspect(revoked access) active")
+	}
+}
+
+func Test_authService_IssueClientToken(t *testing.T) {
+	ctx := context.Background()
+	s := newService(SetupServiceTest(t))
+	client := &model.Client{Id: "worker", Scopes: []string{"users:read", "users:write"}}
+
+	tests := []struct {
+		name      string
+		scopes    []string
+		wantScope string
+		wantErr   error
+	}{
+		{name: "all scopes by default", wantScope: "users:read users:write"},
+		{name: "subset", scopes: []string{"users:read"}, wantScope: "users:read"},
+		{name: "foreign scope", scopes: []string{"users:read", "admin"}, wantErr: authService.ErrInvalidScope},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := s.IssueClientToken(ctx, client, tt.scopes)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("IssueClientToken() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr != nil {
+				return
+			}
+
+			if token.Scope != tt.wantScope || token.RefreshToken != "" || token.ExpiresIn <= 0 {
+				t.Errorf("IssueClientToken() = %+v", token)
+			}
+
+			claims, err := s.VerifyAccessToken(ctx, token.AccessToken)
+			if err != nil {
+				t.Fatalf("VerifyAccessToken() error = %v", err)
+			}
+
+			if claims.ClientId != client.Id || claims.UserId != 0 || claims.Scope != tt.wantScope {
+				t.Errorf("claims = %+v, want client %q with scope %q", claims, client.Id, tt.wantScope)
+			}
+
+			if got := s.Introspect(ctx, token.AccessToken, ""); got.Sub != client.Id || got.ClientId != client.Id {
+				t.Errorf("Introspect() = %+v, want sub %q", got, client.Id)
+			}
+		})
+	}
+}
+
+func Test_authService_TokenTypes(t *testing.T) {
+	ctx := context.Background()
+
+	// the access and refresh secrets are configured identically
+	keyRing := utils.NewKeyRing(utils.NewHMACKey([]byte(refreshSecret)))
+	s := newServiceWithKeys(SetupServiceTest(t), keyRing)
+
+	refreshToken, err := s.GetRefreshToken(ctx, model.UserJwt{UserId: 1, Role: "user"})
+	if err != nil {
+		t.Fatalf("GetRefreshToken() error = %v", err)
+	}
+
+	if _, err = s.VerifyAccessToken(ctx, refreshToken); !errors.Is(err, utils.ErrTokenType) {
+		t.Errorf("VerifyAccessToken() of a refresh token error = %v, want %v", err, utils.ErrTokenType)
+	}
+
+	accessToken, err := s.GetAccessToken(ctx, model.UserJwt{UserId: 1, Role: "user"})
+	if err != nil {
+		t.Fatalf("GetAccessToken() error = %v", err)
+	}
+
+	if _, err = s.VerifyRefreshToken(ctx, accessToken); !errors.Is(err, utils.ErrTokenType) {
+		t.Errorf("VerifyRefreshToken() of an access token error = %v, want %v", err, utils.ErrTokenType)
+	}
+}
@@ -0,0 +1,179 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+	"github.com/laiker/auth/internal/service"
+	authService "github.com/laiker/auth/internal/service/auth"
+	"github.com/laiker/auth/internal/utils"
+	"github.com/pkg/errors"
+)
+
+// newServiceWithSessions revocations see the revoked families, as the Postgres repository does
+func newServiceWithSessions(deps *TestDependencies) service.AuthService {
+	deps.revokedSessions = true
+
+	return newService(deps)
+}
+
+func Test_authService_StartSession(t *testing.T) {
+	device := model.Device{UserAgent: "Mozilla/5.0", Ip: "203.0.113.7"}
+	ctx := utils.WithDevice(context.Background(), device)
+	deps := SetupServiceTest(t)
+	s := newServiceWithSessions(deps)
+
+	token, err := s.StartSession(ctx, model.UserJwt{UserId: 7, Role: "user"})
+	if err != nil {
+		t.Fatalf("StartSession() error = %v", err)
+	}
+
+	claims, err := s.VerifyAccessToken(ctx, token.AccessToken)
+	if err != nil {
+		t.Fatalf("VerifyAccessToken() error = %v", err)
+	}
+
+	familyId := familyOf(t, token.RefreshToken)
+	if claims.FamilyId != familyId {
+		t.Errorf("access token fid = %q, want the refresh token family %q", claims.FamilyId, familyId)
+	}
+
+	session, err := deps.sessionRepoMock.Get(ctx, familyId)
+	if err != nil {
+		t.Fatalf("session of the login was not created: %v", err)
+	}
+
+	if session.UserId != 7 || session.UserAgent != device.UserAgent || session.Ip != device.Ip || session.ClientId.Valid {
+		t.Errorf("session = %+v, want user 7 on %+v without a client", session, device)
+	}
+}
+
+func Test_authService_SessionLastSeen(t *testing.T) {
+	ctx := context.Background()
+	deps := SetupServiceTest(t)
+	s := newServiceWithSessions(deps)
+
+	token, err := s.StartSession(ctx, model.UserJwt{UserId: 7, Role: "user"})
+	if err != nil {
+		t.Fatalf("StartSession() error = %v", err)
+	}
+
+	familyId := familyOf(t, token.RefreshToken)
+	loggedInAt := time.Now().Add(-time.Hour)
+
+	if err = deps.sessionRepoMock.Touch(ctx, familyId, loggedInAt); err != nil {
+		t.Fatalf("Touch() error = %v", err)
+	}
+
+	if _, err = s.RotateRefreshToken(ctx, token.RefreshToken); err != nil {
+		t.Fatalf("RotateRefreshToken() error = %v", err)
+	}
+
+	session, err := deps.sessionRepoMock.Get(ctx, familyId)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+
+	if !session.LastSeenAt.After(loggedInAt) {
+		t.Errorf("LastSeenAt = %v, want it updated by the refresh", session.LastSeenAt)
+	}
+}
+
+func Test_authService_RevokedSession(t *testing.T) {
+	tests := []struct {
+		name   string
+		revoke func(ctx context.Context, s service.AuthService, repo repository.RefreshTokenRepository, token *model.OAuthToken) error
+	}{
+		{
+			name: "revoked family",
+			revoke: func(ctx context.Context, _ service.AuthService, repo repository.RefreshTokenRepository, token *model.OAuthToken) error {
+				return repo.RevokeFamily(ctx, familyOf(t, token.RefreshToken))
+			},
+		},
+		{
+			name: "logout without the refresh token",
+			revoke: func(ctx context.Context, s service.AuthService, _ repository.RefreshTokenRepository, token *model.OAuthToken) error {
+				claims, err := s.VerifyAccessToken(ctx, token.AccessToken)
+				if err != nil {
+					return err
+				}
+
+				return s.Logout(ctx, claims, "")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			deps := SetupServiceTest(t)
+			s := newServiceWithSessions(deps)
+
+			token, err := s.StartSession(ctx, model.UserJwt{UserId: 7, Role: "user"})
+			if err != nil {
+				t.Fatalf("StartSession() error = %v", err)
+			}
+
+			// a second access token of the same session
+			claims, err := s.VerifyRefreshToken(ctx, token.RefreshToken)
+			if err != nil {
+				t.Fatalf("VerifyRefreshToken() error = %v", err)
+			}
+
+			renewed, err := s.GetAccessToken(ctx, model.UserJwt{UserId: claims.UserId, Role: claims.Role, FamilyId: claims.FamilyId})
+			if err != nil {
+				t.Fatalf("GetAccessToken() error = %v", err)
+			}
+
+			if err = tt.revoke(ctx, s, deps.refreshRepoMock, token); err != nil {
+				t.Fatalf("revoke error = %v", err)
+			}
+
+			for _, accessToken := range []string{token.AccessToken, renewed} {
+				if _, err = s.VerifyAccessToken(ctx, accessToken); !errors.Is(err, authService.ErrTokenRevoked) {
+					t.Errorf("VerifyAccessToken() error = %v, want %v", err, authService.ErrTokenRevoked)
+				}
+			}
+
+			if _, err = s.RotateRefreshToken(ctx, token.RefreshToken); err == nil {
+				t.Errorf("RotateRefreshToken() of a revoked session succeeded")
+			}
+		})
+	}
+}
+
+func Test_authService_AmrKeptOnRefresh(t *testing.T) {
+	ctx := context.Background()
+	s := newServiceWithSessions(SetupServiceTest(t))
+
+	token, err := s.StartSession(ctx, model.UserJwt{UserId: 7, Role: "admin", Amr: []string{model.AmrPasskey}})
+	if err != nil {
+		t.Fatalf("StartSession() error = %v", err)
+	}
+
+	claims, err := s.VerifyAccessToken(ctx, token.AccessToken)
+	if err != nil {
+		t.Fatalf("VerifyAccessToken() error = %v", err)
+	}
+
+	if len(claims.Amr) != 1 || claims.Amr[0] != model.AmrPasskey {
+		t.Errorf("access token amr = %v, want [%s]", claims.Amr, model.AmrPasskey)
+	}
+
+	rotated, err := s.RotateRefreshToken(ctx, token.RefreshToken)
+	if err != nil {
+		t.Fatalf("RotateRefreshToken() error = %v", err)
+	}
+
+	claims, err = s.VerifyRefreshToken(ctx, rotated)
+	if err != nil {
+		t.Fatalf("VerifyRefreshToken() error = %v", err)
+	}
+
+	if len(claims.Amr) != 1 || claims.Amr[0] != model.AmrPasskey {
+		t.Errorf("rotated refresh token amr = %v, want [%s]", claims.Amr, model.AmrPasskey)
+	}
+}
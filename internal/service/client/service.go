@@ -0,0 +1,212 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+	"github.com/laiker/auth/internal/service"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidClient  = errors.New("invalid client credentials")
+	ErrMalformedScope = errors.New("scope must be a non-empty string without spaces")
+	ErrRedirectUri    = errors.New("redirect uri must be an absolute uri without fragment")
+	ErrAccessTokenTTL = errors.New("access token ttl must be at least a second")
+	ErrAudience       = errors.New("exchange audience must be a non-empty string without spaces")
+)
+
+type clientService struct {
+	clientRepo repository.ClientRepository
+}
+
+func NewService(clientRepo repository.ClientRepository) service.ClientService {
+	return &clientService{
+		clientRepo: clientRepo,
+	}
+}
+
+// Create registers a client and returns its secret, the secret is shown only once.
+// Public clients get no secret.
+func (s *clientService) Create(ctx context.Context, info *model.ClientInfo) (*model.Client, string, error) {
+	scopes, err := normalize(info.Scopes, ErrMalformedScope)
+	if err != nil {
+		return nil, "", err
+	}
+
+	audiences, err := normalize(info.ExchangeAudiences, ErrAudience)
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = validateRedirectUris(info.RedirectUris)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var accessTokenTTL sql.NullInt64
+	if info.AccessTokenTTL != 0 {
+		accessTokenTTL = sql.NullInt64{Int64: int64(info.AccessTokenTTL / time.Second), Valid: true}
+	}
+
+	err = validateAccessTokenTTL(accessTokenTTL)
+	if err != nil {
+		return nil, "", err
+	}
+
+	raw := make([]byte, 32)
+
+	_, err = rand.Read(raw)
+	if err != nil {
+		return nil, "", err
+	}
+
+	secret := base64.RawURLEncoding.EncodeToString(raw)
+
+	client := &model.Client{
+		Id:                uuid.NewString(),
+		Name:              info.Name,
+		Team:              info.Team,
+		Scopes:            scopes,
+		RedirectUris:      info.RedirectUris,
+		Public:            info.Public,
+		SecretHash:        hashSecret(secret),
+		AccessTokenTTL:    accessTokenTTL,
+		ExchangeAudiences: audiences,
+	}
+
+	err = s.clientRepo.Create(ctx, client)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	if client.Public {
+		return client, "", nil
+	}
+
+	return client, secret, nil
+}
+
+func (s *clientService) Get(ctx context.Context, id string) (*model.Client, error) {
+	return s.clientRepo.Get(ctx, id)
+}
+
+func (s *clientService) List(ctx context.Context, team string) ([]*model.Client, error) {
+	return s.clientRepo.List(ctx, team)
+}
+
+// Update changes the name, team, scopes, exchange audiences and token lifetime,
+// tokens already issued are kept until they expire
+func (s *clientService) Update(ctx context.Context, client *model.Client) error {
+	scopes, err := normalize(client.Scopes, ErrMalformedScope)
+	if err != nil {
+		return err
+	}
+
+	client.Scopes = scopes
+
+	client.ExchangeAudiences, err = normalize(client.ExchangeAudiences, ErrAudience)
+	if err != nil {
+		return err
+	}
+
+	err = validateRedirectUris(client.RedirectUris)
+	if err != nil {
+		return err
+	}
+
+	err = validateAccessTokenTTL(client.AccessTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.clientRepo.Update(ctx, client)
+}
+
+func (s *clientService) Delete(ctx context.Context, id string) error {
+	return s.clientRepo.Delete(ctx, id)
+}
+
+// Identify looks up a public client, they have no secret to authenticate with
+func (s *clientService) Identify(ctx context.Context, clientId string) (*model.Client, error) {
+	client, err := s.clientRepo.Get(ctx, clientId)
+
+	if err != nil || !client.Public {
+		return nil, ErrInvalidClient
+	}
+
+	return client, nil
+}
+
+func (s *clientService) Authenticate(ctx context.Context, clientId string, secret string) (*model.Client, error) {
+	client, err := s.clientRepo.Get(ctx, clientId)
+
+	if err != nil {
+		return nil, ErrInvalidClient
+	}
+
+	if client.Public || subtle.ConstantTimeCompare([]byte(client.SecretHash), []byte(hashSecret(secret))) != 1 {
+		return nil, ErrInvalidClient
+	}
+
+	return client, nil
+}
+
+// hashSecret secrets are 256 random bits, so a plain hash is enough and keeps
+// authentication cheap for callers that introspect on every request
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// normalize drops duplicates of scopes or audiences, they are passed space separated so they can not contain one
+func normalize(values []string, errMalformed error) ([]string, error) {
+	normalized := make([]string, 0, len(values))
+
+	for _, value := range values {
+		if value == "" || strings.ContainsAny(value, " \t\n") {
+			return nil, errMalformed
+		}
+
+		if !slices.Contains(normalized, value) {
+			normalized = append(normalized, value)
+		}
+	}
+
+	return normalized, nil
+}
+
+// validateRedirectUris redirect uris are compared exactly, custom schemes are allowed for mobile apps
+func validateRedirectUris(uris []string) error {
+	for _, uri := range uris {
+		parsed, err := url.Parse(uri)
+
+		if err != nil || !parsed.IsAbs() || parsed.Fragment != "" || strings.Contains(uri, "#") {
+			return ErrRedirectUri
+		}
+	}
+
+	return nil
+}
+
+func validateAccessTokenTTL(ttl sql.NullInt64) error {
+	if ttl.Valid && ttl.Int64 < 1 {
+		return ErrAccessTokenTTL
+	}
+
+	return nil
+}
@@ -0,0 +1,292 @@
+package device
+
+import (
+	"context"
+	"crypto/rand"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/laiker/auth/client/db"
+	"github.com/laiker/auth/internal/config"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+	"github.com/laiker/auth/internal/service"
+	"github.com/laiker/auth/internal/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	// userCodeAlphabet consonants only, so codes do not spell words and are easy to type (RFC 8628 6.1)
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	// userCodeLen about 34 bits, shown to the user as XXXX-XXXX
+	userCodeLen = 8
+	// slowDownStep added to the interval of a device polling too fast (RFC 8628 3.5)
+	slowDownStep = 5 * time.Second
+)
+
+// Token endpoint errors of RFC 8628 3.5
+var (
+	ErrAuthorizationPending = errors.New("the user has not approved the device yet")
+	ErrSlowDown             = errors.New("the device polls too fast")
+	ErrAccessDenied         = errors.New("the user denied the device")
+	ErrExpiredToken         = errors.New("the device code expired")
+	ErrInvalidGrant         = errors.New("invalid device code")
+)
+
+var (
+	ErrInvalidScope    = errors.New("requested scope is not allowed for the client")
+	ErrInvalidUserCode = errors.New("the code is invalid or expired")
+)
+
+type deviceService struct {
+	codeTTL         time.Duration
+	pollInterval    time.Duration
+	verificationUri string
+	deviceRepo      repository.DeviceCodeRepository
+	userRepo        repository.UserRepository
+	authService     service.AuthService
+	txManager       db.TxManager
+	now             func() time.Time
+}
+
+// NewService the verification page is served under the issuer, next to the other OAuth endpoints
+func NewService(
+	config config.DeviceConfig,
+	jwtConfig config.JwtConfig,
+	deviceRepo repository.DeviceCodeRepository,
+	userRepo repository.UserRepository,
+	authService service.AuthService,
+	txManager db.TxManager,
+	now func() time.Time,
+) service.DeviceService {
+	return &deviceService{
+		codeTTL:         config.GetCodeTTL(),
+		pollInterval:    config.GetPollInterval(),
+		verificationUri: jwtConfig.GetIssuer() + "/oauth/device",
+		deviceRepo:      deviceRepo,
+		userRepo:        userRepo,
+		authService:     authService,
+		txManager:       txManager,
+		now:             now,
+	}
+}
+
+// Authorize starts the flow for a device of the client, the device shows the user code and polls with the device code.
+// Like the authorization code flow, no scopes means a token with the full rights of the user.
+func (s *deviceService) Authorize(ctx context.Context, client *model.Client, scopes []string) (*model.DeviceAuthorization, error) {
+	if !client.AllowsScopes(scopes) {
+		return nil, ErrInvalidScope
+	}
+
+	deviceCode, err := utils.RandomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	userCode, err := newUserCode()
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+
+	err = s.deviceRepo.Create(ctx, &model.DeviceCode{
+		DeviceCodeHash: utils.HashToken(deviceCode),
+		UserCodeHash:   utils.HashToken(userCode),
+		ClientId:       client.Id,
+		Scope:          strings.Join(scopes, " "),
+		PollInterval:   int64(s.pollInterval / time.Second),
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(s.codeTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	shown := userCode[:userCodeLen/2] + "-" + userCode[userCodeLen/2:]
+
+	return &model.DeviceAuthorization{
+		DeviceCode:              deviceCode,
+		UserCode:                shown,
+		VerificationUri:         s.verificationUri,
+		VerificationUriComplete: s.verificationUri + "?user_code=" + url.QueryEscape(shown),
+		ExpiresIn:               int64(s.codeTTL / time.Second),
+		Interval:                int64(s.pollInterval / time.Second),
+	}, nil
+}
+
+// Lookup the request the user code belongs to, while it awaits the user
+func (s *deviceService) Lookup(ctx context.Context, userCode string) (*model.DeviceCode, error) {
+	hash, ok := hashUserCode(userCode)
+	if !ok {
+		return nil, ErrInvalidUserCode
+	}
+
+	code, err := s.deviceRepo.GetPending(ctx, hash, s.now())
+	if errors.Is(err, repository.ErrDeviceCodeNotFound) {
+		return nil, ErrInvalidUserCode
+	}
+
+	return code, err
+}
+
+// Approve lets the device of the user code sign in as the user, amr are the methods the user just signed in with
+func (s *deviceService) Approve(ctx context.Context, userCode string, userId int64, amr []string) error {
+	hash, ok := hashUserCode(userCode)
+	if !ok {
+		return ErrInvalidUserCode
+	}
+
+	approved, err := s.deviceRepo.Approve(ctx, hash, userId, amr, s.now())
+	if err != nil {
+		return err
+	}
+
+	if !approved {
+		return ErrInvalidUserCode
+	}
+
+	return nil
+}
+
+func (s *deviceService) Deny(ctx context.Context, userCode string) error {
+	hash, ok := hashUserCode(userCode)
+	if !ok {
+		return ErrInvalidUserCode
+	}
+
+	denied, err := s.deviceRepo.Deny(ctx, hash, s.now())
+	if err != nil {
+		return err
+	}
+
+	if !denied {
+		return ErrInvalidUserCode
+	}
+
+	return nil
+}
+
+// Poll device_code grant, tokens are issued once the user approved the device and only once.
+// A device polling before its interval passed gets ErrSlowDown and a longer interval.
+func (s *deviceService) Poll(ctx context.Context, client *model.Client, deviceCode string) (*model.OAuthToken, error) {
+	hash := utils.HashToken(deviceCode)
+
+	code, err := s.deviceRepo.Get(ctx, hash)
+	if errors.Is(err, repository.ErrDeviceCodeNotFound) {
+		return nil, ErrInvalidGrant
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if code.ClientId != client.Id || code.UsedAt.Valid {
+		return nil, ErrInvalidGrant
+	}
+
+	now := s.now()
+
+	if !now.Before(code.ExpiresAt) {
+		return nil, ErrExpiredToken
+	}
+
+	if code.DeniedAt.Valid {
+		return nil, ErrAccessDenied
+	}
+
+	interval := code.PollInterval
+	tooFast := code.LastPolledAt.Valid && now.Sub(code.LastPolledAt.Time) < time.Duration(interval)*time.Second
+
+	if tooFast {
+		interval += int64(slowDownStep / time.Second)
+	}
+
+	err = s.deviceRepo.Poll(ctx, hash, interval, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if tooFast {
+		return nil, ErrSlowDown
+	}
+
+	if !code.ApprovedAt.Valid {
+		return nil, ErrAuthorizationPending
+	}
+
+	user, err := s.userRepo.Get(ctx, code.UserId.Int64)
+	if err != nil {
+		return nil, err
+	}
+
+	var token *model.OAuthToken
+
+	err = s.txManager.ReadCommitted(ctx, func(ctx context.Context) error {
+		used, errTx := s.deviceRepo.Use(ctx, hash, now)
+		if errTx != nil {
+			return errTx
+		}
+
+		if !used {
+			return ErrInvalidGrant
+		}
+
+		token, errTx = s.authService.StartSession(ctx, model.UserJwt{
+			UserId:    user.Id,
+			UserLogin: user.Name,
+			Role:      user.Role,
+			Scope:     code.Scope,
+			Azp:       client.Id,
+			Amr:       code.Amr,
+		})
+
+		return errTx
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	token.Scope = code.Scope
+
+	return token, nil
+}
+
+func newUserCode() (string, error) {
+	code := make([]byte, userCodeLen)
+	alphabetLen := big.NewInt(int64(len(userCodeAlphabet)))
+
+	for i := range code {
+		n, err := rand.Int(rand.Reader, alphabetLen)
+		if err != nil {
+			return "", err
+		}
+
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+
+	return string(code), nil
+}
+
+// hashUserCode users may type the code in lower case, with or without the dash
+func hashUserCode(userCode string) (string, bool) {
+	var normalized strings.Builder
+
+	for _, r := range strings.ToUpper(userCode) {
+		switch {
+		case strings.ContainsRune(userCodeAlphabet, r):
+			normalized.WriteRune(r)
+		case r == '-' || r == ' ':
+		default:
+			return "", false
+		}
+	}
+
+	if normalized.Len() != userCodeLen {
+		return "", false
+	}
+
+	return utils.HashToken(normalized.String()), true
+}
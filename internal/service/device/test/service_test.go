@@ -0,0 +1,391 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/laiker/auth/client/db"
+	"github.com/laiker/auth/internal/config"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+	"github.com/laiker/auth/internal/service"
+	deviceService "github.com/laiker/auth/internal/service/device"
+	"github.com/pkg/errors"
+)
+
+const issuer = "https://auth.example.com"
+
+var (
+	cliClient   = &model.Client{Id: "cli", Name: "CLI", Scopes: []string{"repo:read", "repo:write"}}
+	otherClient = &model.Client{Id: "tv", Name: "TV"}
+)
+
+type deviceConfig struct{}
+
+func (deviceConfig) GetCodeTTL() time.Duration      { return 10 * time.Minute }
+func (deviceConfig) GetPollInterval() time.Duration { return 5 * time.Second }
+
+// jwtConfig only the issuer is used by the service
+type jwtConfig struct {
+	config.JwtConfig
+}
+
+func (jwtConfig) GetIssuer() string { return issuer }
+
+type txManager struct{}
+
+func (txManager) ReadCommitted(ctx context.Context, f db.Handler) error {
+	return f(ctx)
+}
+
+// clock fake time device codes expire by
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time { return c.now }
+
+func (c *clock) Add(d time.Duration) { c.now = c.now.Add(d) }
+
+// userRepo serves a single user, the rest of repository.UserRepository is not used by the service
+type userRepo struct {
+	repository.UserRepository
+	user *model.User
+}
+
+func (r *userRepo) Get(_ context.Context, id int64) (*model.User, error) {
+	if id != r.user.Id {
+		return nil, errors.New("Пользователь не найден")
+	}
+
+	return r.user, nil
+}
+
+// authService records the sessions started for devices
+type authService struct {
+	service.AuthService
+	sessions []model.UserJwt
+}
+
+func (s *authService) StartSession(_ context.Context, claims model.UserJwt) (*model.OAuthToken, error) {
+	s.sessions = append(s.sessions, claims)
+
+	return &model.OAuthToken{AccessToken: "access", RefreshToken: "refresh", TokenType: "Bearer"}, nil
+}
+
+// deviceRepo in-memory repository.DeviceCodeRepository
+type deviceRepo struct {
+	mu    sync.Mutex
+	codes map[string]*model.DeviceCode
+}
+
+func (r *deviceRepo) Create(_ context.Context, code *model.DeviceCode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := *code
+	r.codes[code.DeviceCodeHash] = &stored
+
+	return nil
+}
+
+func (r *deviceRepo) Get(_ context.Context, deviceCodeHash string) (*model.DeviceCode, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	code, ok := r.codes[deviceCodeHash]
+	if !ok {
+		return nil, repository.ErrDeviceCodeNotFound
+	}
+
+	found := *code
+
+	return &found, nil
+}
+
+// pending the code the user was shown, as long as it awaits approval
+func (r *deviceRepo) pending(userCodeHash string, at time.Time) *model.DeviceCode {
+	for _, code := range r.codes {
+		if code.UserCodeHash == userCodeHash && !code.ApprovedAt.Valid && !code.DeniedAt.Valid && at.Before(code.ExpiresAt) {
+			return code
+		}
+	}
+
+	return nil
+}
+
+func (r *deviceRepo) GetPending(_ context.Context, userCodeHash string, at time.Time) (*model.DeviceCode, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	code := r.pending(userCodeHash, at)
+	if code == nil {
+		return nil, repository.ErrDeviceCodeNotFound
+	}
+
+	found := *code
+
+	return &found, nil
+}
+
+func (r *deviceRepo) Approve(_ context.Context, userCodeHash string, userId int64, amr []string, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	code := r.pending(userCodeHash, at)
+	if code == nil {
+		return false, nil
+	}
+
+	code.UserId = sql.NullInt64{Int64: userId, Valid: true}
+	code.Amr = amr
+	code.ApprovedAt = sql.NullTime{Time: at, Valid: true}
+
+	return true, nil
+}
+
+func (r *deviceRepo) Deny(_ context.Context, userCodeHash string, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	code := r.pending(userCodeHash, at)
+	if code == nil {
+		return false, nil
+	}
+
+	code.DeniedAt = sql.NullTime{Time: at, Valid: true}
+
+	return true, nil
+}
+
+func (r *deviceRepo) Poll(_ context.Context, deviceCodeHash string, pollInterval int64, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if code, ok := r.codes[deviceCodeHash]; ok {
+		code.PollInterval = pollInterval
+		code.LastPolledAt = sql.NullTime{Time: at, Valid: true}
+	}
+
+	return nil
+}
+
+func (r *deviceRepo) Use(_ context.Context, deviceCodeHash string, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	code, ok := r.codes[deviceCodeHash]
+	if !ok || code.UsedAt.Valid || !code.ApprovedAt.Valid {
+		return false, nil
+	}
+
+	code.UsedAt = sql.NullTime{Time: at, Valid: true}
+
+	return true, nil
+}
+
+type fixture struct {
+	service service.DeviceService
+	clock   *clock
+	user    *model.User
+	auth    *authService
+}
+
+func newFixture() *fixture {
+	f := &fixture{
+		clock: &clock{now: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)},
+		user:  &model.User{Id: 7, Name: "alice", Email: "alice@example.com", Role: "user"},
+		auth:  &authService{},
+	}
+
+	f.service = deviceService.NewService(
+		deviceConfig{},
+		jwtConfig{},
+		&deviceRepo{codes: map[string]*model.DeviceCode{}},
+		&userRepo{user: f.user},
+		f.auth,
+		txManager{},
+		f.clock.Now,
+	)
+
+	return f
+}
+
+func (f *fixture) authorize(t *testing.T, scopes ...string) *model.DeviceAuthorization {
+	t.Helper()
+
+	authorization, err := f.service.Authorize(context.Background(), cliClient, scopes)
+	if err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+
+	return authorization
+}
+
+// poll waits for the interval the device was told and polls
+func (f *fixture) poll(authorization *model.DeviceAuthorization, interval int64) (*model.OAuthToken, error) {
+	f.clock.Add(time.Duration(interval) * time.Second)
+
+	return f.service.Poll(context.Background(), cliClient, authorization.DeviceCode)
+}
+
+func TestDeviceService_Authorize(t *testing.T) {
+	f := newFixture()
+	authorization := f.authorize(t, "repo:read")
+
+	if authorization.DeviceCode == "" || authorization.ExpiresIn != 600 || authorization.Interval != 5 {
+		t.Fatalf("authorization %+v", authorization)
+	}
+
+	if len(authorization.UserCode) != 9 || authorization.UserCode[4] != '-' {
+		t.Fatalf("user code %q is not shown as XXXX-XXXX", authorization.UserCode)
+	}
+
+	if authorization.VerificationUri != issuer+"/oauth/device" ||
+		authorization.VerificationUriComplete != issuer+"/oauth/device?user_code="+authorization.UserCode {
+		t.Fatalf("verification uris %q, %q", authorization.VerificationUri, authorization.VerificationUriComplete)
+	}
+
+	code, err := f.service.Lookup(context.Background(), authorization.UserCode)
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+
+	if code.ClientId != cliClient.Id || code.Scope != "repo:read" {
+		t.Fatalf("code %+v", code)
+	}
+
+	_, err = f.service.Authorize(context.Background(), cliClient, []string{"admin"})
+	if !errors.Is(err, deviceService.ErrInvalidScope) {
+		t.Fatalf("Authorize() with a scope outside of the client error = %v, want ErrInvalidScope", err)
+	}
+}
+
+func TestDeviceService_Poll(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture()
+	authorization := f.authorize(t, "repo:read")
+
+	if _, err := f.poll(authorization, 0); !errors.Is(err, deviceService.ErrAuthorizationPending) {
+		t.Fatalf("Poll() before approval error = %v, want ErrAuthorizationPending", err)
+	}
+
+	if _, err := f.poll(authorization, 1); !errors.Is(err, deviceService.ErrSlowDown) {
+		t.Fatalf("Poll() too fast error = %v, want ErrSlowDown", err)
+	}
+
+	// the interval grew, the old one is too fast now
+	if _, err := f.poll(authorization, 5); !errors.Is(err, deviceService.ErrSlowDown) {
+		t.Fatalf("Poll() at the old interval error = %v, want ErrSlowDown", err)
+	}
+
+	if _, err := f.poll(authorization, 15); !errors.Is(err, deviceService.ErrAuthorizationPending) {
+		t.Fatalf("Poll() at the new interval error = %v, want ErrAuthorizationPending", err)
+	}
+
+	// users type the code as they like
+	userCode := strings.ToLower(strings.ReplaceAll(authorization.UserCode, "-", ""))
+
+	err := f.service.Approve(ctx, userCode, f.user.Id, []string{model.AmrPassword, model.AmrMfa})
+	if err != nil {
+		t.Fatalf("Approve() error = %v", err)
+	}
+
+	if _, err = f.service.Lookup(ctx, authorization.UserCode); !errors.Is(err, deviceService.ErrInvalidUserCode) {
+		t.Fatalf("Lookup() of an approved code error = %v, want ErrInvalidUserCode", err)
+	}
+
+	token, err := f.poll(authorization, 15)
+	if err != nil {
+		t.Fatalf("Poll() after approval error = %v", err)
+	}
+
+	if token.AccessToken == "" || token.Scope != "repo:read" || len(f.auth.sessions) != 1 {
+		t.Fatalf("token %+v, sessions %d", token, len(f.auth.sessions))
+	}
+
+	claims := f.auth.sessions[0]
+
+	if claims.UserId != f.user.Id || claims.UserLogin != f.user.Name || claims.Role != f.user.Role ||
+		claims.Scope != "repo:read" || claims.Azp != cliClient.Id || len(claims.Amr) != 2 {
+		t.Fatalf("session claims %+v", claims)
+	}
+
+	if _, err = f.poll(authorization, 15); !errors.Is(err, deviceService.ErrInvalidGrant) {
+		t.Fatalf("second Poll() error = %v, want ErrInvalidGrant", err)
+	}
+
+	if len(f.auth.sessions) != 1 {
+		t.Fatal("tokens were issued twice for a device code")
+	}
+}
+
+func TestDeviceService_Denied(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture()
+	authorization := f.authorize(t)
+
+	if err := f.service.Deny(ctx, authorization.UserCode); err != nil {
+		t.Fatalf("Deny() error = %v", err)
+	}
+
+	if err := f.service.Approve(ctx, authorization.UserCode, f.user.Id, []string{model.AmrPassword}); !errors.Is(err, deviceService.ErrInvalidUserCode) {
+		t.Fatalf("Approve() of a denied code error = %v, want ErrInvalidUserCode", err)
+	}
+
+	if _, err := f.poll(authorization, 5); !errors.Is(err, deviceService.ErrAccessDenied) {
+		t.Fatalf("Poll() error = %v, want ErrAccessDenied", err)
+	}
+}
+
+func TestDeviceService_Expired(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture()
+	authorization := f.authorize(t)
+
+	f.clock.Add(10 * time.Minute)
+
+	if err := f.service.Approve(ctx, authorization.UserCode, f.user.Id, []string{model.AmrPassword}); !errors.Is(err, deviceService.ErrInvalidUserCode) {
+		t.Fatalf("Approve() of an expired code error = %v, want ErrInvalidUserCode", err)
+	}
+
+	if _, err := f.poll(authorization, 5); !errors.Is(err, deviceService.ErrExpiredToken) {
+		t.Fatalf("Poll() error = %v, want ErrExpiredToken", err)
+	}
+}
+
+func TestDeviceService_Poll_Invalid(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture()
+	authorization := f.authorize(t)
+
+	if err := f.service.Approve(ctx, authorization.UserCode, f.user.Id, []string{model.AmrPassword}); err != nil {
+		t.Fatalf("Approve() error = %v", err)
+	}
+
+	if _, err := f.service.Poll(ctx, otherClient, authorization.DeviceCode); !errors.Is(err, deviceService.ErrInvalidGrant) {
+		t.Fatalf("Poll() by another client error = %v, want ErrInvalidGrant", err)
+	}
+
+	if _, err := f.service.Poll(ctx, cliClient, "unknown"); !errors.Is(err, deviceService.ErrInvalidGrant) {
+		t.Fatalf("Poll() of an unknown code error = %v, want ErrInvalidGrant", err)
+	}
+
+	if len(f.auth.sessions) != 0 {
+		t.Fatal("tokens were issued for an invalid grant")
+	}
+}
+
+func TestDeviceService_Lookup_Malformed(t *testing.T) {
+	f := newFixture()
+
+	for _, userCode := range []string{"", "BCDF", "BCDF-GHJKL", "AEIO-UBCD", "BCDF_GHJK"} {
+		if _, err := f.service.Lookup(context.Background(), userCode); !errors.Is(err, deviceService.ErrInvalidUserCode) {
+			t.Errorf("Lookup(%q) error = %v, want ErrInvalidUserCode", userCode, err)
+		}
+	}
+}
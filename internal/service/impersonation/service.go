@@ -0,0 +1,92 @@
+package impersonation
+
+import (
+	"context"
+	"strconv"
+
+	log "github.com/laiker/auth/internal/logger"
+	"github.com/laiker/auth/internal/logger/logger"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+	"github.com/laiker/auth/internal/service"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrImpersonationDenied the caller can not impersonate the user, e.g. the role of the user is not lower
+	ErrImpersonationDenied = errors.New("impersonation denied")
+	ErrUserNotFound        = errors.New("user to impersonate not found")
+)
+
+type impersonationService struct {
+	userRepo    repository.UserRepository
+	accessRepo  repository.AccessRepository
+	authService service.AuthService
+	logger      logger.DBLoggerInterface
+}
+
+func NewService(
+	userRepo repository.UserRepository,
+	accessRepo repository.AccessRepository,
+	authService service.AuthService,
+	logger logger.DBLoggerInterface,
+) service.ImpersonationService {
+	return &impersonationService{
+		userRepo:    userRepo,
+		accessRepo:  accessRepo,
+		authService: authService,
+		logger:      logger,
+	}
+}
+
+// Impersonate issues a short-lived access token of the user carrying the actor in the act claim.
+// Only users acting as themselves can impersonate, and only users of a lower role priority.
+// The start is written to the audit log before the token is handed out.
+func (s *impersonationService) Impersonate(ctx context.Context, actor model.UserClaims, userId int64) (*model.OAuthToken, error) {
+	if actor.Type != model.TokenTypeAccess || actor.UserId == 0 || actor.ClientId != "" || actor.Act != nil {
+		return nil, ErrImpersonationDenied
+	}
+
+	user, err := s.userRepo.Get(ctx, userId)
+
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	actorRole, err := s.accessRepo.GetRole(ctx, actor.Role)
+
+	if err != nil {
+		return nil, err
+	}
+
+	userRole, err := s.accessRepo.GetRole(ctx, user.Role)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if userRole.Priority >= actorRole.Priority {
+		return nil, ErrImpersonationDenied
+	}
+
+	err = s.logger.Log(ctx, log.LogData{
+		Name:     "impersonate",
+		EntityID: user.Id,
+		ActorID:  actor.UserId,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return s.authService.IssueImpersonationToken(ctx, model.UserJwt{
+		UserId:    user.Id,
+		UserLogin: user.Name,
+		Role:      user.Role,
+		Act: &model.Actor{
+			Subject:   strconv.FormatInt(actor.UserId, 10),
+			UserId:    actor.UserId,
+			UserLogin: actor.UserLogin,
+		},
+	})
+}
@@ -0,0 +1,166 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	log "github.com/laiker/auth/internal/logger"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+	"github.com/laiker/auth/internal/service"
+	impersonationService "github.com/laiker/auth/internal/service/impersonation"
+	"github.com/pkg/errors"
+)
+
+// userRepo the rest of repository.UserRepository is not used by the service
+type userRepo struct {
+	repository.UserRepository
+	users map[int64]*model.User
+}
+
+func (r *userRepo) Get(_ context.Context, id int64) (*model.User, error) {
+	if user, ok := r.users[id]; ok {
+		return user, nil
+	}
+
+	return nil, errors.New("Пользователь не найден")
+}
+
+// accessRepo unknown roles come back empty like the Postgres one
+type accessRepo struct {
+	repository.AccessRepository
+}
+
+func (accessRepo) GetRole(_ context.Context, role string) (*model.Role, error) {
+	return map[string]*model.Role{
+		"user":    {Id: 1, Name: "user", Priority: 10},
+		"support": {Id: 3, Name: "support", Priority: 50},
+		"admin":   {Id: 2, Name: "admin", Priority: 100},
+	}[role], nil
+}
+
+// authService records the claims of the issued impersonation tokens
+type authService struct {
+	service.AuthService
+	issued []model.UserJwt
+}
+
+func (s *authService) IssueImpersonationToken(_ context.Context, claims model.UserJwt) (*model.OAuthToken, error) {
+	s.issued = append(s.issued, claims)
+
+	return &model.OAuthToken{AccessToken: "impersonation-token", TokenType: "Bearer", ExpiresIn: 900}, nil
+}
+
+type dbLogger struct {
+	entries []log.LogData
+	err     error
+}
+
+func (l *dbLogger) Log(_ context.Context, data log.LogData) error {
+	if l.err != nil {
+		return l.err
+	}
+
+	l.entries = append(l.entries, data)
+
+	return nil
+}
+
+type fixture struct {
+	service service.ImpersonationService
+	auth    *authService
+	logger  *dbLogger
+}
+
+func newFixture() *fixture {
+	f := &fixture{auth: &authService{}, logger: &dbLogger{}}
+
+	f.service = impersonationService.NewService(
+		&userRepo{users: map[int64]*model.User{
+			1: {Id: 1, Name: "root", Role: "admin"},
+			2: {Id: 2, Name: "sam", Role: "support"},
+			7: {Id: 7, Name: "alice", Role: "user"},
+			8: {Id: 8, Name: "ops", Role: "admin"},
+		}},
+		accessRepo{},
+		f.auth,
+		f.logger,
+	)
+
+	return f
+}
+
+func admin() model.UserClaims {
+	return model.UserClaims{Type: model.TokenTypeAccess, UserId: 1, UserLogin: "root", Role: "admin"}
+}
+
+func TestImpersonationService_Impersonate(t *testing.T) {
+	f := newFixture()
+
+	token, err := f.service.Impersonate(context.Background(), admin(), 7)
+	if err != nil {
+		t.Fatalf("Impersonate() error = %v", err)
+	}
+
+	if token.AccessToken != "impersonation-token" || len(f.auth.issued) != 1 {
+		t.Fatalf("token %+v, issued %+v", token, f.auth.issued)
+	}
+
+	claims := f.auth.issued[0]
+	if claims.UserId != 7 || claims.Role != "user" || claims.Act == nil || claims.Act.Subject != "1" ||
+		claims.Act.UserId != 1 || claims.Act.UserLogin != "root" {
+		t.Fatalf("issued claims %+v, act %+v", claims, claims.Act)
+	}
+
+	if len(f.logger.entries) != 1 || f.logger.entries[0].EntityID != 7 || f.logger.entries[0].ActorID != 1 {
+		t.Fatalf("audit entries %+v", f.logger.entries)
+	}
+}
+
+func TestImpersonationService_Denied(t *testing.T) {
+	impersonator := admin()
+	impersonator.Act = &model.Actor{Subject: "8", UserId: 8}
+
+	tests := []struct {
+		name   string
+		actor  model.UserClaims
+		userId int64
+		want   error
+	}{
+		{name: "equal role", actor: admin(), userId: 8, want: impersonationService.ErrImpersonationDenied},
+		{name: "higher role", actor: model.UserClaims{Type: model.TokenTypeAccess, UserId: 2, Role: "support"}, userId: 1, want: impersonationService.ErrImpersonationDenied},
+		{name: "self", actor: admin(), userId: 1, want: impersonationService.ErrImpersonationDenied},
+		{name: "already impersonating", actor: impersonator, userId: 7, want: impersonationService.ErrImpersonationDenied},
+		{name: "api key", actor: model.UserClaims{Type: model.TokenTypeApiKey, UserId: 1, Role: "admin"}, userId: 7, want: impersonationService.ErrImpersonationDenied},
+		{name: "client", actor: model.UserClaims{Type: model.TokenTypeAccess, ClientId: "worker"}, userId: 7, want: impersonationService.ErrImpersonationDenied},
+		{name: "unknown user", actor: admin(), userId: 42, want: impersonationService.ErrUserNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture()
+
+			_, err := f.service.Impersonate(context.Background(), tt.actor, tt.userId)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Impersonate() error = %v, want %v", err, tt.want)
+			}
+
+			if len(f.auth.issued) != 0 || len(f.logger.entries) != 0 {
+				t.Fatal("a refused impersonation issued a token or wrote the audit log")
+			}
+		})
+	}
+}
+
+func TestImpersonationService_AuditFails(t *testing.T) {
+	f := newFixture()
+	f.logger.err = errors.New("connection refused")
+
+	if _, err := f.service.Impersonate(context.Background(), admin(), 7); err == nil {
+		t.Fatal("Impersonate() succeeded without an audit entry")
+	}
+
+	if len(f.auth.issued) != 0 {
+		t.Fatal("a token was issued without an audit entry")
+	}
+}
@@ -0,0 +1,299 @@
+package key
+
+import (
+	"context"
+	"time"
+
+	"github.com/laiker/auth/client/db"
+	"github.com/laiker/auth/internal/config"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+	"github.com/laiker/auth/internal/service"
+	"github.com/laiker/auth/internal/utils"
+	"github.com/pkg/errors"
+)
+
+// PrepublishWindow how long a new key sits in the JWKS before it signs,
+// longer than the JWKS cache so verifiers know the key before the first token arrives
+const PrepublishWindow = 10 * time.Minute
+
+var (
+	ErrKeyRingDisabled  = errors.New("key ring is disabled, no key encryption key configured")
+	ErrRetireCurrentKey = errors.New("current key can not be retired, promote another key first")
+)
+
+type keyService struct {
+	keyRepo   repository.SigningKeyRepository
+	txManager db.TxManager
+	keyRing   *utils.KeyRing
+
+	encryptionKey    []byte
+	algorithm        string
+	rotationInterval time.Duration
+	tokenTTL         time.Duration
+}
+
+// NewService manages the keys of keyRing, retired keys keep verifying for tokenTTL plus the clock skew,
+// tokenTTL is the longest lifetime of a token signed with the keys
+func NewService(
+	config config.JwtConfig,
+	keyRing *utils.KeyRing,
+	keyRepo repository.SigningKeyRepository,
+	txManager db.TxManager,
+	tokenTTL time.Duration,
+) service.KeyService {
+	return &keyService{
+		keyRepo:          keyRepo,
+		txManager:        txManager,
+		keyRing:          keyRing,
+		encryptionKey:    config.GetKeyEncryptionKey(),
+		algorithm:        config.GetKeyAlgorithm(),
+		rotationInterval: config.GetKeyRotationInterval(),
+		tokenTTL:         tokenTTL + config.GetClockSkew(),
+	}
+}
+
+// Generate stores a new pending key, it is published in the JWKS but does not sign until promoted
+func (s *keyService) Generate(ctx context.Context, alg string) (*model.SigningKey, error) {
+	if s.encryptionKey == nil {
+		return nil, ErrKeyRingDisabled
+	}
+
+	if alg == "" {
+		alg = s.algorithm
+	}
+
+	key, err := s.generate(ctx, alg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return key, s.Reload(ctx)
+}
+
+// Promote makes the key the signing one, the previous current key is retired
+func (s *keyService) Promote(ctx context.Context, kid string) error {
+	err := s.txManager.ReadCommitted(ctx, func(ctx context.Context) error {
+		errTx := s.keyRepo.Lock(ctx)
+
+		if errTx != nil {
+			return errTx
+		}
+
+		return s.promote(ctx, kid, time.Now())
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return s.Reload(ctx)
+}
+
+// Retire stops publishing the key once every token it signed has expired
+func (s *keyService) Retire(ctx context.Context, kid string) error {
+	err := s.txManager.ReadCommitted(ctx, func(ctx context.Context) error {
+		errTx := s.keyRepo.Lock(ctx)
+
+		if errTx != nil {
+			return errTx
+		}
+
+		key, errTx := s.keyRepo.Get(ctx, kid)
+
+		if errTx != nil {
+			return errTx
+		}
+
+		if key.Status() == "current" {
+			return ErrRetireCurrentKey
+		}
+
+		return s.keyRepo.Retire(ctx, kid, time.Now())
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return s.Reload(ctx)
+}
+
+func (s *keyService) List(ctx context.Context) ([]*model.SigningKey, error) {
+	return s.keyRepo.List(ctx)
+}
+
+// Reload loads the stored keys into the key ring, retired keys are dropped once their tokens expired
+func (s *keyService) Reload(ctx context.Context) error {
+	stored, err := s.keyRepo.List(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	var (
+		current    *utils.SigningKey
+		currentAt  time.Time
+		keys       []*utils.SigningKey
+		expiration = time.Now().Add(-s.tokenTTL)
+	)
+
+	for _, k := range stored {
+		if k.RetiredAt.Valid && k.RetiredAt.Time.Before(expiration) {
+			continue
+		}
+
+		key, errKey := s.decrypt(k)
+
+		if errKey != nil {
+			return errKey
+		}
+
+		keys = append(keys, key)
+
+		if k.Status() == "current" && k.PromotedAt.Time.After(currentAt) {
+			current, currentAt = key, k.PromotedAt.Time
+		}
+	}
+
+	s.keyRing.Load(current, keys)
+
+	return nil
+}
+
+// Rotate runs one step of the scheduled rotation: a new key is generated PrepublishWindow
+// before the current one is due and promoted once it has been published long enough
+func (s *keyService) Rotate(ctx context.Context) error {
+	if s.rotationInterval == 0 {
+		return s.Reload(ctx)
+	}
+
+	err := s.txManager.ReadCommitted(ctx, func(ctx context.Context) error {
+		errTx := s.keyRepo.Lock(ctx)
+
+		if errTx != nil {
+			return errTx
+		}
+
+		keys, errTx := s.keyRepo.List(ctx)
+
+		if errTx != nil {
+			return errTx
+		}
+
+		var current, pending *model.SigningKey
+
+		for _, k := range keys {
+			switch k.Status() {
+			case "current":
+				if current == nil || k.PromotedAt.Time.After(current.PromotedAt.Time) {
+					current = k
+				}
+			case "pending":
+				if pending == nil || k.CreatedAt.After(pending.CreatedAt) {
+					pending = k
+				}
+			}
+		}
+
+		now := time.Now()
+
+		var due time.Time
+		if current != nil {
+			due = current.PromotedAt.Time.Add(s.rotationInterval)
+		}
+
+		if pending == nil {
+			if now.Before(due.Add(-PrepublishWindow)) {
+				return nil
+			}
+
+			_, errTx = s.generate(ctx, s.algorithm)
+
+			return errTx
+		}
+
+		if now.Before(due) || now.Before(pending.CreatedAt.Add(PrepublishWindow)) {
+			return nil
+		}
+
+		return s.promote(ctx, pending.Id, now)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return s.Reload(ctx)
+}
+
+func (s *keyService) generate(ctx context.Context, alg string) (*model.SigningKey, error) {
+	key, err := utils.GenerateSigningKey(alg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := key.MarshalPrivateKey()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// kid is the additional data, so a ciphertext moved to another row does not decrypt
+	encrypted, err := utils.Encrypt(s.encryptionKey, der, []byte(key.Id))
+
+	if err != nil {
+		return nil, err
+	}
+
+	stored := &model.SigningKey{
+		Id:         key.Id,
+		Algorithm:  alg,
+		PrivateKey: encrypted,
+		CreatedAt:  time.Now(),
+	}
+
+	err = s.keyRepo.Create(ctx, stored)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return stored, nil
+}
+
+func (s *keyService) promote(ctx context.Context, kid string, now time.Time) error {
+	keys, err := s.keyRepo.List(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if k.Id != kid && k.Status() == "current" {
+			err = s.keyRepo.Retire(ctx, k.Id, now)
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return s.keyRepo.Promote(ctx, kid, now)
+}
+
+func (s *keyService) decrypt(stored *model.SigningKey) (*utils.SigningKey, error) {
+	if s.encryptionKey == nil {
+		return nil, ErrKeyRingDisabled
+	}
+
+	der, err := utils.Decrypt(s.encryptionKey, stored.PrivateKey, []byte(stored.Id))
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "signing key %s", stored.Id)
+	}
+
+	return utils.ParseSigningKey(der)
+}
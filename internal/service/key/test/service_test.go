@@ -0,0 +1,326 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/laiker/auth/client/db"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/service"
+	keyService "github.com/laiker/auth/internal/service/key"
+	"github.com/laiker/auth/internal/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	tokenTTL         = 24 * time.Hour
+	rotationInterval = 30 * 24 * time.Hour
+)
+
+var encryptionKey = bytes.Repeat([]byte{7}, 32)
+
+type jwtConfig struct {
+	rotationInterval time.Duration
+}
+
+func (jwtConfig) GetAccessSecret() string                 { return "access-secret" }
+func (jwtConfig) GetRefreshSecret() string                { return "refresh-secret" }
+func (jwtConfig) GetAccessKeyFile() string                { return "" }
+func (jwtConfig) GetKeyEncryptionKey() []byte             { return encryptionKey }
+func (jwtConfig) GetKeyAlgorithm() string                 { return "EdDSA" }
+func (c jwtConfig) GetKeyRotationInterval() time.Duration { return c.rotationInterval }
+func (jwtConfig) GetIssuer() string                       { return "https://auth.example.com" }
+func (jwtConfig) GetAudience() string                     { return "https://auth.example.com" }
+func (jwtConfig) GetClockSkew() time.Duration             { return 0 }
+func (jwtConfig) GetAccessTokenTTL() time.Duration        { return tokenTTL }
+func (jwtConfig) GetSessionIdleTimeout() time.Duration    { return 30 * 24 * time.Hour }
+func (jwtConfig) GetSessionMaxAge() time.Duration         { return 0 }
+
+var policy = utils.TokenPolicy{Issuer: "https://auth.example.com", Audience: "https://auth.example.com", Type: model.TokenTypeAccess}
+
+type txManager struct{}
+
+func (txManager) ReadCommitted(ctx context.Context, f db.Handler) error {
+	return f(ctx)
+}
+
+// keyRepo in-memory repository.SigningKeyRepository
+type keyRepo struct {
+	mu   sync.Mutex
+	keys map[string]*model.SigningKey
+}
+
+func newKeyRepo() *keyRepo {
+	return &keyRepo{keys: map[string]*model.SigningKey{}}
+}
+
+func (r *keyRepo) Create(_ context.Context, key *model.SigningKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := *key
+	r.keys[key.Id] = &stored
+
+	return nil
+}
+
+func (r *keyRepo) Get(_ context.Context, kid string) (*model.SigningKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.keys[kid]
+	if !ok {
+		return nil, errors.New("signing key not found")
+	}
+
+	stored := *key
+
+	return &stored, nil
+}
+
+func (r *keyRepo) List(_ context.Context) ([]*model.SigningKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := make([]*model.SigningKey, 0, len(r.keys))
+	for _, key := range r.keys {
+		stored := *key
+		keys = append(keys, &stored)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+
+	return keys, nil
+}
+
+func (r *keyRepo) Promote(_ context.Context, kid string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.keys[kid]
+	if !ok || key.RetiredAt.Valid {
+		return errors.New("signing key not found")
+	}
+
+	key.PromotedAt = sql.NullTime{Time: at, Valid: true}
+
+	return nil
+}
+
+func (r *keyRepo) Retire(_ context.Context, kid string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.keys[kid]
+	if !ok || key.RetiredAt.Valid {
+		return errors.New("signing key not found")
+	}
+
+	key.RetiredAt = sql.NullTime{Time: at, Valid: true}
+
+	return nil
+}
+
+func (r *keyRepo) Lock(_ context.Context) error {
+	return nil
+}
+
+// backdate moves the key timestamps into the past
+func (r *keyRepo) backdate(kid string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := r.keys[kid]
+	key.CreatedAt = key.CreatedAt.Add(-d)
+
+	if key.PromotedAt.Valid {
+		key.PromotedAt.Time = key.PromotedAt.Time.Add(-d)
+	}
+
+	if key.RetiredAt.Valid {
+		key.RetiredAt.Time = key.RetiredAt.Time.Add(-d)
+	}
+}
+
+func newService(repo *keyRepo, interval time.Duration) (service.KeyService, *utils.KeyRing) {
+	keyRing := utils.NewKeyRing(utils.NewHMACKey([]byte("access-secret")))
+
+	return keyService.NewService(jwtConfig{rotationInterval: interval}, keyRing, repo, txManager{}, tokenTTL), keyRing
+}
+
+func sign(t *testing.T, keyRing *utils.KeyRing) string {
+	t.Helper()
+
+	token, err := utils.GenerateToken(model.UserJwt{UserId: 1, Role: "user"}, keyRing.Current(), policy, tokenTTL)
+	if err != nil {
+		t.Fatalf("GenerateToken() error = %v", err)
+	}
+
+	return token
+}
+
+func Test_keyService_Lifecycle(t *testing.T) {
+	ctx := context.Background()
+	repo := newKeyRepo()
+	s, keyRing := newService(repo, 0)
+
+	legacy := sign(t, keyRing)
+
+	first, err := s.Generate(ctx, "")
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	// the private key is not stored in clear and is bound to its kid
+	if _, err = utils.ParseSigningKey(first.PrivateKey); err == nil {
+		t.Errorf("Generate() stored an unencrypted private key")
+	}
+
+	if _, ok := keyRing.Key(first.Id); !ok {
+		t.Errorf("pending key is not published")
+	}
+
+	if keyRing.Current().Id == first.Id {
+		t.Errorf("pending key signs before promotion")
+	}
+
+	if err = s.Promote(ctx, first.Id); err != nil {
+		t.Fatalf("Promote() error = %v", err)
+	}
+
+	if keyRing.Current().Id != first.Id {
+		t.Fatalf("Current() = %q, want %q", keyRing.Current().Id, first.Id)
+	}
+
+	signedByFirst := sign(t, keyRing)
+
+	second, err := s.Generate(ctx, "RS256")
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	if err = s.Promote(ctx, second.Id); err != nil {
+		t.Fatalf("Promote() error = %v", err)
+	}
+
+	if err = s.Retire(ctx, second.Id); !errors.Is(err, keyService.ErrRetireCurrentKey) {
+		t.Errorf("Retire() of the current key error = %v, want %v", err, keyService.ErrRetireCurrentKey)
+	}
+
+	// the first key was retired by the promotion but its tokens are still valid
+	for name, token := range map[string]string{"first": signedByFirst, "second": sign(t, keyRing), "legacy": legacy} {
+		if _, err = utils.VerifyToken(token, keyRing, policy); err != nil {
+			t.Errorf("VerifyToken() of the %s token error = %v", name, err)
+		}
+	}
+
+	// once the tokens signed by the retired key expired the key is dropped
+	repo.backdate(first.Id, tokenTTL+time.Minute)
+
+	if err = s.Reload(ctx); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+
+	if _, ok := keyRing.Key(first.Id); ok {
+		t.Errorf("expired retired key is still accepted")
+	}
+
+	if _, err = utils.VerifyToken(signedByFirst, keyRing, policy); err == nil {
+		t.Errorf("VerifyToken() accepted a token of a dropped key")
+	}
+}
+
+func Test_keyService_Rotate(t *testing.T) {
+	ctx := context.Background()
+	repo := newKeyRepo()
+	s, keyRing := newService(repo, rotationInterval)
+
+	status := func() map[string]int {
+		keys, err := s.List(ctx)
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+
+		counts := map[string]int{}
+		for _, k := range keys {
+			counts[k.Status()]++
+		}
+
+		return counts
+	}
+
+	// an empty ring gets a pending key which is not promoted before it was published long enough
+	for i := 0; i < 2; i++ {
+		if err := s.Rotate(ctx); err != nil {
+			t.Fatalf("Rotate() error = %v", err)
+		}
+	}
+
+	keys, _ := s.List(ctx)
+	if got := status(); len(keys) != 1 || got["pending"] != 1 {
+		t.Fatalf("after bootstrap keys = %v, want one pending", got)
+	}
+
+	first := keys[0].Id
+	repo.backdate(first, keyService.PrepublishWindow)
+
+	if err := s.Rotate(ctx); err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+
+	if keyRing.Current().Id != first {
+		t.Fatalf("Current() = %q, want the published key %q", keyRing.Current().Id, first)
+	}
+
+	// nothing happens until the current key is close to its rotation
+	if err := s.Rotate(ctx); err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+
+	if got := status(); got["current"] != 1 || got["pending"] != 0 {
+		t.Fatalf("keys = %v, want only the current one", got)
+	}
+
+	repo.backdate(first, rotationInterval-keyService.PrepublishWindow)
+
+	if err := s.Rotate(ctx); err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+
+	if got := status(); got["current"] != 1 || got["pending"] != 1 {
+		t.Fatalf("keys = %v, want the successor to be pre-published", got)
+	}
+
+	if keyRing.Current().Id != first {
+		t.Errorf("successor signs before the current key is due")
+	}
+
+	keys, _ = s.List(ctx)
+	successor := keys[1].Id
+
+	repo.backdate(first, keyService.PrepublishWindow)
+	repo.backdate(successor, keyService.PrepublishWindow)
+
+	if err := s.Rotate(ctx); err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+
+	if keyRing.Current().Id != successor {
+		t.Fatalf("Current() = %q, want the successor %q", keyRing.Current().Id, successor)
+	}
+
+	if got := status(); got["current"] != 1 || got["retired"] != 1 {
+		t.Errorf("keys = %v, want one current and one retired", got)
+	}
+
+	if _, ok := keyRing.Key(first); !ok {
+		t.Errorf("retired key stopped verifying before its tokens expired")
+	}
+}
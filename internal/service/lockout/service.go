@@ -0,0 +1,133 @@
+package lockout
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/laiker/auth/internal/config"
+	"github.com/laiker/auth/internal/metrics"
+	"github.com/laiker/auth/internal/repository"
+	"github.com/laiker/auth/internal/service"
+	"github.com/pkg/errors"
+)
+
+// ErrLocked the account or the address failed too often, the same answer for unknown emails
+var ErrLocked = errors.New("too many failed login attempts, try again later")
+
+const (
+	accountScope = "account"
+	ipScope      = "ip"
+)
+
+type lockoutService struct {
+	repo            repository.LoginAttemptRepository
+	maxFailures     int
+	maxIpFailures   int
+	lockoutDuration time.Duration
+	backoffBase     time.Duration
+	now             func() time.Time
+}
+
+// NewService now is the clock lockouts expire by, time.Now outside of tests
+func NewService(config config.LoginConfig, repo repository.LoginAttemptRepository, now func() time.Time) service.LockoutService {
+	return &lockoutService{
+		repo:            repo,
+		maxFailures:     config.GetMaxFailures(),
+		maxIpFailures:   config.GetMaxIpFailures(),
+		lockoutDuration: config.GetLockoutDuration(),
+		backoffBase:     config.GetBackoffBase(),
+		now:             now,
+	}
+}
+
+// Check refuses a login while the account or the address is locked, before the password is checked
+func (s *lockoutService) Check(ctx context.Context, email string, ip string) error {
+	attempts, err := s.repo.List(ctx, keys(email, ip))
+
+	if err != nil {
+		return err
+	}
+
+	now := s.now()
+
+	for _, attempt := range attempts {
+		if attempt.LockedUntil.Valid && now.Before(attempt.LockedUntil.Time) {
+			metrics.IncFailedLogin("locked")
+			return ErrLocked
+		}
+	}
+
+	return nil
+}
+
+// Fail counts a wrong password for the account and the address and locks them for a while.
+// The delay doubles with each failure, reaching the threshold locks for the lockout duration.
+func (s *lockoutService) Fail(ctx context.Context, email string, ip string) error {
+	metrics.IncFailedLogin("invalid_credentials")
+
+	err := s.fail(ctx, accountKey(email), accountScope, s.maxFailures)
+
+	if err != nil || ip == "" {
+		return err
+	}
+
+	return s.fail(ctx, ipKey(ip), ipScope, s.maxIpFailures)
+}
+
+// Succeed forgets the failures of the account, the address keeps its count until it expires
+func (s *lockoutService) Succeed(ctx context.Context, email string) error {
+	return s.repo.Reset(ctx, accountKey(email))
+}
+
+// Unlock lifts the lockout of the account
+func (s *lockoutService) Unlock(ctx context.Context, email string) error {
+	return s.repo.Reset(ctx, accountKey(email))
+}
+
+func (s *lockoutService) fail(ctx context.Context, key string, scope string, maxFailures int) error {
+	now := s.now()
+
+	failures, err := s.repo.Fail(ctx, key, now, now.Add(-s.lockoutDuration))
+
+	if err != nil {
+		return err
+	}
+
+	if failures == maxFailures {
+		metrics.IncLockout(scope)
+	}
+
+	return s.repo.Lock(ctx, key, now.Add(s.delay(failures, maxFailures)))
+}
+
+// delay backoffBase * 2^(failures-1), the lockout duration from maxFailures on
+func (s *lockoutService) delay(failures int, maxFailures int) time.Duration {
+	if failures >= maxFailures {
+		return s.lockoutDuration
+	}
+
+	delay := s.backoffBase
+	for i := 1; i < failures && delay < s.lockoutDuration; i++ {
+		delay *= 2
+	}
+
+	return min(delay, s.lockoutDuration)
+}
+
+func keys(email string, ip string) []string {
+	if ip == "" {
+		return []string{accountKey(email)}
+	}
+
+	return []string{accountKey(email), ipKey(ip)}
+}
+
+// accountKey counts by email, so emails without an account behave like existing ones
+func accountKey(email string) string {
+	return accountScope + ":" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return ipScope + ":" + ip
+}
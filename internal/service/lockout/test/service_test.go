@@ -0,0 +1,251 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/service"
+	lockoutService "github.com/laiker/auth/internal/service/lockout"
+	"github.com/pkg/errors"
+)
+
+const (
+	email = "alice@example.com"
+	ip    = "203.0.113.7"
+)
+
+type loginConfig struct{}
+
+func (loginConfig) GetMaxFailures() int               { return 5 }
+func (loginConfig) GetMaxIpFailures() int             { return 8 }
+func (loginConfig) GetLockoutDuration() time.Duration { return 15 * time.Minute }
+func (loginConfig) GetBackoffBase() time.Duration     { return time.Second }
+
+// clock fake time lockouts expire by
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time { return c.now }
+
+func (c *clock) Add(d time.Duration) { c.now = c.now.Add(d) }
+
+// attemptRepo in-memory repository.LoginAttemptRepository
+type attemptRepo struct {
+	mu       sync.Mutex
+	attempts map[string]*model.LoginAttempt
+}
+
+func newAttemptRepo() *attemptRepo {
+	return &attemptRepo{attempts: map[string]*model.LoginAttempt{}}
+}
+
+func (r *attemptRepo) List(_ context.Context, keys []string) ([]*model.LoginAttempt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var attempts []*model.LoginAttempt
+
+	for _, key := range keys {
+		if attempt, ok := r.attempts[key]; ok {
+			stored := *attempt
+			attempts = append(attempts, &stored)
+		}
+	}
+
+	return attempts, nil
+}
+
+func (r *attemptRepo) Fail(_ context.Context, key string, at time.Time, since time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	attempt, ok := r.attempts[key]
+	if !ok || attempt.LastFailedAt.Before(since) {
+		attempt = &model.LoginAttempt{Key: key}
+		r.attempts[key] = attempt
+	}
+
+	attempt.FailedAttempts++
+	attempt.LastFailedAt = at
+
+	return attempt.FailedAttempts, nil
+}
+
+func (r *attemptRepo) Lock(_ context.Context, key string, until time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if attempt, ok := r.attempts[key]; ok {
+		attempt.LockedUntil = sql.NullTime{Time: until, Valid: true}
+	}
+
+	return nil
+}
+
+func (r *attemptRepo) Reset(_ context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.attempts, key)
+
+	return nil
+}
+
+func newService(c *clock) service.LockoutService {
+	return lockoutService.NewService(loginConfig{}, newAttemptRepo(), c.Now)
+}
+
+func assertLocked(t *testing.T, s service.LockoutService, email string, ip string, want bool) {
+	t.Helper()
+
+	err := s.Check(context.Background(), email, ip)
+
+	if want && !errors.Is(err, lockoutService.ErrLocked) {
+		t.Errorf("Check(%q, %q) error = %v, want %v", email, ip, err, lockoutService.ErrLocked)
+	}
+
+	if !want && err != nil {
+		t.Errorf("Check(%q, %q) error = %v, want nil", email, ip, err)
+	}
+}
+
+func Test_lockoutService_Backoff(t *testing.T) {
+	ctx := context.Background()
+	c := &clock{now: time.Now()}
+	s := newService(c)
+
+	// the wait doubles with each failure: 1s, 2s, 4s, 8s
+	for _, wait := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		if err := s.Fail(ctx, email, ip); err != nil {
+			t.Fatalf("Fail() error = %v", err)
+		}
+
+		c.Add(wait - time.Millisecond)
+		assertLocked(t, s, email, ip, true)
+
+		c.Add(time.Millisecond)
+		assertLocked(t, s, email, ip, false)
+	}
+
+	// the fifth failure locks the account for the lockout duration
+	if err := s.Fail(ctx, email, ip); err != nil {
+		t.Fatalf("Fail() error = %v", err)
+	}
+
+	c.Add(15*time.Minute - time.Second)
+	assertLocked(t, s, email, "", true)
+
+	c.Add(time.Second)
+	assertLocked(t, s, email, "", false)
+}
+
+func Test_lockoutService_UnknownEmail(t *testing.T) {
+	ctx := context.Background()
+	c := &clock{now: time.Now()}
+	s := newService(c)
+
+	// counted like any other email, case and spaces do not start a new count
+	for i := 0; i < 5; i++ {
+		if err := s.Fail(ctx, " Nobody@Example.com", ""); err != nil {
+			t.Fatalf("Fail() error = %v", err)
+		}
+
+		c.Add(time.Minute)
+	}
+
+	assertLocked(t, s, "nobody@example.com", "", true)
+	assertLocked(t, s, email, "", false)
+}
+
+func Test_lockoutService_Ip(t *testing.T) {
+	ctx := context.Background()
+	c := &clock{now: time.Now()}
+	s := newService(c)
+
+	// spraying one password over many accounts locks the address
+	for i := 0; i < 8; i++ {
+		if err := s.Fail(ctx, string(rune('a'+i))+"@example.com", ip); err != nil {
+			t.Fatalf("Fail() error = %v", err)
+		}
+
+		c.Add(time.Minute)
+	}
+
+	assertLocked(t, s, email, ip, true)
+	assertLocked(t, s, email, "198.51.100.1", false)
+}
+
+func Test_lockoutService_Reset(t *testing.T) {
+	tests := []struct {
+		name  string
+		reset func(ctx context.Context, s service.LockoutService) error
+	}{
+		{
+			name:  "successful login",
+			reset: func(ctx context.Context, s service.LockoutService) error { return s.Succeed(ctx, email) },
+		},
+		{
+			name:  "admin unlock",
+			reset: func(ctx context.Context, s service.LockoutService) error { return s.Unlock(ctx, "Alice@example.com") },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := &clock{now: time.Now()}
+			s := newService(c)
+
+			for i := 0; i < 5; i++ {
+				if err := s.Fail(ctx, email, ""); err != nil {
+					t.Fatalf("Fail() error = %v", err)
+				}
+			}
+
+			assertLocked(t, s, email, "", true)
+
+			if err := tt.reset(ctx, s); err != nil {
+				t.Fatalf("reset error = %v", err)
+			}
+
+			assertLocked(t, s, email, "", false)
+
+			// the count starts over, one failure only delays
+			if err := s.Fail(ctx, email, ""); err != nil {
+				t.Fatalf("Fail() error = %v", err)
+			}
+
+			c.Add(time.Second)
+			assertLocked(t, s, email, "", false)
+		})
+	}
+}
+
+func Test_lockoutService_FailuresExpire(t *testing.T) {
+	ctx := context.Background()
+	c := &clock{now: time.Now()}
+	s := newService(c)
+
+	for i := 0; i < 4; i++ {
+		if err := s.Fail(ctx, email, ""); err != nil {
+			t.Fatalf("Fail() error = %v", err)
+		}
+
+		c.Add(10 * time.Second)
+	}
+
+	// failures older than the lockout duration are forgotten
+	c.Add(15 * time.Minute)
+
+	if err := s.Fail(ctx, email, ""); err != nil {
+		t.Fatalf("Fail() error = %v", err)
+	}
+
+	c.Add(time.Second)
+	assertLocked(t, s, email, "", false)
+}
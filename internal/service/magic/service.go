@@ -0,0 +1,183 @@
+package magic
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/laiker/auth/client/db"
+	"github.com/laiker/auth/client/mail"
+	"github.com/laiker/auth/internal/config"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+	"github.com/laiker/auth/internal/service"
+	"github.com/laiker/auth/internal/utils"
+	"github.com/pkg/errors"
+)
+
+var ErrInvalidLink = errors.New("login link is invalid or expired")
+
+// mailTimeout bounds a login mail sent after the request was answered
+const mailTimeout = time.Minute
+
+const mailBody = `Someone asked to sign in to your account.
+
+Follow the link on the same device to sign in, it is valid for %s:
+
+%s
+
+If it was not you, ignore this mail.
+`
+
+type magicLinkService struct {
+	userRepo  repository.UserRepository
+	tokenRepo repository.UserTokenRepository
+	mailer    mail.Mailer
+	txManager db.TxManager
+	logger    *slog.Logger
+	url       string
+	tokenTTL  time.Duration
+	now       func() time.Time
+}
+
+// NewService now is the clock login links expire by, time.Now outside of tests
+func NewService(
+	config config.MagicLinkConfig,
+	userRepo repository.UserRepository,
+	tokenRepo repository.UserTokenRepository,
+	mailer mail.Mailer,
+	txManager db.TxManager,
+	logger *slog.Logger,
+	now func() time.Time,
+) service.MagicLinkService {
+	return &magicLinkService{
+		userRepo:  userRepo,
+		tokenRepo: tokenRepo,
+		mailer:    mailer,
+		txManager: txManager,
+		logger:    logger,
+		url:       config.GetURL(),
+		tokenTTL:  config.GetTokenTTL(),
+		now:       now,
+	}
+}
+
+// Request mails a login link to the owner of the email and returns the nonce of the requesting device,
+// the link only works together with it. Unknown emails get a nonce without a mail, so the answer
+// does not reveal who has an account. The link is issued and mailed after the answer, neither its
+// duration nor its failures tell a known email from an unknown one, failures are logged instead.
+func (s *magicLinkService) Request(ctx context.Context, email string) (string, error) {
+	nonce, err := utils.RandomToken()
+
+	if err != nil {
+		return "", err
+	}
+
+	user, err := s.userRepo.GetByEmail(ctx, email)
+
+	if err != nil {
+		return nonce, nil
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), mailTimeout)
+		defer cancel()
+
+		err := s.send(ctx, user, nonce)
+
+		if err != nil {
+			s.logger.Error("failed to send login link mail", "user_id", user.Id, "error", err)
+		}
+	}()
+
+	return nonce, nil
+}
+
+// send issues a login token bound to the nonce and mails its link to the user
+func (s *magicLinkService) send(ctx context.Context, user *model.User, nonce string) error {
+	token, err := utils.RandomToken()
+
+	if err != nil {
+		return err
+	}
+
+	now := s.now()
+
+	err = s.tokenRepo.Create(ctx, &model.UserToken{
+		TokenHash: linkHash(token, nonce),
+		UserId:    user.Id,
+		Purpose:   model.UserTokenMagicLink,
+		Email:     sql.NullString{String: user.Email, Valid: true},
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.tokenTTL),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	link, err := utils.TokenLink(s.url, token)
+
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, &mail.Message{
+		To:      user.Email,
+		Subject: "Sign in link",
+		Body:    fmt.Sprintf(mailBody, s.tokenTTL, link),
+	})
+}
+
+// Consume spends the link and the other links of the user and returns who it was mailed to.
+// Following the link proves the address, so an unverified email becomes verified.
+func (s *magicLinkService) Consume(ctx context.Context, token string, nonce string) (*model.User, error) {
+	var user *model.User
+
+	err := s.txManager.ReadCommitted(ctx, func(ctx context.Context) error {
+		now := s.now()
+		userToken, errTx := s.tokenRepo.Use(ctx, linkHash(token, nonce), model.UserTokenMagicLink, now)
+
+		if errors.Is(errTx, repository.ErrUserTokenNotFound) {
+			return ErrInvalidLink
+		}
+
+		if errTx != nil {
+			return errTx
+		}
+
+		// the email of the account was changed after the link was mailed
+		verified, errTx := s.userRepo.VerifyEmail(ctx, userToken.UserId, userToken.Email.String)
+
+		if errTx != nil {
+			return errTx
+		}
+
+		if !verified {
+			return ErrInvalidLink
+		}
+
+		errTx = s.tokenRepo.UseAll(ctx, userToken.UserId, model.UserTokenMagicLink, now)
+
+		if errTx != nil {
+			return errTx
+		}
+
+		user, errTx = s.userRepo.Get(ctx, userToken.UserId)
+
+		return errTx
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// linkHash binds the stored hash to the device nonce, a link opened without it matches no token
+func linkHash(token string, nonce string) string {
+	return utils.HashToken(nonce + "." + token)
+}
@@ -0,0 +1,303 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/laiker/auth/client/db"
+	"github.com/laiker/auth/client/mail"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+	"github.com/laiker/auth/internal/service"
+	magicService "github.com/laiker/auth/internal/service/magic"
+	"github.com/pkg/errors"
+)
+
+const email = "alice@example.com"
+
+type magicConfig struct{}
+
+func (magicConfig) GetURL() string             { return "https://app.example.com/magic-link" }
+func (magicConfig) GetTokenTTL() time.Duration { return 15 * time.Minute }
+
+type txManager struct{}
+
+func (txManager) ReadCommitted(ctx context.Context, f db.Handler) error {
+	return f(ctx)
+}
+
+// clock fake time login links expire by
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time { return c.now }
+
+func (c *clock) Add(d time.Duration) { c.now = c.now.Add(d) }
+
+// userRepo serves a single user, the rest of repository.UserRepository is not used by the service
+type userRepo struct {
+	repository.UserRepository
+	user *model.User
+}
+
+func (r *userRepo) Get(_ context.Context, id int64) (*model.User, error) {
+	if id != r.user.Id {
+		return nil, errors.New("Пользователь не найден")
+	}
+
+	return r.user, nil
+}
+
+func (r *userRepo) GetByEmail(_ context.Context, email string) (*model.User, error) {
+	if email != r.user.Email {
+		return nil, errors.New("Пользователь не найден")
+	}
+
+	return r.user, nil
+}
+
+func (r *userRepo) VerifyEmail(_ context.Context, id int64, email string) (bool, error) {
+	if id != r.user.Id || email != r.user.Email {
+		return false, nil
+	}
+
+	r.user.EmailVerified = true
+
+	return true, nil
+}
+
+// tokenRepo in-memory repository.UserTokenRepository
+type tokenRepo struct {
+	mu     sync.Mutex
+	tokens map[string]*model.UserToken
+}
+
+func (r *tokenRepo) Create(_ context.Context, token *model.UserToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := *token
+	r.tokens[token.TokenHash] = &stored
+
+	return nil
+}
+
+func (r *tokenRepo) Use(_ context.Context, tokenHash string, purpose string, at time.Time) (*model.UserToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokens[tokenHash]
+	if !ok || token.Purpose != purpose || token.UsedAt.Valid || !at.Before(token.ExpiresAt) {
+		return nil, repository.ErrUserTokenNotFound
+	}
+
+	token.UsedAt = sql.NullTime{Time: at, Valid: true}
+	used := *token
+
+	return &used, nil
+}
+
+func (r *tokenRepo) UseAll(_ context.Context, userId int64, purpose string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, token := range r.tokens {
+		if token.UserId == userId && token.Purpose == purpose && !token.UsedAt.Valid {
+			token.UsedAt = sql.NullTime{Time: at, Valid: true}
+		}
+	}
+
+	return nil
+}
+
+// mailer hands the mails sent after the request over to the test, err fails every send
+type mailer struct {
+	sent chan *mail.Message
+	err  error
+}
+
+func (m *mailer) Send(_ context.Context, msg *mail.Message) error {
+	m.sent <- msg
+	return m.err
+}
+
+// next the mail sent in the background, nil when none comes
+func (m *mailer) next() *mail.Message {
+	select {
+	case msg := <-m.sent:
+		return msg
+	case <-time.After(time.Second):
+		return nil
+	}
+}
+
+type fixture struct {
+	service service.MagicLinkService
+	clock   *clock
+	user    *model.User
+	mailer  *mailer
+}
+
+func newFixture() *fixture {
+	f := &fixture{
+		clock:  &clock{now: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)},
+		user:   &model.User{Id: 7, Name: "alice", Email: email},
+		mailer: &mailer{sent: make(chan *mail.Message, 1)},
+	}
+
+	f.service = magicService.NewService(
+		magicConfig{},
+		&userRepo{user: f.user},
+		&tokenRepo{tokens: map[string]*model.UserToken{}},
+		f.mailer,
+		txManager{},
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+		f.clock.Now,
+	)
+
+	return f
+}
+
+var linkPattern = regexp.MustCompile(`https://\S+`)
+
+// request asks for a link and returns the token from the mail and the nonce of the device
+func (f *fixture) request(t *testing.T) (string, string) {
+	t.Helper()
+
+	nonce, err := f.service.Request(context.Background(), email)
+	if err != nil {
+		t.Fatalf("Request() error = %v", err)
+	}
+
+	msg := f.mailer.next()
+	if msg == nil || msg.To != email {
+		t.Fatal("no mail was sent to the user")
+	}
+
+	link, err := url.Parse(linkPattern.FindString(msg.Body))
+	if err != nil || link.Path != "/magic-link" || link.Query().Get("token") == "" {
+		t.Fatalf("mail has no login link: %q", msg.Body)
+	}
+
+	if nonce == "" {
+		t.Fatal("no nonce was returned")
+	}
+
+	return link.Query().Get("token"), nonce
+}
+
+func TestMagicLinkService_Consume(t *testing.T) {
+	f := newFixture()
+	token, nonce := f.request(t)
+
+	user, err := f.service.Consume(context.Background(), token, nonce)
+	if err != nil {
+		t.Fatalf("Consume() error = %v", err)
+	}
+
+	if user.Id != f.user.Id {
+		t.Fatalf("signed in as %d", user.Id)
+	}
+
+	if !f.user.EmailVerified {
+		t.Fatal("following the link did not verify the email")
+	}
+
+	_, err = f.service.Consume(context.Background(), token, nonce)
+	if !errors.Is(err, magicService.ErrInvalidLink) {
+		t.Fatalf("second Consume() error = %v, want ErrInvalidLink", err)
+	}
+}
+
+func TestMagicLinkService_OtherDevice(t *testing.T) {
+	f := newFixture()
+	token, _ := f.request(t)
+
+	_, err := f.service.Consume(context.Background(), token, "nonce-of-another-device")
+	if !errors.Is(err, magicService.ErrInvalidLink) {
+		t.Fatalf("Consume() error = %v, want ErrInvalidLink", err)
+	}
+}
+
+func TestMagicLinkService_Expired(t *testing.T) {
+	f := newFixture()
+	token, nonce := f.request(t)
+
+	f.clock.Add(15 * time.Minute)
+
+	_, err := f.service.Consume(context.Background(), token, nonce)
+	if !errors.Is(err, magicService.ErrInvalidLink) {
+		t.Fatalf("Consume() error = %v, want ErrInvalidLink", err)
+	}
+}
+
+func TestMagicLinkService_OtherLinksSpent(t *testing.T) {
+	f := newFixture()
+	first, firstNonce := f.request(t)
+	second, secondNonce := f.request(t)
+
+	if _, err := f.service.Consume(context.Background(), second, secondNonce); err != nil {
+		t.Fatalf("Consume() error = %v", err)
+	}
+
+	_, err := f.service.Consume(context.Background(), first, firstNonce)
+	if !errors.Is(err, magicService.ErrInvalidLink) {
+		t.Fatalf("Consume() of an older link error = %v, want ErrInvalidLink", err)
+	}
+}
+
+func TestMagicLinkService_EmailChanged(t *testing.T) {
+	f := newFixture()
+	token, nonce := f.request(t)
+
+	f.user.Email = "alice@work.example.com"
+
+	_, err := f.service.Consume(context.Background(), token, nonce)
+	if !errors.Is(err, magicService.ErrInvalidLink) {
+		t.Fatalf("Consume() error = %v, want ErrInvalidLink", err)
+	}
+}
+
+func TestMagicLinkService_UnknownEmail(t *testing.T) {
+	f := newFixture()
+
+	nonce, err := f.service.Request(context.Background(), "mallory@example.com")
+	if err != nil {
+		t.Fatalf("Request() error = %v", err)
+	}
+
+	if nonce == "" {
+		t.Fatal("an unknown email got no nonce, the answer reveals it")
+	}
+
+	if len(f.mailer.sent) != 0 {
+		t.Fatal("a mail was sent for an unknown email")
+	}
+}
+
+// a failing mail server answers like an unknown email, the device still gets its nonce
+func TestMagicLinkService_MailFails(t *testing.T) {
+	f := newFixture()
+	f.mailer.err = errors.New("smtp: connection refused")
+
+	nonce, err := f.service.Request(context.Background(), email)
+	if err != nil {
+		t.Fatalf("Request() error = %v", err)
+	}
+
+	if nonce == "" {
+		t.Fatal("no nonce was returned")
+	}
+
+	if f.mailer.next() == nil {
+		t.Fatal("no mail was sent")
+	}
+}
@@ -0,0 +1,322 @@
+package mfa
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/laiker/auth/client/db"
+	"github.com/laiker/auth/internal/config"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+	"github.com/laiker/auth/internal/service"
+	"github.com/laiker/auth/internal/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	RecoveryCodeCount = 10
+	// MaxFailedAttempts codes checked within FailureWindow without a valid one, then verification is refused
+	// until a window passes without attempts
+	MaxFailedAttempts = 5
+	FailureWindow     = 5 * time.Minute
+
+	recoveryCodeSize = 10
+)
+
+var (
+	ErrMfaUnavailable  = errors.New("mfa is not configured")
+	ErrAlreadyEnabled  = errors.New("mfa is already enabled")
+	ErrNotEnrolled     = errors.New("mfa is not enrolled")
+	ErrInvalidCode     = errors.New("invalid mfa code")
+	ErrTooManyAttempts = errors.New("too many invalid mfa codes, try again later")
+)
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type mfaService struct {
+	mfaRepo       repository.MfaRepository
+	userRepo      repository.UserRepository
+	txManager     db.TxManager
+	encryptionKey []byte
+	issuer        string
+	now           func() time.Time
+}
+
+// NewService now is the clock TOTP codes are checked against, time.Now outside of tests
+func NewService(
+	config config.MfaConfig,
+	mfaRepo repository.MfaRepository,
+	userRepo repository.UserRepository,
+	txManager db.TxManager,
+	now func() time.Time,
+) service.MfaService {
+	return &mfaService{
+		mfaRepo:       mfaRepo,
+		userRepo:      userRepo,
+		txManager:     txManager,
+		encryptionKey: config.GetEncryptionKey(),
+		issuer:        config.GetIssuer(),
+		now:           now,
+	}
+}
+
+// Enroll generates a TOTP secret, it is not asked for on login until confirmed.
+// Enrolling again before confirming replaces the secret.
+func (s *mfaService) Enroll(ctx context.Context, userId int64) (*model.MfaEnrollment, error) {
+	if s.encryptionKey == nil {
+		return nil, ErrMfaUnavailable
+	}
+
+	existing, err := s.mfaRepo.Get(ctx, userId)
+
+	if err == nil && existing.Enabled() {
+		return nil, ErrAlreadyEnabled
+	}
+
+	if err != nil && !errors.Is(err, repository.ErrMfaNotFound) {
+		return nil, err
+	}
+
+	user, err := s.userRepo.Get(ctx, userId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := utils.Encrypt(s.encryptionKey, secret, additionalData(userId))
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.mfaRepo.Save(ctx, &model.Mfa{UserId: userId, Secret: sealed})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.MfaEnrollment{
+		Secret: utils.EncodeTOTPSecret(secret),
+		Uri:    utils.TOTPUri(s.issuer, user.Email, secret),
+	}, nil
+}
+
+// Confirm enables the second factor with a code from the app and returns the recovery codes, shown only once
+func (s *mfaService) Confirm(ctx context.Context, userId int64, code string) ([]string, error) {
+	mfa, err := s.mfaRepo.Get(ctx, userId)
+
+	if errors.Is(err, repository.ErrMfaNotFound) {
+		return nil, ErrNotEnrolled
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if mfa.Enabled() {
+		return nil, ErrAlreadyEnabled
+	}
+
+	now := s.now()
+
+	counter, err := s.checkTOTP(ctx, mfa, code, now)
+
+	if err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.txManager.ReadCommitted(ctx, func(ctx context.Context) error {
+		used, errTx := s.mfaRepo.UseCounter(ctx, userId, counter, now)
+
+		if errTx != nil {
+			return errTx
+		}
+
+		if !used {
+			return ErrInvalidCode
+		}
+
+		errTx = s.mfaRepo.SetFailedAttempts(ctx, userId, 0, now)
+
+		if errTx != nil {
+			return errTx
+		}
+
+		return s.mfaRepo.ReplaceRecoveryCodes(ctx, userId, hashes)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Verify accepts a TOTP code or an unused recovery code, every code works once
+func (s *mfaService) Verify(ctx context.Context, userId int64, code string) error {
+	mfa, err := s.mfaRepo.Get(ctx, userId)
+
+	if errors.Is(err, repository.ErrMfaNotFound) || err == nil && !mfa.Enabled() {
+		return ErrNotEnrolled
+	}
+
+	if err != nil {
+		return err
+	}
+
+	now := s.now()
+
+	if len(code) == utils.TOTPDigits {
+		counter, err := s.checkTOTP(ctx, mfa, code, now)
+
+		if err != nil {
+			return err
+		}
+
+		used, err := s.mfaRepo.UseCounter(ctx, userId, counter, now)
+
+		if err != nil {
+			return err
+		}
+
+		if !used {
+			return ErrInvalidCode
+		}
+
+		return s.mfaRepo.SetFailedAttempts(ctx, userId, 0, now)
+	}
+
+	err = s.attempt(ctx, userId, now)
+
+	if err != nil {
+		return err
+	}
+
+	used, err := s.mfaRepo.UseRecoveryCode(ctx, userId, utils.HashToken(normalizeRecoveryCode(code)), now)
+
+	if err != nil {
+		return err
+	}
+
+	if !used {
+		return ErrInvalidCode
+	}
+
+	return s.mfaRepo.SetFailedAttempts(ctx, userId, 0, now)
+}
+
+// Disable removes the second factor and its recovery codes, the user proves possession once more
+func (s *mfaService) Disable(ctx context.Context, userId int64, code string) error {
+	err := s.Verify(ctx, userId, code)
+
+	if err != nil {
+		return err
+	}
+
+	return s.mfaRepo.Delete(ctx, userId)
+}
+
+// IsEnabled errors are returned, so login never skips the second factor because of an outage
+func (s *mfaService) IsEnabled(ctx context.Context, userId int64) (bool, error) {
+	mfa, err := s.mfaRepo.Get(ctx, userId)
+
+	if errors.Is(err, repository.ErrMfaNotFound) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return mfa.Enabled(), nil
+}
+
+// checkTOTP returns the time step of a valid code, the caller still has to record it as used
+func (s *mfaService) checkTOTP(ctx context.Context, mfa *model.Mfa, code string, now time.Time) (int64, error) {
+	if s.encryptionKey == nil {
+		return 0, ErrMfaUnavailable
+	}
+
+	err := s.attempt(ctx, mfa.UserId, now)
+
+	if err != nil {
+		return 0, err
+	}
+
+	secret, err := utils.Decrypt(s.encryptionKey, mfa.Secret, additionalData(mfa.UserId))
+
+	if err != nil {
+		return 0, err
+	}
+
+	counter, ok := utils.VerifyTOTP(secret, code, now)
+
+	if !ok {
+		return 0, ErrInvalidCode
+	}
+
+	return counter, nil
+}
+
+// attempt counts the code before it is checked, so parallel guesses can not share a count read earlier,
+// the decision is made on the count the repository returns and a valid code starts it over
+func (s *mfaService) attempt(ctx context.Context, userId int64, now time.Time) error {
+	attempts, err := s.mfaRepo.Fail(ctx, userId, now, now.Add(-FailureWindow))
+
+	if err != nil {
+		return err
+	}
+
+	if attempts > MaxFailedAttempts {
+		return ErrTooManyAttempts
+	}
+
+	return nil
+}
+
+// additionalData binds a sealed secret to its user, so secrets can not be swapped between rows
+func additionalData(userId int64) []byte {
+	return []byte(strconv.FormatInt(userId, 10))
+}
+
+// newRecoveryCodes 80 bit codes formatted for reading, only their hashes are stored
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, RecoveryCodeCount)
+	hashes := make([]string, 0, RecoveryCodeCount)
+
+	for range RecoveryCodeCount {
+		raw := make([]byte, recoveryCodeSize)
+
+		_, err := rand.Read(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		encoded := strings.ToLower(recoveryEncoding.EncodeToString(raw))
+		codes = append(codes, encoded[0:4]+"-"+encoded[4:8]+"-"+encoded[8:12]+"-"+encoded[12:16])
+		hashes = append(hashes, utils.HashToken(encoded))
+	}
+
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode users may type the code without dashes or in upper case
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
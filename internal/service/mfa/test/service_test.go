@@ -0,0 +1,422 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/laiker/auth/client/db"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+	"github.com/laiker/auth/internal/service"
+	mfaService "github.com/laiker/auth/internal/service/mfa"
+	"github.com/laiker/auth/internal/utils"
+	"github.com/pkg/errors"
+)
+
+var encryptionKey = bytes.Repeat([]byte{7}, 32)
+
+type mfaConfig struct {
+	key []byte
+}
+
+func (c mfaConfig) GetEncryptionKey() []byte { return c.key }
+func (mfaConfig) GetIssuer() string          { return "Example Auth" }
+
+type txManager struct{}
+
+func (txManager) ReadCommitted(ctx context.Context, f db.Handler) error {
+	return f(ctx)
+}
+
+// clock fake time the codes are generated and checked against
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time { return c.now }
+
+func (c *clock) Add(d time.Duration) { c.now = c.now.Add(d) }
+
+// userRepo serves a single user, the rest of repository.UserRepository is not used by the service
+type userRepo struct {
+	repository.UserRepository
+}
+
+func (userRepo) Get(_ context.Context, id int64) (*model.User, error) {
+	return &model.User{Id: id, Name: "alice", Email: "alice@example.com"}, nil
+}
+
+// mfaRepo in-memory repository.MfaRepository
+type mfaRepo struct {
+	mu       sync.Mutex
+	mfa      map[int64]*model.Mfa
+	recovery map[int64]map[string]bool
+}
+
+func newMfaRepo() *mfaRepo {
+	return &mfaRepo{mfa: map[int64]*model.Mfa{}, recovery: map[int64]map[string]bool{}}
+}
+
+func (r *mfaRepo) Save(_ context.Context, mfa *model.Mfa) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.mfa[mfa.UserId]; ok && existing.Enabled() {
+		return nil
+	}
+
+	r.mfa[mfa.UserId] = &model.Mfa{UserId: mfa.UserId, Secret: mfa.Secret}
+
+	return nil
+}
+
+func (r *mfaRepo) Get(_ context.Context, userId int64) (*model.Mfa, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	mfa, ok := r.mfa[userId]
+	if !ok {
+		return nil, repository.ErrMfaNotFound
+	}
+
+	stored := *mfa
+
+	return &stored, nil
+}
+
+func (r *mfaRepo) Delete(_ context.Context, userId int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.mfa, userId)
+	delete(r.recovery, userId)
+
+	return nil
+}
+
+func (r *mfaRepo) UseCounter(_ context.Context, userId int64, counter int64, confirmedAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	mfa := r.mfa[userId]
+	if mfa.LastCounter.Valid && mfa.LastCounter.Int64 >= counter {
+		return false, nil
+	}
+
+	mfa.LastCounter = sql.NullInt64{Int64: counter, Valid: true}
+
+	if !mfa.ConfirmedAt.Valid {
+		mfa.ConfirmedAt = sql.NullTime{Time: confirmedAt, Valid: true}
+	}
+
+	return true, nil
+}
+
+func (r *mfaRepo) SetFailedAttempts(_ context.Context, userId int64, attempts int, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.mfa[userId].FailedAttempts = attempts
+	r.mfa[userId].LastFailedAt = sql.NullTime{Time: at, Valid: true}
+
+	return nil
+}
+
+func (r *mfaRepo) Fail(_ context.Context, userId int64, at time.Time, since time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	mfa := r.mfa[userId]
+	if !mfa.LastFailedAt.Valid || !mfa.LastFailedAt.Time.After(since) {
+		mfa.FailedAttempts = 0
+	}
+
+	mfa.FailedAttempts++
+	mfa.LastFailedAt = sql.NullTime{Time: at, Valid: true}
+
+	return mfa.FailedAttempts, nil
+}
+
+func (r *mfaRepo) ReplaceRecoveryCodes(_ context.Context, userId int64, codeHashes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.recovery[userId] = map[string]bool{}
+
+	for _, codeHash := range codeHashes {
+		r.recovery[userId][codeHash] = false
+	}
+
+	return nil
+}
+
+func (r *mfaRepo) UseRecoveryCode(_ context.Context, userId int64, codeHash string, _ time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	used, ok := r.recovery[userId][codeHash]
+	if !ok || used {
+		return false, nil
+	}
+
+	r.recovery[userId][codeHash] = true
+
+	return true, nil
+}
+
+func newService(repo *mfaRepo, c *clock) service.MfaService {
+	return mfaService.NewService(mfaConfig{key: encryptionKey}, repo, userRepo{}, txManager{}, c.Now)
+}
+
+// codeAt the code an authenticator app shows at t
+func codeAt(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+
+	raw, err := utils.DecodeTOTPSecret(secret)
+	if err != nil {
+		t.Fatalf("invalid secret %q: %v", secret, err)
+	}
+
+	return utils.TOTPCode(raw, utils.TOTPCounter(at))
+}
+
+// enroll enables the second factor for user 1 and returns the secret and the recovery codes
+func enroll(t *testing.T, s service.MfaService, c *clock) (string, []string) {
+	t.Helper()
+	ctx := context.Background()
+
+	enrollment, err := s.Enroll(ctx, 1)
+	if err != nil {
+		t.Fatalf("Enroll() error = %v", err)
+	}
+
+	recoveryCodes, err := s.Confirm(ctx, 1, codeAt(t, enrollment.Secret, c.now))
+	if err != nil {
+		t.Fatalf("Confirm() error = %v", err)
+	}
+
+	return enrollment.Secret, recoveryCodes
+}
+
+func Test_mfaService_Enroll(t *testing.T) {
+	ctx := context.Background()
+	c := &clock{now: time.Unix(1700000000, 0)}
+	repo := newMfaRepo()
+	s := newService(repo, c)
+
+	enrollment, err := s.Enroll(ctx, 1)
+	if err != nil {
+		t.Fatalf("Enroll() error = %v", err)
+	}
+
+	if !strings.HasPrefix(enrollment.Uri, "otpauth://totp/Example%20Auth:alice@example.com?") {
+		t.Errorf("Enroll() uri = %q", enrollment.Uri)
+	}
+
+	// the secret is only stored sealed and bound to its user
+	stored, _ := repo.Get(ctx, 1)
+	raw, _ := utils.DecodeTOTPSecret(enrollment.Secret)
+
+	if bytes.Contains(stored.Secret, raw) {
+		t.Errorf("secret is stored in plain text")
+	}
+
+	if _, err = utils.Decrypt(encryptionKey, stored.Secret, []byte("2")); err == nil {
+		t.Errorf("secret of user 1 decrypts as the secret of user 2")
+	}
+
+	if enabled, _ := s.IsEnabled(ctx, 1); enabled {
+		t.Errorf("IsEnabled() = true before the enrollment was confirmed")
+	}
+
+	if _, err = s.Confirm(ctx, 1, codeAt(t, enrollment.Secret, c.now.Add(-time.Hour))); !errors.Is(err, mfaService.ErrInvalidCode) {
+		t.Errorf("Confirm() with a stale code error = %v, want %v", err, mfaService.ErrInvalidCode)
+	}
+
+	recoveryCodes, err := s.Confirm(ctx, 1, codeAt(t, enrollment.Secret, c.now))
+	if err != nil {
+		t.Fatalf("Confirm() error = %v", err)
+	}
+
+	if len(recoveryCodes) != mfaService.RecoveryCodeCount {
+		t.Errorf("Confirm() returned %d recovery codes, want %d", len(recoveryCodes), mfaService.RecoveryCodeCount)
+	}
+
+	if enabled, _ := s.IsEnabled(ctx, 1); !enabled {
+		t.Errorf("IsEnabled() = false after the enrollment was confirmed")
+	}
+
+	if _, err = s.Enroll(ctx, 1); !errors.Is(err, mfaService.ErrAlreadyEnabled) {
+		t.Errorf("Enroll() of an enabled user error = %v, want %v", err, mfaService.ErrAlreadyEnabled)
+	}
+}
+
+func Test_mfaService_EnrollWithoutKey(t *testing.T) {
+	s := mfaService.NewService(mfaConfig{}, newMfaRepo(), userRepo{}, txManager{}, time.Now)
+
+	if _, err := s.Enroll(context.Background(), 1); !errors.Is(err, mfaService.ErrMfaUnavailable) {
+		t.Errorf("Enroll() error = %v, want %v", err, mfaService.ErrMfaUnavailable)
+	}
+}
+
+func Test_mfaService_Verify(t *testing.T) {
+	ctx := context.Background()
+	c := &clock{now: time.Unix(1700000000, 0)}
+	s := newService(newMfaRepo(), c)
+	secret, recoveryCodes := enroll(t, s, c)
+
+	// the confirmation code can not be used for a login
+	if err := s.Verify(ctx, 1, codeAt(t, secret, c.now)); !errors.Is(err, mfaService.ErrInvalidCode) {
+		t.Errorf("Verify() of the confirmation code error = %v, want %v", err, mfaService.ErrInvalidCode)
+	}
+
+	c.Add(utils.TOTPPeriod)
+	code := codeAt(t, secret, c.now)
+
+	// the app clock may be one step behind
+	c.Add(utils.TOTPPeriod)
+
+	if err := s.Verify(ctx, 1, code); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	if err := s.Verify(ctx, 1, code); !errors.Is(err, mfaService.ErrInvalidCode) {
+		t.Errorf("Verify() of a replayed code error = %v, want %v", err, mfaService.ErrInvalidCode)
+	}
+
+	// recovery codes are accepted once, in any case and without dashes
+	recoveryCode := strings.ToUpper(strings.ReplaceAll(recoveryCodes[0], "-", ""))
+
+	if err := s.Verify(ctx, 1, recoveryCode); err != nil {
+		t.Fatalf("Verify() of a recovery code error = %v", err)
+	}
+
+	if err := s.Verify(ctx, 1, recoveryCodes[0]); !errors.Is(err, mfaService.ErrInvalidCode) {
+		t.Errorf("Verify() of a used recovery code error = %v, want %v", err, mfaService.ErrInvalidCode)
+	}
+
+	if err := s.Verify(ctx, 2, code); !errors.Is(err, mfaService.ErrNotEnrolled) {
+		t.Errorf("Verify() of a user without mfa error = %v, want %v", err, mfaService.ErrNotEnrolled)
+	}
+}
+
+func Test_mfaService_TooManyAttempts(t *testing.T) {
+	ctx := context.Background()
+	c := &clock{now: time.Unix(1700000000, 0)}
+	s := newService(newMfaRepo(), c)
+	secret, recoveryCodes := enroll(t, s, c)
+
+	for i := 0; i < mfaService.MaxFailedAttempts; i++ {
+		if err := s.Verify(ctx, 1, "000000"); !errors.Is(err, mfaService.ErrInvalidCode) {
+			t.Fatalf("Verify() attempt %d error = %v, want %v", i, err, mfaService.ErrInvalidCode)
+		}
+	}
+
+	c.Add(utils.TOTPPeriod)
+
+	// even valid codes are refused until the window passes
+	if err := s.Verify(ctx, 1, codeAt(t, secret, c.now)); !errors.Is(err, mfaService.ErrTooManyAttempts) {
+		t.Errorf("Verify() error = %v, want %v", err, mfaService.ErrTooManyAttempts)
+	}
+
+	if err := s.Verify(ctx, 1, recoveryCodes[0]); !errors.Is(err, mfaService.ErrTooManyAttempts) {
+		t.Errorf("Verify() of a recovery code error = %v, want %v", err, mfaService.ErrTooManyAttempts)
+	}
+
+	c.Add(mfaService.FailureWindow)
+
+	if err := s.Verify(ctx, 1, codeAt(t, secret, c.now)); err != nil {
+		t.Errorf("Verify() after the window error = %v", err)
+	}
+}
+
+// parallel guesses are counted one by one, only MaxFailedAttempts of them get their code checked
+func Test_mfaService_ParallelAttempts(t *testing.T) {
+	ctx := context.Background()
+	c := &clock{now: time.Unix(1700000000, 0)}
+	s := newService(newMfaRepo(), c)
+	enroll(t, s, c)
+
+	const guesses = 4 * mfaService.MaxFailedAttempts
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		invalid int
+		refused int
+	)
+
+	for i := 0; i < guesses; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			err := s.Verify(ctx, 1, "000000")
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			switch {
+			case errors.Is(err, mfaService.ErrInvalidCode):
+				invalid++
+			case errors.Is(err, mfaService.ErrTooManyAttempts):
+				refused++
+			default:
+				t.Errorf("Verify() error = %v", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if invalid != mfaService.MaxFailedAttempts || refused != guesses-mfaService.MaxFailedAttempts {
+		t.Errorf("checked %d and refused %d guesses, want %d and %d", invalid, refused, mfaService.MaxFailedAttempts, guesses-mfaService.MaxFailedAttempts)
+	}
+}
+
+// a valid code starts the count over, earlier typos do not add up to a lockout
+func Test_mfaService_SuccessResetsAttempts(t *testing.T) {
+	ctx := context.Background()
+	c := &clock{now: time.Unix(1700000000, 0)}
+	s := newService(newMfaRepo(), c)
+	secret, _ := enroll(t, s, c)
+
+	for round := 0; round < 2; round++ {
+		for i := 0; i < mfaService.MaxFailedAttempts-1; i++ {
+			if err := s.Verify(ctx, 1, "000000"); !errors.Is(err, mfaService.ErrInvalidCode) {
+				t.Fatalf("Verify() round %d attempt %d error = %v, want %v", round, i, err, mfaService.ErrInvalidCode)
+			}
+		}
+
+		c.Add(utils.TOTPPeriod)
+
+		if err := s.Verify(ctx, 1, codeAt(t, secret, c.now)); err != nil {
+			t.Fatalf("Verify() round %d error = %v", round, err)
+		}
+	}
+}
+
+func Test_mfaService_Disable(t *testing.T) {
+	ctx := context.Background()
+	c := &clock{now: time.Unix(1700000000, 0)}
+	s := newService(newMfaRepo(), c)
+	_, recoveryCodes := enroll(t, s, c)
+
+	if err := s.Disable(ctx, 1, "000000"); !errors.Is(err, mfaService.ErrInvalidCode) {
+		t.Errorf("Disable() with a wrong code error = %v, want %v", err, mfaService.ErrInvalidCode)
+	}
+
+	if err := s.Disable(ctx, 1, recoveryCodes[1]); err != nil {
+		t.Fatalf("Disable() error = %v", err)
+	}
+
+	if enabled, _ := s.IsEnabled(ctx, 1); enabled {
+		t.Errorf("IsEnabled() = true after Disable()")
+	}
+}
@@ -0,0 +1,143 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/laiker/auth/client/pwned"
+	"github.com/laiker/auth/internal/config"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/service"
+)
+
+// minPersonalLen shorter parts of a name or an email are too common to refuse
+const minPersonalLen = 3
+
+// ViolationError password breaks the policy, Violations lists every broken rule
+type ViolationError struct {
+	Violations []model.PasswordViolation
+}
+
+func (e *ViolationError) Error() string {
+	descriptions := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		descriptions = append(descriptions, v.Description)
+	}
+
+	return "password does not meet the policy: " + strings.Join(descriptions, "; ")
+}
+
+type policyService struct {
+	minLength        int
+	maxLength        int
+	minClasses       int
+	disallowPersonal bool
+	pwned            pwned.Store
+}
+
+// NewService store is nil when breached passwords are not checked
+func NewService(config config.PasswordPolicyConfig, store pwned.Store) service.PasswordPolicyService {
+	return &policyService{
+		minLength:        config.GetMinLength(),
+		maxLength:        config.GetMaxLength(),
+		minClasses:       config.GetMinClasses(),
+		disallowPersonal: config.GetDisallowPersonal(),
+		pwned:            store,
+	}
+}
+
+// Check the password of the user with the email and the name, a new user or one changing the password
+func (s *policyService) Check(ctx context.Context, password string, email string, name string) error {
+	var violations []model.PasswordViolation
+
+	length := utf8.RuneCountInString(password)
+
+	if length < s.minLength {
+		violations = append(violations, model.PasswordViolation{
+			Rule:        model.PasswordRuleMinLength,
+			Description: fmt.Sprintf("must be at least %d characters long", s.minLength),
+		})
+	}
+
+	if length > s.maxLength {
+		violations = append(violations, model.PasswordViolation{
+			Rule:        model.PasswordRuleMaxLength,
+			Description: fmt.Sprintf("must be at most %d characters long", s.maxLength),
+		})
+	}
+
+	if classes(password) < s.minClasses {
+		violations = append(violations, model.PasswordViolation{
+			Rule:        model.PasswordRuleClasses,
+			Description: fmt.Sprintf("must mix at least %d of lower case, upper case, digits and other characters", s.minClasses),
+		})
+	}
+
+	if s.disallowPersonal && containsPersonal(password, email, name) {
+		violations = append(violations, model.PasswordViolation{
+			Rule:        model.PasswordRulePersonal,
+			Description: "must not contain the name or the email",
+		})
+	}
+
+	if s.pwned != nil {
+		count, err := pwned.Count(ctx, s.pwned, password)
+
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			violations = append(violations, model.PasswordViolation{
+				Rule:        model.PasswordRuleBreached,
+				Description: "appeared in a data breach, choose another one",
+			})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &ViolationError{Violations: violations}
+	}
+
+	return nil
+}
+
+// classes of characters the password mixes, letters without case count as lower case
+func classes(password string) int {
+	var lower, upper, digit, other int
+
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsLetter(r):
+			lower = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+
+	return lower + upper + digit + other
+}
+
+func containsPersonal(password string, email string, name string) bool {
+	password = strings.ToLower(password)
+
+	local, _, _ := strings.Cut(email, "@")
+	parts := append(strings.Fields(name), local)
+
+	for _, part := range parts {
+		part = strings.ToLower(part)
+
+		if utf8.RuneCountInString(part) >= minPersonalLen && strings.Contains(password, part) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,103 @@
+package test
+
+import (
+	"context"
+	"crypto/sha1" //nolint:gosec
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/laiker/auth/internal/model"
+	policyService "github.com/laiker/auth/internal/service/policy"
+	"github.com/pkg/errors"
+)
+
+type policyConfig struct {
+	minClasses int
+}
+
+func (policyConfig) GetMinLength() int         { return 8 }
+func (policyConfig) GetMaxLength() int         { return 20 }
+func (c policyConfig) GetMinClasses() int      { return c.minClasses }
+func (policyConfig) GetDisallowPersonal() bool { return true }
+func (policyConfig) GetPwnedPath() string      { return "" }
+
+// pwnedStore in-memory pwned.Store holding the hashes of the passwords
+type pwnedStore map[string]int
+
+func newPwnedStore(passwords ...string) pwnedStore {
+	store := pwnedStore{}
+
+	for _, password := range passwords {
+		sum := sha1.Sum([]byte(password)) //nolint:gosec
+		store[strings.ToUpper(hex.EncodeToString(sum[:]))] = 42
+	}
+
+	return store
+}
+
+func (s pwnedStore) Range(_ context.Context, prefix string) (map[string]int, error) {
+	suffixes := map[string]int{}
+
+	for hash, count := range s {
+		if strings.HasPrefix(hash, prefix) {
+			suffixes[hash[len(prefix):]] = count
+		}
+	}
+
+	return suffixes, nil
+}
+
+func TestPolicyService_Check(t *testing.T) {
+	policy := policyService.NewService(policyConfig{minClasses: 3}, newPwnedStore("Password123"))
+
+	tests := []struct {
+		name     string
+		password string
+		want     []string
+	}{
+		{name: "strong", password: "Tr0ub4dor&3", want: nil},
+		{name: "one character", password: "1", want: []string{model.PasswordRuleMinLength, model.PasswordRuleClasses}},
+		{name: "too long", password: "Abcdefgh1" + strings.Repeat("x", 20), want: []string{model.PasswordRuleMaxLength}},
+		{name: "characters not bytes", password: "Пароль12", want: nil},
+		{name: "two classes", password: "abcdefgh12", want: []string{model.PasswordRuleClasses}},
+		{name: "email", password: "Alice.Smith2026", want: []string{model.PasswordRulePersonal}},
+		{name: "name", password: "xBOBBYx-99", want: []string{model.PasswordRulePersonal}},
+		{name: "breached", password: "Password123", want: []string{model.PasswordRuleBreached}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(context.Background(), tt.password, "alice.smith@example.com", "Bobby Li")
+
+			var got []string
+
+			var violation *policyService.ViolationError
+			if errors.As(err, &violation) {
+				for _, v := range violation.Violations {
+					if v.Description == "" {
+						t.Errorf("violation %s has no description", v.Rule)
+					}
+
+					got = append(got, v.Rule)
+				}
+			} else if err != nil {
+				t.Fatalf("Check() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check() violations = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyService_ShortNamesAllowed(t *testing.T) {
+	policy := policyService.NewService(policyConfig{minClasses: 1}, nil)
+
+	// a two letter name is inside too many passwords to refuse them
+	if err := policy.Check(context.Background(), "lighthouse keeper", "li@example.com", "Li"); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+}
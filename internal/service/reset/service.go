@@ -0,0 +1,196 @@
+package reset
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/laiker/auth/client/db"
+	"github.com/laiker/auth/client/mail"
+	"github.com/laiker/auth/internal/config"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+	"github.com/laiker/auth/internal/service"
+	"github.com/laiker/auth/internal/utils"
+	"github.com/pkg/errors"
+)
+
+var ErrInvalidToken = errors.New("password reset token is invalid or expired")
+
+// mailTimeout bounds a reset mail sent after the request was answered
+const mailTimeout = time.Minute
+
+const mailBody = `Someone asked to reset the password of your account.
+
+Follow the link to choose a new password, it is valid for %s:
+
+%s
+
+If it was not you, ignore this mail, your password stays the same.
+`
+
+type resetService struct {
+	userRepo       repository.UserRepository
+	tokenRepo      repository.UserTokenRepository
+	mailer         mail.Mailer
+	hasher         utils.PasswordHasher
+	policy         service.PasswordPolicyService
+	authService    service.AuthService
+	lockoutService service.LockoutService
+	txManager      db.TxManager
+	logger         *slog.Logger
+	url            string
+	tokenTTL       time.Duration
+	now            func() time.Time
+}
+
+// NewService now is the clock reset tokens expire by, time.Now outside of tests
+func NewService(
+	config config.PasswordResetConfig,
+	userRepo repository.UserRepository,
+	tokenRepo repository.UserTokenRepository,
+	mailer mail.Mailer,
+	hasher utils.PasswordHasher,
+	policy service.PasswordPolicyService,
+	authService service.AuthService,
+	lockoutService service.LockoutService,
+	txManager db.TxManager,
+	logger *slog.Logger,
+	now func() time.Time,
+) service.PasswordResetService {
+	return &resetService{
+		userRepo:       userRepo,
+		tokenRepo:      tokenRepo,
+		mailer:         mailer,
+		hasher:         hasher,
+		policy:         policy,
+		authService:    authService,
+		lockoutService: lockoutService,
+		txManager:      txManager,
+		logger:         logger,
+		url:            config.GetURL(),
+		tokenTTL:       config.GetTokenTTL(),
+		now:            now,
+	}
+}
+
+// Request mails a reset link to the owner of the email.
+// Unknown emails succeed without a mail, so the answer does not reveal who has an account.
+// The link is issued and mailed after the answer, neither its duration nor its failures
+// tell a known email from an unknown one, failures are logged instead.
+func (s *resetService) Request(ctx context.Context, email string) error {
+	user, err := s.userRepo.GetByEmail(ctx, email)
+
+	if err != nil {
+		return nil
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), mailTimeout)
+		defer cancel()
+
+		err := s.send(ctx, user)
+
+		if err != nil {
+			s.logger.Error("failed to send password reset mail", "user_id", user.Id, "error", err)
+		}
+	}()
+
+	return nil
+}
+
+// send issues a reset token for the user and mails its link
+func (s *resetService) send(ctx context.Context, user *model.User) error {
+	token, err := utils.RandomToken()
+
+	if err != nil {
+		return err
+	}
+
+	now := s.now()
+
+	err = s.tokenRepo.Create(ctx, &model.UserToken{
+		TokenHash: utils.HashToken(token),
+		UserId:    user.Id,
+		Purpose:   model.UserTokenPasswordReset,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.tokenTTL),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	link, err := utils.TokenLink(s.url, token)
+
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, &mail.Message{
+		To:      user.Email,
+		Subject: "Password reset",
+		Body:    fmt.Sprintf(mailBody, s.tokenTTL, link),
+	})
+}
+
+// Reset sets the password of the token owner, spends the other reset tokens of the user
+// and ends all of their sessions, a stolen session does not survive the reset.
+// A password breaking the policy leaves the token unused for another try.
+func (s *resetService) Reset(ctx context.Context, token string, password string) error {
+	var user *model.User
+
+	err := s.txManager.ReadCommitted(ctx, func(ctx context.Context) error {
+		now := s.now()
+
+		userToken, errTx := s.tokenRepo.Use(ctx, utils.HashToken(token), model.UserTokenPasswordReset, now)
+
+		if errors.Is(errTx, repository.ErrUserTokenNotFound) {
+			return ErrInvalidToken
+		}
+
+		if errTx != nil {
+			return errTx
+		}
+
+		user, errTx = s.userRepo.Get(ctx, userToken.UserId)
+
+		if errTx != nil {
+			return errTx
+		}
+
+		errTx = s.policy.Check(ctx, password, user.Email, user.Name)
+
+		if errTx != nil {
+			return errTx
+		}
+
+		passwordHash, errTx := s.hasher.Hash(password)
+
+		if errTx != nil {
+			return errTx
+		}
+
+		errTx = s.userRepo.UpdatePassword(ctx, user.Id, passwordHash)
+
+		if errTx != nil {
+			return errTx
+		}
+
+		errTx = s.tokenRepo.UseAll(ctx, user.Id, model.UserTokenPasswordReset, now)
+
+		if errTx != nil {
+			return errTx
+		}
+
+		return s.authService.LogoutAll(ctx, user.Id)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// whoever reset the password owns the mailbox, the failures of guessing it are forgotten
+	return s.lockoutService.Unlock(ctx, user.Email)
+}
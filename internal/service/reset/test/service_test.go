@@ -0,0 +1,346 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/laiker/auth/client/db"
+	"github.com/laiker/auth/client/mail"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+	"github.com/laiker/auth/internal/service"
+	policyService "github.com/laiker/auth/internal/service/policy"
+	resetService "github.com/laiker/auth/internal/service/reset"
+	"github.com/laiker/auth/internal/utils"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const email = "alice@example.com"
+
+type resetConfig struct{}
+
+func (resetConfig) GetURL() string             { return "https://app.example.com/reset-password?lang=en" }
+func (resetConfig) GetTokenTTL() time.Duration { return time.Hour }
+
+var hasher, _ = utils.NewPasswordHasher(utils.PasswordBcrypt, utils.Argon2Params{}, bcrypt.MinCost)
+
+type policyConfig struct{}
+
+func (policyConfig) GetMinLength() int         { return 8 }
+func (policyConfig) GetMaxLength() int         { return 64 }
+func (policyConfig) GetMinClasses() int        { return 1 }
+func (policyConfig) GetDisallowPersonal() bool { return true }
+func (policyConfig) GetPwnedPath() string      { return "" }
+
+type txManager struct{}
+
+func (txManager) ReadCommitted(ctx context.Context, f db.Handler) error {
+	return f(ctx)
+}
+
+// clock fake time reset tokens expire by
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time { return c.now }
+
+func (c *clock) Add(d time.Duration) { c.now = c.now.Add(d) }
+
+// userRepo serves a single user, the rest of repository.UserRepository is not used by the service
+type userRepo struct {
+	repository.UserRepository
+	user *model.User
+}
+
+func (r *userRepo) Get(_ context.Context, id int64) (*model.User, error) {
+	if id != r.user.Id {
+		return nil, errors.New("Пользователь не найден")
+	}
+
+	return r.user, nil
+}
+
+func (r *userRepo) GetByEmail(_ context.Context, email string) (*model.User, error) {
+	if email != r.user.Email {
+		return nil, errors.New("Пользователь не найден")
+	}
+
+	return r.user, nil
+}
+
+func (r *userRepo) UpdatePassword(_ context.Context, id int64, passwordHash string) error {
+	if id == r.user.Id {
+		r.user.Password = passwordHash
+	}
+
+	return nil
+}
+
+// tokenRepo in-memory repository.UserTokenRepository
+type tokenRepo struct {
+	mu     sync.Mutex
+	tokens map[string]*model.UserToken
+}
+
+func (r *tokenRepo) Create(_ context.Context, token *model.UserToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := *token
+	r.tokens[token.TokenHash] = &stored
+
+	return nil
+}
+
+func (r *tokenRepo) Use(_ context.Context, tokenHash string, purpose string, at time.Time) (*model.UserToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokens[tokenHash]
+	if !ok || token.Purpose != purpose || token.UsedAt.Valid || !at.Before(token.ExpiresAt) {
+		return nil, repository.ErrUserTokenNotFound
+	}
+
+	token.UsedAt = sql.NullTime{Time: at, Valid: true}
+	used := *token
+
+	return &used, nil
+}
+
+func (r *tokenRepo) UseAll(_ context.Context, userId int64, purpose string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, token := range r.tokens {
+		if token.UserId == userId && token.Purpose == purpose && !token.UsedAt.Valid {
+			token.UsedAt = sql.NullTime{Time: at, Valid: true}
+		}
+	}
+
+	return nil
+}
+
+// mailer hands the mails sent after the request over to the test, err fails every send
+type mailer struct {
+	sent chan *mail.Message
+	err  error
+}
+
+func (m *mailer) Send(_ context.Context, msg *mail.Message) error {
+	m.sent <- msg
+	return m.err
+}
+
+// next the mail sent in the background, nil when none comes
+func (m *mailer) next() *mail.Message {
+	select {
+	case msg := <-m.sent:
+		return msg
+	case <-time.After(time.Second):
+		return nil
+	}
+}
+
+type authService struct {
+	service.AuthService
+	loggedOut []int64
+}
+
+func (s *authService) LogoutAll(_ context.Context, userId int64) error {
+	s.loggedOut = append(s.loggedOut, userId)
+	return nil
+}
+
+type lockoutService struct {
+	service.LockoutService
+	unlocked []string
+}
+
+func (s *lockoutService) Unlock(_ context.Context, email string) error {
+	s.unlocked = append(s.unlocked, email)
+	return nil
+}
+
+type fixture struct {
+	service service.PasswordResetService
+	clock   *clock
+	users   *userRepo
+	mailer  *mailer
+	auth    *authService
+	lockout *lockoutService
+}
+
+func newFixture() *fixture {
+	f := &fixture{
+		clock:   &clock{now: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)},
+		users:   &userRepo{user: &model.User{Id: 7, Email: email, Password: "old hash"}},
+		mailer:  &mailer{sent: make(chan *mail.Message, 1)},
+		auth:    &authService{},
+		lockout: &lockoutService{},
+	}
+
+	f.service = resetService.NewService(
+		resetConfig{},
+		f.users,
+		&tokenRepo{tokens: map[string]*model.UserToken{}},
+		f.mailer,
+		hasher,
+		policyService.NewService(policyConfig{}, nil),
+		f.auth,
+		f.lockout,
+		txManager{},
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+		f.clock.Now,
+	)
+
+	return f
+}
+
+var linkPattern = regexp.MustCompile(`https://\S+`)
+
+// request asks for a reset and returns the token from the mailed link
+func (f *fixture) request(t *testing.T) string {
+	t.Helper()
+
+	if err := f.service.Request(context.Background(), email); err != nil {
+		t.Fatalf("Request() error = %v", err)
+	}
+
+	msg := f.mailer.next()
+	if msg == nil {
+		t.Fatal("no mail was sent")
+	}
+
+	if msg.To != email {
+		t.Fatalf("mail sent to %q", msg.To)
+	}
+
+	link, err := url.Parse(linkPattern.FindString(msg.Body))
+	if err != nil || link.Host != "app.example.com" || link.Path != "/reset-password" {
+		t.Fatalf("mail has no reset link: %q", msg.Body)
+	}
+
+	if link.Query().Get("lang") != "en" {
+		t.Fatalf("query of the configured url was dropped: %v", link)
+	}
+
+	return link.Query().Get("token")
+}
+
+func TestResetService_Reset(t *testing.T) {
+	f := newFixture()
+	token := f.request(t)
+
+	err := f.service.Reset(context.Background(), token, "new password")
+	if err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+
+	if ok, _ := hasher.Verify(f.users.user.Password, "new password"); !ok {
+		t.Fatal("the password was not changed")
+	}
+
+	if len(f.auth.loggedOut) != 1 || f.auth.loggedOut[0] != 7 {
+		t.Fatalf("sessions ended for %v", f.auth.loggedOut)
+	}
+
+	if len(f.lockout.unlocked) != 1 || f.lockout.unlocked[0] != email {
+		t.Fatalf("unlocked %v", f.lockout.unlocked)
+	}
+
+	err = f.service.Reset(context.Background(), token, "another password")
+	if !errors.Is(err, resetService.ErrInvalidToken) {
+		t.Fatalf("second Reset() error = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestResetService_UnknownEmail(t *testing.T) {
+	f := newFixture()
+
+	if err := f.service.Request(context.Background(), "mallory@example.com"); err != nil {
+		t.Fatalf("Request() error = %v", err)
+	}
+
+	if len(f.mailer.sent) != 0 {
+		t.Fatal("a mail was sent for an unknown email")
+	}
+}
+
+// a failing mail server answers like an unknown email, the failure is only logged
+func TestResetService_MailFails(t *testing.T) {
+	f := newFixture()
+	f.mailer.err = errors.New("smtp: connection refused")
+
+	if err := f.service.Request(context.Background(), email); err != nil {
+		t.Fatalf("Request() error = %v", err)
+	}
+
+	if f.mailer.next() == nil {
+		t.Fatal("no mail was sent")
+	}
+}
+
+func TestResetService_ExpiredToken(t *testing.T) {
+	f := newFixture()
+	token := f.request(t)
+
+	f.clock.Add(time.Hour)
+
+	err := f.service.Reset(context.Background(), token, "new password")
+	if !errors.Is(err, resetService.ErrInvalidToken) {
+		t.Fatalf("Reset() error = %v, want ErrInvalidToken", err)
+	}
+
+	if f.users.user.Password != "old hash" || len(f.auth.loggedOut) != 0 {
+		t.Fatal("an expired token changed the account")
+	}
+}
+
+func TestResetService_OtherTokensSpent(t *testing.T) {
+	f := newFixture()
+	first := f.request(t)
+	second := f.request(t)
+
+	if err := f.service.Reset(context.Background(), second, "new password"); err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+
+	err := f.service.Reset(context.Background(), first, "another password")
+	if !errors.Is(err, resetService.ErrInvalidToken) {
+		t.Fatalf("Reset() with an older token error = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestResetService_InvalidToken(t *testing.T) {
+	f := newFixture()
+
+	err := f.service.Reset(context.Background(), "not-a-token", "new password")
+	if !errors.Is(err, resetService.ErrInvalidToken) {
+		t.Fatalf("Reset() error = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestResetService_WeakPassword(t *testing.T) {
+	f := newFixture()
+	token := f.request(t)
+
+	err := f.service.Reset(context.Background(), token, "alice123")
+
+	var violation *policyService.ViolationError
+	if !errors.As(err, &violation) || violation.Violations[0].Rule != model.PasswordRulePersonal {
+		t.Fatalf("Reset() error = %v, want a personal_info violation", err)
+	}
+
+	if f.users.user.Password != "old hash" || len(f.auth.loggedOut) != 0 {
+		t.Fatal("a password breaking the policy changed the account")
+	}
+}
@@ -10,18 +10,127 @@ type UserService interface {
 	Create(ctx context.Context, info *model.UserInfo) (int64, error)
 	Get(ctx context.Context, id int64) (*model.User, error)
 	Delete(ctx context.Context, id int64) error
-	Update(ctx context.Context, info *model.User) error
+	// Update currentPassword of the actor is required when the email changes
+	Update(ctx context.Context, info *model.User, actor model.UserClaims, currentPassword string) error
 	GetByEmail(ctx context.Context, email string) (*model.User, error)
 	FindByName(ctx context.Context, name string) ([]*model.UserName, error)
+	Authenticate(ctx context.Context, email string, password string) (*model.User, error)
+	ChangePassword(ctx context.Context, userId int64, sessionId string, currentPassword string, newPassword string) error
 }
 
 type AuthService interface {
 	GetAccessToken(ctx context.Context, model model.UserJwt) (string, error)
 	GetRefreshToken(ctx context.Context, model model.UserJwt) (string, error)
+	StartSession(ctx context.Context, claims model.UserJwt) (*model.OAuthToken, error)
+	IssueMfaChallenge(ctx context.Context, claims model.UserJwt) (string, error)
+	VerifyMfaChallenge(ctx context.Context, token string) (model.UserClaims, error)
+	ConsumeMfaChallenge(ctx context.Context, claims model.UserClaims) error
+	RotateRefreshToken(ctx context.Context, token string) (string, error)
 	VerifyRefreshToken(ctx context.Context, token string) (model.UserClaims, error)
 	VerifyAccessToken(ctx context.Context, token string) (model.UserClaims, error)
+	Logout(ctx context.Context, claims model.UserClaims, refreshToken string) error
+	LogoutAll(ctx context.Context, userId int64) error
+	LogoutOthers(ctx context.Context, userId int64, sessionId string) error
+	GetJWKS(ctx context.Context) model.JWKS
+	Introspect(ctx context.Context, token string, tokenTypeHint string) model.Introspection
+	IssueClientToken(ctx context.Context, client *model.Client, scopes []string) (*model.OAuthToken, error)
+	IssueImpersonationToken(ctx context.Context, claims model.UserJwt) (*model.OAuthToken, error)
+	CreateAuthorizationCode(ctx context.Context, code *model.AuthorizationCode) (string, error)
+	ExchangeAuthorizationCode(ctx context.Context, client *model.Client, code, redirectUri, codeVerifier string) (*model.OAuthToken, error)
+	RefreshClientToken(ctx context.Context, client *model.Client, refreshToken string) (*model.OAuthToken, error)
+	ExchangeToken(ctx context.Context, client *model.Client, exchange model.TokenExchange) (*model.OAuthToken, error)
+	GetOpenIDConfiguration(ctx context.Context) model.OpenIDConfiguration
+}
+
+type DeviceService interface {
+	Authorize(ctx context.Context, client *model.Client, scopes []string) (*model.DeviceAuthorization, error)
+	Lookup(ctx context.Context, userCode string) (*model.DeviceCode, error)
+	Approve(ctx context.Context, userCode string, userId int64, amr []string) error
+	Deny(ctx context.Context, userCode string) error
+	Poll(ctx context.Context, client *model.Client, deviceCode string) (*model.OAuthToken, error)
+}
+
+type SessionService interface {
+	List(ctx context.Context, userId int64) ([]*model.Session, error)
+	Revoke(ctx context.Context, userId int64, sessionId string) error
+}
+
+type MfaService interface {
+	Enroll(ctx context.Context, userId int64) (*model.MfaEnrollment, error)
+	Confirm(ctx context.Context, userId int64, code string) ([]string, error)
+	Verify(ctx context.Context, userId int64, code string) error
+	Disable(ctx context.Context, userId int64, code string) error
+	IsEnabled(ctx context.Context, userId int64) (bool, error)
+}
+
+type WebAuthnService interface {
+	BeginRegistration(ctx context.Context, userId int64) (*model.WebAuthnCreationOptions, error)
+	FinishRegistration(ctx context.Context, userId int64, name string, response *model.WebAuthnRegistrationResponse) (*model.WebAuthnCredential, error)
+	BeginLogin(ctx context.Context, email string) (*model.WebAuthnRequestOptions, error)
+	FinishLogin(ctx context.Context, response *model.WebAuthnAssertionResponse) (*model.User, error)
+	List(ctx context.Context, userId int64) ([]*model.WebAuthnCredential, error)
+	Delete(ctx context.Context, userId int64, credentialId []byte) error
+}
+
+type LockoutService interface {
+	Check(ctx context.Context, email string, ip string) error
+	Fail(ctx context.Context, email string, ip string) error
+	Succeed(ctx context.Context, email string) error
+	Unlock(ctx context.Context, email string) error
+}
+
+type PasswordResetService interface {
+	Request(ctx context.Context, email string) error
+	Reset(ctx context.Context, token string, password string) error
+}
+
+type PasswordPolicyService interface {
+	// Check returns a *policy.ViolationError listing every rule the password breaks
+	Check(ctx context.Context, password string, email string, name string) error
+}
+
+type MagicLinkService interface {
+	Request(ctx context.Context, email string) (string, error)
+	Consume(ctx context.Context, token string, nonce string) (*model.User, error)
+}
+
+type VerificationService interface {
+	Send(ctx context.Context, userId int64, email string) error
+	ChangeEmail(ctx context.Context, userId int64, email string) error
+	Verify(ctx context.Context, token string) error
+	CheckLogin(user *model.User) error
+}
+
+type ImpersonationService interface {
+	Impersonate(ctx context.Context, actor model.UserClaims, userId int64) (*model.OAuthToken, error)
+}
+
+type ApiKeyService interface {
+	Create(ctx context.Context, userId int64, info *model.ApiKeyInfo) (*model.ApiKey, string, error)
+	List(ctx context.Context, userId int64) ([]*model.ApiKey, error)
+	Revoke(ctx context.Context, userId int64, id string) error
+	Verify(ctx context.Context, apiKey string, ip string) (model.UserClaims, error)
 }
 
 type AccessService interface {
-	HasAccessRight(ctx context.Context, endpoint string, role string) (bool, error)
+	HasAccessRight(ctx context.Context, endpoint string, claims model.UserClaims) (bool, error)
+}
+
+type KeyService interface {
+	Generate(ctx context.Context, alg string) (*model.SigningKey, error)
+	Promote(ctx context.Context, kid string) error
+	Retire(ctx context.Context, kid string) error
+	List(ctx context.Context) ([]*model.SigningKey, error)
+	Reload(ctx context.Context) error
+	Rotate(ctx context.Context) error
+}
+
+type ClientService interface {
+	Create(ctx context.Context, info *model.ClientInfo) (*model.Client, string, error)
+	Get(ctx context.Context, id string) (*model.Client, error)
+	List(ctx context.Context, team string) ([]*model.Client, error)
+	Update(ctx context.Context, client *model.Client) error
+	Delete(ctx context.Context, id string) error
+	Authenticate(ctx context.Context, clientId string, secret string) (*model.Client, error)
+	Identify(ctx context.Context, clientId string) (*model.Client, error)
 }
@@ -0,0 +1,42 @@
+package session
+
+import (
+	"context"
+
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+	"github.com/laiker/auth/internal/service"
+	"github.com/pkg/errors"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+type sessionService struct {
+	sessionRepo repository.SessionRepository
+	refreshRepo repository.RefreshTokenRepository
+}
+
+func NewService(sessionRepo repository.SessionRepository, refreshRepo repository.RefreshTokenRepository) service.SessionService {
+	return &sessionService{
+		sessionRepo: sessionRepo,
+		refreshRepo: refreshRepo,
+	}
+}
+
+// List active sessions of the user, the most recently used first
+func (s *sessionService) List(ctx context.Context, userId int64) ([]*model.Session, error) {
+	return s.sessionRepo.List(ctx, userId)
+}
+
+// Revoke ends a session of the user by revoking its token family,
+// access tokens issued for the session are rejected from now on
+func (s *sessionService) Revoke(ctx context.Context, userId int64, sessionId string) error {
+	session, err := s.sessionRepo.Get(ctx, sessionId)
+
+	// a session of someone else is reported as missing so ids can not be probed
+	if err != nil || session.UserId != userId {
+		return ErrSessionNotFound
+	}
+
+	return s.refreshRepo.RevokeFamily(ctx, session.Id)
+}
@@ -0,0 +1,71 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+	sessionService "github.com/laiker/auth/internal/service/session"
+	"github.com/pkg/errors"
+)
+
+// sessionRepo serves fixed sessions, only Get is used by Revoke
+type sessionRepo struct {
+	repository.SessionRepository
+	sessions map[string]*model.Session
+}
+
+func (r sessionRepo) Get(_ context.Context, id string) (*model.Session, error) {
+	if session, ok := r.sessions[id]; ok {
+		return session, nil
+	}
+
+	return nil, errors.New("session not found")
+}
+
+// refreshRepo records revoked families
+type refreshRepo struct {
+	repository.RefreshTokenRepository
+	revoked []string
+}
+
+func (r *refreshRepo) RevokeFamily(_ context.Context, familyId string) error {
+	r.revoked = append(r.revoked, familyId)
+
+	return nil
+}
+
+func Test_sessionService_Revoke(t *testing.T) {
+	sessions := sessionRepo{sessions: map[string]*model.Session{
+		"phone": {Id: "phone", UserId: 1},
+	}}
+
+	tests := []struct {
+		name        string
+		userId      int64
+		sessionId   string
+		wantErr     error
+		wantRevoked []string
+	}{
+		{name: "own session", userId: 1, sessionId: "phone", wantRevoked: []string{"phone"}},
+		{name: "session of another user", userId: 2, sessionId: "phone", wantErr: sessionService.ErrSessionNotFound},
+		{name: "unknown session", userId: 1, sessionId: "laptop", wantErr: sessionService.ErrSessionNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refresh := &refreshRepo{}
+			s := sessionService.NewService(sessions, refresh)
+
+			err := s.Revoke(context.Background(), tt.userId, tt.sessionId)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Revoke() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if len(refresh.revoked) != len(tt.wantRevoked) || len(tt.wantRevoked) > 0 && refresh.revoked[0] != tt.wantRevoked[0] {
+				t.Errorf("revoked families = %v, want %v", refresh.revoked, tt.wantRevoked)
+			}
+		})
+	}
+}
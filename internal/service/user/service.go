@@ -1,38 +1,90 @@
 package user
 
 import (
+	"sync"
+
 	"github.com/laiker/auth/client/db"
 	log "github.com/laiker/auth/internal/logger"
 	"github.com/laiker/auth/internal/logger/logger"
 	"github.com/laiker/auth/internal/model"
 	"github.com/laiker/auth/internal/repository"
 	"github.com/laiker/auth/internal/service"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/laiker/auth/internal/utils"
+	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 )
 
+var (
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrWrongPassword      = errors.New("current password is wrong")
+	// ErrUpdateDenied the account belongs to another user the actor does not manage
+	ErrUpdateDenied = errors.New("users can only update their own account")
+)
+
+// manageUsersResource the permission a role needs to update accounts of other users
+const manageUsersResource = "/user_v1.UserV1/Update/others"
+
 type serv struct {
-	repo      repository.UserRepository
-	txManager db.TxManager
-	logger    logger.DBLogger
+	repo         repository.UserRepository
+	txManager    db.TxManager
+	logger       logger.DBLoggerInterface
+	verification service.VerificationService
+	hasher       utils.PasswordHasher
+	policy       service.PasswordPolicyService
+	auth         service.AuthService
+	lockout      service.LockoutService
+	access       repository.AccessRepository
+	// dummyHash checked for unknown emails, so they take as long as a wrong password
+	dummyHash func() string
 }
 
-func NewService(repo repository.UserRepository, manager db.TxManager, logger logger.DBLogger) service.UserService {
-	return &serv{repo: repo, txManager: manager, logger: logger}
+func NewService(
+	repo repository.UserRepository,
+	manager db.TxManager,
+	logger logger.DBLoggerInterface,
+	verification service.VerificationService,
+	hasher utils.PasswordHasher,
+	policy service.PasswordPolicyService,
+	auth service.AuthService,
+	lockout service.LockoutService,
+	access repository.AccessRepository,
+) service.UserService {
+	return &serv{
+		repo:         repo,
+		txManager:    manager,
+		logger:       logger,
+		verification: verification,
+		hasher:       hasher,
+		policy:       policy,
+		auth:         auth,
+		lockout:      lockout,
+		access:       access,
+		dummyHash: sync.OnceValue(func() string {
+			hash, _ := hasher.Hash("dummy password")
+
+			return hash
+		}),
+	}
 }
 
 func (s *serv) Create(ctx context.Context, userInfo *model.UserInfo) (int64, error) {
 	var id int64
 
-	err := s.txManager.ReadCommitted(ctx, func(ctx context.Context) error {
+	err := s.policy.Check(ctx, userInfo.Password, userInfo.Email, userInfo.Name)
+
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.txManager.ReadCommitted(ctx, func(ctx context.Context) error {
 		var errTx error
-		pw, err := bcrypt.GenerateFromPassword([]byte(userInfo.Password), bcrypt.DefaultCost)
+		pw, err := s.hasher.Hash(userInfo.Password)
 
 		if err != nil {
 			return err
 		}
 
-		userInfo.Password = string(pw)
+		userInfo.Password = pw
 
 		id, errTx = s.repo.Create(ctx, userInfo)
 
@@ -51,7 +103,7 @@ func (s *serv) Create(ctx context.Context, userInfo *model.UserInfo) (int64, err
 			return errTx
 		}
 
-		return nil
+		return s.verification.Send(ctx, id, userInfo.Email)
 	})
 
 	if err != nil {
@@ -69,14 +121,212 @@ func (s *serv) GetByEmail(ctx context.Context, email string) (*model.User, error
 	return s.repo.GetByEmail(ctx, email)
 }
 
+// Authenticate checks the password, unknown emails and wrong passwords are not distinguished.
+// A hash of an outdated algorithm or cost is replaced while the password is at hand.
+func (s *serv) Authenticate(ctx context.Context, email string, password string) (*model.User, error) {
+	user, err := s.repo.GetByEmail(ctx, email)
+
+	if err != nil {
+		s.hasher.Verify(s.dummyHash(), password)
+		return nil, ErrInvalidCredentials
+	}
+
+	ok, rehash := s.hasher.Verify(user.Password, password)
+
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	if rehash {
+		s.rehash(ctx, user, password)
+	}
+
+	if err = s.verification.CheckLogin(user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
 func (s *serv) Delete(ctx context.Context, id int64) error {
 	return s.repo.Delete(ctx, id)
 }
 
-func (s *serv) Update(ctx context.Context, info *model.User) error {
-	return s.repo.Update(ctx, info)
+// Update changes the name right away, a new email stays pending until it is verified.
+// The email is enough to take the account over, changing it needs the current password of the caller.
+// Users update their own account, accounts of other users need a role with the permission
+// to manage them and a priority not below the role of the owner. Every update is written to the audit log.
+func (s *serv) Update(ctx context.Context, info *model.User, actor model.UserClaims, currentPassword string) error {
+	if actor.UserId != info.Id {
+		err := s.checkManages(ctx, actor, info.Id)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(info.Email) > 0 {
+		_, err := s.checkPassword(ctx, actor.UserId, currentPassword)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.txManager.ReadCommitted(ctx, func(ctx context.Context) error {
+		errTx := s.repo.Update(ctx, info)
+
+		if errTx != nil {
+			return errTx
+		}
+
+		data := log.LogData{
+			Name:     "update user",
+			EntityID: info.Id,
+		}
+
+		if actor.UserId != info.Id {
+			data.ActorID = actor.UserId
+		}
+
+		errTx = s.logger.Log(ctx, data)
+
+		if errTx != nil || len(info.Email) == 0 {
+			return errTx
+		}
+
+		return s.verification.ChangeEmail(ctx, info.Id, info.Email)
+	})
 }
 
 func (s *serv) FindByName(ctx context.Context, name string) ([]*model.UserName, error) {
 	return s.repo.FindByName(ctx, name)
 }
+
+// ChangePassword replaces the password after checking the current one and ends the other sessions
+// of the user, the session the change is made from stays signed in
+func (s *serv) ChangePassword(ctx context.Context, userId int64, sessionId string, currentPassword string, newPassword string) error {
+	user, err := s.checkPassword(ctx, userId, currentPassword)
+
+	if err != nil {
+		return err
+	}
+
+	err = s.policy.Check(ctx, newPassword, user.Email, user.Name)
+
+	if err != nil {
+		return err
+	}
+
+	passwordHash, err := s.hasher.Hash(newPassword)
+
+	if err != nil {
+		return err
+	}
+
+	return s.txManager.ReadCommitted(ctx, func(ctx context.Context) error {
+		errTx := s.repo.UpdatePassword(ctx, userId, passwordHash)
+
+		if errTx != nil {
+			return errTx
+		}
+
+		errTx = s.logger.Log(ctx, log.LogData{
+			Name:     "change password",
+			EntityID: userId,
+		})
+
+		if errTx != nil {
+			return errTx
+		}
+
+		return s.auth.LogoutOthers(ctx, userId, sessionId)
+	})
+}
+
+// checkManages ErrUpdateDenied unless the role of the actor may manage the account of the user
+func (s *serv) checkManages(ctx context.Context, actor model.UserClaims, userId int64) error {
+	permission, err := s.access.GetEndpointPermission(ctx, manageUsersResource)
+
+	if err != nil {
+		return err
+	}
+
+	// without the permission nobody manages other accounts
+	if permission.Id == 0 {
+		return ErrUpdateDenied
+	}
+
+	user, err := s.repo.Get(ctx, userId)
+
+	if err != nil {
+		return err
+	}
+
+	actorRole, err := s.access.GetRole(ctx, actor.Role)
+
+	if err != nil {
+		return err
+	}
+
+	userRole, err := s.access.GetRole(ctx, user.Role)
+
+	if err != nil {
+		return err
+	}
+
+	if actorRole.Priority < permission.MinPriority || actorRole.Priority < userRole.Priority {
+		return ErrUpdateDenied
+	}
+
+	return nil
+}
+
+// checkPassword the user whose password it is, ErrWrongPassword otherwise. Wrong passwords count
+// towards the lockout of the login, so a stolen access token can not be used to guess the password.
+func (s *serv) checkPassword(ctx context.Context, userId int64, password string) (*model.User, error) {
+	user, err := s.repo.Get(ctx, userId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ip := utils.DeviceFromContext(ctx).Ip
+
+	err = s.lockout.Check(ctx, user.Email, ip)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if ok, _ := s.hasher.Verify(user.Password, password); !ok {
+		err = s.lockout.Fail(ctx, user.Email, ip)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, ErrWrongPassword
+	}
+
+	err = s.lockout.Succeed(ctx, user.Email)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// rehash failures do not fail the login, the repository logs them and the old hash keeps working
+func (s *serv) rehash(ctx context.Context, user *model.User, password string) {
+	hash, err := s.hasher.Hash(password)
+
+	if err != nil {
+		return
+	}
+
+	if s.repo.UpdatePassword(ctx, user.Id, hash) == nil {
+		user.Password = hash
+	}
+}
@@ -2,6 +2,7 @@ package test
 
 import (
 	"database/sql"
+	"strings"
 	"testing"
 	"time"
 
@@ -11,21 +12,39 @@ import (
 	"github.com/laiker/auth/internal/logger/logger"
 	"github.com/laiker/auth/internal/model"
 	"github.com/laiker/auth/internal/repository"
+	"github.com/laiker/auth/internal/service"
+	lockoutService "github.com/laiker/auth/internal/service/lockout"
+	policyService "github.com/laiker/auth/internal/service/policy"
 	serv "github.com/laiker/auth/internal/service/user"
+	"github.com/laiker/auth/internal/utils"
 	. "github.com/ovechkin-dm/mockio/mock"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/net/context"
 )
 
 type fields struct {
-	repo      repository.UserRepository
-	txManager db.TxManager
-	logger    logger.DBLoggerInterface
+	repo         repository.UserRepository
+	txManager    db.TxManager
+	logger       logger.DBLoggerInterface
+	verification service.VerificationService
+	hasher       utils.PasswordHasher
+	policy       service.PasswordPolicyService
+	auth         service.AuthService
+	lockout      service.LockoutService
+	access       repository.AccessRepository
 }
 
 type TestDependencies struct {
 	UserRepositoryMock repository.UserRepository
 	txManagerMock      db.TxManager
 	loggerMock         logger.DBLoggerInterface
+	verificationMock   service.VerificationService
+	hasherMock         utils.PasswordHasher
+	policyMock         service.PasswordPolicyService
+	authMock           service.AuthService
+	lockoutMock        service.LockoutService
+	accessMock         repository.AccessRepository
 	contextMock        context.Context
 }
 
@@ -35,11 +54,23 @@ func SetupServiceTest(t *testing.T) *TestDependencies {
 	r := Mock[repository.UserRepository]()
 	tx := Mock[db.TxManager]()
 	dblogger := Mock[logger.DBLoggerInterface]()
+	verification := Mock[service.VerificationService]()
+	hasher := Mock[utils.PasswordHasher]()
+	policy := Mock[service.PasswordPolicyService]()
+	auth := Mock[service.AuthService]()
+	lockout := Mock[service.LockoutService]()
+	access := Mock[repository.AccessRepository]()
 
 	deps := &TestDependencies{
 		UserRepositoryMock: r,
 		txManagerMock:      tx,
 		loggerMock:         dblogger,
+		verificationMock:   verification,
+		hasherMock:         hasher,
+		policyMock:         policy,
+		authMock:           auth,
+		lockoutMock:        lockout,
+		accessMock:         access,
 		contextMock:        context.Background(),
 	}
 
@@ -102,15 +133,21 @@ func Test_serv_Create(t *testing.T) {
 			wantErr: false,
 			args:    a,
 			fields: fields{
-				repo:      deps.UserRepositoryMock,
-				txManager: deps.txManagerMock,
-				logger:    deps.loggerMock,
+				repo:         deps.UserRepositoryMock,
+				txManager:    deps.txManagerMock,
+				logger:       deps.loggerMock,
+				verification: deps.verificationMock,
+				hasher:       deps.hasherMock,
+				policy:       deps.policyMock,
+				auth:         deps.authMock,
+				lockout:      deps.lockoutMock,
+				access:       deps.accessMock,
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := serv.NewService(tt.fields.repo, tt.fields.txManager, tt.fields.logger)
+			s := serv.NewService(tt.fields.repo, tt.fields.txManager, tt.fields.logger, tt.fields.verification, tt.fields.hasher, tt.fields.policy, tt.fields.auth, tt.fields.lockout, tt.fields.access)
 			_, err := s.Create(tt.args.ctx, tt.args.userInfo)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Create() error = %v, wantErr %v", err, tt.wantErr)
@@ -147,16 +184,22 @@ func Test_serv_Delete(t *testing.T) {
 			wantErr: false,
 			args:    a,
 			fields: fields{
-				repo:      deps.UserRepositoryMock,
-				txManager: deps.txManagerMock,
-				logger:    deps.loggerMock,
+				repo:         deps.UserRepositoryMock,
+				txManager:    deps.txManagerMock,
+				logger:       deps.loggerMock,
+				verification: deps.verificationMock,
+				hasher:       deps.hasherMock,
+				policy:       deps.policyMock,
+				auth:         deps.authMock,
+				lockout:      deps.lockoutMock,
+				access:       deps.accessMock,
 			},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := serv.NewService(tt.fields.repo, tt.fields.txManager, tt.fields.logger)
+			s := serv.NewService(tt.fields.repo, tt.fields.txManager, tt.fields.logger, tt.fields.verification, tt.fields.hasher, tt.fields.policy, tt.fields.auth, tt.fields.lockout, tt.fields.access)
 			if err := s.Delete(tt.args.ctx, tt.args.id); (err != nil) != tt.wantErr {
 				t.Errorf("Delete() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -205,16 +248,22 @@ func Test_serv_Get(t *testing.T) {
 			wantErr: false,
 			args:    a,
 			fields: fields{
-				repo:      deps.UserRepositoryMock,
-				txManager: deps.txManagerMock,
-				logger:    deps.loggerMock,
+				repo:         deps.UserRepositoryMock,
+				txManager:    deps.txManagerMock,
+				logger:       deps.loggerMock,
+				verification: deps.verificationMock,
+				hasher:       deps.hasherMock,
+				policy:       deps.policyMock,
+				auth:         deps.authMock,
+				lockout:      deps.lockoutMock,
+				access:       deps.accessMock,
 			},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := serv.NewService(tt.fields.repo, tt.fields.txManager, tt.fields.logger)
+			s := serv.NewService(tt.fields.repo, tt.fields.txManager, tt.fields.logger, tt.fields.verification, tt.fields.hasher, tt.fields.policy, tt.fields.auth, tt.fields.lockout, tt.fields.access)
 			got, err := s.Get(tt.args.ctx, tt.args.id)
 
 			if (err != nil) != tt.wantErr {
@@ -228,61 +277,426 @@ func Test_serv_Get(t *testing.T) {
 	}
 }
 
-func Test_serv_Update(t *testing.T) {
-	deps := SetupServiceTest(t)
+// passwordRepo serves a single user and records password updates
+type passwordRepo struct {
+	repository.UserRepository
+	user    *model.User
+	updated []string
+}
 
-	name := gofakeit.Name()
-	email := gofakeit.Email()
+func (r *passwordRepo) Get(_ context.Context, id int64) (*model.User, error) {
+	if id != r.user.Id {
+		return nil, errors.New("Пользователь не найден")
+	}
 
-	mi := &model.User{
-		Id:        1,
-		Name:      name,
-		Email:     email,
-		Role:      "USER",
-		UpdatedAt: sql.NullTime{},
-		CreatedAt: time.Time{},
+	return r.user, nil
+}
+
+func (r *passwordRepo) GetByEmail(_ context.Context, email string) (*model.User, error) {
+	if email != r.user.Email {
+		return nil, errors.New("Пользователь не найден")
 	}
 
-	When(deps.UserRepositoryMock.Update(deps.contextMock, mi)).ThenReturn(nil)
+	return r.user, nil
+}
 
-	type args struct {
-		ctx       context.Context
-		modelUser *model.User
+func (r *passwordRepo) Update(_ context.Context, info *model.User) error {
+	r.user.Name = info.Name
+
+	return nil
+}
+
+func (r *passwordRepo) UpdatePassword(_ context.Context, _ int64, passwordHash string) error {
+	r.updated = append(r.updated, passwordHash)
+	r.user.Password = passwordHash
+
+	return nil
+}
+
+func Test_serv_AuthenticateRehash(t *testing.T) {
+	legacy, _ := utils.NewPasswordHasher(utils.PasswordBcrypt, utils.Argon2Params{}, bcrypt.MinCost)
+	current, _ := utils.NewPasswordHasher(utils.PasswordArgon2id, utils.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1}, bcrypt.MinCost)
+
+	oldHash, _ := legacy.Hash("secret")
+	repo := &passwordRepo{user: &model.User{Id: 1, Email: "alice@example.com", Password: oldHash}}
+
+	s := serv.NewService(repo, nil, nil, Mock[service.VerificationService](), current, Mock[service.PasswordPolicyService](), Mock[service.AuthService](), newLockout(), Mock[repository.AccessRepository]())
+
+	if _, err := s.Authenticate(context.Background(), "alice@example.com", "wrong"); err == nil {
+		t.Fatal("a wrong password was accepted")
 	}
 
-	a := args{
-		ctx:       deps.contextMock,
-		modelUser: mi,
+	if len(repo.updated) != 0 {
+		t.Fatal("a wrong password replaced the hash")
+	}
+
+	user, err := s.Authenticate(context.Background(), "alice@example.com", "secret")
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+
+	if len(repo.updated) != 1 || !strings.HasPrefix(repo.updated[0], "$argon2id$") || user.Password != repo.updated[0] {
+		t.Fatalf("updated hashes %q", repo.updated)
+	}
+
+	if ok, rehash := current.Verify(repo.updated[0], "secret"); !ok || rehash {
+		t.Fatalf("Verify() of the new hash = %v, %v", ok, rehash)
+	}
+
+	if _, err = s.Authenticate(context.Background(), "alice@example.com", "secret"); err != nil || len(repo.updated) != 1 {
+		t.Fatalf("an up to date hash was replaced, error = %v", err)
+	}
+}
+
+type txManager struct{}
+
+func (txManager) ReadCommitted(ctx context.Context, f db.Handler) error {
+	return f(ctx)
+}
+
+type dbLogger struct {
+	entries []log.LogData
+}
+
+func (l *dbLogger) Log(_ context.Context, data log.LogData) error {
+	l.entries = append(l.entries, data)
+	return nil
+}
+
+type policyConfig struct{}
+
+func (policyConfig) GetMinLength() int         { return 8 }
+func (policyConfig) GetMaxLength() int         { return 64 }
+func (policyConfig) GetMinClasses() int        { return 1 }
+func (policyConfig) GetDisallowPersonal() bool { return true }
+func (policyConfig) GetPwnedPath() string      { return "" }
+
+// authService records the sessions kept by LogoutOthers
+type authService struct {
+	service.AuthService
+	kept map[int64]string
+}
+
+func (s *authService) LogoutOthers(_ context.Context, userId int64, sessionId string) error {
+	s.kept[userId] = sessionId
+	return nil
+}
+
+// lockout locks an email after three failures in a row
+type lockout struct {
+	service.LockoutService
+	failures map[string]int
+}
+
+func newLockout() *lockout {
+	return &lockout{failures: map[string]int{}}
+}
+
+func (l *lockout) Check(_ context.Context, email string, _ string) error {
+	if l.failures[email] >= 3 {
+		return lockoutService.ErrLocked
 	}
 
+	return nil
+}
+
+func (l *lockout) Fail(_ context.Context, email string, _ string) error {
+	l.failures[email]++
+	return nil
+}
+
+func (l *lockout) Succeed(_ context.Context, email string) error {
+	delete(l.failures, email)
+	return nil
+}
+
+func Test_serv_ChangePassword(t *testing.T) {
+	hasher, _ := utils.NewPasswordHasher(utils.PasswordArgon2id, utils.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1}, bcrypt.MinCost)
+	oldHash, _ := hasher.Hash("old secret")
+
 	tests := []struct {
-		name    string
-		want    *model.User
-		fields  fields
-		args    args
-		wantErr bool
+		name            string
+		currentPassword string
+		newPassword     string
+		wantErr         func(error) bool
 	}{
 		{
-			name:    "Success Test",
-			wantErr: false,
-			args:    a,
-			fields: fields{
-				repo:      deps.UserRepositoryMock,
-				txManager: deps.txManagerMock,
-				logger:    deps.loggerMock,
+			name:            "wrong current password",
+			currentPassword: "not the secret",
+			newPassword:     "brand new secret",
+			wantErr:         func(err error) bool { return errors.Is(err, serv.ErrWrongPassword) },
+		},
+		{
+			name:            "new password breaks the policy",
+			currentPassword: "old secret",
+			newPassword:     "short",
+			wantErr: func(err error) bool {
+				var violation *policyService.ViolationError
+				return errors.As(err, &violation)
 			},
 		},
+		{
+			name:            "success",
+			currentPassword: "old secret",
+			newPassword:     "brand new secret",
+			wantErr:         func(err error) bool { return err == nil },
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := serv.NewService(tt.fields.repo, tt.fields.txManager, tt.fields.logger)
-			err := s.Update(tt.args.ctx, tt.args.modelUser)
+			repo := &passwordRepo{user: &model.User{Id: 1, Name: "alice", Email: "alice@example.com", Password: oldHash}}
+			audit := &dbLogger{}
+			auth := &authService{kept: map[int64]string{}}
 
-			if (err != nil) != tt.wantErr {
-				t.Errorf("Update() error = %v, wantErr %v", err, tt.wantErr)
+			s := serv.NewService(repo, txManager{}, audit, Mock[service.VerificationService](), hasher,
+				policyService.NewService(policyConfig{}, nil), auth, newLockout(), Mock[repository.AccessRepository]())
+
+			err := s.ChangePassword(context.Background(), 1, "current-session", tt.currentPassword, tt.newPassword)
+			if !tt.wantErr(err) {
+				t.Fatalf("ChangePassword() error = %v", err)
+			}
+
+			if err != nil {
+				if len(repo.updated) != 0 || len(audit.entries) != 0 || len(auth.kept) != 0 {
+					t.Fatal("a refused change touched the user")
+				}
+
+				return
+			}
+
+			if ok, _ := hasher.Verify(repo.user.Password, tt.newPassword); !ok {
+				t.Fatal("the new password does not match the stored hash")
+			}
+
+			if len(audit.entries) != 1 || audit.entries[0].EntityID != 1 {
+				t.Fatalf("audit entries %+v", audit.entries)
 			}
 
+			if auth.kept[1] != "current-session" {
+				t.Fatalf("kept sessions %v, want the current one", auth.kept)
+			}
+		})
+	}
+}
+
+// the current password is guessed no faster than the password of the login
+func Test_serv_ChangePasswordLockout(t *testing.T) {
+	hasher, _ := utils.NewPasswordHasher(utils.PasswordArgon2id, utils.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1}, bcrypt.MinCost)
+	oldHash, _ := hasher.Hash("old secret")
+
+	repo := &passwordRepo{user: &model.User{Id: 1, Name: "alice", Email: "alice@example.com", Password: oldHash}}
+	locks := newLockout()
+
+	s := serv.NewService(repo, txManager{}, &dbLogger{}, Mock[service.VerificationService](), hasher,
+		policyService.NewService(policyConfig{}, nil), &authService{kept: map[int64]string{}}, locks, Mock[repository.AccessRepository]())
+
+	ctx := context.Background()
+
+	// a success starts the count over
+	if err := s.ChangePassword(ctx, 1, "session", "guess", "brand new secret"); !errors.Is(err, serv.ErrWrongPassword) {
+		t.Fatalf("ChangePassword() error = %v, want %v", err, serv.ErrWrongPassword)
+	}
+
+	if err := s.ChangePassword(ctx, 1, "session", "old secret", "brand new secret"); err != nil {
+		t.Fatalf("ChangePassword() error = %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := s.ChangePassword(ctx, 1, "session", "guess", "another new secret"); !errors.Is(err, serv.ErrWrongPassword) {
+			t.Fatalf("ChangePassword() attempt %d error = %v, want %v", i, err, serv.ErrWrongPassword)
+		}
+	}
+
+	if locks.failures["alice@example.com"] != 3 {
+		t.Fatalf("failures %v, want the wrong passwords counted", locks.failures)
+	}
+
+	// even the right password is refused while the account is locked
+	if err := s.ChangePassword(ctx, 1, "session", "brand new secret", "another new secret"); !errors.Is(err, lockoutService.ErrLocked) {
+		t.Fatalf("ChangePassword() of a locked account error = %v, want %v", err, lockoutService.ErrLocked)
+	}
+
+	if err := s.Update(ctx, &model.User{Id: 1, Email: "alice@work.example.com"}, model.UserClaims{UserId: 1, Role: "user"}, "brand new secret"); !errors.Is(err, lockoutService.ErrLocked) {
+		t.Fatalf("Update() of a locked account error = %v, want %v", err, lockoutService.ErrLocked)
+	}
+}
+
+// verification records the emails waiting for confirmation
+type verification struct {
+	service.VerificationService
+	pending []string
+}
+
+func (v *verification) ChangeEmail(_ context.Context, _ int64, email string) error {
+	v.pending = append(v.pending, email)
+	return nil
+}
+
+func Test_serv_Update(t *testing.T) {
+	hasher, _ := utils.NewPasswordHasher(utils.PasswordArgon2id, utils.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1}, bcrypt.MinCost)
+	hash, _ := hasher.Hash("secret")
+
+	tests := []struct {
+		name            string
+		info            *model.User
+		currentPassword string
+		wantErr         error
+		wantPending     int
+	}{
+		{
+			name: "name only",
+			info: &model.User{Id: 1, Name: "alice"},
+		},
+		{
+			name:            "email with the password",
+			info:            &model.User{Id: 1, Name: "alice", Email: "alice@work.example.com"},
+			currentPassword: "secret",
+			wantPending:     1,
+		},
+		{
+			name:            "email with a wrong password",
+			info:            &model.User{Id: 1, Name: "mallory", Email: "mallory@example.com"},
+			currentPassword: "guess",
+			wantErr:         serv.ErrWrongPassword,
+		},
+		{
+			name:    "email without the password",
+			info:    &model.User{Id: 1, Name: "mallory", Email: "mallory@example.com"},
+			wantErr: serv.ErrWrongPassword,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &passwordRepo{user: &model.User{Id: 1, Name: "al", Email: "alice@example.com", Password: hash}}
+			emails := &verification{}
+
+			s := serv.NewService(repo, txManager{}, &dbLogger{}, emails, hasher, Mock[service.PasswordPolicyService](), Mock[service.AuthService](), newLockout(), Mock[repository.AccessRepository]())
+
+			err := s.Update(context.Background(), tt.info, model.UserClaims{UserId: 1, Role: "user"}, tt.currentPassword)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Update() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if len(emails.pending) != tt.wantPending {
+				t.Fatalf("pending emails %q", emails.pending)
+			}
+
+			if err != nil && repo.user.Name != "al" {
+				t.Fatal("a refused update changed the user")
+			}
+		})
+	}
+}
+
+// usersRepo serves a fixed set of users and records the updated ones
+type usersRepo struct {
+	repository.UserRepository
+	users   map[int64]*model.User
+	updated []int64
+}
+
+func (r *usersRepo) Get(_ context.Context, id int64) (*model.User, error) {
+	if user, ok := r.users[id]; ok {
+		return user, nil
+	}
+
+	return nil, errors.New("Пользователь не найден")
+}
+
+func (r *usersRepo) Update(_ context.Context, info *model.User) error {
+	r.updated = append(r.updated, info.Id)
+	return nil
+}
+
+// accessRepo unknown roles and resources come back empty like the Postgres one
+type accessRepo struct {
+	repository.AccessRepository
+	manageUsers int64
+}
+
+func (r accessRepo) GetEndpointPermission(_ context.Context, endpoint string) (*model.Permission, error) {
+	if endpoint != "/user_v1.UserV1/Update/others" || r.manageUsers == 0 {
+		return &model.Permission{}, nil
+	}
+
+	return &model.Permission{Id: 23, Endpoint: endpoint, MinPriority: r.manageUsers}, nil
+}
+
+func (accessRepo) GetRole(_ context.Context, role string) (*model.Role, error) {
+	roles := map[string]*model.Role{
+		"user":    {Id: 1, Name: "user", Priority: 10},
+		"support": {Id: 3, Name: "support", Priority: 50},
+		"admin":   {Id: 2, Name: "admin", Priority: 100},
+	}
+
+	if r, ok := roles[role]; ok {
+		return r, nil
+	}
+
+	return &model.Role{}, nil
+}
+
+func Test_serv_UpdateOtherAccount(t *testing.T) {
+	users := map[int64]*model.User{
+		1: {Id: 1, Name: "root", Role: "admin"},
+		2: {Id: 2, Name: "carol", Role: "admin"},
+		3: {Id: 3, Name: "dave", Role: "support"},
+		7: {Id: 7, Name: "alice", Role: "user"},
+		8: {Id: 8, Name: "bob", Role: "user"},
+	}
+
+	tests := []struct {
+		name        string
+		actor       model.UserClaims
+		userId      int64
+		manageUsers int64
+		wantErr     error
+	}{
+		{name: "own account", actor: model.UserClaims{UserId: 7, Role: "user"}, userId: 7, manageUsers: 50},
+		{name: "user of the same role", actor: model.UserClaims{UserId: 7, Role: "user"}, userId: 8, manageUsers: 50, wantErr: serv.ErrUpdateDenied},
+		{name: "lower role", actor: model.UserClaims{UserId: 3, Role: "support"}, userId: 7, manageUsers: 50},
+		{name: "higher role", actor: model.UserClaims{UserId: 3, Role: "support"}, userId: 2, manageUsers: 50, wantErr: serv.ErrUpdateDenied},
+		{name: "same role managing users", actor: model.UserClaims{UserId: 1, Role: "admin"}, userId: 2, manageUsers: 50},
+		{name: "role below the permission", actor: model.UserClaims{UserId: 3, Role: "support"}, userId: 7, manageUsers: 100, wantErr: serv.ErrUpdateDenied},
+		{name: "without the permission", actor: model.UserClaims{UserId: 1, Role: "admin"}, userId: 7, wantErr: serv.ErrUpdateDenied},
+		{name: "unknown role", actor: model.UserClaims{UserId: 1, Role: "guest"}, userId: 7, manageUsers: 50, wantErr: serv.ErrUpdateDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &usersRepo{users: users}
+			audit := &dbLogger{}
+
+			s := serv.NewService(repo, txManager{}, audit, &verification{}, nil, Mock[service.PasswordPolicyService](), Mock[service.AuthService](), newLockout(), accessRepo{manageUsers: tt.manageUsers})
+
+			err := s.Update(context.Background(), &model.User{Id: tt.userId, Name: "renamed"}, tt.actor, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Update() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if updated := len(repo.updated) == 1; updated != (tt.wantErr == nil) {
+				t.Fatalf("updated %v", repo.updated)
+			}
+
+			if tt.wantErr != nil {
+				if len(audit.entries) != 0 {
+					t.Fatalf("a refused update was logged: %+v", audit.entries)
+				}
+
+				return
+			}
+
+			// the actor is recorded when it is not the owner of the account
+			want := log.LogData{Name: "update user", EntityID: tt.userId}
+			if tt.actor.UserId != tt.userId {
+				want.ActorID = tt.actor.UserId
+			}
+
+			if len(audit.entries) != 1 || audit.entries[0] != want {
+				t.Fatalf("audit log %+v, want %+v", audit.entries, want)
+			}
 		})
 	}
 }
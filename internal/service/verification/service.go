@@ -0,0 +1,189 @@
+package verification
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/laiker/auth/client/db"
+	"github.com/laiker/auth/client/mail"
+	"github.com/laiker/auth/internal/config"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+	"github.com/laiker/auth/internal/service"
+	"github.com/laiker/auth/internal/utils"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidToken     = errors.New("email verification token is invalid or expired")
+	ErrEmailNotVerified = errors.New("email is not verified")
+	ErrEmailTaken       = errors.New("email is used by another account")
+)
+
+const (
+	signupBody = `Welcome! Confirm your email address by following the link, it is valid for %s:
+
+%s
+`
+	changeBody = `Someone asked to use this address for their account.
+
+Follow the link to confirm the change, it is valid for %s:
+
+%s
+
+If it was not you, ignore this mail.
+`
+)
+
+type verificationService struct {
+	userRepo  repository.UserRepository
+	tokenRepo repository.UserTokenRepository
+	mailer    mail.Mailer
+	txManager db.TxManager
+	url       string
+	tokenTTL  time.Duration
+	required  bool
+	now       func() time.Time
+}
+
+// NewService now is the clock verification tokens expire by, time.Now outside of tests
+func NewService(
+	config config.VerificationConfig,
+	userRepo repository.UserRepository,
+	tokenRepo repository.UserTokenRepository,
+	mailer mail.Mailer,
+	txManager db.TxManager,
+	now func() time.Time,
+) service.VerificationService {
+	return &verificationService{
+		userRepo:  userRepo,
+		tokenRepo: tokenRepo,
+		mailer:    mailer,
+		txManager: txManager,
+		url:       config.GetURL(),
+		tokenTTL:  config.GetTokenTTL(),
+		required:  config.GetRequired(),
+		now:       now,
+	}
+}
+
+// Send mails a link confirming the address of a new user
+func (s *verificationService) Send(ctx context.Context, userId int64, email string) error {
+	return s.send(ctx, userId, email, "Confirm your email", signupBody)
+}
+
+// ChangeEmail keeps the new address pending and mails a link to it, the current address stays in use
+// until the link is followed. Changing back to the current address cancels a pending change.
+func (s *verificationService) ChangeEmail(ctx context.Context, userId int64, email string) error {
+	user, err := s.userRepo.Get(ctx, userId)
+
+	if err != nil {
+		return err
+	}
+
+	if strings.EqualFold(user.Email, email) {
+		if !user.PendingEmail.Valid {
+			return nil
+		}
+
+		return s.userRepo.SetPendingEmail(ctx, userId, "")
+	}
+
+	if _, err = s.userRepo.GetByEmail(ctx, email); err == nil {
+		return ErrEmailTaken
+	}
+
+	err = s.txManager.ReadCommitted(ctx, func(ctx context.Context) error {
+		errTx := s.userRepo.SetPendingEmail(ctx, userId, email)
+
+		if errTx != nil {
+			return errTx
+		}
+
+		// links mailed for an earlier change stop working
+		errTx = s.tokenRepo.UseAll(ctx, userId, model.UserTokenEmailVerification, s.now())
+
+		if errTx != nil {
+			return errTx
+		}
+
+		return s.send(ctx, userId, email, "Confirm your new email", changeBody)
+	})
+
+	return err
+}
+
+// Verify confirms the address the token was mailed to, the email of a new user or a pending new email
+func (s *verificationService) Verify(ctx context.Context, token string) error {
+	return s.txManager.ReadCommitted(ctx, func(ctx context.Context) error {
+		userToken, errTx := s.tokenRepo.Use(ctx, utils.HashToken(token), model.UserTokenEmailVerification, s.now())
+
+		if errors.Is(errTx, repository.ErrUserTokenNotFound) {
+			return ErrInvalidToken
+		}
+
+		if errTx != nil {
+			return errTx
+		}
+
+		// the change was cancelled or replaced after the link was mailed
+		verified, errTx := s.userRepo.VerifyEmail(ctx, userToken.UserId, userToken.Email.String)
+
+		if errTx != nil {
+			return errTx
+		}
+
+		if !verified {
+			return ErrInvalidToken
+		}
+
+		return nil
+	})
+}
+
+// CheckLogin refuses users with an unverified email when verification is required
+func (s *verificationService) CheckLogin(user *model.User) error {
+	if s.required && !user.EmailVerified {
+		return ErrEmailNotVerified
+	}
+
+	return nil
+}
+
+func (s *verificationService) send(ctx context.Context, userId int64, email string, subject string, body string) error {
+	token, err := utils.RandomToken()
+
+	if err != nil {
+		return err
+	}
+
+	now := s.now()
+
+	err = s.tokenRepo.Create(ctx, &model.UserToken{
+		TokenHash: utils.HashToken(token),
+		UserId:    userId,
+		Purpose:   model.UserTokenEmailVerification,
+		Email:     sql.NullString{String: email, Valid: true},
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.tokenTTL),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	link, err := utils.TokenLink(s.url, token)
+
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, &mail.Message{
+		To:      email,
+		Subject: subject,
+		Body:    fmt.Sprintf(body, s.tokenTTL, link),
+	})
+}
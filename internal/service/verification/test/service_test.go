@@ -0,0 +1,348 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/laiker/auth/client/db"
+	"github.com/laiker/auth/client/mail"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+	"github.com/laiker/auth/internal/service"
+	verificationService "github.com/laiker/auth/internal/service/verification"
+	"github.com/pkg/errors"
+)
+
+const (
+	email    = "alice@example.com"
+	newEmail = "alice@work.example.com"
+)
+
+type verificationConfig struct {
+	required bool
+}
+
+func (verificationConfig) GetURL() string             { return "https://app.example.com/verify-email" }
+func (verificationConfig) GetTokenTTL() time.Duration { return 24 * time.Hour }
+func (c verificationConfig) GetRequired() bool        { return c.required }
+
+type txManager struct{}
+
+func (txManager) ReadCommitted(ctx context.Context, f db.Handler) error {
+	return f(ctx)
+}
+
+// clock fake time verification tokens expire by
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time { return c.now }
+
+func (c *clock) Add(d time.Duration) { c.now = c.now.Add(d) }
+
+// userRepo in-memory users, the rest of repository.UserRepository is not used by the service
+type userRepo struct {
+	repository.UserRepository
+	users []*model.User
+}
+
+func (r *userRepo) Get(_ context.Context, id int64) (*model.User, error) {
+	for _, user := range r.users {
+		if user.Id == id {
+			return user, nil
+		}
+	}
+
+	return nil, errors.New("Пользователь не найден")
+}
+
+func (r *userRepo) GetByEmail(_ context.Context, email string) (*model.User, error) {
+	for _, user := range r.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+
+	return nil, errors.New("Пользователь не найден")
+}
+
+func (r *userRepo) SetPendingEmail(_ context.Context, id int64, email string) error {
+	for _, user := range r.users {
+		if user.Id == id {
+			user.PendingEmail = sql.NullString{String: email, Valid: len(email) > 0}
+		}
+	}
+
+	return nil
+}
+
+func (r *userRepo) VerifyEmail(_ context.Context, id int64, email string) (bool, error) {
+	for _, user := range r.users {
+		if user.Id == id && (user.Email == email || user.PendingEmail.Valid && user.PendingEmail.String == email) {
+			user.Email = email
+			user.EmailVerified = true
+			user.PendingEmail = sql.NullString{}
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// tokenRepo in-memory repository.UserTokenRepository
+type tokenRepo struct {
+	mu     sync.Mutex
+	tokens map[string]*model.UserToken
+}
+
+func (r *tokenRepo) Create(_ context.Context, token *model.UserToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := *token
+	r.tokens[token.TokenHash] = &stored
+
+	return nil
+}
+
+func (r *tokenRepo) Use(_ context.Context, tokenHash string, purpose string, at time.Time) (*model.UserToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokens[tokenHash]
+	if !ok || token.Purpose != purpose || token.UsedAt.Valid || !at.Before(token.ExpiresAt) {
+		return nil, repository.ErrUserTokenNotFound
+	}
+
+	token.UsedAt = sql.NullTime{Time: at, Valid: true}
+	used := *token
+
+	return &used, nil
+}
+
+func (r *tokenRepo) UseAll(_ context.Context, userId int64, purpose string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, token := range r.tokens {
+		if token.UserId == userId && token.Purpose == purpose && !token.UsedAt.Valid {
+			token.UsedAt = sql.NullTime{Time: at, Valid: true}
+		}
+	}
+
+	return nil
+}
+
+type mailer struct {
+	sent []*mail.Message
+}
+
+func (m *mailer) Send(_ context.Context, msg *mail.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+type fixture struct {
+	service service.VerificationService
+	clock   *clock
+	user    *model.User
+	mailer  *mailer
+}
+
+func newFixture(required bool) *fixture {
+	f := &fixture{
+		clock:  &clock{now: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)},
+		user:   &model.User{Id: 7, Email: email},
+		mailer: &mailer{},
+	}
+
+	users := &userRepo{users: []*model.User{f.user, {Id: 8, Email: "bob@example.com", EmailVerified: true}}}
+
+	f.service = verificationService.NewService(
+		verificationConfig{required: required},
+		users,
+		&tokenRepo{tokens: map[string]*model.UserToken{}},
+		f.mailer,
+		txManager{},
+		f.clock.Now,
+	)
+
+	return f
+}
+
+var linkPattern = regexp.MustCompile(`https://\S+`)
+
+// token returns the token from the last mail, which must be sent to the address
+func (f *fixture) token(t *testing.T, to string) string {
+	t.Helper()
+
+	if len(f.mailer.sent) == 0 {
+		t.Fatal("no mail was sent")
+	}
+
+	msg := f.mailer.sent[len(f.mailer.sent)-1]
+	if msg.To != to {
+		t.Fatalf("mail sent to %q, want %q", msg.To, to)
+	}
+
+	link, err := url.Parse(linkPattern.FindString(msg.Body))
+	if err != nil || link.Path != "/verify-email" || link.Query().Get("token") == "" {
+		t.Fatalf("mail has no verification link: %q", msg.Body)
+	}
+
+	return link.Query().Get("token")
+}
+
+func TestVerificationService_Signup(t *testing.T) {
+	f := newFixture(true)
+
+	if err := f.service.Send(context.Background(), f.user.Id, email); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	if err := f.service.CheckLogin(f.user); !errors.Is(err, verificationService.ErrEmailNotVerified) {
+		t.Fatalf("CheckLogin() before verification error = %v, want ErrEmailNotVerified", err)
+	}
+
+	token := f.token(t, email)
+
+	if err := f.service.Verify(context.Background(), token); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	if !f.user.EmailVerified {
+		t.Fatal("the email was not marked verified")
+	}
+
+	if err := f.service.CheckLogin(f.user); err != nil {
+		t.Fatalf("CheckLogin() error = %v", err)
+	}
+
+	err := f.service.Verify(context.Background(), token)
+	if !errors.Is(err, verificationService.ErrInvalidToken) {
+		t.Fatalf("second Verify() error = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestVerificationService_NotRequired(t *testing.T) {
+	f := newFixture(false)
+
+	if err := f.service.CheckLogin(f.user); err != nil {
+		t.Fatalf("CheckLogin() error = %v", err)
+	}
+}
+
+func TestVerificationService_ExpiredToken(t *testing.T) {
+	f := newFixture(true)
+
+	if err := f.service.Send(context.Background(), f.user.Id, email); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	f.clock.Add(24 * time.Hour)
+
+	err := f.service.Verify(context.Background(), f.token(t, email))
+	if !errors.Is(err, verificationService.ErrInvalidToken) {
+		t.Fatalf("Verify() error = %v, want ErrInvalidToken", err)
+	}
+
+	if f.user.EmailVerified {
+		t.Fatal("an expired token verified the email")
+	}
+}
+
+func TestVerificationService_ChangeEmail(t *testing.T) {
+	f := newFixture(false)
+	f.user.EmailVerified = true
+
+	if err := f.service.ChangeEmail(context.Background(), f.user.Id, newEmail); err != nil {
+		t.Fatalf("ChangeEmail() error = %v", err)
+	}
+
+	if f.user.Email != email || f.user.PendingEmail.String != newEmail {
+		t.Fatalf("email = %q, pending = %q", f.user.Email, f.user.PendingEmail.String)
+	}
+
+	if !strings.Contains(f.mailer.sent[0].Subject, "new email") {
+		t.Fatalf("subject = %q", f.mailer.sent[0].Subject)
+	}
+
+	if err := f.service.Verify(context.Background(), f.token(t, newEmail)); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	if f.user.Email != newEmail || f.user.PendingEmail.Valid || !f.user.EmailVerified {
+		t.Fatalf("email = %q, pending = %v, verified = %v", f.user.Email, f.user.PendingEmail, f.user.EmailVerified)
+	}
+}
+
+func TestVerificationService_ChangeEmailTwice(t *testing.T) {
+	f := newFixture(false)
+
+	if err := f.service.ChangeEmail(context.Background(), f.user.Id, "old@example.com"); err != nil {
+		t.Fatalf("ChangeEmail() error = %v", err)
+	}
+
+	first := f.token(t, "old@example.com")
+
+	if err := f.service.ChangeEmail(context.Background(), f.user.Id, newEmail); err != nil {
+		t.Fatalf("ChangeEmail() error = %v", err)
+	}
+
+	err := f.service.Verify(context.Background(), first)
+	if !errors.Is(err, verificationService.ErrInvalidToken) {
+		t.Fatalf("Verify() of a replaced change error = %v, want ErrInvalidToken", err)
+	}
+
+	if f.user.Email != email || f.user.PendingEmail.String != newEmail {
+		t.Fatalf("email = %q, pending = %q", f.user.Email, f.user.PendingEmail.String)
+	}
+}
+
+func TestVerificationService_ChangeEmailCancelled(t *testing.T) {
+	f := newFixture(false)
+
+	if err := f.service.ChangeEmail(context.Background(), f.user.Id, newEmail); err != nil {
+		t.Fatalf("ChangeEmail() error = %v", err)
+	}
+
+	token := f.token(t, newEmail)
+
+	if err := f.service.ChangeEmail(context.Background(), f.user.Id, email); err != nil {
+		t.Fatalf("ChangeEmail() back error = %v", err)
+	}
+
+	if f.user.PendingEmail.Valid {
+		t.Fatalf("pending = %q after changing back", f.user.PendingEmail.String)
+	}
+
+	err := f.service.Verify(context.Background(), token)
+	if !errors.Is(err, verificationService.ErrInvalidToken) {
+		t.Fatalf("Verify() of a cancelled change error = %v, want ErrInvalidToken", err)
+	}
+
+	if f.user.Email != email {
+		t.Fatalf("email = %q", f.user.Email)
+	}
+}
+
+func TestVerificationService_ChangeEmailTaken(t *testing.T) {
+	f := newFixture(false)
+
+	err := f.service.ChangeEmail(context.Background(), f.user.Id, "bob@example.com")
+	if !errors.Is(err, verificationService.ErrEmailTaken) {
+		t.Fatalf("ChangeEmail() error = %v, want ErrEmailTaken", err)
+	}
+
+	if f.user.PendingEmail.Valid || len(f.mailer.sent) != 0 {
+		t.Fatal("a taken email was accepted")
+	}
+}
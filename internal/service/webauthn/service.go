@@ -0,0 +1,434 @@
+package webauthn
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/binary"
+	"slices"
+	"time"
+
+	"github.com/laiker/auth/internal/config"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+	"github.com/laiker/auth/internal/service"
+	"github.com/laiker/auth/internal/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ChallengeTTL time the browser has to answer a ceremony
+	ChallengeTTL = 5 * time.Minute
+
+	challengeSize  = 32
+	credentialType = "public-key"
+	clientDataGet  = "webauthn.get"
+	clientDataNew  = "webauthn.create"
+)
+
+var (
+	ErrWebAuthnUnavailable = errors.New("passkeys are not configured")
+	ErrInvalidChallenge    = errors.New("passkey challenge is invalid or expired")
+	ErrInvalidCredential   = errors.New("passkey verification failed")
+	ErrCredentialExists    = errors.New("passkey is already registered")
+	ErrCredentialNotFound  = errors.New("passkey not found")
+	// ErrSignCount the authenticator reported a counter not above the stored one, it may have been cloned
+	ErrSignCount = errors.New("passkey signature counter did not increase")
+)
+
+type webAuthnService struct {
+	repo     repository.WebAuthnRepository
+	userRepo repository.UserRepository
+	rpId     string
+	rpName   string
+	origins  []string
+	now      func() time.Time
+}
+
+// NewService now is the clock challenges expire by, time.Now outside of tests
+func NewService(
+	config config.WebAuthnConfig,
+	repo repository.WebAuthnRepository,
+	userRepo repository.UserRepository,
+	now func() time.Time,
+) service.WebAuthnService {
+	return &webAuthnService{
+		repo:     repo,
+		userRepo: userRepo,
+		rpId:     config.GetRPID(),
+		rpName:   config.GetRPName(),
+		origins:  config.GetOrigins(),
+		now:      now,
+	}
+}
+
+// BeginRegistration options for navigator.credentials.create. A discoverable credential
+// with user verification is required, so the passkey alone is enough to log in.
+func (s *webAuthnService) BeginRegistration(ctx context.Context, userId int64) (*model.WebAuthnCreationOptions, error) {
+	if s.rpId == "" {
+		return nil, ErrWebAuthnUnavailable
+	}
+
+	user, err := s.userRepo.Get(ctx, userId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := s.repo.ListCredentials(ctx, userId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	challenge, err := s.newChallenge(ctx, sql.NullInt64{Int64: userId, Valid: true}, model.WebAuthnRegistration)
+
+	if err != nil {
+		return nil, err
+	}
+
+	params := make([]model.WebAuthnCredentialParameter, 0, len(utils.COSEAlgorithms))
+	for _, alg := range utils.COSEAlgorithms {
+		params = append(params, model.WebAuthnCredentialParameter{Type: credentialType, Alg: alg})
+	}
+
+	return &model.WebAuthnCreationOptions{
+		Rp: model.WebAuthnRelyingParty{Id: s.rpId, Name: s.rpName},
+		User: model.WebAuthnUser{
+			Id:          utils.WebAuthnEncoding.EncodeToString(userHandle(userId)),
+			Name:        user.Email,
+			DisplayName: user.Name,
+		},
+		Challenge:          challenge,
+		PubKeyCredParams:   params,
+		Timeout:            ChallengeTTL.Milliseconds(),
+		ExcludeCredentials: descriptors(credentials),
+		AuthenticatorSelection: model.WebAuthnAuthenticatorSelection{
+			ResidentKey:        "required",
+			RequireResidentKey: true,
+			UserVerification:   "required",
+		},
+		Attestation: "none",
+	}, nil
+}
+
+// FinishRegistration stores the credential created for the challenge of BeginRegistration
+func (s *webAuthnService) FinishRegistration(
+	ctx context.Context,
+	userId int64,
+	name string,
+	response *model.WebAuthnRegistrationResponse,
+) (*model.WebAuthnCredential, error) {
+	if s.rpId == "" {
+		return nil, ErrWebAuthnUnavailable
+	}
+
+	if response.Type != credentialType {
+		return nil, ErrInvalidCredential
+	}
+
+	challenge, err := s.useChallenge(ctx, response.Response.ClientDataJSON, clientDataNew, model.WebAuthnRegistration)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if challenge.UserId.Int64 != userId {
+		return nil, ErrInvalidChallenge
+	}
+
+	attestationObject, err := utils.WebAuthnEncoding.DecodeString(response.Response.AttestationObject)
+
+	if err != nil {
+		return nil, ErrInvalidCredential
+	}
+
+	authData, err := utils.ParseAttestationObject(attestationObject)
+
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidCredential, err.Error())
+	}
+
+	err = s.checkAuthenticatorData(authData)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rawId, err := utils.WebAuthnEncoding.DecodeString(response.RawId)
+
+	if err != nil || !bytes.Equal(rawId, authData.CredentialId) {
+		return nil, ErrInvalidCredential
+	}
+
+	_, err = utils.ParseCOSEKey(authData.PublicKey)
+
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidCredential, err.Error())
+	}
+
+	credential := &model.WebAuthnCredential{
+		Id:         authData.CredentialId,
+		UserId:     userId,
+		PublicKey:  authData.PublicKey,
+		SignCount:  int64(authData.SignCount),
+		Transports: response.Response.Transports,
+		Name:       name,
+		CreatedAt:  s.now(),
+	}
+
+	created, err := s.repo.CreateCredential(ctx, credential)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !created {
+		return nil, ErrCredentialExists
+	}
+
+	return credential, nil
+}
+
+// BeginLogin options for navigator.credentials.get. An unknown email gets the same
+// answer as an empty one, so the options do not reveal who has an account.
+func (s *webAuthnService) BeginLogin(ctx context.Context, email string) (*model.WebAuthnRequestOptions, error) {
+	if s.rpId == "" {
+		return nil, ErrWebAuthnUnavailable
+	}
+
+	var (
+		userId      sql.NullInt64
+		credentials []*model.WebAuthnCredential
+	)
+
+	if email != "" {
+		user, err := s.userRepo.GetByEmail(ctx, email)
+
+		if err == nil {
+			credentials, err = s.repo.ListCredentials(ctx, user.Id)
+
+			if err != nil {
+				return nil, err
+			}
+
+			userId = sql.NullInt64{Int64: user.Id, Valid: true}
+		}
+	}
+
+	challenge, err := s.newChallenge(ctx, userId, model.WebAuthnLogin)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.WebAuthnRequestOptions{
+		Challenge:        challenge,
+		Timeout:          ChallengeTTL.Milliseconds(),
+		RpId:             s.rpId,
+		AllowCredentials: descriptors(credentials),
+		UserVerification: "required",
+	}, nil
+}
+
+// FinishLogin verifies the assertion and returns the user owning the passkey
+func (s *webAuthnService) FinishLogin(ctx context.Context, response *model.WebAuthnAssertionResponse) (*model.User, error) {
+	if s.rpId == "" {
+		return nil, ErrWebAuthnUnavailable
+	}
+
+	if response.Type != credentialType {
+		return nil, ErrInvalidCredential
+	}
+
+	challenge, err := s.useChallenge(ctx, response.Response.ClientDataJSON, clientDataGet, model.WebAuthnLogin)
+
+	if err != nil {
+		return nil, err
+	}
+
+	credentialId, err := utils.WebAuthnEncoding.DecodeString(response.RawId)
+
+	if err != nil {
+		return nil, ErrInvalidCredential
+	}
+
+	credential, err := s.repo.GetCredential(ctx, credentialId)
+
+	if errors.Is(err, repository.ErrWebAuthnCredentialNotFound) {
+		return nil, ErrInvalidCredential
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// a login started for one user can not be finished with the passkey of another
+	if challenge.UserId.Valid && challenge.UserId.Int64 != credential.UserId {
+		return nil, ErrInvalidCredential
+	}
+
+	if response.Response.UserHandle != "" {
+		handle, errDecode := utils.WebAuthnEncoding.DecodeString(response.Response.UserHandle)
+
+		if errDecode != nil || subtle.ConstantTimeCompare(handle, userHandle(credential.UserId)) != 1 {
+			return nil, ErrInvalidCredential
+		}
+	}
+
+	rawAuthData, err := utils.WebAuthnEncoding.DecodeString(response.Response.AuthenticatorData)
+
+	if err != nil {
+		return nil, ErrInvalidCredential
+	}
+
+	authData, err := utils.ParseAuthenticatorData(rawAuthData)
+
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidCredential, err.Error())
+	}
+
+	err = s.checkAuthenticatorData(authData)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := utils.ParseCOSEKey(credential.PublicKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataJSON, _ := utils.WebAuthnEncoding.DecodeString(response.Response.ClientDataJSON)
+	signature, err := utils.WebAuthnEncoding.DecodeString(response.Response.Signature)
+
+	if err != nil {
+		return nil, ErrInvalidCredential
+	}
+
+	err = key.VerifyAssertion(rawAuthData, clientDataJSON, signature)
+
+	if err != nil {
+		return nil, ErrInvalidCredential
+	}
+
+	used, err := s.repo.UseCredential(ctx, credential.Id, int64(authData.SignCount), s.now())
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !used {
+		return nil, ErrSignCount
+	}
+
+	return s.userRepo.Get(ctx, credential.UserId)
+}
+
+func (s *webAuthnService) List(ctx context.Context, userId int64) ([]*model.WebAuthnCredential, error) {
+	return s.repo.ListCredentials(ctx, userId)
+}
+
+func (s *webAuthnService) Delete(ctx context.Context, userId int64, credentialId []byte) error {
+	deleted, err := s.repo.DeleteCredential(ctx, userId, credentialId)
+
+	if err != nil {
+		return err
+	}
+
+	if !deleted {
+		return ErrCredentialNotFound
+	}
+
+	return nil
+}
+
+// newChallenge stores the hash of a random challenge and returns it base64url encoded
+func (s *webAuthnService) newChallenge(ctx context.Context, userId sql.NullInt64, ceremony string) (string, error) {
+	raw := make([]byte, challengeSize)
+
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := utils.WebAuthnEncoding.EncodeToString(raw)
+
+	err = s.repo.CreateChallenge(ctx, &model.WebAuthnChallenge{
+		ChallengeHash: utils.HashToken(challenge),
+		UserId:        userId,
+		Ceremony:      ceremony,
+		ExpiresAt:     s.now().Add(ChallengeTTL),
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return challenge, nil
+}
+
+// useChallenge checks the client data the browser signed and consumes the challenge it answers
+func (s *webAuthnService) useChallenge(ctx context.Context, encoded string, clientDataType string, ceremony string) (*model.WebAuthnChallenge, error) {
+	raw, err := utils.WebAuthnEncoding.DecodeString(encoded)
+
+	if err != nil {
+		return nil, ErrInvalidCredential
+	}
+
+	clientData, err := utils.ParseClientData(raw)
+
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidCredential, err.Error())
+	}
+
+	if clientData.Type != clientDataType || clientData.CrossOrigin || !slices.Contains(s.origins, clientData.Origin) {
+		return nil, ErrInvalidCredential
+	}
+
+	challenge, err := s.repo.UseChallenge(ctx, utils.HashToken(clientData.Challenge), ceremony, s.now())
+
+	if errors.Is(err, repository.ErrWebAuthnChallengeNotFound) {
+		return nil, ErrInvalidChallenge
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return challenge, nil
+}
+
+// checkAuthenticatorData the data is for our relying party and the user was verified, not only present
+func (s *webAuthnService) checkAuthenticatorData(authData *utils.AuthenticatorData) error {
+	if !authData.CheckRPID(s.rpId) || !authData.UserPresent() || !authData.UserVerified() {
+		return ErrInvalidCredential
+	}
+
+	return nil
+}
+
+// userHandle user.id of the credential, the user id as 8 big endian bytes
+func userHandle(userId int64) []byte {
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, uint64(userId))
+
+	return handle
+}
+
+func descriptors(credentials []*model.WebAuthnCredential) []model.WebAuthnCredentialDescriptor {
+	result := make([]model.WebAuthnCredentialDescriptor, 0, len(credentials))
+
+	for _, credential := range credentials {
+		result = append(result, model.WebAuthnCredentialDescriptor{
+			Type:       credentialType,
+			Id:         utils.WebAuthnEncoding.EncodeToString(credential.Id),
+			Transports: credential.Transports,
+		})
+	}
+
+	return result
+}
@@ -0,0 +1,204 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/utils"
+)
+
+// cborMap map with a fixed key order, so encodings are deterministic
+type cborMap []cborPair
+
+type cborPair struct {
+	key   interface{}
+	value interface{}
+}
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		head := []byte{major<<5 | 25, 0, 0}
+		binary.BigEndian.PutUint16(head[1:], uint16(n))
+
+		return head
+	}
+
+	head := []byte{major<<5 | 26, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(head[1:], uint32(n))
+
+	return head
+}
+
+// encodeCBOR the subset authenticators produce
+func encodeCBOR(v interface{}) []byte {
+	switch v := v.(type) {
+	case int:
+		if v >= 0 {
+			return cborHead(0, uint64(v))
+		}
+
+		return cborHead(1, uint64(-1-v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case cborMap:
+		encoded := cborHead(5, uint64(len(v)))
+		for _, pair := range v {
+			encoded = append(encoded, encodeCBOR(pair.key)...)
+			encoded = append(encoded, encodeCBOR(pair.value)...)
+		}
+
+		return encoded
+	}
+
+	panic("unsupported cbor value")
+}
+
+// authenticator software passkey signing with an ES256 key, like a platform authenticator would
+type authenticator struct {
+	rpId         string
+	origin       string
+	key          *ecdsa.PrivateKey
+	credentialId []byte
+	userHandle   string
+	signCount    uint32
+	// counter authenticators without a counter always report 0
+	counter bool
+	flags   byte
+}
+
+func newAuthenticator(t *testing.T) *authenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	credentialId := make([]byte, 16)
+	if _, err = rand.Read(credentialId); err != nil {
+		t.Fatalf("rand.Read() error = %v", err)
+	}
+
+	return &authenticator{
+		rpId:         rpId,
+		origin:       origin,
+		key:          key,
+		credentialId: credentialId,
+		flags:        utils.AuthenticatorUserPresent | utils.AuthenticatorUserVerified,
+	}
+}
+
+func (a *authenticator) coseKey() []byte {
+	return encodeCBOR(cborMap{
+		{1, 2},
+		{3, -7},
+		{-1, 1},
+		{-2, a.key.PublicKey.X.FillBytes(make([]byte, 32))},
+		{-3, a.key.PublicKey.Y.FillBytes(make([]byte, 32))},
+	})
+}
+
+func (a *authenticator) authenticatorData(attested bool) []byte {
+	rpIdHash := sha256.Sum256([]byte(a.rpId))
+	data := append([]byte(nil), rpIdHash[:]...)
+
+	flags := a.flags
+	if attested {
+		flags |= utils.AuthenticatorAttested
+	}
+
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+
+	if attested {
+		data = append(data, make([]byte, 16)...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialId)))
+		data = append(data, a.credentialId...)
+		data = append(data, a.coseKey()...)
+	}
+
+	return data
+}
+
+func (a *authenticator) clientData(t *testing.T, clientDataType string, challenge string) []byte {
+	t.Helper()
+
+	clientData, err := json.Marshal(utils.ClientData{Type: clientDataType, Challenge: challenge, Origin: a.origin})
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+
+	return clientData
+}
+
+// create answers navigator.credentials.create
+func (a *authenticator) create(t *testing.T, options *model.WebAuthnCreationOptions) *model.WebAuthnRegistrationResponse {
+	t.Helper()
+
+	a.userHandle = options.User.Id
+
+	attestationObject := encodeCBOR(cborMap{
+		{"fmt", "none"},
+		{"attStmt", cborMap{}},
+		{"authData", a.authenticatorData(true)},
+	})
+
+	id := utils.WebAuthnEncoding.EncodeToString(a.credentialId)
+
+	return &model.WebAuthnRegistrationResponse{
+		Id:    id,
+		RawId: id,
+		Type:  "public-key",
+		Response: model.WebAuthnAttestation{
+			ClientDataJSON:    utils.WebAuthnEncoding.EncodeToString(a.clientData(t, "webauthn.create", options.Challenge)),
+			AttestationObject: utils.WebAuthnEncoding.EncodeToString(attestationObject),
+			Transports:        []string{"internal", "hybrid"},
+		},
+	}
+}
+
+// get answers navigator.credentials.get
+func (a *authenticator) get(t *testing.T, options *model.WebAuthnRequestOptions) *model.WebAuthnAssertionResponse {
+	t.Helper()
+
+	if a.counter {
+		a.signCount++
+	}
+
+	authData := a.authenticatorData(false)
+	clientData := a.clientData(t, "webauthn.get", options.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatalf("SignASN1() error = %v", err)
+	}
+
+	id := utils.WebAuthnEncoding.EncodeToString(a.credentialId)
+
+	return &model.WebAuthnAssertionResponse{
+		Id:    id,
+		RawId: id,
+		Type:  "public-key",
+		Response: model.WebAuthnAssertion{
+			ClientDataJSON:    utils.WebAuthnEncoding.EncodeToString(clientData),
+			AuthenticatorData: utils.WebAuthnEncoding.EncodeToString(authData),
+			Signature:         utils.WebAuthnEncoding.EncodeToString(signature),
+			UserHandle:        a.userHandle,
+		},
+	}
+}
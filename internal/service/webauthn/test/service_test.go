@@ -0,0 +1,558 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/repository"
+	"github.com/laiker/auth/internal/service"
+	webauthnService "github.com/laiker/auth/internal/service/webauthn"
+	"github.com/laiker/auth/internal/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	rpId   = "example.com"
+	origin = "https://example.com"
+
+	alice = int64(7)
+	bob   = int64(8)
+)
+
+type webAuthnConfig struct {
+	rpId string
+}
+
+func (c webAuthnConfig) GetRPID() string    { return c.rpId }
+func (webAuthnConfig) GetRPName() string    { return "Example" }
+func (webAuthnConfig) GetOrigins() []string { return []string{origin} }
+
+// clock fake time challenges expire by
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time { return c.now }
+
+func (c *clock) Add(d time.Duration) { c.now = c.now.Add(d) }
+
+var users = map[int64]*model.User{
+	alice: {Id: alice, Name: "alice", Email: "alice@example.com", Role: "admin"},
+	bob:   {Id: bob, Name: "bob", Email: "bob@example.com", Role: "user"},
+}
+
+// userRepo serves alice and bob, the rest of repository.UserRepository is not used by the service
+type userRepo struct {
+	repository.UserRepository
+}
+
+func (userRepo) Get(_ context.Context, id int64) (*model.User, error) {
+	if user, ok := users[id]; ok {
+		return user, nil
+	}
+
+	return nil, errors.New("user not found")
+}
+
+func (userRepo) GetByEmail(_ context.Context, email string) (*model.User, error) {
+	for _, user := range users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+
+	return nil, errors.New("user not found")
+}
+
+// webAuthnRepo in-memory repository.WebAuthnRepository
+type webAuthnRepo struct {
+	mu          sync.Mutex
+	credentials map[string]*model.WebAuthnCredential
+	challenges  map[string]*model.WebAuthnChallenge
+	used        map[string]bool
+}
+
+func newWebAuthnRepo() *webAuthnRepo {
+	return &webAuthnRepo{
+		credentials: map[string]*model.WebAuthnCredential{},
+		challenges:  map[string]*model.WebAuthnChallenge{},
+		used:        map[string]bool{},
+	}
+}
+
+func (r *webAuthnRepo) CreateCredential(_ context.Context, credential *model.WebAuthnCredential) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.credentials[string(credential.Id)]; ok {
+		return false, nil
+	}
+
+	stored := *credential
+	r.credentials[string(credential.Id)] = &stored
+
+	return true, nil
+}
+
+func (r *webAuthnRepo) GetCredential(_ context.Context, id []byte) (*model.WebAuthnCredential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	credential, ok := r.credentials[string(id)]
+	if !ok {
+		return nil, repository.ErrWebAuthnCredentialNotFound
+	}
+
+	stored := *credential
+
+	return &stored, nil
+}
+
+func (r *webAuthnRepo) ListCredentials(_ context.Context, userId int64) ([]*model.WebAuthnCredential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var credentials []*model.WebAuthnCredential
+
+	for _, credential := range r.credentials {
+		if credential.UserId == userId {
+			stored := *credential
+			credentials = append(credentials, &stored)
+		}
+	}
+
+	return credentials, nil
+}
+
+func (r *webAuthnRepo) UseCredential(_ context.Context, id []byte, signCount int64, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	credential, ok := r.credentials[string(id)]
+	if !ok || (credential.SignCount >= signCount && credential.SignCount != 0) {
+		return false, nil
+	}
+
+	credential.SignCount = signCount
+	credential.LastUsedAt = sql.NullTime{Time: at, Valid: true}
+
+	return true, nil
+}
+
+func (r *webAuthnRepo) DeleteCredential(_ context.Context, userId int64, id []byte) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	credential, ok := r.credentials[string(id)]
+	if !ok || credential.UserId != userId {
+		return false, nil
+	}
+
+	delete(r.credentials, string(id))
+
+	return true, nil
+}
+
+func (r *webAuthnRepo) CreateChallenge(_ context.Context, challenge *model.WebAuthnChallenge) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := *challenge
+	r.challenges[challenge.ChallengeHash] = &stored
+
+	return nil
+}
+
+func (r *webAuthnRepo) UseChallenge(_ context.Context, challengeHash string, ceremony string, at time.Time) (*model.WebAuthnChallenge, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	challenge, ok := r.challenges[challengeHash]
+	if !ok || r.used[challengeHash] || challenge.Ceremony != ceremony || !challenge.ExpiresAt.After(at) {
+		return nil, repository.ErrWebAuthnChallengeNotFound
+	}
+
+	r.used[challengeHash] = true
+
+	return challenge, nil
+}
+
+func newService(repo *webAuthnRepo, c *clock) service.WebAuthnService {
+	return webauthnService.NewService(webAuthnConfig{rpId: rpId}, repo, userRepo{}, c.Now)
+}
+
+// register adds the passkey of the authenticator to the user
+func register(t *testing.T, s service.WebAuthnService, userId int64, a *authenticator) *model.WebAuthnCredential {
+	t.Helper()
+
+	ctx := context.Background()
+
+	options, err := s.BeginRegistration(ctx, userId)
+	if err != nil {
+		t.Fatalf("BeginRegistration() error = %v", err)
+	}
+
+	credential, err := s.FinishRegistration(ctx, userId, "laptop", a.create(t, options))
+	if err != nil {
+		t.Fatalf("FinishRegistration() error = %v", err)
+	}
+
+	return credential
+}
+
+func Test_webAuthnService_RegisterAndLogin(t *testing.T) {
+	ctx := context.Background()
+	repo := newWebAuthnRepo()
+	c := &clock{now: time.Now()}
+	s := newService(repo, c)
+	a := newAuthenticator(t)
+	a.counter = true
+
+	options, err := s.BeginRegistration(ctx, alice)
+	if err != nil {
+		t.Fatalf("BeginRegistration() error = %v", err)
+	}
+
+	if options.Rp.Id != rpId || options.User.Name != "alice@example.com" || options.AuthenticatorSelection.UserVerification != "required" {
+		t.Errorf("BeginRegistration() options = %+v", options)
+	}
+
+	credential, err := s.FinishRegistration(ctx, alice, "laptop", a.create(t, options))
+	if err != nil {
+		t.Fatalf("FinishRegistration() error = %v", err)
+	}
+
+	if !bytes.Equal(credential.Id, a.credentialId) || credential.UserId != alice || len(credential.Transports) != 2 {
+		t.Errorf("FinishRegistration() credential = %+v", credential)
+	}
+
+	// registering the same authenticator again is prevented by the browser with excludeCredentials
+	options, err = s.BeginRegistration(ctx, alice)
+	if err != nil {
+		t.Fatalf("BeginRegistration() error = %v", err)
+	}
+
+	if len(options.ExcludeCredentials) != 1 || options.ExcludeCredentials[0].Id != utils.WebAuthnEncoding.EncodeToString(a.credentialId) {
+		t.Errorf("BeginRegistration() excludeCredentials = %+v", options.ExcludeCredentials)
+	}
+
+	if _, err = s.FinishRegistration(ctx, alice, "laptop", a.create(t, options)); !errors.Is(err, webauthnService.ErrCredentialExists) {
+		t.Errorf("FinishRegistration() of a registered credential error = %v, want %v", err, webauthnService.ErrCredentialExists)
+	}
+
+	for _, email := range []string{"", "alice@example.com"} {
+		login, err := s.BeginLogin(ctx, email)
+		if err != nil {
+			t.Fatalf("BeginLogin(%q) error = %v", email, err)
+		}
+
+		c.Add(time.Minute)
+
+		user, err := s.FinishLogin(ctx, a.get(t, login))
+		if err != nil {
+			t.Fatalf("FinishLogin() error = %v", err)
+		}
+
+		if user.Id != alice {
+			t.Errorf("FinishLogin() user = %d, want %d", user.Id, alice)
+		}
+	}
+
+	passkeys, err := s.List(ctx, alice)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+
+	if len(passkeys) != 1 || passkeys[0].SignCount != 2 || !passkeys[0].LastUsedAt.Time.Equal(c.now) {
+		t.Errorf("List() = %+v, want the passkey used twice", passkeys)
+	}
+}
+
+func Test_webAuthnService_BeginLogin(t *testing.T) {
+	ctx := context.Background()
+	s := newService(newWebAuthnRepo(), &clock{now: time.Now()})
+	register(t, s, alice, newAuthenticator(t))
+
+	options, err := s.BeginLogin(ctx, "alice@example.com")
+	if err != nil {
+		t.Fatalf("BeginLogin() error = %v", err)
+	}
+
+	if len(options.AllowCredentials) != 1 || options.RpId != rpId {
+		t.Errorf("BeginLogin() options = %+v, want the passkey of alice", options)
+	}
+
+	// unknown users look like users without passkeys
+	for _, email := range []string{"", "bob@example.com", "nobody@example.com"} {
+		options, err = s.BeginLogin(ctx, email)
+		if err != nil {
+			t.Fatalf("BeginLogin(%q) error = %v", email, err)
+		}
+
+		if len(options.AllowCredentials) != 0 {
+			t.Errorf("BeginLogin(%q) allowCredentials = %+v, want none", email, options.AllowCredentials)
+		}
+	}
+}
+
+func Test_webAuthnService_FinishRegistration_Rejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(a *authenticator)
+		tamper  func(response *model.WebAuthnRegistrationResponse)
+		userId  int64
+		wantErr error
+	}{
+		{
+			name:    "other relying party",
+			prepare: func(a *authenticator) { a.rpId = "evil.example" },
+			userId:  alice,
+			wantErr: webauthnService.ErrInvalidCredential,
+		},
+		{
+			name:    "other origin",
+			prepare: func(a *authenticator) { a.origin = "https://evil.example" },
+			userId:  alice,
+			wantErr: webauthnService.ErrInvalidCredential,
+		},
+		{
+			name:    "user not verified",
+			prepare: func(a *authenticator) { a.flags = utils.AuthenticatorUserPresent },
+			userId:  alice,
+			wantErr: webauthnService.ErrInvalidCredential,
+		},
+		{
+			name: "raw id of another credential",
+			tamper: func(response *model.WebAuthnRegistrationResponse) {
+				response.RawId = utils.WebAuthnEncoding.EncodeToString([]byte("another"))
+			},
+			userId:  alice,
+			wantErr: webauthnService.ErrInvalidCredential,
+		},
+		{
+			name:    "challenge of another user",
+			userId:  bob,
+			wantErr: webauthnService.ErrInvalidChallenge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := newWebAuthnRepo()
+			s := newService(repo, &clock{now: time.Now()})
+			a := newAuthenticator(t)
+
+			options, err := s.BeginRegistration(ctx, alice)
+			if err != nil {
+				t.Fatalf("BeginRegistration() error = %v", err)
+			}
+
+			if tt.prepare != nil {
+				tt.prepare(a)
+			}
+
+			response := a.create(t, options)
+
+			if tt.tamper != nil {
+				tt.tamper(response)
+			}
+
+			if _, err = s.FinishRegistration(ctx, tt.userId, "laptop", response); !errors.Is(err, tt.wantErr) {
+				t.Errorf("FinishRegistration() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if len(repo.credentials) != 0 {
+				t.Errorf("a rejected credential was stored")
+			}
+		})
+	}
+}
+
+func Test_webAuthnService_FinishLogin_Rejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		email   string
+		answer  func(t *testing.T, s service.WebAuthnService, a *authenticator, options *model.WebAuthnRequestOptions) *model.WebAuthnAssertionResponse
+		wantErr error
+	}{
+		{
+			name: "client data of another challenge",
+			answer: func(t *testing.T, _ service.WebAuthnService, a *authenticator, options *model.WebAuthnRequestOptions) *model.WebAuthnAssertionResponse {
+				response := a.get(t, options)
+				response.Response.ClientDataJSON = utils.WebAuthnEncoding.EncodeToString(a.clientData(t, "webauthn.get", options.Challenge+"A"))
+
+				return response
+			},
+			wantErr: webauthnService.ErrInvalidChallenge,
+		},
+		{
+			name: "signature of another key",
+			answer: func(t *testing.T, _ service.WebAuthnService, a *authenticator, options *model.WebAuthnRequestOptions) *model.WebAuthnAssertionResponse {
+				impostor := newAuthenticator(t)
+				impostor.credentialId = a.credentialId
+				impostor.userHandle = a.userHandle
+
+				return impostor.get(t, options)
+			},
+			wantErr: webauthnService.ErrInvalidCredential,
+		},
+		{
+			name: "user not verified",
+			answer: func(t *testing.T, _ service.WebAuthnService, a *authenticator, options *model.WebAuthnRequestOptions) *model.WebAuthnAssertionResponse {
+				a.flags = utils.AuthenticatorUserPresent
+
+				return a.get(t, options)
+			},
+			wantErr: webauthnService.ErrInvalidCredential,
+		},
+		{
+			name: "unknown credential",
+			answer: func(t *testing.T, _ service.WebAuthnService, _ *authenticator, options *model.WebAuthnRequestOptions) *model.WebAuthnAssertionResponse {
+				return newAuthenticator(t).get(t, options)
+			},
+			wantErr: webauthnService.ErrInvalidCredential,
+		},
+		{
+			name: "user handle of another user",
+			answer: func(t *testing.T, _ service.WebAuthnService, a *authenticator, options *model.WebAuthnRequestOptions) *model.WebAuthnAssertionResponse {
+				a.userHandle = utils.WebAuthnEncoding.EncodeToString([]byte{0, 0, 0, 0, 0, 0, 0, 8})
+
+				return a.get(t, options)
+			},
+			wantErr: webauthnService.ErrInvalidCredential,
+		},
+		{
+			name:  "login started for another user",
+			email: "bob@example.com",
+			answer: func(t *testing.T, _ service.WebAuthnService, a *authenticator, options *model.WebAuthnRequestOptions) *model.WebAuthnAssertionResponse {
+				return a.get(t, options)
+			},
+			wantErr: webauthnService.ErrInvalidCredential,
+		},
+		{
+			name: "registration challenge",
+			answer: func(t *testing.T, s service.WebAuthnService, a *authenticator, _ *model.WebAuthnRequestOptions) *model.WebAuthnAssertionResponse {
+				registration, err := s.BeginRegistration(context.Background(), alice)
+				if err != nil {
+					t.Fatalf("BeginRegistration() error = %v", err)
+				}
+
+				return a.get(t, &model.WebAuthnRequestOptions{Challenge: registration.Challenge})
+			},
+			wantErr: webauthnService.ErrInvalidChallenge,
+		},
+		{
+			name: "replayed assertion",
+			answer: func(t *testing.T, s service.WebAuthnService, a *authenticator, options *model.WebAuthnRequestOptions) *model.WebAuthnAssertionResponse {
+				response := a.get(t, options)
+
+				if _, err := s.FinishLogin(context.Background(), response); err != nil {
+					t.Fatalf("FinishLogin() error = %v", err)
+				}
+
+				return response
+			},
+			wantErr: webauthnService.ErrInvalidChallenge,
+		},
+		{
+			name: "cloned authenticator",
+			answer: func(t *testing.T, s service.WebAuthnService, a *authenticator, options *model.WebAuthnRequestOptions) *model.WebAuthnAssertionResponse {
+				clone := *a
+				a.counter = true
+				clone.counter = true
+
+				if _, err := s.FinishLogin(context.Background(), a.get(t, options)); err != nil {
+					t.Fatalf("FinishLogin() error = %v", err)
+				}
+
+				next, err := s.BeginLogin(context.Background(), "")
+				if err != nil {
+					t.Fatalf("BeginLogin() error = %v", err)
+				}
+
+				// the clone reports the counter the original already used
+				return clone.get(t, next)
+			},
+			wantErr: webauthnService.ErrSignCount,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newService(newWebAuthnRepo(), &clock{now: time.Now()})
+			a := newAuthenticator(t)
+			register(t, s, alice, a)
+
+			options, err := s.BeginLogin(ctx, tt.email)
+			if err != nil {
+				t.Fatalf("BeginLogin() error = %v", err)
+			}
+
+			if _, err = s.FinishLogin(ctx, tt.answer(t, s, a, options)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("FinishLogin() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_webAuthnService_ExpiredChallenge(t *testing.T) {
+	ctx := context.Background()
+	c := &clock{now: time.Now()}
+	s := newService(newWebAuthnRepo(), c)
+	a := newAuthenticator(t)
+	register(t, s, alice, a)
+
+	options, err := s.BeginLogin(ctx, "")
+	if err != nil {
+		t.Fatalf("BeginLogin() error = %v", err)
+	}
+
+	c.Add(webauthnService.ChallengeTTL)
+
+	if _, err = s.FinishLogin(ctx, a.get(t, options)); !errors.Is(err, webauthnService.ErrInvalidChallenge) {
+		t.Errorf("FinishLogin() error = %v, want %v", err, webauthnService.ErrInvalidChallenge)
+	}
+}
+
+func Test_webAuthnService_Delete(t *testing.T) {
+	ctx := context.Background()
+	s := newService(newWebAuthnRepo(), &clock{now: time.Now()})
+	a := newAuthenticator(t)
+	credential := register(t, s, alice, a)
+
+	if err := s.Delete(ctx, bob, credential.Id); !errors.Is(err, webauthnService.ErrCredentialNotFound) {
+		t.Errorf("Delete() of another user's passkey error = %v, want %v", err, webauthnService.ErrCredentialNotFound)
+	}
+
+	if err := s.Delete(ctx, alice, credential.Id); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	options, err := s.BeginLogin(ctx, "")
+	if err != nil {
+		t.Fatalf("BeginLogin() error = %v", err)
+	}
+
+	if _, err = s.FinishLogin(ctx, a.get(t, options)); !errors.Is(err, webauthnService.ErrInvalidCredential) {
+		t.Errorf("FinishLogin() with a deleted passkey error = %v, want %v", err, webauthnService.ErrInvalidCredential)
+	}
+}
+
+func Test_webAuthnService_Unavailable(t *testing.T) {
+	s := webauthnService.NewService(webAuthnConfig{}, newWebAuthnRepo(), userRepo{}, time.Now)
+
+	if _, err := s.BeginRegistration(context.Background(), alice); !errors.Is(err, webauthnService.ErrWebAuthnUnavailable) {
+		t.Errorf("BeginRegistration() error = %v, want %v", err, webauthnService.ErrWebAuthnUnavailable)
+	}
+
+	if _, err := s.BeginLogin(context.Background(), ""); !errors.Is(err, webauthnService.ErrWebAuthnUnavailable) {
+		t.Errorf("BeginLogin() error = %v, want %v", err, webauthnService.ErrWebAuthnUnavailable)
+	}
+}
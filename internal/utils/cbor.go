@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// cborMaxDepth nesting accepted from authenticators, real attestations use three levels
+const cborMaxDepth = 16
+
+var errCBOR = errors.New("malformed cbor")
+
+// DecodeCBOR decodes the first data item of data and returns the bytes following it.
+// Only the definite-length subset used by CTAP2 is supported: integers come back as int64,
+// byte strings as []byte, maps as map[interface{}]interface{}.
+func DecodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeCBOR(data, 0)
+}
+
+func decodeCBOR(data []byte, depth int) (interface{}, []byte, error) {
+	if len(data) == 0 || depth > cborMaxDepth {
+		return nil, nil, errCBOR
+	}
+
+	major, info := data[0]>>5, data[0]&0x1f
+
+	// floats and simple values keep their raw additional information
+	if major == 7 {
+		return decodeCBORSimple(info, data[1:])
+	}
+
+	arg, rest, err := cborArgument(info, data[1:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, errCBOR
+		}
+
+		return int64(arg), rest, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, errCBOR
+		}
+
+		return -1 - int64(arg), rest, nil
+	case 2, 3:
+		if arg > uint64(len(rest)) {
+			return nil, nil, errCBOR
+		}
+
+		if major == 2 {
+			return append([]byte(nil), rest[:arg]...), rest[arg:], nil
+		}
+
+		return string(rest[:arg]), rest[arg:], nil
+	case 4:
+		// every item takes at least a byte
+		if arg > uint64(len(rest)) {
+			return nil, nil, errCBOR
+		}
+
+		items := make([]interface{}, 0, arg)
+
+		for i := uint64(0); i < arg; i++ {
+			var item interface{}
+
+			item, rest, err = decodeCBOR(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			items = append(items, item)
+		}
+
+		return items, rest, nil
+	case 5:
+		if arg > uint64(len(rest)) {
+			return nil, nil, errCBOR
+		}
+
+		items := make(map[interface{}]interface{}, arg)
+
+		for i := uint64(0); i < arg; i++ {
+			var key, value interface{}
+
+			key, rest, err = decodeCBOR(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errCBOR
+			}
+
+			value, rest, err = decodeCBOR(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			items[key] = value
+		}
+
+		return items, rest, nil
+	case 6:
+		// tags carry no meaning for WebAuthn, the tagged item is returned as is
+		return decodeCBOR(rest, depth+1)
+	}
+
+	return nil, nil, errCBOR
+}
+
+// cborArgument reads the argument of the initial byte, indefinite lengths are rejected
+func cborArgument(info byte, data []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24 && len(data) >= 1:
+		return uint64(data[0]), data[1:], nil
+	case info == 25 && len(data) >= 2:
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26 && len(data) >= 4:
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27 && len(data) >= 8:
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	}
+
+	return 0, nil, errCBOR
+}
+
+func decodeCBORSimple(info byte, data []byte) (interface{}, []byte, error) {
+	switch {
+	case info == 20:
+		return false, data, nil
+	case info == 21:
+		return true, data, nil
+	case info == 22 || info == 23:
+		return nil, data, nil
+	case info == 26 && len(data) >= 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data))), data[4:], nil
+	case info == 27 && len(data) >= 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), data[8:], nil
+	}
+
+	return nil, nil, errCBOR
+}
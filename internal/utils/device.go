@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/laiker/auth/internal/model"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+type deviceKey struct{}
+
+// TrustedProxies the proxies in front of us, only the X-Forwarded-For hops they appended are believed
+type TrustedProxies []netip.Prefix
+
+// ParseTrustedProxies comma separated addresses or CIDR ranges
+func ParseTrustedProxies(value string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, errors.Errorf("trusted proxy %q is not an address", entry)
+			}
+
+			proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, errors.Errorf("trusted proxy %q is not a CIDR range", entry)
+		}
+
+		proxies = append(proxies, prefix.Masked())
+	}
+
+	return proxies, nil
+}
+
+func (p TrustedProxies) trusts(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+
+	for _, prefix := range p {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ClientIp the peer, unless it is a trusted proxy. Then the hops are read from the right,
+// the first one not appended by another trusted proxy is the client, whatever it put on the left.
+func (p TrustedProxies) ClientIp(peerIp string, forwardedFor string) string {
+	ip := peerIp
+
+	hops := strings.Split(forwardedFor, ",")
+
+	for i := len(hops) - 1; i >= 0 && p.trusts(ip); i-- {
+		hop := strings.TrimSpace(hops[i])
+		if len(hop) == 0 {
+			break
+		}
+
+		ip = hop
+	}
+
+	return ip
+}
+
+// WithDevice attaches the caller resolved by DeviceInterceptor or the HTTP device middleware
+func WithDevice(ctx context.Context, device model.Device) context.Context {
+	return context.WithValue(ctx, deviceKey{}, device)
+}
+
+// DeviceFromContext the caller attached with WithDevice, otherwise the peer of the gRPC call
+// with forwarded headers for display only
+func DeviceFromContext(ctx context.Context) model.Device {
+	if device, ok := ctx.Value(deviceKey{}).(model.Device); ok {
+		return device
+	}
+
+	return DeviceFromMetadata(ctx, nil)
+}
+
+// DeviceFromMetadata the caller of a gRPC call, X-Forwarded-For is followed through trusted proxies only
+func DeviceFromMetadata(ctx context.Context, proxies TrustedProxies) model.Device {
+	device := model.Device{}
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	// the gateway forwards the browser user agent under its own key
+	for _, key := range []string{"grpcgateway-user-agent", "user-agent"} {
+		if values := md.Get(key); len(values) > 0 {
+			device.UserAgent = values[0]
+			break
+		}
+	}
+
+	device.ForwardedFor = strings.Join(md.Get("x-forwarded-for"), ", ")
+
+	if p, ok := peer.FromContext(ctx); ok {
+		device.Ip = proxies.ClientIp(HostOf(p.Addr.String()), device.ForwardedFor)
+	}
+
+	return device
+}
+
+// DeviceFromRequest the caller of a plain HTTP request, X-Forwarded-For is followed through trusted proxies only
+func DeviceFromRequest(r *http.Request, proxies TrustedProxies) model.Device {
+	forwardedFor := strings.Join(r.Header.Values("X-Forwarded-For"), ", ")
+
+	return model.Device{
+		UserAgent:    r.UserAgent(),
+		Ip:           proxies.ClientIp(HostOf(r.RemoteAddr), forwardedFor),
+		ForwardedFor: forwardedFor,
+	}
+}
+
+// HostOf strips the port of a remote address
+func HostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
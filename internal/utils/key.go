@@ -0,0 +1,170 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/laiker/auth/internal/model"
+	"github.com/pkg/errors"
+)
+
+// SigningKey key used to sign and verify tokens.
+// Asymmetric keys carry a kid and are published in the JWKS, HMAC secrets are not.
+type SigningKey struct {
+	Id      string
+	Method  jwt.SigningMethod
+	private interface{}
+	public  interface{}
+}
+
+func NewHMACKey(secret []byte) *SigningKey {
+	return &SigningKey{
+		Method:  jwt.SigningMethodHS256,
+		private: secret,
+		public:  secret,
+	}
+}
+
+// LoadSigningKey reads a PKCS#8 or PKCS#1 private key from a PEM file
+func LoadSigningKey(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read signing key")
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("signing key is not a PEM file")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse signing key")
+		}
+
+		return NewSigningKey(key)
+	case "PRIVATE KEY":
+		return ParseSigningKey(block.Bytes)
+	default:
+		return nil, errors.Errorf("unsupported PEM block %q", block.Type)
+	}
+}
+
+// ParseSigningKey reads a PKCS#8 DER private key
+func ParseSigningKey(der []byte) (*SigningKey, error) {
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse signing key")
+	}
+
+	return NewSigningKey(key)
+}
+
+// GenerateSigningKey creates a new RS256 or EdDSA key
+func GenerateSigningKey(alg string) (*SigningKey, error) {
+	var (
+		key interface{}
+		err error
+	)
+
+	switch alg {
+	case jwt.SigningMethodRS256.Alg():
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jwt.SigningMethodEdDSA.Alg():
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, errors.Errorf("unsupported signing algorithm %q", alg)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSigningKey(key)
+}
+
+// NewSigningKey wraps an RSA or Ed25519 private key, the kid is its RFC 7638 thumbprint
+func NewSigningKey(key interface{}) (*SigningKey, error) {
+	signingKey := &SigningKey{private: key}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		signingKey.Method = jwt.SigningMethodRS256
+		signingKey.public = &k.PublicKey
+	case ed25519.PrivateKey:
+		signingKey.Method = jwt.SigningMethodEdDSA
+		signingKey.public = k.Public()
+	default:
+		return nil, errors.Errorf("unsupported signing key type %T", key)
+	}
+
+	jwk, _ := signingKey.JWK()
+
+	// members in lexicographic order as required by RFC 7638
+	var thumbprint []byte
+	if jwk.Kty == "RSA" {
+		thumbprint, _ = json.Marshal(struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N})
+	} else {
+		thumbprint, _ = json.Marshal(struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X})
+	}
+
+	sum := sha256.Sum256(thumbprint)
+	signingKey.Id = base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return signingKey, nil
+}
+
+// MarshalPrivateKey PKCS#8 DER of the private key
+func (k *SigningKey) MarshalPrivateKey() ([]byte, error) {
+	return x509.MarshalPKCS8PrivateKey(k.private)
+}
+
+// Asymmetric false for the HMAC secret, only asymmetric keys can be verified by other parties
+func (k *SigningKey) Asymmetric() bool {
+	_, ok := k.JWK()
+
+	return ok
+}
+
+// JWK public part of the key, false for symmetric keys
+func (k *SigningKey) JWK() (model.JWK, bool) {
+	jwk := model.JWK{
+		Kid: k.Id,
+		Use: "sig",
+		Alg: k.Method.Alg(),
+	}
+
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	default:
+		return model.JWK{}, false
+	}
+
+	return jwk, true
+}
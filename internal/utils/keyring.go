@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"slices"
+	"sort"
+	"sync"
+)
+
+type KeySet interface {
+	Key(kid string) (*SigningKey, bool)
+}
+
+// KeyRing current signing key and every key still accepted for verification.
+// Fallback keys come from the config, they verify forever and sign while the ring has no current key.
+// Once the ring holds an asymmetric key the HMAC secret no longer signs id_tokens, clients can not verify them.
+type KeyRing struct {
+	mu       sync.RWMutex
+	current  *SigningKey
+	idToken  *SigningKey
+	keys     map[string]*SigningKey
+	fallback []*SigningKey
+}
+
+func NewKeyRing(fallback ...*SigningKey) *KeyRing {
+	r := &KeyRing{fallback: fallback}
+	r.Load(nil, nil)
+
+	return r
+}
+
+// Load replaces the keys loaded from the storage, oldest first, a nil current falls back to the first config key
+func (r *KeyRing) Load(current *SigningKey, keys []*SigningKey) {
+	all := make(map[string]*SigningKey, len(keys)+len(r.fallback))
+
+	for _, key := range r.fallback {
+		all[key.Id] = key
+	}
+
+	for _, key := range keys {
+		all[key.Id] = key
+	}
+
+	if current == nil && len(r.fallback) > 0 {
+		current = r.fallback[0]
+	}
+
+	if current != nil {
+		all[current.Id] = current
+	}
+
+	// the HMAC secret signs id_tokens only while there is nothing else, else the newest published key does
+	idToken := current
+	if idToken != nil && !idToken.Asymmetric() {
+		for _, key := range slices.Concat(r.fallback, keys) {
+			if key.Asymmetric() {
+				idToken = key
+			}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.current = current
+	r.idToken = idToken
+	r.keys = all
+}
+
+func (r *KeyRing) Current() *SigningKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.current
+}
+
+// IdTokenKey the key id_tokens are signed with, the current key unless it is the HMAC secret
+func (r *KeyRing) IdTokenKey() *SigningKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.idToken
+}
+
+func (r *KeyRing) Key(kid string) (*SigningKey, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	key, ok := r.keys[kid]
+
+	return key, ok
+}
+
+// Keys every verification key ordered by kid
+func (r *KeyRing) Keys() []*SigningKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := make([]*SigningKey, 0, len(r.keys))
+	for _, key := range r.keys {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Id < keys[j].Id
+	})
+
+	return keys
+}
@@ -0,0 +1,17 @@
+package utils
+
+import "net/url"
+
+// TokenLink adds the token to the query of a frontend page, keeping the parameters it already has
+func TokenLink(page string, token string) (string, error) {
+	link, err := url.Parse(page)
+	if err != nil {
+		return "", err
+	}
+
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	return link.String(), nil
+}
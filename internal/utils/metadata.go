@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	authPrefix   = "Bearer "
+	apiKeyPrefix = "ApiKey "
+)
+
+// GetBearerToken extracts the access token from the authorization metadata
+func GetBearerToken(ctx context.Context) (string, error) {
+	return getAuthorization(ctx, authPrefix)
+}
+
+// GetApiKey extracts the API key from authorization metadata of the ApiKey scheme
+func GetApiKey(ctx context.Context) (string, error) {
+	return getAuthorization(ctx, apiKeyPrefix)
+}
+
+func getAuthorization(ctx context.Context, prefix string) (string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", errors.New("metadata is not provided")
+	}
+
+	authHeader, ok := md["authorization"]
+	if !ok || len(authHeader) == 0 {
+		return "", errors.New("authorization header is not provided")
+	}
+
+	if !strings.HasPrefix(authHeader[0], prefix) {
+		return "", errors.New("invalid authorization header format")
+	}
+
+	return strings.TrimPrefix(authHeader[0], prefix), nil
+}
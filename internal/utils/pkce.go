@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"regexp"
+)
+
+// RFC 7636 4.1 code verifier and the length of a base64url encoded S256 challenge
+var (
+	codeVerifierRegexp  = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+	codeChallengeRegexp = regexp.MustCompile(`^[A-Za-z0-9\-_]{43}$`)
+)
+
+func IsCodeChallenge(challenge string) bool {
+	return codeChallengeRegexp.MatchString(challenge)
+}
+
+// VerifyCodeChallenge checks the S256 PKCE challenge, the plain method is not supported
+func VerifyCodeChallenge(challenge string, verifier string) bool {
+	if !codeVerifierRegexp.MatchString(verifier) {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
@@ -0,0 +1,153 @@
+package test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/utils"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestDeviceFromContext(t *testing.T) {
+	withPeer := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 53122},
+	})
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want model.Device
+	}{
+		{
+			name: "grpc peer",
+			ctx:  metadata.NewIncomingContext(withPeer, metadata.Pairs("user-agent", "grpc-go/1.70")),
+			want: model.Device{UserAgent: "grpc-go/1.70", Ip: "10.0.0.2"},
+		},
+		{
+			// without trusted proxies the header is only shown, the client could have sent it
+			name: "forwarded",
+			ctx: metadata.NewIncomingContext(withPeer, metadata.Pairs(
+				"user-agent", "grpc-go/1.70",
+				"grpcgateway-user-agent", "Mozilla/5.0",
+				"x-forwarded-for", "203.0.113.7, 10.0.0.1",
+			)),
+			want: model.Device{UserAgent: "Mozilla/5.0", Ip: "10.0.0.2", ForwardedFor: "203.0.113.7, 10.0.0.1"},
+		},
+		{
+			name: "plain http",
+			ctx:  utils.WithDevice(withPeer, model.Device{UserAgent: "curl/8.5", Ip: "198.51.100.4"}),
+			want: model.Device{UserAgent: "curl/8.5", Ip: "198.51.100.4"},
+		},
+		{
+			name: "unknown",
+			ctx:  context.Background(),
+			want: model.Device{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := utils.DeviceFromContext(tt.ctx); got != tt.want {
+				t.Errorf("DeviceFromContext() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeviceFromMetadata(t *testing.T) {
+	proxies, err := utils.ParseTrustedProxies("127.0.0.1, 10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies() error = %v", err)
+	}
+
+	// the gateway appended the address of the proxy, which appended the browser and kept the forged hop
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 53122}})
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-forwarded-for", "192.0.2.1, 203.0.113.7, 10.0.0.1"))
+
+	want := model.Device{Ip: "203.0.113.7", ForwardedFor: "192.0.2.1, 203.0.113.7, 10.0.0.1"}
+
+	if got := utils.DeviceFromMetadata(ctx, proxies); got != want {
+		t.Errorf("DeviceFromMetadata() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDeviceFromRequest(t *testing.T) {
+	proxies, err := utils.ParseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies() error = %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		want         string
+	}{
+		{
+			name:       "direct",
+			remoteAddr: "198.51.100.4:41000",
+			want:       "198.51.100.4",
+		},
+		{
+			name:         "forged without a proxy",
+			remoteAddr:   "198.51.100.4:41000",
+			forwardedFor: []string{"203.0.113.7"},
+			want:         "198.51.100.4",
+		},
+		{
+			name:         "through the proxy",
+			remoteAddr:   "10.0.0.1:41000",
+			forwardedFor: []string{"192.0.2.1, 203.0.113.7"},
+			want:         "203.0.113.7",
+		},
+		{
+			name:         "headers of the client and the proxy",
+			remoteAddr:   "10.0.0.1:41000",
+			forwardedFor: []string{"192.0.2.1", "203.0.113.7"},
+			want:         "203.0.113.7",
+		},
+		{
+			name:         "chain of proxies",
+			remoteAddr:   "[::ffff:10.0.0.1]:41000",
+			forwardedFor: []string{"203.0.113.7, 10.0.0.2"},
+			want:         "203.0.113.7",
+		},
+		{
+			name:       "proxy without a header",
+			remoteAddr: "10.0.0.1:41000",
+			want:       "10.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/oauth/token", nil)
+			r.RemoteAddr = tt.remoteAddr
+
+			for _, value := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+
+			if got := utils.DeviceFromRequest(r, proxies).Ip; got != tt.want {
+				t.Errorf("DeviceFromRequest().Ip = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	for _, value := range []string{"10.0.0.0/33", "proxy.internal", "10.0.0.1:8080"} {
+		if _, err := utils.ParseTrustedProxies(value); err == nil {
+			t.Errorf("ParseTrustedProxies(%q) accepted an invalid proxy", value)
+		}
+	}
+
+	if proxies, err := utils.ParseTrustedProxies(""); err != nil || len(proxies) != 0 {
+		t.Errorf("ParseTrustedProxies(\"\") = %v, %v, want no proxies", proxies, err)
+	}
+}
@@ -0,0 +1,167 @@
+package test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/laiker/auth/internal/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheapArgon2 keeps the tests fast, production defaults are in env.NewPasswordHashConfig
+var cheapArgon2 = utils.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1}
+
+func newHasher(t testing.TB, algorithm string, params utils.Argon2Params, cost int) utils.PasswordHasher {
+	t.Helper()
+
+	h, err := utils.NewPasswordHasher(algorithm, params, cost)
+	if err != nil {
+		t.Fatalf("NewPasswordHasher() error = %v", err)
+	}
+
+	return h
+}
+
+func TestPasswordHasher_Argon2id(t *testing.T) {
+	h := newHasher(t, utils.PasswordArgon2id, cheapArgon2, bcrypt.MinCost)
+
+	hash, err := h.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") || strings.Count(hash, "$") != 5 {
+		t.Fatalf("hash %q is not a PHC string", hash)
+	}
+
+	if other, _ := h.Hash("correct horse battery staple"); other == hash {
+		t.Fatal("two hashes of a password are equal, the salt is not random")
+	}
+
+	if ok, rehash := h.Verify(hash, "correct horse battery staple"); !ok || rehash {
+		t.Fatalf("Verify() = %v, %v, want true, false", ok, rehash)
+	}
+
+	if ok, _ := h.Verify(hash, "wrong password"); ok {
+		t.Fatal("a wrong password matched")
+	}
+}
+
+func TestPasswordHasher_Rehash(t *testing.T) {
+	argon := newHasher(t, utils.PasswordArgon2id, cheapArgon2, bcrypt.MinCost)
+	stronger := newHasher(t, utils.PasswordArgon2id, utils.Argon2Params{Memory: 2048, Iterations: 1, Parallelism: 1}, bcrypt.MinCost)
+	legacy := newHasher(t, utils.PasswordBcrypt, cheapArgon2, bcrypt.MinCost)
+	costlier := newHasher(t, utils.PasswordBcrypt, cheapArgon2, bcrypt.MinCost+1)
+
+	argonHash, _ := argon.Hash("secret")
+	bcryptHash, _ := legacy.Hash("secret")
+
+	tests := []struct {
+		name       string
+		hasher     utils.PasswordHasher
+		hash       string
+		wantRehash bool
+	}{
+		{name: "same argon2id parameters", hasher: argon, hash: argonHash, wantRehash: false},
+		{name: "more argon2id memory", hasher: stronger, hash: argonHash, wantRehash: true},
+		{name: "bcrypt to argon2id", hasher: argon, hash: bcryptHash, wantRehash: true},
+		{name: "argon2id to bcrypt", hasher: legacy, hash: argonHash, wantRehash: true},
+		{name: "same bcrypt cost", hasher: legacy, hash: bcryptHash, wantRehash: false},
+		{name: "higher bcrypt cost", hasher: costlier, hash: bcryptHash, wantRehash: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, rehash := tt.hasher.Verify(tt.hash, "secret")
+			if !ok || rehash != tt.wantRehash {
+				t.Errorf("Verify() = %v, %v, want true, %v", ok, rehash, tt.wantRehash)
+			}
+
+			if ok, rehash = tt.hasher.Verify(tt.hash, "wrong"); ok || rehash {
+				t.Errorf("Verify() of a wrong password = %v, %v", ok, rehash)
+			}
+		})
+	}
+}
+
+func TestPasswordHasher_MalformedHash(t *testing.T) {
+	h := newHasher(t, utils.PasswordArgon2id, cheapArgon2, bcrypt.MinCost)
+
+	for _, hash := range []string{
+		"",
+		"secret",
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA",
+		"$argon2id$v=16$m=1024,t=1,p=1$c2FsdHNhbHQ$aGFzaA",
+		"$argon2id$v=19$m=1024,t=0,p=1$c2FsdHNhbHQ$aGFzaA",
+		"$argon2i$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ$aGFzaA",
+		"$2a$10$invalid",
+	} {
+		if ok, rehash := h.Verify(hash, "secret"); ok || rehash {
+			t.Errorf("Verify(%q) = %v, %v", hash, ok, rehash)
+		}
+	}
+}
+
+func TestNewPasswordHasher_Invalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm string
+		params    utils.Argon2Params
+		cost      int
+	}{
+		{name: "unknown algorithm", algorithm: "scrypt", params: cheapArgon2, cost: bcrypt.DefaultCost},
+		{name: "no argon2 iterations", algorithm: utils.PasswordArgon2id, params: utils.Argon2Params{Memory: 1024, Parallelism: 1}},
+		{name: "argon2 memory below 8 KiB a lane", algorithm: utils.PasswordArgon2id, params: utils.Argon2Params{Memory: 16, Iterations: 1, Parallelism: 4}},
+		{name: "bcrypt cost too high", algorithm: utils.PasswordBcrypt, cost: bcrypt.MaxCost + 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := utils.NewPasswordHasher(tt.algorithm, tt.params, tt.cost); err == nil {
+				t.Error("NewPasswordHasher() accepted invalid parameters")
+			}
+		})
+	}
+}
+
+// BenchmarkPasswordHasher_Argon2id time of a login at each cost, pick parameters that take
+// tens of milliseconds on the production hardware: go test -bench Argon2id ./internal/utils/test
+func BenchmarkPasswordHasher_Argon2id(b *testing.B) {
+	for _, params := range []utils.Argon2Params{
+		{Memory: 19 * 1024, Iterations: 2, Parallelism: 1},
+		{Memory: 46 * 1024, Iterations: 1, Parallelism: 1},
+		{Memory: 64 * 1024, Iterations: 1, Parallelism: 4},
+		{Memory: 64 * 1024, Iterations: 3, Parallelism: 4},
+		{Memory: 128 * 1024, Iterations: 3, Parallelism: 4},
+		{Memory: 256 * 1024, Iterations: 2, Parallelism: 4},
+	} {
+		b.Run(fmt.Sprintf("m=%d,t=%d,p=%d", params.Memory, params.Iterations, params.Parallelism), func(b *testing.B) {
+			benchmarkVerify(b, newHasher(b, utils.PasswordArgon2id, params, bcrypt.DefaultCost))
+		})
+	}
+}
+
+func BenchmarkPasswordHasher_Bcrypt(b *testing.B) {
+	for cost := bcrypt.DefaultCost; cost <= 13; cost++ {
+		b.Run(fmt.Sprintf("cost=%d", cost), func(b *testing.B) {
+			benchmarkVerify(b, newHasher(b, utils.PasswordBcrypt, cheapArgon2, cost))
+		})
+	}
+}
+
+func benchmarkVerify(b *testing.B, h utils.PasswordHasher) {
+	hash, err := h.Hash("correct horse battery staple")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if ok, _ := h.Verify(hash, "correct horse battery staple"); !ok {
+			b.Fatal("the password did not match")
+		}
+	}
+}
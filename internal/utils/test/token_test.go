@@ -0,0 +1,192 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/laiker/auth/internal/model"
+	"github.com/laiker/auth/internal/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	secret   = "access-secret"
+	issuer   = "https://auth.example.com"
+	audience = "https://api.example.com"
+)
+
+var policy = utils.TokenPolicy{
+	Issuer:   issuer,
+	Audience: audience,
+	Type:     model.TokenTypeAccess,
+	Leeway:   30 * time.Second,
+}
+
+// validClaims claims accepted by policy, the cases below break one of them
+func validClaims() model.UserClaims {
+	now := time.Now()
+
+	return model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    issuer,
+			Audience:  audience,
+			Subject:   "1",
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(time.Minute).Unix(),
+		},
+		Type:   model.TokenTypeAccess,
+		UserId: 1,
+		Role:   "user",
+	}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, claims model.UserClaims) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("SignedString() error = %v", err)
+	}
+
+	return token
+}
+
+func TestVerifyToken(t *testing.T) {
+	keys := utils.NewKeyRing(utils.NewHMACKey([]byte(secret)))
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		modify  func(c *model.UserClaims)
+		key     string
+		method  jwt.SigningMethod
+		wantErr error
+	}{
+		{
+			name:   "valid",
+			modify: func(*model.UserClaims) {},
+		},
+		{
+			name:    "other secret",
+			modify:  func(*model.UserClaims) {},
+			key:     "refresh-secret",
+			wantErr: utils.ErrTokenInvalid,
+		},
+		{
+			name:    "other algorithm",
+			modify:  func(*model.UserClaims) {},
+			method:  jwt.SigningMethodHS512,
+			wantErr: utils.ErrTokenInvalid,
+		},
+		{
+			name:    "expired",
+			modify:  func(c *model.UserClaims) { c.ExpiresAt = now.Add(-time.Minute).Unix() },
+			wantErr: utils.ErrTokenExpired,
+		},
+		{
+			name:   "expired within the leeway",
+			modify: func(c *model.UserClaims) { c.ExpiresAt = now.Add(-10 * time.Second).Unix() },
+		},
+		{
+			name:    "without expiration",
+			modify:  func(c *model.UserClaims) { c.ExpiresAt = 0 },
+			wantErr: utils.ErrTokenExpired,
+		},
+		{
+			name:    "not before in the future",
+			modify:  func(c *model.UserClaims) { c.NotBefore = now.Add(time.Minute).Unix() },
+			wantErr: utils.ErrTokenNotYetValid,
+		},
+		{
+			name:   "not before within the leeway",
+			modify: func(c *model.UserClaims) { c.NotBefore = now.Add(10 * time.Second).Unix() },
+		},
+		{
+			name:    "issued in the future",
+			modify:  func(c *model.UserClaims) { c.IssuedAt = now.Add(time.Minute).Unix() },
+			wantErr: utils.ErrTokenNotYetValid,
+		},
+		{
+			name:    "other issuer",
+			modify:  func(c *model.UserClaims) { c.Issuer = "https://evil.example.com" },
+			wantErr: utils.ErrTokenIssuer,
+		},
+		{
+			name:    "without issuer",
+			modify:  func(c *model.UserClaims) { c.Issuer = "" },
+			wantErr: utils.ErrTokenIssuer,
+		},
+		{
+			name:    "other audience",
+			modify:  func(c *model.UserClaims) { c.Audience = "https://other.example.com" },
+			wantErr: utils.ErrTokenAudience,
+		},
+		{
+			name:    "without audience",
+			modify:  func(c *model.UserClaims) { c.Audience = "" },
+			wantErr: utils.ErrTokenAudience,
+		},
+		{
+			name:    "refresh token",
+			modify:  func(c *model.UserClaims) { c.Type = model.TokenTypeRefresh },
+			wantErr: utils.ErrTokenType,
+		},
+		{
+			name:    "without type",
+			modify:  func(c *model.UserClaims) { c.Type = "" },
+			wantErr: utils.ErrTokenType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims()
+			tt.modify(&claims)
+
+			key, method := tt.key, tt.method
+			if key == "" {
+				key = secret
+			}
+
+			if method == nil {
+				method = jwt.SigningMethodHS256
+			}
+
+			got, err := utils.VerifyToken(sign(t, method, []byte(key), claims), keys, policy)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("VerifyToken() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr == nil && got.UserId != claims.UserId {
+				t.Errorf("VerifyToken() = %+v", got)
+			}
+		})
+	}
+}
+
+func TestGenerateToken(t *testing.T) {
+	key := utils.NewHMACKey([]byte(secret))
+
+	token, err := utils.GenerateToken(model.UserJwt{ClientId: "gateway", Scope: "users:read"}, key, policy, time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateToken() error = %v", err)
+	}
+
+	claims, err := utils.VerifyToken(token, utils.NewKeyRing(key), policy)
+	if err != nil {
+		t.Fatalf("VerifyToken() error = %v", err)
+	}
+
+	if claims.Subject != "gateway" || claims.Type != model.TokenTypeAccess || claims.NotBefore == 0 || claims.IssuedAt == 0 {
+		t.Errorf("GenerateToken() claims = %+v", claims)
+	}
+
+	refresh := policy
+	refresh.Type = model.TokenTypeRefresh
+
+	if _, err = utils.VerifyToken(token, utils.NewKeyRing(key), refresh); !errors.Is(err, utils.ErrTokenType) {
+		t.Errorf("VerifyToken() as a refresh token error = %v, want %v", err, utils.ErrTokenType)
+	}
+}
@@ -0,0 +1,78 @@
+package test
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/laiker/auth/internal/utils"
+)
+
+// rfc6238Secret SHA1 seed of the RFC 6238 appendix B test vectors
+var rfc6238Secret = []byte("12345678901234567890")
+
+func TestTOTPCode(t *testing.T) {
+	// the last six digits of the RFC 6238 appendix B values
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := utils.TOTPCode(rfc6238Secret, utils.TOTPCounter(time.Unix(tt.unix, 0))); got != tt.want {
+				t.Errorf("TOTPCode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	counter := utils.TOTPCounter(now)
+
+	tests := []struct {
+		name        string
+		code        string
+		wantCounter int64
+		wantOk      bool
+	}{
+		{name: "current step", code: utils.TOTPCode(rfc6238Secret, counter), wantCounter: counter, wantOk: true},
+		{name: "previous step", code: utils.TOTPCode(rfc6238Secret, counter-1), wantCounter: counter - 1, wantOk: true},
+		{name: "next step", code: utils.TOTPCode(rfc6238Secret, counter+1), wantCounter: counter + 1, wantOk: true},
+		{name: "two steps ago", code: utils.TOTPCode(rfc6238Secret, counter-2)},
+		{name: "wrong length", code: "12345"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotCounter, gotOk := utils.VerifyTOTP(rfc6238Secret, tt.code, now)
+			if gotOk != tt.wantOk || gotOk && gotCounter != tt.wantCounter {
+				t.Errorf("VerifyTOTP() = %d, %v, want %d, %v", gotCounter, gotOk, tt.wantCounter, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestTOTPUri(t *testing.T) {
+	uri, err := url.Parse(utils.TOTPUri("Example Auth", "alice@example.com", rfc6238Secret))
+	if err != nil {
+		t.Fatalf("TOTPUri() is not a URI: %v", err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Example Auth:alice@example.com" {
+		t.Errorf("TOTPUri() = %s, want otpauth://totp/Example Auth:alice@example.com", uri)
+	}
+
+	query := uri.Query()
+	if query.Get("secret") != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" || query.Get("issuer") != "Example Auth" {
+		t.Errorf("TOTPUri() query = %v", query)
+	}
+}
@@ -0,0 +1,239 @@
+package test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/laiker/auth/internal/utils"
+	"github.com/pkg/errors"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	// RFC 8949 appendix A
+	tests := []struct {
+		hex  string
+		want interface{}
+	}{
+		{hex: "00", want: int64(0)},
+		{hex: "17", want: int64(23)},
+		{hex: "1818", want: int64(24)},
+		{hex: "190100", want: int64(256)},
+		{hex: "1a000f4240", want: int64(1000000)},
+		{hex: "20", want: int64(-1)},
+		{hex: "3863", want: int64(-100)},
+		{hex: "43010203", want: []byte{1, 2, 3}},
+		{hex: "6449455446", want: "IETF"},
+		{hex: "83010203", want: []interface{}{int64(1), int64(2), int64(3)}},
+		{hex: "a201020304", want: map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(4)}},
+		{hex: "c11a514b67b0", want: int64(1363896240)},
+		{hex: "f4", want: false},
+		{hex: "f5", want: true},
+		{hex: "f6", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.hex, func(t *testing.T) {
+			data, _ := hex.DecodeString(tt.hex)
+
+			got, rest, err := utils.DecodeCBOR(data)
+			if err != nil {
+				t.Fatalf("DecodeCBOR() error = %v", err)
+			}
+
+			if len(rest) != 0 || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DecodeCBOR() = %#v, rest %x, want %#v", got, rest, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeCBOR_Malformed(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+	}{
+		{name: "empty", hex: ""},
+		{name: "truncated integer", hex: "1a0000"},
+		{name: "truncated bytes", hex: "4401"},
+		{name: "indefinite length", hex: "5f42010243030405ff"},
+		{name: "array longer than the data", hex: "9a0fffffff"},
+		{name: "array map key", hex: "a1820102f5"},
+		{name: "too deep", hex: "818181818181818181818181818181818181818100"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := hex.DecodeString(tt.hex)
+
+			if _, _, err := utils.DecodeCBOR(data); err == nil {
+				t.Errorf("DecodeCBOR() succeeded, want an error")
+			}
+		})
+	}
+}
+
+// FuzzDecodeCBOR authenticators are not trusted, any input must fail cleanly
+// and a decoded item must consume a part of the data
+func FuzzDecodeCBOR(f *testing.F) {
+	for _, seed := range []string{
+		"1a000f4240", "3863", "43010203", "6449455446", "83010203", "a201020304", "c11a514b67b0", "f5",
+		"fa47c35000", "9a0fffffff", "a1820102f5", "818181818181818181818181818181818181818100",
+		"a3636669746464666e6f6e656761747453746d74a068617574684461746141ff",
+	} {
+		data, _ := hex.DecodeString(seed)
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, rest, err := utils.DecodeCBOR(data)
+		if err != nil {
+			return
+		}
+
+		if len(rest) >= len(data) || !bytes.Equal(rest, data[len(data)-len(rest):]) {
+			t.Errorf("DecodeCBOR(%x) rest = %x, want a shorter suffix", data, rest)
+		}
+	})
+}
+
+// ed25519COSEKey COSE_Key {1: 1, 3: -8, -1: 6, -2: x}
+func ed25519COSEKey(public ed25519.PublicKey) []byte {
+	return append([]byte{0xa4, 0x01, 0x01, 0x03, 0x27, 0x20, 0x06, 0x21, 0x58, 0x20}, public...)
+}
+
+// es256COSEKey COSE_Key {1: 2, 3: -7, -1: 1, -2: x, -3: y}
+func es256COSEKey(x, y []byte) []byte {
+	key := append([]byte{0xa5, 0x01, 0x02, 0x03, 0x26, 0x20, 0x01, 0x21, 0x58, 0x20}, x...)
+
+	return append(append(key, 0x22, 0x58, 0x20), y...)
+}
+
+func TestCOSEKey_VerifyAssertion(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	key, err := utils.ParseCOSEKey(ed25519COSEKey(public))
+	if err != nil {
+		t.Fatalf("ParseCOSEKey() error = %v", err)
+	}
+
+	if key.Alg != utils.COSEAlgEdDSA {
+		t.Errorf("ParseCOSEKey() alg = %d, want %d", key.Alg, utils.COSEAlgEdDSA)
+	}
+
+	authData := make([]byte, 37)
+	clientData := []byte(`{"type":"webauthn.get"}`)
+	clientDataHash := sha256.Sum256(clientData)
+	signature := ed25519.Sign(private, append(append([]byte(nil), authData...), clientDataHash[:]...))
+
+	if err = key.VerifyAssertion(authData, clientData, signature); err != nil {
+		t.Errorf("VerifyAssertion() error = %v", err)
+	}
+
+	if err = key.VerifyAssertion(authData, []byte(`{"type":"webauthn.create"}`), signature); !errors.Is(err, utils.ErrSignature) {
+		t.Errorf("VerifyAssertion() of other client data error = %v, want %v", err, utils.ErrSignature)
+	}
+}
+
+func TestParseCOSEKey_Unsupported(t *testing.T) {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	one := append(make([]byte, 31), 1)
+
+	tests := []struct {
+		name string
+		key  []byte
+	}{
+		{name: "not a map", key: []byte{0x01}},
+		// alg -7 with an OKP key
+		{name: "algorithm of another key type", key: append([]byte{0xa4, 0x01, 0x01, 0x03, 0x26, 0x20, 0x06, 0x21, 0x58, 0x20}, public...)},
+		{name: "point not on the curve", key: es256COSEKey(one, one)},
+		{name: "trailing bytes", key: append(ed25519COSEKey(public), 0x00)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := utils.ParseCOSEKey(tt.key); !errors.Is(err, utils.ErrCOSEKey) {
+				t.Errorf("ParseCOSEKey() error = %v, want %v", err, utils.ErrCOSEKey)
+			}
+		})
+	}
+}
+
+func TestParseAuthenticatorData(t *testing.T) {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	rpIdHash := sha256.Sum256([]byte("example.com"))
+	data := append([]byte(nil), rpIdHash[:]...)
+	data = append(data, utils.AuthenticatorUserPresent|utils.AuthenticatorUserVerified|utils.AuthenticatorAttested, 0, 0, 0, 5)
+	data = append(data, make([]byte, 16)...)
+	data = append(data, 0, 2, 0xca, 0xfe)
+	data = append(data, ed25519COSEKey(public)...)
+
+	authData, err := utils.ParseAuthenticatorData(data)
+	if err != nil {
+		t.Fatalf("ParseAuthenticatorData() error = %v", err)
+	}
+
+	if !authData.CheckRPID("example.com") || authData.CheckRPID("evil.example") {
+		t.Errorf("CheckRPID() does not match the rp id hash")
+	}
+
+	if !authData.UserPresent() || !authData.UserVerified() || authData.SignCount != 5 {
+		t.Errorf("ParseAuthenticatorData() = %+v", authData)
+	}
+
+	if !reflect.DeepEqual(authData.CredentialId, []byte{0xca, 0xfe}) || !reflect.DeepEqual(authData.PublicKey, ed25519COSEKey(public)) {
+		t.Errorf("ParseAuthenticatorData() credential = %x, key = %x", authData.CredentialId, authData.PublicKey)
+	}
+
+	for _, malformed := range [][]byte{data[:36], data[:len(data)-1], append(data, 0)} {
+		if _, err = utils.ParseAuthenticatorData(malformed); !errors.Is(err, utils.ErrAuthenticatorData) {
+			t.Errorf("ParseAuthenticatorData() of %d bytes error = %v, want %v", len(malformed), err, utils.ErrAuthenticatorData)
+		}
+	}
+}
+
+// FuzzParseAuthenticatorData a credential is parsed exactly when the attested flag is set,
+// its key is handed to ParseCOSEKey like a registration does
+func FuzzParseAuthenticatorData(f *testing.F) {
+	rpIdHash := sha256.Sum256([]byte("example.com"))
+	data := append([]byte(nil), rpIdHash[:]...)
+	data = append(data, utils.AuthenticatorUserPresent|utils.AuthenticatorAttested|utils.AuthenticatorExtensions, 0, 0, 0, 5)
+	data = append(data, make([]byte, 16)...)
+	data = append(data, 0, 2, 0xca, 0xfe)
+	data = append(data, ed25519COSEKey(make(ed25519.PublicKey, ed25519.PublicKeySize))...)
+	data = append(data, 0xa0)
+
+	f.Add(data)
+	f.Add(data[:37])
+	f.Add(data[:len(data)-1])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		authData, err := utils.ParseAuthenticatorData(data)
+		if err != nil {
+			return
+		}
+
+		if attested := authData.Flags&utils.AuthenticatorAttested != 0; attested != (authData.CredentialId != nil) {
+			t.Fatalf("ParseAuthenticatorData(%x) credential %x with flags %08b", data, authData.CredentialId, authData.Flags)
+		}
+
+		if authData.PublicKey != nil {
+			_, _ = utils.ParseCOSEKey(authData.PublicKey)
+		}
+	})
+}
@@ -1,52 +1,156 @@
 package utils
 
 import (
-	"fmt"
+	"strconv"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/pkg/errors"
 
 	"github.com/laiker/auth/internal/model"
 )
 
-func GenerateToken(info model.UserJwt, secretKey []byte, duration time.Duration) (string, error) {
-	fmt.Printf("%v", info)
+var (
+	ErrTokenInvalid     = errors.New("token signature is invalid")
+	ErrTokenExpired     = errors.New("token is expired")
+	ErrTokenNotYetValid = errors.New("token is not valid yet")
+	ErrTokenIssuer      = errors.New("token issuer mismatch")
+	ErrTokenAudience    = errors.New("token audience mismatch")
+	ErrTokenType        = errors.New("token type mismatch")
+)
+
+// TokenPolicy claims every token of one kind is issued with and checked against
+type TokenPolicy struct {
+	Issuer   string
+	Audience string
+	// Type model.TokenTypeAccess or model.TokenTypeRefresh, keeps one kind from being replayed as the other
+	Type string
+	// Leeway clock skew tolerated between the issuer and the verifier
+	Leeway time.Duration
+}
+
+func GenerateToken(info model.UserJwt, key *SigningKey, policy TokenPolicy, duration time.Duration) (string, error) {
+	now := time.Now()
+
+	subject := strconv.FormatInt(info.UserId, 10)
+	if info.ClientId != "" {
+		subject = info.ClientId
+	}
+
+	audience := policy.Audience
+	if info.Audience != "" {
+		audience = info.Audience
+	}
+
 	claims := model.UserClaims{
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(duration).Unix(),
+			Id:        info.TokenId,
+			Issuer:    policy.Issuer,
+			Audience:  audience,
+			Subject:   subject,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(duration).Unix(),
 		},
-		UserId: info.UserId,
-		Role:   info.Role,
+		Type:       policy.Type,
+		UserId:     info.UserId,
+		UserLogin:  info.UserLogin,
+		Role:       info.Role,
+		FamilyId:   info.FamilyId,
+		ClientId:   info.ClientId,
+		Scope:      info.Scope,
+		Azp:        info.Azp,
+		Amr:        info.Amr,
+		Act:        info.Act,
+		IssuedAtMs: now.UnixMilli(),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return signClaims(claims, key)
+}
+
+// GenerateIdToken signs OpenID Connect id_token claims with the access token key
+func GenerateIdToken(claims model.IdTokenClaims, key *SigningKey, duration time.Duration) (string, error) {
+	claims.IssuedAt = time.Now().Unix()
+	claims.ExpiresAt = time.Now().Add(duration).Unix()
 
-	return token.SignedString(secretKey)
+	return signClaims(claims, key)
 }
 
-func VerifyToken(tokenStr string, secretKey []byte) (*model.UserClaims, error) {
+func signClaims(claims jwt.Claims, key *SigningKey) (string, error) {
+	token := jwt.NewWithClaims(key.Method, claims)
 
-	token, err := jwt.ParseWithClaims(
+	if key.Id != "" {
+		token.Header["kid"] = key.Id
+	}
+
+	return token.SignedString(key.private)
+}
+
+// VerifyToken checks the token against the key with the matching kid,
+// tokens without kid are checked against the HMAC key.
+// The claims must match the policy, time claims are compared with the policy leeway.
+func VerifyToken(tokenStr string, keys KeySet, policy TokenPolicy) (*model.UserClaims, error) {
+	// the time claims are checked below, the parser has no leeway
+	parser := jwt.NewParser(jwt.WithoutClaimsValidation())
+
+	token, err := parser.ParseWithClaims(
 		tokenStr,
 		&model.UserClaims{},
 		func(token *jwt.Token) (interface{}, error) {
-			_, ok := token.Method.(*jwt.SigningMethodHMAC)
+			kid, _ := token.Header["kid"].(string)
+
+			key, ok := keys.Key(kid)
 			if !ok {
+				return nil, errors.Errorf("unknown signing key %q", kid)
+			}
+
+			// the algorithm is bound to the key, never taken from the token
+			if token.Method.Alg() != key.Method.Alg() {
 				return nil, errors.Errorf("unexpected token signing method")
 			}
 
-			return secretKey, nil
+			return key.public, nil
 		},
 	)
 	if err != nil {
-		return nil, errors.Errorf("invalid token: %s", err.Error())
+		return nil, errors.Wrap(ErrTokenInvalid, err.Error())
 	}
 
 	claims, ok := token.Claims.(*model.UserClaims)
 	if !ok {
-		return nil, errors.Errorf("invalid token claims")
+		return nil, errors.Wrap(ErrTokenInvalid, "invalid token claims")
+	}
+
+	err = checkClaims(claims, policy, time.Now())
+	if err != nil {
+		return nil, err
 	}
 
 	return claims, nil
 }
+
+func checkClaims(claims *model.UserClaims, policy TokenPolicy, now time.Time) error {
+	leeway := int64(policy.Leeway.Seconds())
+
+	if claims.ExpiresAt == 0 || now.Unix() > claims.ExpiresAt+leeway {
+		return ErrTokenExpired
+	}
+
+	if now.Unix() < claims.NotBefore-leeway || now.Unix() < claims.IssuedAt-leeway {
+		return ErrTokenNotYetValid
+	}
+
+	if claims.Issuer != policy.Issuer {
+		return ErrTokenIssuer
+	}
+
+	if !claims.VerifyAudience(policy.Audience, true) {
+		return ErrTokenAudience
+	}
+
+	if claims.Type != policy.Type {
+		return ErrTokenType
+	}
+
+	return nil
+}
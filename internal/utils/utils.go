@@ -1,8 +0,0 @@
-package utils
-
-import "golang.org/x/crypto/bcrypt"
-
-func VerifyPassword(hashedPassword string, candidatePassword string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(candidatePassword))
-	return err == nil
-}
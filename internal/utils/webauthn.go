@@ -0,0 +1,274 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// authenticator data flags of WebAuthn §6.1
+const (
+	AuthenticatorUserPresent  byte = 0x01
+	AuthenticatorUserVerified byte = 0x04
+	AuthenticatorAttested     byte = 0x40
+	AuthenticatorExtensions   byte = 0x80
+)
+
+// COSE algorithms accepted for credential public keys
+const (
+	COSEAlgES256 int64 = -7
+	COSEAlgEdDSA int64 = -8
+	COSEAlgRS256 int64 = -257
+)
+
+// COSEAlgorithms preference order offered in pubKeyCredParams
+var COSEAlgorithms = []int64{COSEAlgES256, COSEAlgEdDSA, COSEAlgRS256}
+
+const (
+	authenticatorDataMinSize = 37
+	aaguidSize               = 16
+	rsaMinBits               = 2048
+)
+
+var (
+	ErrAuthenticatorData = errors.New("malformed authenticator data")
+	ErrCOSEKey           = errors.New("unsupported credential public key")
+	ErrSignature         = errors.New("assertion signature is invalid")
+)
+
+// WebAuthnEncoding base64url without padding, used by WebAuthn for every binary value in JSON
+var WebAuthnEncoding = base64.RawURLEncoding
+
+// ClientData collectedClientData the browser signs over, WebAuthn §5.8.1
+type ClientData struct {
+	// Type webauthn.create or webauthn.get
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+func ParseClientData(raw []byte) (*ClientData, error) {
+	clientData := &ClientData{}
+
+	err := json.Unmarshal(raw, clientData)
+	if err != nil {
+		return nil, errors.Wrap(err, "malformed client data")
+	}
+
+	return clientData, nil
+}
+
+type AuthenticatorData struct {
+	RPIDHash  []byte
+	Flags     byte
+	SignCount uint32
+	// CredentialId and PublicKey are only present in registration responses
+	CredentialId []byte
+	// PublicKey COSE_Key encoded credential public key
+	PublicKey []byte
+}
+
+func (a *AuthenticatorData) UserPresent() bool {
+	return a.Flags&AuthenticatorUserPresent != 0
+}
+
+func (a *AuthenticatorData) UserVerified() bool {
+	return a.Flags&AuthenticatorUserVerified != 0
+}
+
+// CheckRPID the data was produced for our relying party
+func (a *AuthenticatorData) CheckRPID(rpId string) bool {
+	hash := sha256.Sum256([]byte(rpId))
+
+	return subtle.ConstantTimeCompare(a.RPIDHash, hash[:]) == 1
+}
+
+func ParseAuthenticatorData(data []byte) (*AuthenticatorData, error) {
+	if len(data) < authenticatorDataMinSize {
+		return nil, ErrAuthenticatorData
+	}
+
+	authData := &AuthenticatorData{
+		RPIDHash:  append([]byte(nil), data[:32]...),
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+
+	rest := data[authenticatorDataMinSize:]
+
+	if authData.Flags&AuthenticatorAttested != 0 {
+		if len(rest) < aaguidSize+2 {
+			return nil, ErrAuthenticatorData
+		}
+
+		rest = rest[aaguidSize:]
+		idLength := int(binary.BigEndian.Uint16(rest))
+		rest = rest[2:]
+
+		if idLength == 0 || len(rest) < idLength {
+			return nil, ErrAuthenticatorData
+		}
+
+		authData.CredentialId = append([]byte(nil), rest[:idLength]...)
+		rest = rest[idLength:]
+
+		_, afterKey, err := DecodeCBOR(rest)
+		if err != nil {
+			return nil, ErrAuthenticatorData
+		}
+
+		authData.PublicKey = append([]byte(nil), rest[:len(rest)-len(afterKey)]...)
+		rest = afterKey
+	}
+
+	// extension outputs are not used, but must be well formed
+	if authData.Flags&AuthenticatorExtensions != 0 {
+		var err error
+
+		_, rest, err = DecodeCBOR(rest)
+		if err != nil {
+			return nil, ErrAuthenticatorData
+		}
+	}
+
+	if len(rest) != 0 {
+		return nil, ErrAuthenticatorData
+	}
+
+	return authData, nil
+}
+
+// ParseAttestationObject returns the authenticator data of a registration response.
+// The attestation statement is not verified, we request "none" conveyance and trust
+// the credential because the user is signed in when registering it.
+func ParseAttestationObject(data []byte) (*AuthenticatorData, error) {
+	decoded, rest, err := DecodeCBOR(data)
+	if err != nil || len(rest) != 0 {
+		return nil, errors.New("malformed attestation object")
+	}
+
+	object, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("malformed attestation object")
+	}
+
+	if _, ok = object["fmt"].(string); !ok {
+		return nil, errors.New("attestation object has no format")
+	}
+
+	rawAuthData, ok := object["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("attestation object has no authenticator data")
+	}
+
+	authData, err := ParseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+
+	if authData.CredentialId == nil {
+		return nil, errors.New("attestation object has no credential")
+	}
+
+	return authData, nil
+}
+
+// COSEKey credential public key with the algorithm it signs with
+type COSEKey struct {
+	Alg int64
+	key crypto.PublicKey
+}
+
+func ParseCOSEKey(data []byte) (*COSEKey, error) {
+	decoded, rest, err := DecodeCBOR(data)
+	if err != nil || len(rest) != 0 {
+		return nil, ErrCOSEKey
+	}
+
+	params, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrCOSEKey
+	}
+
+	kty, _ := params[int64(1)].(int64)
+	alg, _ := params[int64(3)].(int64)
+
+	switch {
+	case alg == COSEAlgES256 && kty == 2:
+		crv, _ := params[int64(-1)].(int64)
+		x, _ := params[int64(-2)].([]byte)
+		y, _ := params[int64(-3)].([]byte)
+
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, ErrCOSEKey
+		}
+
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, ErrCOSEKey
+		}
+
+		return &COSEKey{Alg: alg, key: key}, nil
+	case alg == COSEAlgEdDSA && kty == 1:
+		crv, _ := params[int64(-1)].(int64)
+		x, _ := params[int64(-2)].([]byte)
+
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, ErrCOSEKey
+		}
+
+		return &COSEKey{Alg: alg, key: ed25519.PublicKey(x)}, nil
+	case alg == COSEAlgRS256 && kty == 3:
+		n, _ := params[int64(-1)].([]byte)
+		e, _ := params[int64(-2)].([]byte)
+
+		if len(e) == 0 || len(e) > 4 {
+			return nil, ErrCOSEKey
+		}
+
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if key.N.BitLen() < rsaMinBits || key.E < 3 {
+			return nil, ErrCOSEKey
+		}
+
+		return &COSEKey{Alg: alg, key: key}, nil
+	}
+
+	return nil, ErrCOSEKey
+}
+
+// VerifyAssertion checks the signature over authenticatorData || SHA-256(clientDataJSON)
+func (k *COSEKey) VerifyAssertion(authData []byte, clientDataJSON []byte, signature []byte) error {
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), authData...), clientDataHash[:]...)
+
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(signed)
+		if ecdsa.VerifyASN1(key, digest[:], signature) {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if ed25519.Verify(key, signed, signature) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(signed)
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil {
+			return nil
+		}
+	}
+
+	return ErrSignature
+}
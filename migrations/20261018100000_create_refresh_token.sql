@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS refresh_token_family (
+    id varchar(36) primary key,
+    user_id int not null,
+    FOREIGN KEY (user_id) REFERENCES auth_user(id) ON DELETE CASCADE,
+    created_at timestamp not null default now(),
+    revoked_at timestamp
+);
+
+CREATE TABLE IF NOT EXISTS refresh_token (
+    id varchar(36) primary key,
+    family_id varchar(36) not null,
+    FOREIGN KEY (family_id) REFERENCES refresh_token_family(id) ON DELETE CASCADE,
+    user_id int not null,
+    expires_at timestamp not null,
+    used_at timestamp,
+    created_at timestamp not null default now()
+);
+
+CREATE INDEX IF NOT EXISTS refresh_token_family_id_idx ON refresh_token (family_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists refresh_token;
+drop table if exists refresh_token_family;
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS revoked_token (
+    jti varchar(36) primary key,
+    user_id int not null,
+    expires_at timestamp not null,
+    created_at timestamp not null default now()
+);
+
+CREATE INDEX IF NOT EXISTS revoked_token_expires_at_idx ON revoked_token (expires_at);
+
+CREATE TABLE IF NOT EXISTS user_token_revocation (
+    user_id int primary key,
+    FOREIGN KEY (user_id) REFERENCES auth_user(id) ON DELETE CASCADE,
+    revoked_before timestamp not null
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists revoked_token;
+drop table if exists user_token_revocation;
+-- +goose StatementEnd
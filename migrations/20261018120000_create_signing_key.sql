@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS signing_key (
+    kid varchar(64) primary key,
+    algorithm varchar(16) not null,
+    private_key bytea not null,
+    created_at timestamptz not null default now(),
+    promoted_at timestamptz null,
+    retired_at timestamptz null
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists signing_key;
+-- +goose StatementEnd
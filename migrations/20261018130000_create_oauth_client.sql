@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS oauth_client (
+    id varchar(36) primary key,
+    name varchar(255) not null,
+    secret_hash varchar(64) not null,
+    created_at timestamp not null default now()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists oauth_client;
+-- +goose StatementEnd
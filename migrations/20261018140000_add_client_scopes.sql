@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE oauth_client ADD COLUMN IF NOT EXISTS team varchar(255) not null default '';
+ALTER TABLE oauth_client ADD COLUMN IF NOT EXISTS scopes text[] not null default '{}';
+
+-- scope a client token needs for the endpoint, clients are denied restricted endpoints without one
+ALTER TABLE permission ADD COLUMN IF NOT EXISTS required_scope varchar(100) not null default '';
+
+INSERT INTO permission (permission_id, resource_name, min_role_priority)
+VALUES
+    (10, '/auth_v1.AuthV1/CreateClient', 100),
+    (11, '/auth_v1.AuthV1/GetClient', 100),
+    (12, '/auth_v1.AuthV1/ListClients', 100),
+    (13, '/auth_v1.AuthV1/UpdateClient', 100),
+    (14, '/auth_v1.AuthV1/DeleteClient', 100)
+ON CONFLICT (permission_id) DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permission WHERE permission_id BETWEEN 10 AND 14;
+ALTER TABLE permission DROP COLUMN IF EXISTS required_scope;
+ALTER TABLE oauth_client DROP COLUMN IF EXISTS scopes;
+ALTER TABLE oauth_client DROP COLUMN IF EXISTS team;
+-- +goose StatementEnd
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE oauth_client ADD COLUMN IF NOT EXISTS redirect_uris text[] not null default '{}';
+-- public clients (SPA, mobile) can not keep a secret and authenticate with PKCE only
+ALTER TABLE oauth_client ADD COLUMN IF NOT EXISTS public boolean not null default false;
+
+CREATE TABLE IF NOT EXISTS authorization_code (
+    code_hash varchar(64) primary key,
+    client_id varchar(36) not null,
+    FOREIGN KEY (client_id) REFERENCES oauth_client(id) ON DELETE CASCADE,
+    user_id int not null,
+    FOREIGN KEY (user_id) REFERENCES auth_user(id) ON DELETE CASCADE,
+    user_login text not null,
+    role varchar(50) not null,
+    redirect_uri text not null,
+    scope text not null,
+    code_challenge varchar(128) not null,
+    family_id varchar(36) null,
+    expires_at timestamptz not null,
+    used_at timestamptz null,
+    created_at timestamptz not null default now()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists authorization_code;
+ALTER TABLE oauth_client DROP COLUMN IF EXISTS public;
+ALTER TABLE oauth_client DROP COLUMN IF EXISTS redirect_uris;
+-- +goose StatementEnd
@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- OpenID Connect nonce, echoed in the id_token issued for the code
+ALTER TABLE authorization_code ADD COLUMN IF NOT EXISTS nonce text not null default '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE authorization_code DROP COLUMN IF EXISTS nonce;
+-- +goose StatementEnd
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+-- lifetimes in seconds, null keeps the configured one; overrides can only shorten it
+ALTER TABLE user_role ADD COLUMN IF NOT EXISTS access_token_ttl int null;
+ALTER TABLE user_role ADD COLUMN IF NOT EXISTS session_idle_timeout int null;
+ALTER TABLE user_role ADD COLUMN IF NOT EXISTS session_max_age int null;
+
+-- admins: 15 minute access tokens, sessions end after 8 idle hours and at most after a day
+UPDATE user_role
+SET access_token_ttl = 900, session_idle_timeout = 28800, session_max_age = 86400
+WHERE role_name = 'admin';
+
+ALTER TABLE oauth_client ADD COLUMN IF NOT EXISTS access_token_ttl int null;
+
+-- absolute end of the session, rotation never issues a refresh token living past it
+ALTER TABLE refresh_token_family ADD COLUMN IF NOT EXISTS expires_at timestamp null;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE refresh_token_family DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE oauth_client DROP COLUMN IF EXISTS access_token_ttl;
+ALTER TABLE user_role DROP COLUMN IF EXISTS session_max_age;
+ALTER TABLE user_role DROP COLUMN IF EXISTS session_idle_timeout;
+ALTER TABLE user_role DROP COLUMN IF EXISTS access_token_ttl;
+-- +goose StatementEnd
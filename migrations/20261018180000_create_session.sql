@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+-- a session is the refresh token family started on login, revoking the family ends the session
+CREATE TABLE IF NOT EXISTS session (
+    id varchar(36) primary key,
+    FOREIGN KEY (id) REFERENCES refresh_token_family(id) ON DELETE CASCADE,
+    user_id int not null,
+    FOREIGN KEY (user_id) REFERENCES auth_user(id) ON DELETE CASCADE,
+    -- set for sessions started by the authorization code flow
+    client_id varchar(36) null,
+    FOREIGN KEY (client_id) REFERENCES oauth_client(id) ON DELETE CASCADE,
+    user_agent text not null default '',
+    ip varchar(45) not null default '',
+    created_at timestamp not null default now(),
+    last_seen_at timestamp not null default now()
+);
+
+CREATE INDEX IF NOT EXISTS session_user_id_idx ON session (user_id);
+
+INSERT INTO permission (permission_id, resource_name, min_role_priority)
+VALUES
+    (15, '/user_v1.UserV1/ListSessions', 100),
+    (16, '/user_v1.UserV1/RevokeSession', 100)
+ON CONFLICT (permission_id) DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permission WHERE permission_id IN (15, 16);
+drop table if exists session;
+-- +goose StatementEnd
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS user_mfa (
+    user_id int primary key,
+    FOREIGN KEY (user_id) REFERENCES auth_user(id) ON DELETE CASCADE,
+    -- TOTP secret sealed with AES-GCM, the user id is the additional data
+    secret bytea not null,
+    -- null until the user proves the authenticator app works
+    confirmed_at timestamp null,
+    -- last accepted time step, a code can not be used twice
+    last_counter bigint null,
+    failed_attempts int not null default 0,
+    last_failed_at timestamp null,
+    created_at timestamp not null default now()
+);
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_code (
+    user_id int not null,
+    FOREIGN KEY (user_id) REFERENCES user_mfa(user_id) ON DELETE CASCADE,
+    code_hash varchar(64) not null,
+    used_at timestamp null,
+    primary key (user_id, code_hash)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists mfa_recovery_code;
+drop table if exists user_mfa;
+-- +goose StatementEnd
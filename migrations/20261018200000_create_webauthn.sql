@@ -0,0 +1,39 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS webauthn_credential (
+    id bytea primary key,
+    user_id int not null,
+    FOREIGN KEY (user_id) REFERENCES auth_user(id) ON DELETE CASCADE,
+    -- COSE_Key encoded public key from the attestation
+    public_key bytea not null,
+    -- signature counter of the authenticator, a counter going back means a cloned authenticator
+    sign_count bigint not null default 0,
+    transports text[] not null default '{}',
+    name varchar(255) not null default '',
+    created_at timestamp not null default now(),
+    last_used_at timestamp null
+);
+
+CREATE INDEX IF NOT EXISTS webauthn_credential_user_id_idx ON webauthn_credential (user_id);
+
+-- challenges of started ceremonies, each can be answered once
+CREATE TABLE IF NOT EXISTS webauthn_challenge (
+    challenge_hash varchar(64) primary key,
+    -- null for a login started without an email, the credential names the user
+    user_id int null,
+    FOREIGN KEY (user_id) REFERENCES auth_user(id) ON DELETE CASCADE,
+    ceremony varchar(20) not null,
+    expires_at timestamp not null,
+    used_at timestamp null
+);
+
+-- endpoints only sessions started with a passkey may call
+ALTER TABLE permission ADD COLUMN IF NOT EXISTS require_passkey boolean not null default false;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE permission DROP COLUMN IF EXISTS require_passkey;
+drop table if exists webauthn_challenge;
+drop table if exists webauthn_credential;
+-- +goose StatementEnd
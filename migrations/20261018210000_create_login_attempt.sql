@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+-- failed password logins per account (account:<email>) and per client address (ip:<address>),
+-- counted for emails without an account too, so a lockout does not reveal which emails exist
+CREATE TABLE IF NOT EXISTS login_attempt (
+    key varchar(330) primary key,
+    failed_attempts int not null default 0,
+    last_failed_at timestamp not null default now(),
+    -- logins are refused until then, the backoff grows with every failure
+    locked_until timestamp null
+);
+
+INSERT INTO permission (permission_id, resource_name, min_role_priority)
+VALUES
+    (17, '/user_v1.UserV1/Unlock', 100)
+ON CONFLICT (permission_id) DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permission WHERE permission_id = 17;
+drop table if exists login_attempt;
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+-- one-time tokens mailed to a user, only their hash is stored
+CREATE TABLE IF NOT EXISTS user_token (
+    token_hash varchar(64) primary key,
+    user_id int not null,
+    FOREIGN KEY (user_id) REFERENCES auth_user(id) ON DELETE CASCADE,
+    -- what the token may be used for, e.g. password_reset
+    purpose varchar(30) not null,
+    created_at timestamp not null default now(),
+    expires_at timestamp not null,
+    used_at timestamp null
+);
+
+CREATE INDEX IF NOT EXISTS user_token_user_id_idx ON user_token (user_id, purpose);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists user_token;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE auth_user ADD COLUMN IF NOT EXISTS email_verified boolean not null default false;
+-- new address of an email change, it replaces email once confirmed
+ALTER TABLE auth_user ADD COLUMN IF NOT EXISTS pending_email text null;
+
+-- accounts created before verification existed keep working when it is required
+UPDATE auth_user SET email_verified = true;
+
+-- address a verification token was mailed to
+ALTER TABLE user_token ADD COLUMN IF NOT EXISTS email text null;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE user_token DROP COLUMN IF EXISTS email;
+ALTER TABLE auth_user DROP COLUMN IF EXISTS pending_email;
+ALTER TABLE auth_user DROP COLUMN IF EXISTS email_verified;
+-- +goose StatementEnd
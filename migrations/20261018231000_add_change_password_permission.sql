@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- any signed in user may change their own password
+INSERT INTO permission (permission_id, resource_name, min_role_priority)
+VALUES
+    (18, '/user_v1.UserV1/ChangePassword', 10)
+ON CONFLICT (permission_id) DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permission WHERE permission_id = 18;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+-- personal access tokens sent as "Authorization: ApiKey ak_<prefix>_<secret>", looked up by the prefix
+CREATE TABLE IF NOT EXISTS api_key (
+    id varchar(36) primary key,
+    user_id int not null,
+    FOREIGN KEY (user_id) REFERENCES auth_user(id) ON DELETE CASCADE,
+    name varchar(255) not null,
+    prefix varchar(16) not null unique,
+    secret_hash varchar(64) not null,
+    scopes text[] not null default '{}',
+    created_at timestamp not null default now(),
+    expires_at timestamp not null,
+    last_used_at timestamp null,
+    last_used_ip varchar(45) null,
+    revoked_at timestamp null
+);
+
+CREATE INDEX IF NOT EXISTS api_key_user_id_idx ON api_key (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists api_key;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+-- admin acting on behalf of the user entity_id, null when users act themselves
+ALTER TABLE auth_user_log ADD COLUMN IF NOT EXISTS actor_id int null;
+
+CREATE INDEX IF NOT EXISTS auth_user_log_actor_id_idx ON auth_user_log (actor_id) WHERE actor_id IS NOT NULL;
+
+INSERT INTO permission (permission_id, resource_name, min_role_priority)
+VALUES
+    (19, '/auth_v1.AuthV1/Impersonate', 100)
+ON CONFLICT (permission_id) DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permission WHERE permission_id = 19;
+DROP INDEX IF EXISTS auth_user_log_actor_id_idx;
+ALTER TABLE auth_user_log DROP COLUMN IF EXISTS actor_id;
+-- +goose StatementEnd
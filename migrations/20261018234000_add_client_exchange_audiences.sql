@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- audiences the client may exchange user tokens for (RFC 8693), none by default
+ALTER TABLE oauth_client ADD COLUMN IF NOT EXISTS exchange_audiences text[] not null default '{}';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE oauth_client DROP COLUMN IF EXISTS exchange_audiences;
+-- +goose StatementEnd
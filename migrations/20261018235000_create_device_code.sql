@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+-- RFC 8628 device authorizations, only hashes of the device code and of the user code are stored
+CREATE TABLE IF NOT EXISTS device_code (
+    device_code_hash varchar(64) primary key,
+    user_code_hash varchar(64) not null,
+    client_id varchar(36) not null,
+    FOREIGN KEY (client_id) REFERENCES oauth_client(id) ON DELETE CASCADE,
+    scope text not null,
+    -- seconds the device waits between token requests, slow_down raises it
+    poll_interval int not null,
+    -- set once the user approved the device
+    user_id int null,
+    FOREIGN KEY (user_id) REFERENCES auth_user(id) ON DELETE CASCADE,
+    amr text[] not null default '{}',
+    approved_at timestamp null,
+    denied_at timestamp null,
+    last_polled_at timestamp null,
+    used_at timestamp null,
+    created_at timestamp not null default now(),
+    expires_at timestamp not null
+);
+
+CREATE INDEX IF NOT EXISTS device_code_user_code_hash_idx ON device_code (user_code_hash);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists device_code;
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+-- the seed used the wrong case of the service, the rows never matched and left the methods open
+UPDATE permission SET resource_name = '/user_v1.UserV1/Create' WHERE permission_id = 1;
+UPDATE permission SET resource_name = '/user_v1.UserV1/Delete' WHERE permission_id = 3;
+
+-- any signed in user. VerifyEmail stays open, the mailed token is the credential
+-- and the link is often opened signed out
+INSERT INTO permission (permission_id, resource_name, min_role_priority)
+VALUES
+    (20, '/user_v1.UserV1/Get', 10),
+    (21, '/user_v1.UserV1/Update', 10),
+    (22, '/user_v1.UserV1/FindByLogin', 10)
+ON CONFLICT (permission_id) DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permission WHERE permission_id BETWEEN 20 AND 22;
+UPDATE permission SET resource_name = '/user_v1.userV1/Delete' WHERE permission_id = 3;
+UPDATE permission SET resource_name = '/user_v1.userV1/Create' WHERE permission_id = 1;
+-- +goose StatementEnd
@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- RFC 8176 methods the user signed in with to approve the request, carried into the tokens
+ALTER TABLE authorization_code ADD COLUMN IF NOT EXISTS amr text[] not null default '{}';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE authorization_code DROP COLUMN IF EXISTS amr;
+-- +goose StatementEnd
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- not a method: the priority a role needs to update accounts of other users,
+-- the owner of the account must not have a higher role on top of it
+INSERT INTO permission (permission_id, resource_name, min_role_priority)
+VALUES
+    (23, '/user_v1.UserV1/Update/others', 100)
+ON CONFLICT (permission_id) DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permission WHERE permission_id = 23;
+-- +goose StatementEnd